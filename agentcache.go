@@ -0,0 +1,48 @@
+package main
+
+import (
+	"crypto/sha256"
+	"strings"
+	"sync"
+)
+
+// agentAnswer is the output of a sub-agent and the number of file changes
+// recorded in the session when it finished
+type agentAnswer struct {
+	output  string
+	changes int
+}
+
+// agentCache keeps the answers of sub-agents for the session, so the same
+// prompt delegated again is answered at once instead of by another
+// sub-session, as long as no file changed since
+type agentCache struct {
+	mu      sync.Mutex
+	answers map[[32]byte]agentAnswer
+}
+
+// GlobalAgentCache is the application-wide cache of sub-agent answers
+var GlobalAgentCache = &agentCache{answers: map[[32]byte]agentAnswer{}}
+
+// agentPromptKey identifies a delegation by its prompt and the tools of the agent
+func agentPromptKey(prompt, tools string) [32]byte {
+	return sha256.Sum256([]byte(strings.TrimSpace(prompt) + "\x00" + tools))
+}
+
+// Get returns the answer to the same delegation, unless files changed since
+func (c *agentCache) Get(key [32]byte) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	answer, ok := c.answers[key]
+	if !ok || answer.changes != GlobalChangeLedger.Len() {
+		return "", false
+	}
+	return answer.output, true
+}
+
+// Put stores the answer of a sub-agent, after its own file changes were recorded
+func (c *agentCache) Put(key [32]byte, output string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.answers[key] = agentAnswer{output: output, changes: GlobalChangeLedger.Len()}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// agentProgressEnv is set for sub-agents so they report their activity on stderr
+const agentProgressEnv = "AICODE_AGENT_PROGRESS"
+
+// agentProgressPrefix marks progress lines among the other stderr output of a sub-agent
+const agentProgressPrefix = "aicode-progress: "
+
+// maxProgressLine is the length at which progress lines are cut
+const maxProgressLine = 120
+
+// Message carrying one line of activity of a running sub-agent
+type agentProgressMsg struct {
+	line string
+}
+
+var progressMu sync.Mutex
+
+// reportAgentProgress tells the parent process what this sub-agent is doing.
+// It does nothing when the process was not started as a sub-agent.
+func reportAgentProgress(format string, args ...interface{}) {
+	if os.Getenv(agentProgressEnv) == "" {
+		return
+	}
+	line := strings.Join(strings.Fields(fmt.Sprintf(format, args...)), " ")
+	if len(line) > maxProgressLine {
+		line = line[:maxProgressLine-3] + "..."
+	}
+	writeProgressLine(line)
+}
+
+// writeProgressLine writes one progress line, keeping concurrent tools from interleaving
+func writeProgressLine(line string) {
+	progressMu.Lock()
+	defer progressMu.Unlock()
+	fmt.Fprintln(os.Stderr, agentProgressPrefix+line)
+}
+
+// relayAgentProgress reads the stderr of a sub-agent, shows its progress lines
+// in the UI, or passes them on when this process is itself a sub-agent, and
+// returns the remaining output
+func relayAgentProgress(stderr io.Reader) string {
+	var rest strings.Builder
+	scanner := bufio.NewScanner(stderr)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		progress, ok := strings.CutPrefix(line, agentProgressPrefix)
+		if !ok {
+			rest.WriteString(line + "\n")
+			continue
+		}
+		if programRef != nil {
+			programRef.Send(agentProgressMsg{line: progress})
+		} else if os.Getenv(agentProgressEnv) != "" {
+			// Indent the activity of nested agents under their own dispatch
+			writeProgressLine("  " + progress)
+		}
+	}
+	return rest.String()
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// maxErrorBody is the number of characters of an opaque error body shown to the user
+const maxErrorBody = 300
+
+// maxLoggedErrorBody is the number of characters of an error body written to the log
+const maxLoggedErrorBody = 4000
+
+// requestIDHeaders are the response headers identifying a request for the provider's support
+var requestIDHeaders = []string{"request-id", "x-request-id", "cf-ray"}
+
+// apiError is a failed request to a provider, with what is needed to report it
+type apiError struct {
+	Provider   string
+	StatusCode int
+	Status     string
+	RequestID  string
+	Message    string // Error message of the provider, if the body had one
+	Body       string // Summary of the body otherwise
+}
+
+func (e *apiError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s API error: %s", e.Provider, e.Status)
+	if e.RequestID != "" {
+		fmt.Fprintf(&b, " (request id %s)", e.RequestID)
+	}
+	if e.Message != "" {
+		b.WriteString(": " + e.Message)
+	} else if e.Body != "" {
+		b.WriteString(": " + e.Body)
+	}
+	return b.String()
+}
+
+// newAPIError builds the error of a response, using message when the body
+// had one and a short summary of the body otherwise. The full details are logged.
+func newAPIError(provider string, resp *http.Response, body []byte, message string) *apiError {
+	e := &apiError{
+		Provider:   provider,
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		Message:    message,
+	}
+	if e.Status == "" {
+		e.Status = fmt.Sprintf("%d %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+	}
+	for _, header := range requestIDHeaders {
+		if id := resp.Header.Get(header); id != "" {
+			e.RequestID = id
+			break
+		}
+	}
+	if message == "" {
+		e.Body = summarizeErrorBody(resp.Header.Get("Content-Type"), body)
+	}
+
+	logged := string(body)
+	if len(logged) > maxLoggedErrorBody {
+		logged = logged[:maxLoggedErrorBody] + "..."
+	}
+	slog.Error("API error", "provider", provider, "url", resp.Request.URL.String(), "status", e.Status,
+		"request_id", e.RequestID, "message", message, "body", logged)
+	return e
+}
+
+// summarizeErrorBody turns an error body into one short line, keeping the
+// title and text of HTML pages such as proxy or gateway errors
+func summarizeErrorBody(contentType string, body []byte) string {
+	text := string(body)
+	if strings.Contains(contentType, "html") || strings.HasPrefix(strings.TrimSpace(strings.ToLower(text)), "<") {
+		doc := parseHTML(text)
+		var title string
+		for _, node := range doc.querySelectorAll("title") {
+			title = strings.TrimSpace(node.renderText(false))
+		}
+		text = doc.mainContent().renderText(true)
+		if title != "" && !strings.Contains(text, title) {
+			text = title + " - " + text
+		}
+	}
+
+	text = strings.Join(strings.Fields(text), " ")
+	if text == "" {
+		return "empty response body"
+	}
+	if len(text) > maxErrorBody {
+		text = text[:maxErrorBody] + "... (see the log for the full body)"
+	}
+	return text
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// approvalHelp is shown in the status line while a write waits for approval
+const approvalHelp = "Approve the write | y write it, e edit it first, n or esc reject"
+
+// writeDecision is the answer of the user to a proposed write
+type writeDecision struct {
+	content  string // Content to write, edited by the user or as proposed
+	approved bool
+}
+
+// Message asking the user to approve the content the model proposes for a file
+type writeApprovalMsg struct {
+	tool     string
+	path     string
+	original string // Empty when the file doesn't exist yet
+	proposed string
+	edited   bool // The user changed the proposed content
+	reply    chan writeDecision
+}
+
+// approveWrite asks the user to approve a write of Edit or Replace when
+// approve_writes is enabled. It returns the content to write and, when the
+// user edited it, the changes they made to tell the model.
+func approveWrite(config Config, tool, path, original, proposed string) (string, string, error) {
+	if !config.ApproveWrites || programRef == nil {
+		return proposed, "", nil
+	}
+
+	reply := make(chan writeDecision, 1)
+	programRef.Send(writeApprovalMsg{tool: tool, path: path, original: original, proposed: proposed, reply: reply})
+	decision := <-reply
+	if !decision.approved {
+		return "", "", fmt.Errorf("the user rejected the change to %s, ask them what to change instead of retrying it", path)
+	}
+	if decision.content == proposed {
+		return proposed, "", nil
+	}
+	note := fmt.Sprintf("\nThe user edited your proposed content before approving it, %s now contains their version. Their changes to your proposal:\n%s",
+		path, plainDiff(proposed, decision.content))
+	return decision.content, note, nil
+}
+
+// showApprovalDiff prints the diff of the write waiting for approval
+func (m *chatModel) showApprovalDiff() {
+	a := m.approval
+	header := fmt.Sprintf("%s proposes to write %s:", a.tool, a.path)
+	if a.edited {
+		header = fmt.Sprintf("Your version of %s:", a.path)
+	}
+	m.outputs = append(m.outputs, header+"\n"+renderDiff(a.original, a.proposed))
+	m.updateViewportContent()
+}
+
+// handleApprovalKey answers the pending write approval
+func (m *chatModel) handleApprovalKey(msg tea.KeyMsg) tea.Cmd {
+	a := m.approval
+	switch msg.String() {
+	case "y", "Y":
+		a.reply <- writeDecision{content: a.proposed, approved: true}
+		m.outputs = append(m.outputs, "Approved "+a.path)
+	case "e":
+		return editInEditor(a.proposed)
+	case "n", "N", "esc":
+		a.reply <- writeDecision{}
+		m.outputs = append(m.outputs, "Rejected "+a.path)
+	default:
+		return nil
+	}
+	m.approval = nil
+	m.updateViewportContent()
+	return nil
+}
+
+// actionQuestion asks the user to allow a tool call, showing a Bash command
+// in full with the explanation of what it does and its risks
+func actionQuestion(toolName string, input json.RawMessage, paramsStr string, untrusted bool) string {
+	if toolName == "Bash" {
+		if params, err := parseToolParams[BashToolParams](input, "Command"); err == nil {
+			question := "Run it? (y/n)"
+			if untrusted {
+				question = "It follows content fetched from the web. Run it? (y/n)"
+			}
+			return fmt.Sprintf("Bash command:\n  $ %s\n  %s\n%s", params.Command, explainCommand(params.Command), question)
+		}
+	}
+	if !untrusted {
+		return fmt.Sprintf("%s(%s) Run it? (y/n)", toolName, paramsStr)
+	}
+	return fmt.Sprintf("%s(%s) follows content fetched from the web. Run it? (y/n)", toolName, paramsStr)
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// redacted replaces the API keys in the audit log
+const redacted = "[REDACTED]"
+
+// secretHeaders are the request headers holding credentials
+var secretHeaders = map[string]bool{"Authorization": true, "X-Api-Key": true, "Proxy-Authorization": true}
+
+// secretHeaderWords mark the names of headers holding credentials, such as
+// the api-key of Azure or the cf-aig-authorization of Cloudflare gateways
+var secretHeaderWords = []string{"key", "token", "secret", "auth"}
+
+// isSecretHeader reports whether the value of a request header is redacted
+// from the audit log: the credential headers, the headers set by the profile,
+// whose values often expand secrets of the environment, and the headers named
+// after a credential
+func isSecretHeader(config Config, name string) bool {
+	if secretHeaders[http.CanonicalHeaderKey(name)] {
+		return true
+	}
+	for header := range config.Headers {
+		if strings.EqualFold(header, name) {
+			return true
+		}
+	}
+	lower := strings.ToLower(name)
+	for _, word := range secretHeaderWords {
+		if strings.Contains(lower, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// auditEntry is one attempt of a request to the provider, as sent and
+// answered, written to the audit log of the session with audit_log
+type auditEntry struct {
+	Time       time.Time         `json:"time"`
+	Provider   string            `json:"provider"`
+	Method     string            `json:"method"`
+	URL        string            `json:"url"`
+	Attempt    int               `json:"attempt"`
+	Headers    map[string]string `json:"headers"`
+	Request    json.RawMessage   `json:"request"`
+	Status     int               `json:"status,omitempty"`
+	Response   json.RawMessage   `json:"response,omitempty"`
+	Error      string            `json:"error,omitempty"`
+	DurationMs int64             `json:"duration_ms"`
+}
+
+var auditMu sync.Mutex
+
+// sessionAuditPath returns the audit log of the session with the given id
+func sessionAuditPath(id string) string {
+	return filepath.Join(".aicode", "sessions", id, "api.jsonl")
+}
+
+// requestBody returns a copy of the body of a request, leaving it to be sent
+func requestBody(req *http.Request) []byte {
+	if req.GetBody == nil {
+		return nil
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil
+	}
+	defer body.Close()
+	data, _ := io.ReadAll(body)
+	return data
+}
+
+// redactKeys replaces the API keys of the profile in text
+func redactKeys(config Config, text string) string {
+	for _, key := range append([]string{config.ApiKey}, config.ApiKeys...) {
+		if key != "" {
+			text = strings.ReplaceAll(text, key, redacted)
+		}
+	}
+	return text
+}
+
+// recordAudit appends an attempt to the audit log of the session, with the
+// API keys of the profile redacted from the headers and bodies and the values
+// of secret headers redacted
+func recordAudit(config Config, provider string, attempt int, req *http.Request, reqBody []byte, resp *http.Response, respBody []byte, err error, duration time.Duration) {
+	redact := func(text string) string {
+		return redactKeys(config, text)
+	}
+
+	entry := auditEntry{
+		Time:       time.Now(),
+		Provider:   provider,
+		Method:     req.Method,
+		URL:        redact(req.URL.String()),
+		Attempt:    attempt,
+		Headers:    map[string]string{},
+		Request:    auditBody(redact(string(reqBody))),
+		DurationMs: duration.Milliseconds(),
+	}
+	for name, values := range req.Header {
+		value := strings.Join(values, ", ")
+		if isSecretHeader(config, name) {
+			value = redacted
+		}
+		entry.Headers[name] = redact(value)
+	}
+	if resp != nil {
+		entry.Status = resp.StatusCode
+		entry.Response = auditBody(redact(string(respBody)))
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	data, marshalErr := json.Marshal(entry)
+	if marshalErr != nil {
+		return
+	}
+
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	path := sessionAuditPath(sessionID)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		slog.Debug("Failed to create the session directory", "error", err)
+		return
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		slog.Debug("Failed to open the audit log", "error", err)
+		return
+	}
+	defer file.Close()
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		slog.Debug("Failed to write the audit log", "error", err)
+	}
+}
+
+// auditBody keeps a JSON body as is so that the log can be queried with jq,
+// other bodies are logged as a string
+func auditBody(body string) json.RawMessage {
+	if body == "" {
+		return nil
+	}
+	if json.Valid([]byte(body)) {
+		return json.RawMessage(body)
+	}
+	data, _ := json.Marshal(body)
+	return data
+}
+
+// logBodies writes an attempt with its bodies to the log with log_bodies,
+// API keys redacted
+func logBodies(config Config, provider string, attempt int, req *http.Request, reqBody []byte, resp *http.Response, respBody []byte) {
+	slog.Info("API request", "provider", provider, "attempt", attempt, "url", redactKeys(config, req.URL.String()), "body", redactKeys(config, string(reqBody)))
+	if resp != nil {
+		slog.Info("API response", "provider", provider, "attempt", attempt, "status", resp.StatusCode, "body", redactKeys(config, string(respBody)))
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecordAuditRedactsGatewayHeaders(t *testing.T) {
+	dir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(dir)
+
+	config := Config{Headers: map[string]string{"X-Tenant": "${TENANT}"}}
+	req, _ := http.NewRequest("POST", "https://gateway.example.com/v1/chat/completions", nil)
+	secrets := map[string]string{
+		"Api-Key":              "azure-secret",
+		"X-Portkey-Api-Key":    "portkey-secret",
+		"Cf-Aig-Authorization": "cloudflare-secret",
+		"X-Tenant":             "tenant-secret",
+	}
+	for name, value := range secrets {
+		req.Header.Set(name, value)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	recordAudit(config, "openai", 1, req, nil, nil, nil, nil, time.Second)
+
+	data, err := os.ReadFile(sessionAuditPath(sessionID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entry auditEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		t.Fatal(err)
+	}
+	for name, value := range secrets {
+		if strings.Contains(string(data), value) {
+			t.Errorf("the value of %s is in the audit log", name)
+		}
+		if entry.Headers[name] != redacted {
+			t.Errorf("%s = %q, want it redacted", name, entry.Headers[name])
+		}
+	}
+	if entry.Headers["Content-Type"] != "application/json" {
+		t.Errorf("Content-Type = %q, want it kept", entry.Headers["Content-Type"])
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"os"
+	"path"
+	"strings"
+)
+
+// BashEnv selects the environment variables passed to Bash tool commands
+type BashEnv struct {
+	Allow []string `yaml:"allow"` // Only these variables are passed when set
+	Deny  []string `yaml:"deny"`  // Removed variables, replaces the default list when set
+}
+
+// defaultBashEnvDeny keeps common credentials out of the commands the model runs
+var defaultBashEnvDeny = []string{
+	"AWS_*", "AZURE_*", "GOOGLE_APPLICATION_CREDENTIALS", "GCLOUD_*",
+	"ANTHROPIC_API_KEY", "OPENAI_API_KEY", "GITHUB_TOKEN", "GH_TOKEN", "NPM_TOKEN",
+	"*_TOKEN", "*_SECRET", "*_SECRET_*", "*_API_KEY", "*_PASSWORD", "*_CREDENTIALS",
+}
+
+// bashEnvEssentials are always passed so commands keep working with an
+// allowlist, SSH_* for the agent of git and of the ssh of remote workspaces
+var bashEnvEssentials = []string{"PATH", "HOME", "USER", "SHELL", "TERM", "LANG", "LC_*", "TMPDIR", "PWD", "SSH_*"}
+
+// matchesAny reports whether name matches one of the glob patterns
+func matchesAny(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// bashEnvDeny returns the patterns of the variables removed from Bash tool commands
+func bashEnvDeny(config Config) []string {
+	if config.BashEnv.Deny == nil {
+		return defaultBashEnvDeny
+	}
+	return config.BashEnv.Deny
+}
+
+// bashEnvironment returns the environment of Bash tool commands, or of the
+// ssh running them in a remote workspace
+func bashEnvironment(config Config) []string {
+	deny := bashEnvDeny(config)
+	env := []string{}
+	for _, entry := range os.Environ() {
+		name, _, _ := strings.Cut(entry, "=")
+		if len(config.BashEnv.Allow) > 0 && !matchesAny(name, config.BashEnv.Allow) && !matchesAny(name, bashEnvEssentials) {
+			continue
+		}
+		if matchesAny(name, deny) {
+			continue
+		}
+		env = append(env, entry)
+	}
+	return env
+}
+
+// bashEnvCommand returns the command line of a Bash tool command. In a
+// remote workspace, the environment of the host is filtered by the command
+// line itself, since the environment of ssh does not reach it.
+func bashEnvCommand(config Config, command string) string {
+	if activeRemote == nil {
+		return command
+	}
+
+	var filter strings.Builder
+	filter.WriteString("for __aicode_var in $(compgen -e); do case $__aicode_var in ")
+	if len(config.BashEnv.Allow) > 0 {
+		keep := append(append([]string{}, config.BashEnv.Allow...), bashEnvEssentials...)
+		filter.WriteString(casePatterns(keep) + ") ;; *) unset \"$__aicode_var\" 2>/dev/null ;; esac; case $__aicode_var in ")
+	}
+	filter.WriteString(casePatterns(bashEnvDeny(config)) + ") unset \"$__aicode_var\" 2>/dev/null ;; esac; done; unset __aicode_var\n")
+	return filter.String() + command
+}
+
+// casePatterns returns glob patterns as the alternatives of a shell case,
+// escaping all but the wildcards
+func casePatterns(patterns []string) string {
+	if len(patterns) == 0 {
+		// Matches no variable name
+		return "="
+	}
+	quoted := make([]string, len(patterns))
+	for i, pattern := range patterns {
+		var b strings.Builder
+		for _, r := range pattern {
+			if r != '*' && r != '?' && r != '_' && !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9') {
+				b.WriteByte('\\')
+			}
+			b.WriteRune(r)
+		}
+		quoted[i] = b.String()
+	}
+	return strings.Join(quoted, "|")
+}
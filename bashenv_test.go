@@ -0,0 +1,58 @@
+package main
+
+import (
+	"os/exec"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestBashEnvCommandFiltersRemoteEnvironment(t *testing.T) {
+	defer func() { activeRemote = nil }()
+	hostEnv := []string{"PATH=/usr/bin:/bin", "HOME=/home/dev", "AWS_SECRET_ACCESS_KEY=secret", "DEPLOY_TOKEN=token", "GOPATH=/go", "EDITOR=vi"}
+	remoteEnv := func(config Config) []string {
+		// Runs the command line as the host would
+		cmd := exec.Command("bash", "-c", bashEnvCommand(config, "env"))
+		cmd.Env = hostEnv
+		output, err := cmd.Output()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.Split(strings.TrimSpace(string(output)), "\n")
+	}
+
+	if command := bashEnvCommand(Config{}, "env"); command != "env" {
+		t.Fatalf("local command changed to %q", command)
+	}
+
+	activeRemote = &RemoteConfig{Host: "devbox"}
+	env := remoteEnv(Config{})
+	for _, entry := range []string{"AWS_SECRET_ACCESS_KEY=secret", "DEPLOY_TOKEN=token"} {
+		if slices.Contains(env, entry) {
+			t.Errorf("denied %s reached the remote command: %v", entry, env)
+		}
+	}
+	if !slices.Contains(env, "EDITOR=vi") || !slices.Contains(env, "PATH=/usr/bin:/bin") {
+		t.Errorf("allowed variables removed: %v", env)
+	}
+
+	env = remoteEnv(Config{BashEnv: BashEnv{Allow: []string{"GO*"}}})
+	if slices.Contains(env, "EDITOR=vi") || slices.Contains(env, "AWS_SECRET_ACCESS_KEY=secret") {
+		t.Errorf("variables outside the allowlist reached the remote command: %v", env)
+	}
+	if !slices.Contains(env, "GOPATH=/go") || !slices.Contains(env, "HOME=/home/dev") {
+		t.Errorf("allowed variables removed: %v", env)
+	}
+}
+
+func TestBashEnvironmentKeepsSSHAgentWithAllowlist(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "/tmp/agent.sock")
+	t.Setenv("EDITOR", "vi")
+	env := bashEnvironment(Config{BashEnv: BashEnv{Allow: []string{"GOPATH"}}})
+	if !slices.Contains(env, "SSH_AUTH_SOCK=/tmp/agent.sock") {
+		t.Errorf("SSH_AUTH_SOCK removed: %v", env)
+	}
+	if slices.Contains(env, "EDITOR=vi") {
+		t.Errorf("EDITOR passed despite the allowlist: %v", env)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// BashFilter post-processes the output of Bash commands matching a pattern
+// before it is added to the conversation
+type BashFilter struct {
+	Match   string   `yaml:"match"`
+	Filters []string `yaml:"filters"`
+	Command string   `yaml:"command"`
+}
+
+// defaultBashFilters are used when the profile doesn't define bash_filters
+var defaultBashFilters = []BashFilter{
+	{Match: ".*", Filters: []string{"strip_ansi", "collapse_stack_traces"}},
+	{Match: `(^|[;&|]\s*)go test\b`, Filters: []string{"go_test_failures"}},
+}
+
+// maxStackFrames is the number of consecutive stack frame lines kept by collapse_stack_traces
+const maxStackFrames = 6
+
+var (
+	ansiEscape = regexp.MustCompile(`\x1b\[[0-9;?]*[A-Za-z]|\x1b\][^\x07]*\x07`)
+	stackFrame = regexp.MustCompile(`^(\s+at |\s+File "|\t\S+\.go:\d+|\S+\(.*\)$)`)
+	// Lines starting a stack trace, whose frames follow
+	stackTraceHeader = regexp.MustCompile(`^(Traceback \(most recent call last\):|panic: |goroutine \d+ \[|Exception in thread |Caused by: |\S+(Exception|Error)(: |$))`)
+	goTestNoise      = regexp.MustCompile(`^(=== (RUN|PAUSE|CONT|NAME)|\s*--- (PASS|SKIP):|PASS$|ok\s)`)
+)
+
+// builtinBashFilters are the filters that can be referenced by name in bash_filters
+var builtinBashFilters = map[string]func(string) string{
+	"strip_ansi":            stripAnsi,
+	"collapse_stack_traces": collapseStackTraces,
+	"go_test_failures":      goTestFailures,
+}
+
+// stripAnsi removes terminal color and control sequences
+func stripAnsi(output string) string {
+	return ansiEscape.ReplaceAllString(output, "")
+}
+
+// collapseStackTraces keeps the first frames of long stack traces and replaces
+// the rest with a marker. Only the frames following the header of a trace,
+// such as a Python Traceback or a Go panic, are collapsed, leaving lines that
+// merely look like frames elsewhere in the output alone.
+func collapseStackTraces(output string) string {
+	lines := strings.Split(output, "\n")
+	var result []string
+	inTrace, run := false, 0
+	for i, line := range lines {
+		switch {
+		case stackTraceHeader.MatchString(line):
+			inTrace, run = true, 0
+		case inTrace && stackFrame.MatchString(line):
+			run++
+		case inTrace && pythonSourceLine(lines, i):
+			// The source line of a Python frame goes with its frame
+		default:
+			inTrace = false
+		}
+		if inTrace && run > maxStackFrames {
+			// Emit the marker once the run of frames ends
+			if i == len(lines)-1 || !continuesTrace(lines, i+1) {
+				result = append(result, fmt.Sprintf("    ... %d more frames", run-maxStackFrames))
+			}
+			continue
+		}
+		result = append(result, line)
+	}
+	return strings.Join(result, "\n")
+}
+
+// continuesTrace tells whether line i continues the trace before it
+func continuesTrace(lines []string, i int) bool {
+	return !stackTraceHeader.MatchString(lines[i]) && (stackFrame.MatchString(lines[i]) || pythonSourceLine(lines, i))
+}
+
+// pythonSourceLine tells whether line i is the source line following a frame
+// of a Python traceback
+func pythonSourceLine(lines []string, i int) bool {
+	return i > 0 && strings.HasPrefix(strings.TrimSpace(lines[i-1]), `File "`) && strings.HasPrefix(lines[i], " ")
+}
+
+// goTestFailures drops the lines of passing tests from go test output, keeping
+// failures, panics, build errors and the package summaries
+func goTestFailures(output string) string {
+	var result []string
+	passed := 0
+	for _, line := range strings.Split(output, "\n") {
+		if goTestNoise.MatchString(line) {
+			if strings.Contains(line, "--- PASS") || strings.HasPrefix(line, "ok") {
+				passed++
+			}
+			continue
+		}
+		result = append(result, line)
+	}
+	if passed > 0 {
+		result = append(result, fmt.Sprintf("(%d passing tests and packages omitted)", passed))
+	}
+	return strings.Join(result, "\n")
+}
+
+// applyBashFilters runs the filters configured for the command over its output
+func applyBashFilters(command, output string, config Config) string {
+	filters := config.BashFilters
+	if filters == nil {
+		filters = defaultBashFilters
+	}
+
+	for _, filter := range filters {
+		pattern, err := regexp.Compile(filter.Match)
+		if err != nil {
+			slog.Warn("Invalid bash filter pattern", "match", filter.Match, "error", err)
+			continue
+		}
+		if !pattern.MatchString(command) {
+			continue
+		}
+
+		for _, name := range filter.Filters {
+			fn, ok := builtinBashFilters[name]
+			if !ok {
+				slog.Warn("Unknown bash filter", "name", name)
+				continue
+			}
+			output = fn(output)
+		}
+
+		if filter.Command != "" {
+			cmd := exec.CommandContext(GlobalAppContext.Context(), "bash", "-c", filter.Command)
+			cmd.Stdin = strings.NewReader(output)
+			filtered, err := cmd.Output()
+			if err != nil {
+				slog.Warn("Bash filter command failed", "command", filter.Command, "error", err)
+				continue
+			}
+			output = string(filtered)
+		}
+	}
+	return output
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// batchEditHunk is an occurrence of the old_string of an edit in the snapshot of the file
+type batchEditHunk struct {
+	start, end int
+	edit       int // Index of the edit in its group
+}
+
+// planBatchSteps orders a run of consecutive serial invocations of a Batch
+// into steps, each a single invocation or the Edits of one file applied
+// together. Edits of a file are grouped until a Bash invocation, which may
+// change any file, or a Replace of that file.
+func planBatchSteps(invocations []BatchInvocation, serial []int) [][]int {
+	var steps [][]int
+	open := map[string]int{} // Files with the step holding their edits
+	for _, i := range serial {
+		inv := invocations[i]
+		if path, ok := groupedEditPath(inv); ok {
+			if step, ok := open[path]; ok {
+				steps[step] = append(steps[step], i)
+				continue
+			}
+			open[path] = len(steps)
+		}
+		switch inv.ToolName {
+		case "Bash":
+			open = map[string]int{}
+		case "Replace":
+			if path, ok := inv.Input["file_path"].(string); ok {
+				delete(open, absPath(path))
+			}
+		}
+		steps = append(steps, []int{i})
+	}
+	return steps
+}
+
+// groupedEditPath returns the file of an Edit that may be applied along
+// with other edits of the file: not creating it nor only validating
+func groupedEditPath(inv BatchInvocation) (string, bool) {
+	if inv.ToolName != "Edit" {
+		return "", false
+	}
+	path, _ := inv.Input["file_path"].(string)
+	oldString, _ := inv.Input["old_string"].(string)
+	validateOnly, _ := inv.Input["validate_only"].(bool)
+	if path == "" || oldString == "" || validateOnly {
+		return "", false
+	}
+	return absPath(path), true
+}
+
+// executeBatchEdits applies several Edits of the same file to a single
+// snapshot of it and writes the result once. The edits are located in the
+// file as it was before the Batch, so overlapping edits are refused before
+// anything is written. It returns the result of each edit, or the error
+// failing all of them.
+func executeBatchEdits(invocations []BatchInvocation, config Config) ([]string, error) {
+
+	edits := make([]EditToolParams, len(invocations))
+	for i, inv := range invocations {
+		input, err := json.Marshal(inv.Input)
+		if err != nil {
+			return nil, fmt.Errorf("error marshaling input: %v", err)
+		}
+		if err := json.Unmarshal(input, &edits[i]); err != nil {
+			return nil, fmt.Errorf("failed to parse edit tool parameters: %v", err)
+		}
+		if edits[i].NewString == "" {
+			return nil, fmt.Errorf("new_string parameter is required in edit %d of %s", i+1, edits[i].FilePath)
+		}
+	}
+	path := edits[0].FilePath
+
+	release, err := GlobalToolScheduler.Acquire(GlobalAppContext.Context(), "Edit")
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	unlock, err := GlobalFileTracker.Lock(path)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	if err := GlobalFileTracker.CheckStale(path); err != nil {
+		return nil, err
+	}
+	content, err := workspaceReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading file: %v", err)
+	}
+	original := string(content)
+
+	// Locate every edit in the snapshot before changing anything
+	var hunks []batchEditHunk
+	for i, edit := range edits {
+		expected := max(edit.ExpectedReplacements, 1)
+		if count := strings.Count(original, edit.OldString); count != expected {
+			return nil, fmt.Errorf("edit %d of %s: found %d occurrences of the old string, but expected %d. Edits of a file in one Batch apply to its content before the Batch, put edits depending on each other in a single Edit",
+				i+1, path, count, expected)
+		}
+		for offset := 0; offset < len(original); {
+			index := strings.Index(original[offset:], edit.OldString)
+			if index < 0 {
+				break
+			}
+			start := offset + index
+			hunks = append(hunks, batchEditHunk{start: start, end: start + len(edit.OldString), edit: i})
+			offset = start + len(edit.OldString)
+		}
+	}
+	sort.Slice(hunks, func(i, j int) bool { return hunks[i].start < hunks[j].start })
+	for i := 1; i < len(hunks); i++ {
+		if hunks[i].start < hunks[i-1].end {
+			return nil, fmt.Errorf("edits %d and %d of %s change overlapping text, combine them into a single Edit",
+				min(hunks[i-1].edit, hunks[i].edit)+1, max(hunks[i-1].edit, hunks[i].edit)+1, path)
+		}
+	}
+
+	var b strings.Builder
+	last := 0
+	for _, hunk := range hunks {
+		b.WriteString(original[last:hunk.start])
+		b.WriteString(edits[hunk.edit].NewString)
+		last = hunk.end
+	}
+	b.WriteString(original[last:])
+
+	newContent, note, err := approveWrite(config, "Edit", path, original, b.String())
+	if err != nil {
+		return nil, err
+	}
+	if err := workspaceWriteFile(path, []byte(newContent)); err != nil {
+		return nil, fmt.Errorf("error writing to file: %v", err)
+	}
+	GlobalFileTracker.RecordWrite(path, []byte(newContent))
+	GlobalChangeLedger.RecordWrite(path, true, "Edit")
+
+	results := make([]string, len(invocations))
+	for i, edit := range edits {
+		results[i] = fmt.Sprintf("Edit: Successfully edited file %s, replacing %d occurrence(s) of old_string with new_string, written once with the %d edits of the file in this Batch.",
+			path, max(edit.ExpectedReplacements, 1), len(edits))
+	}
+	results[len(results)-1] += note
+	return results, nil
+}
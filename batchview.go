@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// batchSeq numbers the Batches of the session, to route the progress of
+// their invocations to their group in the transcript
+var batchSeq atomic.Int64
+
+// Message sent when a Batch starts, to show it as a group of its invocations
+type batchStartedMsg struct {
+	id          int64
+	description string
+	invocations []batchInvocationView
+}
+
+// Message sent when an invocation of a Batch finished
+type batchInvocationMsg struct {
+	id         int64
+	invocation int
+	result     batchResult
+}
+
+// batchInvocationView is an invocation of a Batch as listed in its group
+type batchInvocationView struct {
+	tool   string
+	params string // Input, shortened
+	done   bool
+	result batchResult
+}
+
+// batchGroup is a Batch shown in the transcript as one entry, listing its
+// invocations with their status as they finish
+type batchGroup struct {
+	id          int64
+	index       int // Index in outputs
+	output      int // Number of the full result for /open, 0 until the Batch finished
+	description string
+	invocations []batchInvocationView
+}
+
+// batchProgress reports the invocations of a running Batch to its group
+type batchProgress struct {
+	id int64
+}
+
+// startBatchGroup shows a Batch in the transcript of the interactive mode,
+// returning nil in the other modes
+func startBatchGroup(params BatchToolParams) *batchProgress {
+	if programRef == nil {
+		return nil
+	}
+	msg := batchStartedMsg{id: batchSeq.Add(1), description: params.Description}
+	for _, inv := range params.Invocations {
+		input, _ := json.Marshal(inv.Input)
+		paramsStr := string(input)
+		if len(paramsStr) > 64 {
+			paramsStr = paramsStr[:61] + "..."
+		}
+		msg.invocations = append(msg.invocations, batchInvocationView{tool: inv.ToolName, params: paramsStr})
+	}
+	programRef.Send(msg)
+	return &batchProgress{id: msg.id}
+}
+
+// finish reports the result of invocation i
+func (p *batchProgress) finish(i int, result batchResult) {
+	if p == nil {
+		return
+	}
+	programRef.Send(batchInvocationMsg{id: p.id, invocation: i, result: result})
+}
+
+// addBatchGroup shows a Batch that started
+func (m *chatModel) addBatchGroup(msg batchStartedMsg) {
+	group := &batchGroup{id: msg.id, index: len(m.outputs), description: msg.description, invocations: msg.invocations}
+	m.batches = append(m.batches, group)
+	m.outputs = append(m.outputs, m.renderBatch(group))
+}
+
+// updateBatchGroup shows the result of an invocation in the group of its Batch
+func (m *chatModel) updateBatchGroup(msg batchInvocationMsg) {
+	for _, group := range m.batches {
+		if group.id == msg.id && msg.invocation < len(group.invocations) && group.index < len(m.outputs) {
+			group.invocations[msg.invocation].done = true
+			group.invocations[msg.invocation].result = msg.result
+			m.outputs[group.index] = m.renderBatch(group)
+			return
+		}
+	}
+}
+
+// addBatchOutput keeps the full result of the last Batch for /open, its
+// invocations being shown by its group
+func (m *chatModel) addBatchOutput(output string) {
+	m.toolOutputs = append(m.toolOutputs, output)
+	if len(m.batches) == 0 {
+		return
+	}
+	group := m.batches[len(m.batches)-1]
+	group.output = len(m.toolOutputs)
+	if group.index < len(m.outputs) {
+		m.outputs[group.index] = m.renderBatch(group)
+	}
+}
+
+// renderBatch renders the group of a Batch: its description and the status
+// of each invocation, with their outputs when /batch expanded the groups
+func (m *chatModel) renderBatch(group *batchGroup) string {
+	ok := lipgloss.NewStyle().Foreground(lipgloss.Color("2"))
+	failed := lipgloss.NewStyle().Foreground(lipgloss.Color("1"))
+	faint := lipgloss.NewStyle().Faint(true)
+
+	done, failures := 0, 0
+	for _, inv := range group.invocations {
+		if inv.done {
+			done++
+		}
+		if inv.result.failed {
+			failures++
+		}
+	}
+	title := group.description
+	if title == "" {
+		title = fmt.Sprintf("%d invocations", len(group.invocations))
+	}
+	status := fmt.Sprintf("%d/%d done", done, len(group.invocations))
+	if failures > 0 {
+		status += ", " + failed.Render(fmt.Sprintf("%d failed", failures))
+	}
+	header := fmt.Sprintf("▸ Batch: %s (%s) %s", title, status, faint.Render("(/batch to expand)"))
+	if m.batchesExpanded {
+		header = fmt.Sprintf("▾ Batch: %s (%s) %s", title, status, faint.Render("(/batch to collapse)"))
+	}
+
+	lines := []string{header}
+	for _, inv := range group.invocations {
+		icon, duration := faint.Render("○"), ""
+		if inv.done {
+			icon = ok.Render("✓")
+			if inv.result.failed {
+				icon = failed.Render("✗")
+			}
+			duration = " " + faint.Render(formatDuration(inv.result.duration))
+		}
+		lines = append(lines, fmt.Sprintf("  %s %s(%s)%s", icon, inv.tool, inv.params, duration))
+		if !m.batchesExpanded || !inv.done {
+			continue
+		}
+		output := strings.TrimPrefix(inv.result.output, inv.tool+": ")
+		for _, chunk := range chunkOutput(strings.TrimRight(output, "\n"), 4) {
+			for _, line := range strings.Split(chunk, "\n") {
+				lines = append(lines, "      "+line)
+			}
+		}
+	}
+	if m.batchesExpanded && group.output > 0 {
+		lines = append(lines, faint.Render(fmt.Sprintf("  /open %d for the full output", group.output)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// dropBatches forgets the groups of outputs removed from the transcript
+func (m *chatModel) dropBatches() {
+	kept := m.batches[:0]
+	for _, group := range m.batches {
+		if group.index < len(m.outputs) {
+			kept = append(kept, group)
+		}
+	}
+	m.batches = kept
+}
+
+// batchHandler expands or collapses the groups of the Batches of the transcript
+func batchHandler(m *chatModel) error {
+	if len(m.batches) == 0 {
+		return fmt.Errorf("no Batch in this session yet")
+	}
+	m.batchesExpanded = !m.batchesExpanded
+	for _, group := range m.batches {
+		m.outputs[group.index] = m.renderBatch(group)
+	}
+	return nil
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// maxChangesTranscript is the number of trailing transcript characters sent
+// to the cheap model when summarizing the changes of the session
+const maxChangesTranscript = 16000
+
+// maxSummaryCommands is the number of commands listed in a session summary
+const maxSummaryCommands = 30
+
+// sessionSummaryTimeout bounds the time spent writing the session summary
+// with /summary
+const sessionSummaryTimeout = 90 * time.Second
+
+// sessionEndTimeout bounds the time spent collecting the follow-ups and
+// writing the summary when the session ends, so that exiting stays quick
+const sessionEndTimeout = 20 * time.Second
+
+// sessionID identifies the session in .aicode/sessions
+var sessionID = time.Now().Format("20060102-150405")
+
+// testCommand matches the commands that run a test suite
+var testCommand = regexp.MustCompile(`\b(go test|cargo test|pytest|tox|jest|vitest|(npm|yarn|pnpm|bun)( run)? test|make (test|check)|mvn test|gradle test)\b`)
+
+// sessionCommand is a command run by the Bash tool
+type sessionCommand struct {
+	Command string
+	Failed  bool
+}
+
+// sessionActivity records what the tools did during the session, for its summary
+type sessionActivity struct {
+	mu          sync.Mutex
+	commands    []sessionCommand
+	calls       map[string]string // Description of each tool call by id
+	failedTools []string          // Failed tool calls and their errors
+}
+
+// GlobalSessionActivity is the application-wide session activity
+var GlobalSessionActivity = &sessionActivity{}
+
+// RecordCommand remembers a command run by the Bash tool
+func (a *sessionActivity) RecordCommand(command string, failed bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.commands = append(a.commands, sessionCommand{Command: command, Failed: failed})
+}
+
+// RecordEvent remembers the tool calls of the session answered with an error
+func (a *sessionActivity) RecordEvent(event streamEvent) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	switch event.Type {
+	case "tool_call":
+		if a.calls == nil {
+			a.calls = map[string]string{}
+		}
+		a.calls[event.ID] = describeToolCall(event.Name, event.Input)
+	case "tool_result":
+		if strings.HasPrefix(event.Output, "Error") {
+			output := strings.Join(strings.Fields(event.Output), " ")
+			if len(output) > 300 {
+				output = output[:300] + "..."
+			}
+			a.failedTools = append(a.failedTools, fmt.Sprintf("- %s: %s", a.calls[event.ID], output))
+		}
+	}
+}
+
+// FailedTools lists the failed tool calls of the session, oldest first
+func (a *sessionActivity) FailedTools() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]string(nil), a.failedTools...)
+}
+
+// Commands returns the commands run during the session, oldest first
+func (a *sessionActivity) Commands() []sessionCommand {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]sessionCommand(nil), a.commands...)
+}
+
+// testsStatus describes the result of the last test command of the session
+func testsStatus(commands []sessionCommand) string {
+	for i := len(commands) - 1; i >= 0; i-- {
+		if !testCommand.MatchString(commands[i].Command) {
+			continue
+		}
+		if commands[i].Failed {
+			return fmt.Sprintf("Failing: the last test run `%s` failed", commands[i].Command)
+		}
+		return fmt.Sprintf("Passing: the last test run `%s` succeeded", commands[i].Command)
+	}
+	return "Not run during the session"
+}
+
+// sessionSummary builds the change summary of the session: an overview
+// written by the cheap model, the changed files, the commands and the tests status
+func sessionSummary(ctx context.Context, llm Llm, config Config, title string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Session %s", sessionID)
+	if title != "" {
+		fmt.Fprintf(&b, ": %s", title)
+	}
+	b.WriteString("\n\n")
+
+	overview, err := quickCompletion(ctx, config, changesPrompt, conversationTranscript(llm, maxChangesTranscript))
+	if err != nil {
+		overview = fmt.Sprintf("(overview unavailable: %v)", err)
+	}
+	if overview = strings.TrimSpace(overview); overview != "" && overview != "NONE" {
+		b.WriteString(overview + "\n\n")
+	}
+
+	b.WriteString("### Files changed\n\n")
+	b.WriteString(GlobalChangeLedger.Markdown())
+
+	b.WriteString("\n### Commands run\n\n")
+	commands := GlobalSessionActivity.Commands()
+	if len(commands) == 0 {
+		b.WriteString("None\n")
+	}
+	if len(commands) > maxSummaryCommands {
+		fmt.Fprintf(&b, "- ... %d earlier commands\n", len(commands)-maxSummaryCommands)
+	}
+	for _, command := range commands[max(len(commands)-maxSummaryCommands, 0):] {
+		line := strings.Join(strings.Fields(command.Command), " ")
+		if len(line) > 200 {
+			line = line[:200] + "..."
+		}
+		if command.Failed {
+			fmt.Fprintf(&b, "- `%s` (failed)\n", line)
+		} else {
+			fmt.Fprintf(&b, "- `%s`\n", line)
+		}
+	}
+
+	b.WriteString("\n### Tests\n\n" + testsStatus(commands) + "\n")
+	if notes := sessionNotes(); len(notes) > 0 {
+		b.WriteString("\n### Notes\n\n" + notesMarkdown(notes))
+	}
+	return b.String()
+}
+
+// sessionSummaryPath returns the file the summary of the session is written to
+func sessionSummaryPath() string {
+	return filepath.Join(".aicode", "sessions", sessionID, "SUMMARY.md")
+}
+
+// writeSessionSummary writes the summary to .aicode/sessions/<id>/SUMMARY.md
+func writeSessionSummary(summary string) (string, error) {
+	path := sessionSummaryPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path, []byte("# Session summary\n\n"+summary), 0644); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// appendSummaryToPR adds the summary to the description of the pull request
+// of the current branch, replacing the one added earlier in the session
+func appendSummaryToPR(ctx context.Context, summary string) (string, error) {
+	if _, err := exec.LookPath("gh"); err != nil {
+		return "", errors.New("gh is not installed")
+	}
+	output, err := exec.CommandContext(ctx, "gh", "pr", "view", "--json", "body,url").Output()
+	if err != nil {
+		return "", fmt.Errorf("gh pr view: %v", commandError(err))
+	}
+	var pr struct {
+		Body string `json:"body"`
+		URL  string `json:"url"`
+	}
+	if err := json.Unmarshal(output, &pr); err != nil {
+		return "", fmt.Errorf("failed to parse gh output: %v", err)
+	}
+
+	begin := "<!-- aicode session " + sessionID + " -->"
+	end := "<!-- /aicode session -->"
+	body := pr.Body
+	if start := strings.Index(body, begin); start >= 0 {
+		if stop := strings.Index(body[start:], end); stop >= 0 {
+			body = body[:start] + body[start+stop+len(end):]
+		}
+	}
+	body = strings.TrimRight(body, "\n")
+	if body != "" {
+		body += "\n\n"
+	}
+	body += begin + "\n## Session summary\n\n" + summary + end + "\n"
+
+	cmd := exec.CommandContext(ctx, "gh", "pr", "edit", "--body-file", "-")
+	cmd.Stdin = strings.NewReader(body)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("gh pr edit: %v\n%s", err, output)
+	}
+	return pr.URL, nil
+}
+
+// Message carrying the result of /summary
+type sessionSummaryMsg struct {
+	path  string
+	prURL string
+	err   error
+}
+
+// summaryHandler writes the change summary of the session, and appends it
+// to the pull request description with /summary pr
+func summaryHandler(m *chatModel) error {
+	args := m.commandArgs()
+	toPR := len(args) > 0 && args[0] == "pr"
+	if len(args) > 0 && !toPR {
+		return fmt.Errorf("usage: /summary [pr]")
+	}
+
+	m.outputs = append(m.outputs, "Summarizing the session...")
+	llm, config, title := m.llm, m.config, m.title
+	m.afterCmd = func() tea.Msg {
+		summaryCtx, cancel := context.WithTimeout(context.Background(), sessionSummaryTimeout)
+		defer cancel()
+		summary := sessionSummary(summaryCtx, llm, config, title)
+		path, err := writeSessionSummary(summary)
+		if err != nil || !toPR {
+			return sessionSummaryMsg{path: path, err: err}
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), ciTimeout)
+		defer cancel()
+		url, err := appendSummaryToPR(ctx, summary)
+		return sessionSummaryMsg{path: path, prURL: url, err: err}
+	}
+	return nil
+}
+
+// handleSessionSummary reports the result of /summary
+func (m *chatModel) handleSessionSummary(msg sessionSummaryMsg) {
+	if msg.path != "" {
+		m.outputs = append(m.outputs, "Session summary written to "+msg.path)
+	}
+	if msg.prURL != "" {
+		m.outputs = append(m.outputs, "Session summary added to "+msg.prURL)
+	}
+	if msg.err != nil {
+		m.outputs = append(m.outputs, fmt.Sprintf("Failed to write the session summary: %v", msg.err))
+	}
+}
+
+// saveSessionSummary writes the summary of a session that modified files
+// when it ends, and appends it to the pull request with summary_to_pr
+func saveSessionSummary(ctx context.Context, llm Llm, config Config, title string) {
+	if len(GlobalChangeLedger.Changes()) == 0 {
+		return
+	}
+
+	summary := sessionSummary(ctx, llm, config, title)
+	path, err := writeSessionSummary(summary)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write the session summary: %v\n", err)
+		return
+	}
+	fmt.Println("Session summary written to " + path)
+
+	if config.SummaryToPR {
+		ctx, cancel := context.WithTimeout(context.Background(), ciTimeout)
+		defer cancel()
+		url, err := appendSummaryToPR(ctx, summary)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to add the session summary to the pull request: %v\n", err)
+			return
+		}
+		fmt.Println("Session summary added to " + url)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// maxCILogLines is the number of trailing log lines attached by /ci
+const maxCILogLines = 400
+
+// ciTimeout bounds the time spent downloading CI logs
+const ciTimeout = 60 * time.Second
+
+// Message carrying the logs of the latest failing CI run
+type ciLogsMsg struct {
+	source string
+	logs   string
+	err    error
+}
+
+// ghRun is a workflow run as listed by gh run list --json
+type ghRun struct {
+	DatabaseID   int64  `json:"databaseId"`
+	DisplayTitle string `json:"displayTitle"`
+	WorkflowName string `json:"workflowName"`
+	URL          string `json:"url"`
+}
+
+// ciHandler fetches the logs of the latest failing CI run of the current
+// branch and attaches them to the next message
+func ciHandler(m *chatModel) error {
+	branch, err := gitOutput("branch", "--show-current")
+	if err != nil {
+		return err
+	}
+	if branch == "" {
+		return errors.New("not on a branch")
+	}
+	if m.config.CiLogsCommand == "" {
+		if _, err := exec.LookPath("gh"); err != nil {
+			return errors.New("gh is not installed, install it or set ci_logs_command in the profile")
+		}
+	}
+
+	m.outputs = append(m.outputs, "Fetching CI logs for "+branch+"...")
+	config := m.config
+	m.afterCmd = func() tea.Msg {
+		ctx, cancel := context.WithTimeout(context.Background(), ciTimeout)
+		defer cancel()
+		source, logs, err := fetchCILogs(ctx, config, branch)
+		return ciLogsMsg{source: source, logs: logs, err: err}
+	}
+	return nil
+}
+
+// fetchCILogs returns a description of the failing run and its logs, using
+// ci_logs_command when configured and the GitHub CLI otherwise
+func fetchCILogs(ctx context.Context, config Config, branch string) (string, string, error) {
+	if config.CiLogsCommand != "" {
+		cmd := exec.CommandContext(ctx, "bash", "-c", config.CiLogsCommand)
+		cmd.Env = append(cmd.Environ(), "AICODE_BRANCH="+branch)
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			return "", "", fmt.Errorf("ci_logs_command failed: %v\n%s", err, output)
+		}
+		return config.CiLogsCommand, string(output), nil
+	}
+
+	output, err := exec.CommandContext(ctx, "gh", "run", "list", "--branch", branch, "--status", "failure",
+		"--limit", "1", "--json", "databaseId,displayTitle,workflowName,url").Output()
+	if err != nil {
+		return "", "", fmt.Errorf("gh run list: %v", commandError(err))
+	}
+	var runs []ghRun
+	if err := json.Unmarshal(output, &runs); err != nil {
+		return "", "", fmt.Errorf("failed to parse gh output: %v", err)
+	}
+	if len(runs) == 0 {
+		return "", "", fmt.Errorf("no failing CI run on %s", branch)
+	}
+	run := runs[0]
+
+	output, err = exec.CommandContext(ctx, "gh", "run", "view", fmt.Sprint(run.DatabaseID), "--log-failed").Output()
+	if err != nil {
+		return "", "", fmt.Errorf("gh run view: %v", commandError(err))
+	}
+	return fmt.Sprintf("%s: %s (%s)", run.WorkflowName, run.DisplayTitle, run.URL), string(output), nil
+}
+
+// commandError includes the stderr of a failed command in its error
+func commandError(err error) error {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(exitErr.Stderr)))
+	}
+	return err
+}
+
+// tailLines keeps the last n lines of s
+func tailLines(s string, n int) (string, int) {
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	if len(lines) <= n {
+		return strings.Join(lines, "\n"), 0
+	}
+	return strings.Join(lines[len(lines)-n:], "\n"), len(lines) - n
+}
+
+// handleCILogs attaches the fetched logs to the next message
+func (m *chatModel) handleCILogs(msg ciLogsMsg) {
+	if msg.err != nil {
+		m.outputs = append(m.outputs, fmt.Sprintf("Failed to fetch CI logs: %v", msg.err))
+		return
+	}
+
+	logs, dropped := tailLines(stripAnsi(msg.logs), maxCILogLines)
+	if dropped > 0 {
+		logs = fmt.Sprintf("... [%d earlier lines omitted]\n%s", dropped, logs)
+	}
+	m.attachedContext = append(m.attachedContext,
+		fmt.Sprintf("<ci_logs source=%q>\n%s\n</ci_logs>", msg.source, logs))
+	m.outputs = append(m.outputs, fmt.Sprintf("[CI logs attached: %s, %d lines]", msg.source, strings.Count(logs, "\n")+1))
+}
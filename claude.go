@@ -3,23 +3,25 @@ package main
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"log/slog"
 	"net/http"
-	"os"
+	"slices"
 	"strings"
 )
 
 type claudeRequest struct {
-	Model       string                `json:"model"`
-	Messages    []claudeMessage       `json:"messages"`
-	System      []claudeSystemMessage `json:"system,omitempty"`
-	Tools       []claudeTool          `json:"tools,omitempty"`
-	MaxTokens   int                   `json:"max_tokens"`
-	Temperature float64               `json:"temperature,omitempty"`
+	Model         string                `json:"model"`
+	Messages      []claudeMessage       `json:"messages"`
+	System        []claudeSystemMessage `json:"system,omitempty"`
+	Tools         []claudeTool          `json:"tools,omitempty"`
+	MaxTokens     int                   `json:"max_tokens"`
+	Temperature   *float64              `json:"temperature,omitempty"`
+	TopP          *float64              `json:"top_p,omitempty"`
+	StopSequences []string              `json:"stop_sequences,omitempty"`
 }
 
 type claudeCacheControl struct {
@@ -46,13 +48,20 @@ type claudeSystemMessage struct {
 }
 
 type claudeContentBlock struct {
-	Type      string          `json:"type"`
-	Text      string          `json:"text,omitempty"`
-	Name      string          `json:"name,omitempty"`
-	ID        string          `json:"id,omitempty"`
-	Input     json.RawMessage `json:"input,omitempty"`
-	ToolUseID string          `json:"tool_use_id,omitempty"`
-	Content   string          `json:"content,omitempty"`
+	Type      string             `json:"type"`
+	Text      string             `json:"text,omitempty"`
+	Name      string             `json:"name,omitempty"`
+	ID        string             `json:"id,omitempty"`
+	Input     json.RawMessage    `json:"input,omitempty"`
+	ToolUseID string             `json:"tool_use_id,omitempty"`
+	Content   string             `json:"content,omitempty"`
+	Source    *claudeImageSource `json:"source,omitempty"`
+}
+
+type claudeImageSource struct {
+	Type      string `json:"type"`
+	MediaType string `json:"media_type"`
+	Data      string `json:"data"`
 }
 
 type claudeResponse struct {
@@ -72,29 +81,14 @@ type claudeResponse struct {
 	} `json:"error,omitempty"`
 }
 
-// loadClaudeTools loads tools using the schema constants defined in tools.go
-func loadClaudeTools() []claudeTool {
+// loadClaudeTools loads the schemas of the given tools, defined in tools.go
+func loadClaudeTools(toolNames []string) []claudeTool {
 	var toolsList []claudeTool
-
-	// Process each tool
-	for toolName, toolInfo := range ToolData {
-		// Parse the JSON schema
-		var toolSchema struct {
-			Name        string          `json:"name"`
-			Description string          `json:"description"`
-			Parameters  json.RawMessage `json:"parameters"`
-		}
-
-		err := json.Unmarshal([]byte(toolInfo.Schema), &toolSchema)
-		if err != nil {
-			slog.Error("Failed to unmarshal tool schema", "tool", toolName, "error", err)
-			os.Exit(1)
-		}
-
+	for _, toolName := range toolNames {
 		toolsList = append(toolsList, claudeTool{
-			Name:        toolSchema.Name,
-			Description: toolInfo.Description, // Use the markdown description
-			InputSchema: toolSchema.Parameters,
+			Name:        toolName,
+			Description: ToolData[toolName].Description,
+			InputSchema: toolSchema(toolName, toolDialectAnthropic),
 		})
 	}
 
@@ -117,17 +111,18 @@ func (c *Claude) Inference(ctx context.Context, prompt string) (InferenceRespons
 // inferenceWithRetry handles the actual inference with optional retry for rate limiting
 func (c *Claude) inferenceWithRetry(ctx context.Context, isRetry bool) (InferenceResponse, error) {
 	// Check if we need to summarize the conversation
+	c.countContextTokens(ctx)
 	if c.shouldSummarizeConversation() || isRetry {
 		slog.Debug("Context usage approaching limit. Summarizing conversation...")
 		beforeCount := len(c.conversationHistory)
-		beforeTokens := c.InputTokens
+		beforeTokens := c.currentContextTokens()
 
 		err := c.summarizeConversation()
 		if err != nil {
 			slog.Warn("Failed to summarize conversation", "error", err)
 		} else {
 			afterCount := len(c.conversationHistory)
-			afterTokens := c.InputTokens
+			afterTokens := c.currentContextTokens()
 			reductionPercent := 100 - (float64(afterTokens) * 100 / float64(beforeTokens))
 			slog.Debug("Conversation summarized",
 				"beforeCount", beforeCount,
@@ -153,51 +148,65 @@ func (c *Claude) inferenceWithRetry(ctx context.Context, isRetry bool) (Inferenc
 		MaxTokens: c.MaxTokens,
 	}
 
-	// Create request
-	bodyBytes, _ := json.Marshal(&reqBody)
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(bodyBytes))
-	if err != nil {
-		return InferenceResponse{}, err
-	}
+	reqBody.Temperature = c.Config.Temperature
+	reqBody.TopP = c.Config.TopP
+	reqBody.StopSequences = c.Config.Stop
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("x-api-key", c.Config.ApiKey)
-	req.Header.Set("anthropic-version", "2023-06-01")
+	// Add the verbosity instruction after the cached system prompt
+	if instruction, ok := verbosityInstructions[c.Config.Verbosity]; ok {
+		reqBody.System = append(append([]claudeSystemMessage{}, c.systemMessages...), claudeSystemMessage{Type: "text", Text: instruction})
+	}
 
-	// Use the context for cancellation
-	req = req.WithContext(ctx)
+	// Create request
+	bodyBytes, _ := json.Marshal(&reqBody)
 
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return InferenceResponse{}, err
+	// Prune the largest earlier tool results of a request the provider would refuse
+	if limit := maxRequestBytes(c.Config.Model, c.Config); len(bodyBytes) > limit {
+		pruned := pruneLargestToolResults(c.historicalToolResults(), len(bodyBytes)-limit)
+		if len(pruned) == 0 {
+			return InferenceResponse{}, &requestTooLargeError{Bytes: len(bodyBytes), Limit: limit}
+		}
+		slog.Warn("Pruned tool results to fit the request size limit", "size", len(bodyBytes), "limit", limit, "pruned", strings.Join(pruned, ", "))
+		return c.inferenceWithRetry(ctx, isRetry)
 	}
-	defer resp.Body.Close()
 
-	// Check for rate limit error (HTTP 429)
-	if resp.StatusCode == 429 && !isRetry {
-		slog.Debug("Received rate limit (429) error. Summarizing conversation and retrying...")
+	// Compact or refuse before uploading a request the model cannot accept
+	if err := checkContextFits(bodyBytes, c.Config.Model, c.ContextWindowSize, c.MaxTokens); err != nil {
+		if isRetry {
+			return InferenceResponse{}, err
+		}
+		slog.Debug("Request exceeds context window. Summarizing conversation...", "error", err)
 		return c.inferenceWithRetry(ctx, true)
 	}
 
-	body, _ := io.ReadAll(resp.Body)
+	resp, body, err := postWithRetry(ctx, c.Config, "Claude", func() (*http.Request, error) {
+		return c.newRequest(url, bodyBytes)
+	})
+	if err != nil {
+		return InferenceResponse{}, err
+	}
 
 	var out claudeResponse
 	if err := json.Unmarshal(body, &out); err != nil {
-		return InferenceResponse{}, fmt.Errorf("error unmarshaling response: %v\nResponse body: %s", err, string(body))
+		return InferenceResponse{}, newAPIError("Claude", resp, body, "")
 	}
 
 	if out.Error != nil {
-		// Check if the error is about rate limiting and we haven't retried yet
-		slog.Error("Inference error", "url", url, "error", out.Error.Message)
+		// Rate limits answered with another status were not retried yet,
+		// a shorter conversation may fit the limit
 		if (strings.Contains(strings.ToLower(out.Error.Message), "rate limit") ||
-			strings.Contains(strings.ToLower(out.Error.Message), "too many requests")) && !isRetry {
+			strings.Contains(strings.ToLower(out.Error.Message), "too many requests")) && !isRetry && !retryableStatus(resp.StatusCode) {
 			slog.Debug("Received rate limit error in response. Summarizing conversation and retrying...")
 			return c.inferenceWithRetry(ctx, true)
 		}
-		return InferenceResponse{}, errors.New(out.Error.Message)
+		return InferenceResponse{}, newAPIError("Claude", resp, body, out.Error.Message)
+	}
+	if resp.StatusCode/100 != 2 {
+		return InferenceResponse{}, newAPIError("Claude", resp, body, "")
 	}
 
 	// Accumulate token usage
+	costBefore := c.CalculatePrice()
 	c.InputTokens += out.Usage.InputTokens
 	c.TotalInputTokens += out.Usage.InputTokens
 	c.OutputTokens += out.Usage.OutputTokens
@@ -211,6 +220,9 @@ func (c *Claude) inferenceWithRetry(ctx context.Context, isRetry bool) (Inferenc
 		c.CacheReadInputTokens += out.Usage.CacheReadInputTokens
 		c.CachedInputTokens += out.Usage.CacheReadInputTokens
 	}
+	recordUsage(c.Config.Model, out.Usage.InputTokens, out.Usage.CacheReadInputTokens,
+		out.Usage.OutputTokens, c.CalculatePrice()-costBefore)
+	GlobalKeys.Record(requestKey(resp.Request), out.Usage.InputTokens, out.Usage.OutputTokens, c.CalculatePrice()-costBefore)
 
 	// Process the response into our unified format and build our response
 	response := InferenceResponse{
@@ -260,11 +272,26 @@ func (c *Claude) inferenceWithRetry(ctx context.Context, isRetry bool) (Inferenc
 			Content: assistantContent,
 		})
 	}
+	c.contextCount = contextCount{
+		tokens:   out.Usage.InputTokens + out.Usage.CacheReadInputTokens + out.Usage.CacheCreationInputTokens + out.Usage.OutputTokens,
+		messages: len(c.conversationHistory),
+	}
 
 	return response, nil
 }
 
-// Function removed since it was unused
+// newRequest builds a request to the Anthropic API
+func (c *Claude) newRequest(url string, body []byte) (*http.Request, error) {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("x-api-key", c.Config.ApiKey)
+	req.Header.Set("anthropic-version", "2023-06-01")
+	setCustomHeaders(req, c.Config.Headers)
+	return req, nil
+}
 
 // Claude struct implements Llm interface
 type Claude struct {
@@ -279,27 +306,94 @@ type Claude struct {
 	InputPricePerMillion       float64         // Price per million input tokens
 	CachedInputPricePerMillion float64         // Price per million cached input tokens
 	OutputPricePerMillion      float64         // Price per million output tokens
+	unpriced                   bool            // Model without a price in the registry or the profile
 	Config                     Config          // Configuration
 	ContextWindowSize          int             // Maximum context window size in tokens
+	contextCount               contextCount    // Size of the conversation in the last response
 	conversationHistory        []claudeMessage // Internal conversation history
 	systemMessages             []claudeSystemMessage
 	tools                      []claudeTool
 	MaxTokens                  int
+	pendingImages              []claudeContentBlock // Images attached to the next user message
+	restored                   restoredUsage        // Usage of the previous session and earlier models, part of the totals
+}
+
+// RefreshSystemPrompt rebuilds the system prompt from the current environment
+func (c *Claude) RefreshSystemPrompt() {
+	c.systemMessages[0].Text = GetSystemPrompt(c.Config)
 }
 
 func (c *Claude) Clear() {
 	c.conversationHistory = make([]claudeMessage, 0)
+	c.contextCount = contextCount{}
+}
+
+// UserMessages returns the prompts entered by the user still present in the history
+func (c *Claude) UserMessages() []string {
+	var messages []string
+	for _, msg := range c.conversationHistory {
+		if content, ok := claudeUserPrompt(msg); ok {
+			messages = append(messages, content)
+		}
+	}
+	return messages
+}
+
+// Rewind drops the history starting at the user message with the given index
+func (c *Claude) Rewind(index int) {
+	n := 0
+	for i, msg := range c.conversationHistory {
+		if _, ok := claudeUserPrompt(msg); ok {
+			if n == index {
+				c.conversationHistory = c.conversationHistory[:i]
+				c.contextCount = contextCount{}
+				return
+			}
+			n++
+		}
+	}
+}
+
+// claudeUserPrompt returns the text of a message entered by the user, as
+// opposed to tool results which are also sent with the user role
+func claudeUserPrompt(msg claudeMessage) (string, bool) {
+	if msg.Role != "user" {
+		return "", false
+	}
+	if content, ok := msg.Content.(string); ok {
+		return content, true
+	}
+	blocks, ok := msg.Content.([]claudeContentBlock)
+	if !ok {
+		return "", false
+	}
+	var text string
+	for _, block := range blocks {
+		if block.Type == "tool_result" {
+			return "", false
+		}
+		text += block.Text
+	}
+	return text, true
 }
 
 // shouldSummarizeConversation checks if the conversation needs to be summarized
-// based on the actual token usage compared to the context window size
+// based on the tokens of the current history compared to the context window size
 func (c *Claude) shouldSummarizeConversation() bool {
-	// Use the actual token count from previous API calls
-	usedTokens := c.InputTokens
-
 	// Check if we're using more than 80% of the context window
 	contextThreshold := int(float64(c.ContextWindowSize) * 0.8)
-	return usedTokens > contextThreshold
+	return c.currentContextTokens() > contextThreshold
+}
+
+// currentContextTokens returns the tokens the system prompt and the history take
+func (c *Claude) currentContextTokens() int {
+	return contextTokens(c.Config, c.contextCount, c.systemMessages, c.conversationHistory)
+}
+
+// remainingContext estimates the tokens left in the context window for the
+// history to grow, after the reserve for the response
+func (c *Claude) remainingContext() int {
+	return c.ContextWindowSize - c.MaxTokens - c.currentContextTokens()
 }
 
 // summarizeConversation creates a summary of the conversation history
@@ -310,10 +404,15 @@ func (c *Claude) summarizeConversation() error {
 		return nil
 	}
 
-	slog.Debug("Summarizing conversation...")
+	// Keep the last messages, with the tool calls and results they belong to
+	tail := claudeHistoryShape(c.conversationHistory).tailStart(compactKeptMessages)
+	if tail == 0 {
+		// Nothing older than the kept messages to summarize
+		return nil
+	}
+	lastMessages := c.conversationHistory[tail:]
 
-	// Save the last couple of messages to preserve context
-	lastMessages := c.conversationHistory[len(c.conversationHistory)-2:]
+	slog.Debug("Summarizing conversation...")
 
 	// Copy conversation for summarization request
 	summaryMessages := make([]claudeMessage, len(c.conversationHistory))
@@ -340,35 +439,28 @@ func (c *Claude) summarizeConversation() error {
 		Messages:    summaryMessages,
 		System:      systemMessages,
 		MaxTokens:   c.MaxTokens,
-		Temperature: 0.2, // Lower temperature for more consistent summaries
+		Temperature: floatPtr(0.2), // Lower temperature for more consistent summaries
 	}
 
 	// Create request
 	bodyBytes, _ := json.Marshal(&reqBody)
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(bodyBytes))
-	if err != nil {
-		return err
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("x-api-key", c.Config.ApiKey)
-	req.Header.Set("anthropic-version", "2023-06-01")
-
-	resp, err := http.DefaultClient.Do(req)
+	resp, body, err := postWithRetry(context.Background(), c.Config, "Claude", func() (*http.Request, error) {
+		return c.newRequest(url, bodyBytes)
+	})
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
-	body, _ := io.ReadAll(resp.Body)
 
 	var out claudeResponse
 	if err := json.Unmarshal(body, &out); err != nil {
-		return fmt.Errorf("error unmarshaling response: %v", err)
+		return newAPIError("Claude", resp, body, "")
 	}
 
 	if out.Error != nil {
-		fmt.Printf("Inference error: url=%s, error=%s\n", url, out.Error.Message)
-		return errors.New(out.Error.Message)
+		return newAPIError("Claude", resp, body, out.Error.Message)
+	}
+	if resp.StatusCode/100 != 2 {
+		return newAPIError("Claude", resp, body, "")
 	}
 
 	// Extract the summary text
@@ -397,48 +489,13 @@ func (c *Claude) summarizeConversation() error {
 		},
 	}
 
-	// Check if last message is a tool result that needs its corresponding tool call
-	toolCallNeeded := false
-	var toolUseID string
-
-	// If we have at least 1 message and it's a user message
-	if len(lastMessages) > 0 && lastMessages[len(lastMessages)-1].Role == "user" {
-		// Check if it's a tool result message
-		if blocks, ok := lastMessages[len(lastMessages)-1].Content.([]claudeContentBlock); ok {
-			for _, block := range blocks {
-				if block.Type == "tool_result" {
-					toolCallNeeded = true
-					toolUseID = block.ToolUseID
-				}
-			}
-		}
-	}
-
-	// If we need to find a matching tool call, look through history
-	if toolCallNeeded {
-		// Find the corresponding tool call
-		for i := len(c.conversationHistory) - 3; i >= 0; i-- {
-			if c.conversationHistory[i].Role == "assistant" {
-				if blocks, ok := c.conversationHistory[i].Content.([]claudeContentBlock); ok {
-					for _, block := range blocks {
-						if block.Type == "tool_use" && block.ID == toolUseID {
-							// Found the matching tool call, include it in preserved messages
-							lastMessages = append([]claudeMessage{c.conversationHistory[i]}, lastMessages...)
-							break
-						}
-					}
-				}
-			}
-			// Once we found the tool call, stop searching
-			if len(lastMessages) > 2 {
-				break
-			}
-		}
-	}
-
-	// Add back the last messages
+	// Add back the last messages, an invalid history would be rejected by every later request
 	newConversation = append(newConversation, lastMessages...)
+	if err := claudeHistoryShape(newConversation).validate(); err != nil {
+		return fmt.Errorf("keeping the full conversation, its summary would be invalid: %v", err)
+	}
 	c.conversationHistory = newConversation
+	c.contextCount = contextCount{}
 
 	// Calculate token stats before reset
 	inputTokensBefore := c.InputTokens
@@ -498,13 +555,14 @@ func (c *Claude) summarizeConversation() error {
 
 // CalculatePrice calculates the price for Claude API usage
 func (c *Claude) CalculatePrice() float64 {
-	// Calculate uncached input tokens
-	nonCachedInputTokens := c.TotalInputTokens - c.CachedInputTokens
+	// The tokens of a previous session are counted at the cost it saved
+	cachedInputTokens := c.CachedInputTokens - c.restored.cachedInput
+	nonCachedInputTokens := c.TotalInputTokens - c.restored.input - cachedInputTokens
 	nonCachedInputPrice := float64(nonCachedInputTokens) * c.InputPricePerMillion / 1000000.0
-	cachedInputPrice := float64(c.CachedInputTokens) * c.CachedInputPricePerMillion / 1000000.0
+	cachedInputPrice := float64(cachedInputTokens) * c.CachedInputPricePerMillion / 1000000.0
 	inputPrice := nonCachedInputPrice + cachedInputPrice
-	outputPrice := float64(c.TotalOutputTokens) * c.OutputPricePerMillion / 1000000.0
-	return inputPrice + outputPrice
+	outputPrice := float64(c.TotalOutputTokens-c.restored.output) * c.OutputPricePerMillion / 1000000.0
+	return c.restored.cost + inputPrice + outputPrice
 }
 
 // AddMessage adds a message to the conversation history
@@ -512,28 +570,116 @@ func (c *Claude) AddMessage(content string, role string) {
 	if content == "" {
 		return
 	}
+	if role == "user" && len(c.pendingImages) > 0 {
+		blocks := append(c.pendingImages, claudeContentBlock{Type: "text", Text: content})
+		c.pendingImages = nil
+		c.conversationHistory = append(c.conversationHistory, claudeMessage{
+			Role:    role,
+			Content: blocks,
+		})
+		return
+	}
 	c.conversationHistory = append(c.conversationHistory, claudeMessage{
 		Role:    role,
 		Content: content,
 	})
 }
 
+// AttachImage attaches an image to the next user message
+func (c *Claude) AttachImage(mediaType string, data []byte) {
+	c.pendingImages = append(c.pendingImages, claudeContentBlock{
+		Type: "image",
+		Source: &claudeImageSource{
+			Type:      "base64",
+			MediaType: mediaType,
+			Data:      base64.StdEncoding.EncodeToString(data),
+		},
+	})
+}
+
 // AddToolResult adds a tool result to the conversation history
 func (c *Claude) AddToolResult(toolUseID string, result string) {
 	if result == "" {
 		result = "No result"
 	}
+	images := GlobalToolImages.Take(result)
+	result = guardToolResult(result, c.remainingContext(), c.Config.ToolResultShare)
 
-	c.conversationHistory = append(c.conversationHistory, claudeMessage{
-		Role: "user",
-		Content: []claudeContentBlock{
-			{
-				Type:      "tool_result",
-				ToolUseID: toolUseID,
-				Content:   result,
-			},
+	blocks := []claudeContentBlock{
+		{
+			Type:      "tool_result",
+			ToolUseID: toolUseID,
+			Content:   result,
 		},
-	})
+	}
+	// Images read by the tool follow the results in the same message
+	for _, image := range images {
+		blocks = append(blocks, claudeContentBlock{
+			Type:   "image",
+			Source: &claudeImageSource{Type: "base64", MediaType: image.mediaType, Data: base64.StdEncoding.EncodeToString(image.data)},
+		})
+	}
+	c.appendToolResults(blocks)
+}
+
+// appendToolResults adds blocks starting with tool_result blocks to the user
+// message holding the results of the other tool calls of the same response,
+// as the API expects the results of parallel calls in one message, or else
+// to a new message. The results come first in the message, the other blocks
+// such as the images read by the tools after them.
+func (c *Claude) appendToolResults(blocks []claudeContentBlock) {
+	if n := len(c.conversationHistory); n > 0 && c.conversationHistory[n-1].Role == "user" {
+		previous, _ := c.conversationHistory[n-1].Content.([]claudeContentBlock)
+		if split := leadingToolResults(previous); split > 0 {
+			added := leadingToolResults(blocks)
+			c.conversationHistory[n-1].Content = slices.Concat(previous[:split], blocks[:added], previous[split:], blocks[added:])
+			return
+		}
+	}
+	c.conversationHistory = append(c.conversationHistory, claudeMessage{Role: "user", Content: blocks})
+}
+
+// leadingToolResults returns the number of tool_result blocks blocks start with
+func leadingToolResults(blocks []claudeContentBlock) int {
+	n := 0
+	for n < len(blocks) && blocks[n].Type == "tool_result" {
+		n++
+	}
+	return n
+}
+
+// historicalToolResults returns the tool results answering earlier tool
+// calls, those of the last response are kept whole for the model to read
+func (c *Claude) historicalToolResults() []toolResultRef {
+	last := -1
+	tools := map[string]string{}
+	for i, msg := range c.conversationHistory {
+		if msg.Role != "assistant" {
+			continue
+		}
+		last = i
+		if blocks, ok := msg.Content.([]claudeContentBlock); ok {
+			for _, block := range blocks {
+				if block.Type == "tool_use" {
+					tools[block.ID] = block.Name
+				}
+			}
+		}
+	}
+
+	var results []toolResultRef
+	for i := 0; i < last; i++ {
+		blocks, ok := c.conversationHistory[i].Content.([]claudeContentBlock)
+		if !ok {
+			continue
+		}
+		for j := range blocks {
+			if blocks[j].Type == "tool_result" {
+				results = append(results, toolResultRef{tool: tools[blocks[j].ToolUseID], id: blocks[j].ToolUseID, content: &blocks[j].Content})
+			}
+		}
+	}
+	return results
 }
 
 // GetFormattedHistory returns the conversation history formatted for display
@@ -563,6 +709,8 @@ func (c *Claude) GetFormattedHistory() []string {
 					outputs = append(outputs, fmt.Sprintf("%s [Tool Result: %s]", role, block.Content))
 				} else if block.Type == "tool_use" {
 					outputs = append(outputs, fmt.Sprintf("%s [Tool Use: %s]", role, block.Name))
+				} else if block.Type == "image" {
+					outputs = append(outputs, fmt.Sprintf("%s [Image]", role))
 				}
 			}
 		}
@@ -571,27 +719,149 @@ func (c *Claude) GetFormattedHistory() []string {
 	return outputs
 }
 
+// SetConfig replaces the configuration used for the next requests. When the
+// model changes, the tokens used so far keep the cost of the previous model.
+func (c *Claude) SetConfig(config Config) {
+	modelChanged := config.Model != c.Config.Model
+	if modelChanged {
+		c.restored = restoredUsage{
+			input:       c.TotalInputTokens,
+			cachedInput: c.CachedInputTokens,
+			output:      c.TotalOutputTokens,
+			cost:        c.CalculatePrice(),
+		}
+	}
+	c.Config = config
+	if modelChanged {
+		c.applyModel()
+	}
+}
+
+// SetToolSubset restricts the tools sent with the next requests, nil sends all enabled tools
+func (c *Claude) SetToolSubset(toolNames []string) {
+	c.tools = loadClaudeTools(activeTools(c.Config.EnabledTools, toolNames))
+}
+
 func (c *Claude) GetModel() string {
 	return c.Config.Model
 }
 
+// RestoreUsage adds the tokens used by a previous session to the session
+// totals, counted at the cost it saved rather than the prices of the model
+func (c *Claude) RestoreUsage(input, cachedInput, output int, cost float64) {
+	c.TotalInputTokens += input
+	c.CachedInputTokens += cachedInput
+	c.TotalOutputTokens += output
+	c.restored.input += input
+	c.restored.cachedInput += cachedInput
+	c.restored.output += output
+	c.restored.cost += cost
+}
+
+// History returns the conversation in the format shared by all providers
+func (c *Claude) History() []Message {
+	var messages []Message
+	for _, msg := range c.conversationHistory {
+		if content, ok := msg.Content.(string); ok {
+			messages = append(messages, Message{Role: msg.Role, Content: content})
+			continue
+		}
+		blocks, _ := msg.Content.([]claudeContentBlock)
+		var shared []ContentBlock
+		for _, block := range blocks {
+			if block.Type == "image" {
+				continue
+			}
+			shared = append(shared, ContentBlock{
+				Type:      block.Type,
+				Text:      block.Text,
+				ID:        block.ID,
+				Name:      block.Name,
+				Input:     block.Input,
+				ToolUseID: block.ToolUseID,
+				Content:   block.Content,
+			})
+		}
+		if len(shared) > 0 {
+			messages = append(messages, Message{Role: msg.Role, Content: shared})
+		}
+	}
+	return messages
+}
+
+// SetHistory replaces the conversation with one in the shared format
+func (c *Claude) SetHistory(messages []Message) {
+	c.conversationHistory = []claudeMessage{}
+	c.contextCount = contextCount{}
+	for _, msg := range messages {
+		if content, ok := msg.Content.(string); ok {
+			c.AddMessage(content, msg.Role)
+			continue
+		}
+		blocks, _ := msg.Content.([]ContentBlock)
+		var converted []claudeContentBlock
+		for _, block := range blocks {
+			// Claude refuses empty text blocks
+			if block.Type == "text" && block.Text == "" {
+				continue
+			}
+			// The input of a tool call must be an object, even when the other
+			// provider got unparsable arguments
+			input := block.Input
+			if block.Type == "tool_use" && !strings.HasPrefix(strings.TrimSpace(string(input)), "{") {
+				input = json.RawMessage("{}")
+			}
+			converted = append(converted, claudeContentBlock{
+				Type:      block.Type,
+				Text:      block.Text,
+				ID:        block.ID,
+				Name:      block.Name,
+				Input:     input,
+				ToolUseID: block.ToolUseID,
+				Content:   block.Content,
+			})
+		}
+		switch {
+		case len(converted) == 0:
+		case msg.Role == "user" && converted[0].Type == "tool_result":
+			// Providers answering each call in a message of its own are batched
+			c.appendToolResults(converted)
+		default:
+			c.conversationHistory = append(c.conversationHistory, claudeMessage{Role: msg.Role, Content: converted})
+		}
+	}
+}
+
+// ProviderInfo describes the provider, its model and the tokens used so far
+func (c *Claude) ProviderInfo() ProviderInfo {
+	return ProviderInfo{
+		Provider:          "anthropic",
+		Model:             c.Config.Model,
+		ContextWindow:     c.ContextWindowSize,
+		Price:             ModelPrice{Input: c.InputPricePerMillion, CachedInput: c.CachedInputPricePerMillion, Output: c.OutputPricePerMillion},
+		Priced:            !c.unpriced,
+		InputTokens:       c.InputTokens,
+		OutputTokens:      c.OutputTokens,
+		ContextTokens:     c.contextCount.tokens,
+		TotalInputTokens:  c.TotalInputTokens,
+		CachedInputTokens: c.CachedInputTokens,
+		TotalOutputTokens: c.TotalOutputTokens,
+	}
+}
+
 // NewClaude creates a new Claude provider
 func NewClaude(config Config) *Claude {
-	tools := loadClaudeTools()
-
-	return &Claude{
-		Config:                     config,
-		InputTokens:                0,
-		OutputTokens:               0,
-		CachedInputTokens:          0,
-		CacheCreationInputTokens:   0,
-		CacheReadInputTokens:       0,
-		InputPricePerMillion:       3.0, // $3 per million input tokens
-		CachedInputPricePerMillion: 3.75,
-		OutputPricePerMillion:      15.0, // $15 per million output tokens
-		ContextWindowSize:          200_000,
-		conversationHistory:        []claudeMessage{},
-		tools:                      tools,
+	tools := loadClaudeTools(activeTools(config.EnabledTools, nil))
+
+	c := &Claude{
+		Config:                   config,
+		InputTokens:              0,
+		OutputTokens:             0,
+		CachedInputTokens:        0,
+		CacheCreationInputTokens: 0,
+		CacheReadInputTokens:     0,
+		conversationHistory:      []claudeMessage{},
+		tools:                    tools,
 		systemMessages: []claudeSystemMessage{
 			{
 				Type:         "text",
@@ -599,6 +869,16 @@ func NewClaude(config Config) *Claude {
 				CacheControl: &claudeCacheControl{Type: "ephemeral"},
 			},
 		},
-		MaxTokens: 20_000,
 	}
+	c.applyModel()
+	return c
+}
+
+// applyModel sets the prices and limits of the model of the configuration
+func (c *Claude) applyModel() {
+	// Models missing from the registry only show their tokens
+	price, ok := modelPrice(c.Config, c.Config.Model)
+	c.unpriced = !ok
+	c.InputPricePerMillion, c.CachedInputPricePerMillion, c.OutputPricePerMillion = price.Input, price.CachedInput, price.Output
+	c.ContextWindowSize, c.MaxTokens = contextLimits(c.Config, c.Config.Model)
 }
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/charmbracelet/bubbles/textarea"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// pngSignature is the magic number every PNG file starts with
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// readClipboardImage returns the PNG image currently stored in the clipboard.
+// It relies on the platform clipboard tools since the terminal itself can only
+// paste text.
+func readClipboardImage() ([]byte, error) {
+	var candidates [][]string
+	switch runtime.GOOS {
+	case "darwin":
+		candidates = [][]string{
+			{"pngpaste", "-"},
+			{"osascript", "-e", "get the clipboard as «class PNGf»"},
+		}
+	default:
+		candidates = [][]string{
+			{"wl-paste", "--no-newline", "--type", "image/png"},
+			{"xclip", "-selection", "clipboard", "-t", "image/png", "-o"},
+		}
+	}
+
+	for _, args := range candidates {
+		if _, err := exec.LookPath(args[0]); err != nil {
+			continue
+		}
+		output, err := exec.Command(args[0], args[1:]...).Output()
+		if err != nil {
+			continue
+		}
+		if args[0] == "osascript" {
+			output = decodeAppleScriptData(output)
+		}
+		if bytes.HasPrefix(output, pngSignature) {
+			return output, nil
+		}
+	}
+
+	return nil, errors.New("no image found in clipboard")
+}
+
+// decodeAppleScriptData converts the «data PNGf89504E47...» literal printed by
+// osascript into raw bytes
+func decodeAppleScriptData(output []byte) []byte {
+	output = bytes.TrimSpace(output)
+	output = bytes.TrimPrefix(output, []byte("«data PNGf"))
+	output = bytes.TrimSuffix(output, []byte("»"))
+
+	data, err := hex.DecodeString(string(output))
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// Message carrying the image read from the clipboard, or why there is none
+type clipboardImageMsg struct {
+	data     []byte
+	path     string // Temp file holding the image, to open or reference it later
+	err      error
+	fromKeys bool // Ctrl+V, which pastes the clipboard text when there is no image
+}
+
+// pasteClipboardImage reads the clipboard image in the background, as the
+// clipboard tools may take a while to answer, and saves it to a temp file
+func pasteClipboardImage(fromKeys bool) tea.Cmd {
+	return func() tea.Msg {
+		data, err := readClipboardImage()
+		if err != nil {
+			return clipboardImageMsg{err: err, fromKeys: fromKeys}
+		}
+		path, err := saveClipboardImage(data)
+		return clipboardImageMsg{data: data, path: path, err: err}
+	}
+}
+
+// pastedImages lists the temp files of the images pasted in this session,
+// removed by removePastedImages when the UI exits
+var pastedImages struct {
+	sync.Mutex
+	paths []string
+}
+
+// saveClipboardImage writes a pasted image to a temp file and returns its path
+func saveClipboardImage(data []byte) (string, error) {
+	file, err := os.CreateTemp("", "aicode-paste-*.png")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp file: %v", err)
+	}
+	defer file.Close()
+	if _, err := file.Write(data); err != nil {
+		os.Remove(file.Name())
+		return "", fmt.Errorf("failed to write image: %v", err)
+	}
+	pastedImages.Lock()
+	pastedImages.paths = append(pastedImages.paths, file.Name())
+	pastedImages.Unlock()
+	return file.Name(), nil
+}
+
+// removePastedImages deletes the temp files of the pasted images, the
+// images themselves stay in the conversation as sent to the model
+func removePastedImages() {
+	pastedImages.Lock()
+	defer pastedImages.Unlock()
+	for _, path := range pastedImages.paths {
+		os.Remove(path)
+	}
+	pastedImages.paths = nil
+}
+
+// attachClipboardImage attaches the image read from the clipboard to the next
+// message sent to the model
+func (m *chatModel) attachClipboardImage(msg clipboardImageMsg) tea.Cmd {
+	switch {
+	case msg.err != nil && msg.fromKeys:
+		return textarea.Paste
+	case msg.err != nil:
+		m.outputs = append(m.outputs, fmt.Sprintf("Error executing command: %v", msg.err))
+	default:
+		m.llm.AttachImage("image/png", msg.data)
+		m.outputs = append(m.outputs, fmt.Sprintf("[Image attached: %s, %d KB]", msg.path, (len(msg.data)+1023)/1024))
+	}
+	return m.scheduleViewportUpdate()
+}
+
+// writeClipboard copies text to the clipboard with the platform clipboard tools
+func writeClipboard(text string) error {
+	var candidates [][]string
+	switch runtime.GOOS {
+	case "darwin":
+		candidates = [][]string{{"pbcopy"}}
+	default:
+		candidates = [][]string{
+			{"wl-copy"},
+			{"xclip", "-selection", "clipboard", "-i"},
+			{"xsel", "--clipboard", "--input"},
+		}
+	}
+
+	for _, args := range candidates {
+		if _, err := exec.LookPath(args[0]); err != nil {
+			continue
+		}
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Stdin = strings.NewReader(text)
+		if err := cmd.Run(); err != nil {
+			continue
+		}
+		return nil
+	}
+
+	return errors.New("no clipboard tool found, install wl-clipboard or xclip, or give a file path")
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// maxCmdTemplateChars is the length above which a custom command is
+// reported, about 10k tokens sent with every invocation
+const maxCmdTemplateChars = 40_000
+
+// lintCmdTemplate checks a custom command when it is loaded, so its problems
+// are listed in /help rather than found when it is invoked: the front
+// matter, the template syntax, variables a session doesn't set and the length
+func lintCmdTemplate(content string) []string {
+	var problems []string
+	if len(content) > maxCmdTemplateChars {
+		problems = append(problems, fmt.Sprintf("%d characters long, about %s tokens sent with every invocation",
+			len(content), formatTokenCount(len(content)/4)))
+	}
+
+	_, body, err := parseFrontMatter(content)
+	if err != nil {
+		return append(problems, err.Error())
+	}
+	tmpl, err := template.New("cmd").Parse(body)
+	if err != nil {
+		return append(problems, fmt.Sprintf("invalid template: %v", err))
+	}
+
+	fields := map[string]bool{}
+	if tmpl.Tree != nil {
+		templateFields(tmpl.Tree.Root, fields)
+	}
+	delete(fields, "ARGS")
+	var unknown []string
+	for field := range fields {
+		unknown = append(unknown, "{{."+field+"}}")
+	}
+	sort.Strings(unknown)
+	switch len(unknown) {
+	case 0:
+	case 1:
+		problems = append(problems, fmt.Sprintf("unknown variable %s, only {{.ARGS}} is set in a session", unknown[0]))
+	default:
+		problems = append(problems, fmt.Sprintf("unknown variables %s, only {{.ARGS}} is set in a session", strings.Join(unknown, ", ")))
+	}
+	return problems
+}
+
+// templateFields collects the names of the fields a template reads from its data
+func templateFields(node parse.Node, fields map[string]bool) {
+	switch node := node.(type) {
+	case *parse.ListNode:
+		if node == nil {
+			return
+		}
+		for _, child := range node.Nodes {
+			templateFields(child, fields)
+		}
+	case *parse.ActionNode:
+		templateFields(node.Pipe, fields)
+	case *parse.PipeNode:
+		if node == nil {
+			return
+		}
+		for _, cmd := range node.Cmds {
+			for _, arg := range cmd.Args {
+				templateFields(arg, fields)
+			}
+		}
+	case *parse.FieldNode:
+		fields[node.Ident[0]] = true
+	case *parse.IfNode:
+		templateFields(&node.BranchNode, fields)
+	case *parse.RangeNode:
+		templateFields(&node.BranchNode, fields)
+	case *parse.WithNode:
+		templateFields(&node.BranchNode, fields)
+	case *parse.BranchNode:
+		templateFields(node.Pipe, fields)
+		templateFields(node.List, fields)
+		templateFields(node.ElseList, fields)
+	case *parse.TemplateNode:
+		templateFields(node.Pipe, fields)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// fenceLanguagesByExt are the fence languages of file extensions, used to
+// guess the language of a block from the files the model worked on
+var fenceLanguagesByExt = map[string]string{
+	".go": "go", ".py": "python", ".js": "javascript", ".mjs": "javascript", ".jsx": "jsx",
+	".ts": "typescript", ".tsx": "tsx", ".rs": "rust", ".rb": "ruby", ".java": "java",
+	".kt": "kotlin", ".swift": "swift", ".c": "c", ".h": "c", ".cpp": "cpp", ".cc": "cpp",
+	".hpp": "cpp", ".cs": "csharp", ".php": "php", ".lua": "lua", ".sh": "bash", ".bash": "bash",
+	".zsh": "bash", ".yml": "yaml", ".yaml": "yaml", ".json": "json", ".toml": "toml",
+	".html": "html", ".css": "css", ".sql": "sql", ".md": "markdown", ".proto": "protobuf",
+}
+
+// fenceLanguageSignals are patterns typical of a language, each match
+// counting towards it when a block has no language
+var fenceLanguageSignals = []struct {
+	language string
+	pattern  *regexp.Regexp
+}{
+	{"go", regexp.MustCompile(`(?m)^package \w+$|^func (\(\w+ \*?\w+\) )?\w+\(|:= |if err != nil|fmt\.\w+\(`)},
+	{"python", regexp.MustCompile(`(?m)^\s*def \w+\(.*\):\s*$|^\s*(from \S+ )?import \w+|^\s*class \w+(\(.*\))?:\s*$|\bself\.\w+|^\s*elif |print\(`)},
+	{"rust", regexp.MustCompile(`(?m)^\s*(pub )?fn \w+|\blet mut\b|^\s*impl\b|^\s*use \w+::|\w+!\(`)},
+	{"javascript", regexp.MustCompile(`(?m)\b(const|let) \w+ = |=> \{|console\.log\(|require\(|^\s*function \w+\(`)},
+	// TypeScript also counts the JavaScript signals, so that it only wins with types
+	{"typescript", regexp.MustCompile(`(?m)\b(const|let) \w+ = |=> \{|console\.log\(|require\(|^\s*function \w+\(|^\s*(export )?(interface|type) \w+|: (string|number|boolean)\b`)},
+	{"java", regexp.MustCompile(`(?m)\bpublic (static )?(class|void)\b|System\.out\.`)},
+	{"c", regexp.MustCompile(`(?m)^#include [<"]|\bprintf\(|int main\(`)},
+	{"ruby", regexp.MustCompile(`(?m)^\s*(def \w+[?!]?$|end$|require ['"])|\bputs\b`)},
+	{"sql", regexp.MustCompile(`(?im)^\s*(select .+ from|insert into|update \w+ set|create (table|index)|delete from|alter table)\b`)},
+	{"html", regexp.MustCompile(`(?i)<!doctype html|</?(html|head|body|div|span|p|a|ul|li|script)\b[^>]*>`)},
+	{"dockerfile", regexp.MustCompile(`(?m)^(FROM \S+|RUN |COPY |WORKDIR |ENTRYPOINT |CMD \[)`)},
+	{"diff", regexp.MustCompile(`(?m)^(--- \S|\+\+\+ \S|@@ -\d)`)},
+	{"bash", regexp.MustCompile(`(?m)^\s*(\$ |#!/bin/(ba)?sh|(sudo|git|go|npm|npx|yarn|pnpm|pip|cd|ls|mkdir|export|echo|make|docker|kubectl|curl|brew|apt|cargo|aicode) )`)},
+}
+
+// yamlLine matches the lines of a YAML document
+var yamlLine = regexp.MustCompile(`^\s*(#.*|- .*|-|[\w.-]+:( [^;{}]*)?)$`)
+
+// codeBlock is a fenced code block of a response
+type codeBlock struct {
+	Language string
+	Code     string
+	Inferred bool // The language was guessed, the fence had none
+	line     int  // Line of the opening fence
+}
+
+// codeFence matches the opening or closing line of a fenced code block
+var codeFence = regexp.MustCompile("^(\\s*)(```+|~~~+)\\s*([^`\\s]*)")
+
+// codeBlocks returns the fenced code blocks of text, guessing the language
+// of blocks without one
+func codeBlocks(text string) []codeBlock {
+	var blocks []codeBlock
+	var current *codeBlock
+	var fence string
+	var lines []string
+	for i, line := range strings.Split(text, "\n") {
+		match := codeFence.FindStringSubmatch(line)
+		switch {
+		case current == nil && match != nil:
+			current, fence, lines = &codeBlock{Language: match[3], line: i}, match[2], nil
+		case current != nil && match != nil && strings.HasPrefix(match[2], fence) && match[3] == "":
+			current.Code = strings.Join(lines, "\n")
+			if current.Language == "" {
+				current.Language = inferFenceLanguage(current.Code, GlobalFileTracker.LastTouched())
+				current.Inferred = current.Language != ""
+			}
+			blocks = append(blocks, *current)
+			current = nil
+		case current != nil:
+			lines = append(lines, line)
+		}
+	}
+	return blocks
+}
+
+// labelCodeFences adds the guessed language to the fences of text that have none
+func labelCodeFences(text string) string {
+	if !strings.Contains(text, "```") && !strings.Contains(text, "~~~") {
+		return text
+	}
+	lines := strings.Split(text, "\n")
+	for _, block := range codeBlocks(text) {
+		if block.Inferred {
+			match := codeFence.FindStringSubmatch(lines[block.line])
+			lines[block.line] = match[1] + match[2] + block.Language + lines[block.line][len(match[0]):]
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// inferFenceLanguage guesses the language of a code block from its content,
+// falling back to the language of the file the model last worked on for
+// blocks that look like code but match no language
+func inferFenceLanguage(code, recentFile string) string {
+	trimmed := strings.TrimSpace(code)
+	if trimmed == "" {
+		return ""
+	}
+	if (trimmed[0] == '{' || trimmed[0] == '[') && json.Valid([]byte(trimmed)) {
+		return "json"
+	}
+	if isYAML(trimmed) {
+		return "yaml"
+	}
+
+	recent := fenceLanguagesByExt[strings.ToLower(filepath.Ext(recentFile))]
+	if filepath.Base(recentFile) == "Dockerfile" {
+		recent = "dockerfile"
+	}
+	best, bestScore := "", 0
+	for _, signal := range fenceLanguageSignals {
+		score := len(signal.pattern.FindAllStringIndex(code, -1))
+		// The language of the recent file wins ties
+		if score > bestScore || (score == bestScore && score > 0 && signal.language == recent) {
+			best, bestScore = signal.language, score
+		}
+	}
+	if best != "" {
+		return best
+	}
+	if strings.ContainsAny(code, "{}();=") {
+		return recent
+	}
+	return ""
+}
+
+// isYAML tells whether every line of a block looks like YAML, with at least one key
+func isYAML(code string) bool {
+	if !strings.Contains(code, ":") {
+		return false
+	}
+	for _, line := range strings.Split(code, "\n") {
+		if strings.TrimSpace(line) != "" && !yamlLine.MatchString(line) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// maxCommitDiff is the number of diff characters sent to generate a commit message
+const maxCommitDiff = 30000
+
+// commitFlow tracks /commit while it waits for the user
+type commitFlow struct {
+	awaitingStage bool // Asking whether to stage all changes
+	editing       bool // The generated message is in the input for editing
+}
+
+// Message carrying the generated commit message
+type commitMessageMsg struct {
+	message string
+	err     error
+}
+
+// commitHelp is shown in the status line while the commit message is edited
+const commitHelp = "Edit the commit message | enter commit, alt+enter new line, esc cancel"
+
+// gitOutput runs git with args and returns its trimmed output
+func gitOutput(args ...string) (string, error) {
+	output, err := exec.Command("git", args...).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %v\n%s", args[0], err, output)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// commitHandler starts /commit: it generates a message for the staged changes,
+// offering to stage everything when nothing is staged
+func commitHandler(m *chatModel) error {
+	staged, err := gitOutput("diff", "--cached")
+	if err != nil {
+		return err
+	}
+	if staged != "" {
+		m.startCommitMessage(staged)
+		return nil
+	}
+
+	status, err := gitOutput("status", "--porcelain")
+	if err != nil {
+		return err
+	}
+	if status == "" {
+		return errors.New("nothing to commit, working tree clean")
+	}
+
+	m.commit = &commitFlow{awaitingStage: true}
+	m.outputs = append(m.outputs, "No staged changes:\n"+status+"\nStage all changes with git add -A? (y/n)")
+	return nil
+}
+
+// startCommitMessage generates the commit message for diff in the background
+func (m *chatModel) startCommitMessage(diff string) {
+	m.commit = &commitFlow{}
+	m.outputs = append(m.outputs, "Generating commit message...")
+	if len(diff) > maxCommitDiff {
+		diff = diff[:maxCommitDiff] + "\n... [diff truncated]"
+	}
+
+	config := m.config
+	m.afterCmd = func() tea.Msg {
+		message, err := quickCompletion(context.Background(), config, defaultCommitPrompt, diff)
+		return commitMessageMsg{message: message, err: err}
+	}
+}
+
+// handleCommitMessage puts the generated message in the input for editing
+func (m *chatModel) handleCommitMessage(msg commitMessageMsg) {
+	if m.commit == nil {
+		return
+	}
+	if msg.err != nil {
+		m.commit = nil
+		m.outputs = append(m.outputs, fmt.Sprintf("Failed to generate commit message: %v", msg.err))
+		m.updateViewportContent()
+		return
+	}
+	m.commit.editing = true
+	m.textarea.SetValue(msg.message)
+}
+
+// handleCommitKey processes a key press while /commit waits for the user. It
+// returns false for keys that should reach the input.
+func (m *chatModel) handleCommitKey(msg tea.KeyMsg) (tea.Cmd, bool) {
+	if m.commit.awaitingStage {
+		switch msg.String() {
+		case "y":
+			if _, err := gitOutput("add", "-A"); err != nil {
+				m.commit = nil
+				m.outputs = append(m.outputs, err.Error())
+				break
+			}
+			staged, err := gitOutput("diff", "--cached")
+			if err != nil {
+				m.commit = nil
+				m.outputs = append(m.outputs, err.Error())
+				break
+			}
+			m.startCommitMessage(staged)
+		case "n", "esc":
+			m.commit = nil
+			m.outputs = append(m.outputs, "Commit canceled")
+		}
+		m.updateViewportContent()
+		cmd := m.afterCmd
+		m.afterCmd = nil
+		return cmd, true
+	}
+
+	if !m.commit.editing {
+		// Still generating the message, only allow canceling
+		if msg.Type == tea.KeyEsc {
+			m.commit = nil
+			m.outputs = append(m.outputs, "Commit canceled")
+			m.updateViewportContent()
+		}
+		return nil, true
+	}
+
+	switch {
+	case msg.Type == tea.KeyEsc:
+		m.commit = nil
+		m.textarea.Reset()
+		m.outputs = append(m.outputs, "Commit canceled")
+	case msg.Type == tea.KeyEnter && !msg.Alt:
+		message := strings.TrimSpace(m.textarea.Value())
+		if message == "" {
+			return nil, true
+		}
+		cmd := exec.Command("git", "commit", "-F", "-")
+		cmd.Stdin = strings.NewReader(message + "\n")
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			m.outputs = append(m.outputs, fmt.Sprintf("Commit failed: %v\n%s", err, output))
+		} else {
+			m.outputs = append(m.outputs, strings.TrimSpace(string(output)))
+		}
+		m.commit = nil
+		m.textarea.Reset()
+	default:
+		return nil, false
+	}
+	m.updateViewportContent()
+	return nil, true
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// compactKeptMessages is the number of trailing messages at least kept
+// verbatim when the conversation is summarized
+const compactKeptMessages = 2
+
+// historyShape gives the IDs of the tool calls made by each message of a
+// history and of the tool results it carries, whatever the provider
+type historyShape struct {
+	length  int
+	calls   func(i int) []string
+	results func(i int) []string
+}
+
+// units splits the history into the spans kept or dropped together: an
+// assistant message calling tools with the messages holding their results,
+// or a single message. It returns the start index of each unit.
+func (h historyShape) units() []int {
+	var starts []int
+	for i := 0; i < h.length; {
+		starts = append(starts, i)
+		pending := map[string]bool{}
+		for _, id := range h.calls(i) {
+			pending[id] = true
+		}
+		i++
+		for i < h.length && len(pending) > 0 && answersAll(h.results(i), pending) {
+			for _, id := range h.results(i) {
+				delete(pending, id)
+			}
+			i++
+		}
+	}
+	return starts
+}
+
+// answersAll tells whether results is not empty and only answers pending calls
+func answersAll(results []string, pending map[string]bool) bool {
+	for _, id := range results {
+		if !pending[id] {
+			return false
+		}
+	}
+	return len(results) > 0
+}
+
+// tailStart returns the index of the first message of the trailing whole
+// units holding at least n messages
+func (h historyShape) tailStart(n int) int {
+	starts := h.units()
+	for i := len(starts) - 1; i >= 0; i-- {
+		if h.length-starts[i] >= n {
+			return starts[i]
+		}
+	}
+	return 0
+}
+
+// validate checks that every tool call is answered by the messages right
+// after it and that every tool result answers a call of the message before,
+// which the APIs reject otherwise
+func (h historyShape) validate() error {
+	pending := map[string]bool{}
+	for i := 0; i < h.length; i++ {
+		results := h.results(i)
+		for _, id := range results {
+			if !pending[id] {
+				return fmt.Errorf("message %d has a tool result for %s without a matching tool call", i, id)
+			}
+			delete(pending, id)
+		}
+		calls := h.calls(i)
+		if len(pending) > 0 && (len(results) == 0 || len(calls) > 0) {
+			return fmt.Errorf("message %d follows tool calls without results: %v", i, slices.Sorted(maps.Keys(pending)))
+		}
+		for _, id := range calls {
+			pending[id] = true
+		}
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("the history ends with tool calls without results: %v", slices.Sorted(maps.Keys(pending)))
+	}
+	return nil
+}
+
+// claudeHistoryShape describes the tool_use and tool_result blocks of a Claude history
+func claudeHistoryShape(history []claudeMessage) historyShape {
+	ids := func(i int, results bool) []string {
+		blocks, _ := history[i].Content.([]claudeContentBlock)
+		var ids []string
+		for _, block := range blocks {
+			if block.Type == "tool_use" && !results {
+				ids = append(ids, block.ID)
+			}
+			if block.Type == "tool_result" && results {
+				ids = append(ids, block.ToolUseID)
+			}
+		}
+		return ids
+	}
+	return historyShape{
+		length:  len(history),
+		calls:   func(i int) []string { return ids(i, false) },
+		results: func(i int) []string { return ids(i, true) },
+	}
+}
+
+// openaiHistoryShape describes the tool calls and tool messages of an OpenAI history
+func openaiHistoryShape(history []openaiMessage) historyShape {
+	return historyShape{
+		length: len(history),
+		calls: func(i int) []string {
+			var ids []string
+			for _, call := range history[i].ToolCalls {
+				ids = append(ids, call.ID)
+			}
+			return ids
+		},
+		results: func(i int) []string {
+			if history[i].Role != "tool" {
+				return nil
+			}
+			return []string{history[i].ToolCallID}
+		},
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+// shapeMessage is a message of a test history: the tool calls it makes and
+// the tool results it carries
+type shapeMessage struct {
+	calls   []string
+	results []string
+}
+
+// claudeTestHistory builds a Claude history with a user message carrying all
+// the results of a message
+func claudeTestHistory(messages []shapeMessage) []claudeMessage {
+	history := make([]claudeMessage, len(messages))
+	for i, m := range messages {
+		switch {
+		case len(m.calls) > 0:
+			blocks := []claudeContentBlock{{Type: "text", Text: "calling tools"}}
+			for _, id := range m.calls {
+				blocks = append(blocks, claudeContentBlock{Type: "tool_use", ID: id, Name: "View"})
+			}
+			history[i] = claudeMessage{Role: "assistant", Content: blocks}
+		case len(m.results) > 0:
+			var blocks []claudeContentBlock
+			for _, id := range m.results {
+				blocks = append(blocks, claudeContentBlock{Type: "tool_result", ToolUseID: id, Content: "result"})
+			}
+			history[i] = claudeMessage{Role: "user", Content: blocks}
+		default:
+			history[i] = claudeMessage{Role: "user", Content: "text"}
+		}
+	}
+	return history
+}
+
+// openaiTestHistory builds an OpenAI history, where a tool message carries a
+// single result
+func openaiTestHistory(t *testing.T, messages []shapeMessage) []openaiMessage {
+	history := make([]openaiMessage, len(messages))
+	for i, m := range messages {
+		switch {
+		case len(m.calls) > 0:
+			var calls []openaiToolCall
+			for _, id := range m.calls {
+				calls = append(calls, openaiToolCall{ID: id, Type: "function", Function: openaiFunction{Name: "View"}})
+			}
+			history[i] = openaiMessage{Role: "assistant", ToolCalls: calls}
+		case len(m.results) > 1:
+			t.Fatalf("message %d: an OpenAI tool message carries a single result", i)
+		case len(m.results) == 1:
+			history[i] = openaiMessage{Role: "tool", ToolCallID: m.results[0], Content: "result"}
+		default:
+			history[i] = openaiMessage{Role: "user", Content: "text"}
+		}
+	}
+	return history
+}
+
+func callMessage(ids ...string) shapeMessage   { return shapeMessage{calls: ids} }
+func resultMessage(ids ...string) shapeMessage { return shapeMessage{results: ids} }
+
+var textMessage = shapeMessage{}
+
+func TestHistoryShape(t *testing.T) {
+	tests := []struct {
+		name       string
+		messages   []shapeMessage
+		claudeOnly bool        // Several results in one message
+		units      []int       // Start index of each unit
+		tails      map[int]int // tailStart of n messages
+		err        string      // Part of the error of validate, empty when valid
+	}{
+		{
+			name:     "messages without tools",
+			messages: []shapeMessage{textMessage, textMessage, textMessage},
+			units:    []int{0, 1, 2},
+			tails:    map[int]int{1: 2, 2: 1, 3: 0, 4: 0},
+		},
+		{
+			name:     "multi-call message with several result messages",
+			messages: []shapeMessage{textMessage, callMessage("a", "b"), resultMessage("a"), resultMessage("b"), textMessage},
+			units:    []int{0, 1, 4},
+			tails:    map[int]int{1: 4, 2: 1, 4: 1, 5: 0},
+		},
+		{
+			name:       "multi-call message with its results in one message",
+			messages:   []shapeMessage{textMessage, callMessage("a", "b"), resultMessage("a", "b"), textMessage},
+			claudeOnly: true,
+			units:      []int{0, 1, 3},
+			tails:      map[int]int{1: 3, 2: 1, 3: 1},
+		},
+		{
+			name:     "summary boundary inside a unit",
+			messages: []shapeMessage{textMessage, textMessage, callMessage("a", "b"), resultMessage("a"), resultMessage("b"), textMessage},
+			units:    []int{0, 1, 2, 5},
+			// The last 2 or 3 messages start within the unit of the calls
+			tails: map[int]int{2: 2, 3: 2, 4: 2, 5: 1},
+		},
+		{
+			name:     "consecutive tool rounds",
+			messages: []shapeMessage{textMessage, callMessage("a"), resultMessage("a"), callMessage("b"), resultMessage("b"), textMessage},
+			units:    []int{0, 1, 3, 5},
+			tails:    map[int]int{2: 3, 3: 3, 4: 1},
+		},
+		{
+			name:     "orphaned tool result",
+			messages: []shapeMessage{textMessage, resultMessage("x"), textMessage},
+			units:    []int{0, 1, 2},
+			tails:    map[int]int{2: 1},
+			err:      "message 1 has a tool result for x without a matching tool call",
+		},
+		{
+			name:     "tool result answering a call of an earlier unit",
+			messages: []shapeMessage{textMessage, callMessage("a"), resultMessage("a"), textMessage, resultMessage("a")},
+			units:    []int{0, 1, 3, 4},
+			err:      "message 4 has a tool result for a without a matching tool call",
+		},
+		{
+			name:     "trailing unanswered call",
+			messages: []shapeMessage{textMessage, callMessage("a")},
+			units:    []int{0, 1},
+			tails:    map[int]int{1: 1, 2: 0},
+			err:      "the history ends with tool calls without results: [a]",
+		},
+		{
+			name:     "call left unanswered before the next message",
+			messages: []shapeMessage{textMessage, callMessage("a", "b"), resultMessage("a"), textMessage},
+			units:    []int{0, 1, 3},
+			tails:    map[int]int{1: 3, 2: 1},
+			err:      "message 3 follows tool calls without results: [b]",
+		},
+		{
+			name:     "empty history",
+			messages: nil,
+			units:    nil,
+			tails:    map[int]int{2: 0},
+		},
+	}
+
+	for _, tt := range tests {
+		shapes := map[string]historyShape{"claude": claudeHistoryShape(claudeTestHistory(tt.messages))}
+		if !tt.claudeOnly {
+			shapes["openai"] = openaiHistoryShape(openaiTestHistory(t, tt.messages))
+		}
+		for provider, shape := range shapes {
+			t.Run(provider+"/"+tt.name, func(t *testing.T) {
+				if units := shape.units(); !slices.Equal(units, tt.units) {
+					t.Errorf("units() = %v, want %v", units, tt.units)
+				}
+				for n, want := range tt.tails {
+					if got := shape.tailStart(n); got != want {
+						t.Errorf("tailStart(%d) = %d, want %d", n, got, want)
+					}
+				}
+				err := shape.validate()
+				switch {
+				case tt.err == "" && err != nil:
+					t.Errorf("validate() = %v, want no error", err)
+				case tt.err != "" && err == nil:
+					t.Errorf("validate() = nil, want an error containing %q", tt.err)
+				case tt.err != "" && !strings.Contains(err.Error(), tt.err):
+					t.Errorf("validate() = %v, want an error containing %q", err, tt.err)
+				}
+			})
+		}
+	}
+}
@@ -2,49 +2,104 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"log/slog"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/goccy/go-yaml"
 )
 
 // Config represents the application configuration
 type Config struct {
-	ApiKeyShell     string   `yaml:"api_key_shell"`
-	ApiKey          string   `yaml:"api_key"`
-	Model           string   `yaml:"model"`
-	InitialPrompt   string   `yaml:"initial_prompt"`
-	NonInteractive  bool     `yaml:"non_interactive"`
-	Debug           bool     `yaml:"debug"`
-	Quiet           bool     `yaml:"quiet"`
-	EnabledTools    []string `yaml:"enabled_tools"`
-	SystemFiles     []string `yaml:"system_files"`
-	BaseUrl         string   `yaml:"base_url"`
-	NotifyCmd       string   `yaml:"notify_cmd"`
-	ReasoningEffort string   `yaml:"reasoning_effort"`
+	ApiKeyShell             string              `yaml:"api_key_shell"`
+	ApiKey                  string              `yaml:"api_key"`
+	ApiKeys                 []string            `yaml:"api_keys"`
+	Model                   string              `yaml:"model"`
+	Provider                string              `yaml:"provider"`
+	Endpoint                string              `yaml:"endpoint"`
+	Endpoints               map[string]Endpoint `yaml:"endpoints"`
+	FallbackModel           string              `yaml:"fallback_model"`
+	FallbackEndpoint        string              `yaml:"fallback_endpoint"`
+	OpenRouter              OpenRouterConfig    `yaml:"openrouter"`
+	InitialPrompt           string              `yaml:"initial_prompt"`
+	InputPrefill            string              `yaml:"-"` // Left in the input of the UI to edit and send, e.g. by -continue
+	NonInteractive          bool                `yaml:"non_interactive"`
+	Debug                   bool                `yaml:"debug"`
+	Quiet                   bool                `yaml:"quiet"`
+	OutputFormat            string              `yaml:"output"`
+	EnabledTools            []string            `yaml:"enabled_tools"`
+	SystemFiles             []string            `yaml:"system_files"`
+	BaseUrl                 string              `yaml:"base_url"`
+	NotifyCmd               string              `yaml:"notify_cmd"`
+	ReasoningEffort         string              `yaml:"reasoning_effort"`
+	ResponsesAPI            bool                `yaml:"responses_api"`
+	BuiltinTools            []string            `yaml:"builtin_tools"`
+	SyntaxChecks            map[string]string   `yaml:"syntax_checks"`
+	CheapModel              string              `yaml:"cheap_model"`
+	BashFilters             []BashFilter        `yaml:"bash_filters"`
+	ToolConcurrency         map[string]int      `yaml:"tool_concurrency"`
+	MaxParallelTools        int                 `yaml:"max_parallel_tools"`
+	MaxAgentDepth           int                 `yaml:"max_agent_depth"`
+	MaxRepeatedToolCalls    int                 `yaml:"max_repeated_tool_calls"`
+	CiLogsCommand           string              `yaml:"ci_logs_command"`
+	ToolResultShare         float64             `yaml:"tool_result_share"`
+	MaxRequestBytes         int                 `yaml:"max_request_bytes"`
+	MaxAttempts             int                 `yaml:"max_attempts"`
+	CountTokens             bool                `yaml:"count_tokens"`
+	RateLimit               RateLimit           `yaml:"rate_limit"`
+	Proxy                   string              `yaml:"proxy"`
+	CACert                  string              `yaml:"ca_cert"`
+	InsecureSkipVerify      bool                `yaml:"insecure_skip_verify"`
+	Headers                 map[string]string   `yaml:"headers"`
+	ConfirmUntrustedActions bool                `yaml:"confirm_untrusted_actions"`
+	ApproveWrites           bool                `yaml:"approve_writes"`
+	ApproveCommands         bool                `yaml:"approve_commands"`
+	ShowReasoning           bool                `yaml:"show_reasoning"`
+	SummaryToPR             bool                `yaml:"summary_to_pr"`
+	Prices                  ModelPrices         `yaml:"prices"`
+	Models                  ModelRegistry       `yaml:"models"`
+	Currency                Currency            `yaml:"currency"`
+	BashEnv                 BashEnv             `yaml:"bash_env"`
+	Remote                  RemoteConfig        `yaml:"remote"`
+	ToolProfiles            map[string][]string `yaml:"tool_profiles"`
+	GitProfiles             map[string]string   `yaml:"git_profiles"`
+	Verbosity               string              `yaml:"verbosity"`
+	Temperature             *float64            `yaml:"temperature"`
+	TopP                    *float64            `yaml:"top_p"`
+	MaxTokens               int                 `yaml:"max_tokens"`
+	Stop                    []string            `yaml:"stop"`
+	TurnTimeLimit           time.Duration       `yaml:"turn_time_limit"`
+	TurnDeadline            time.Duration       `yaml:"turn_deadline"`
+	RequestTimeout          time.Duration       `yaml:"request_timeout"`
+	AuditLog                bool                `yaml:"audit_log"`
+	RecordTranscript        bool                `yaml:"record_transcript"`
+	PromptCache             string              `yaml:"prompt_cache"`
+	LogLevel                string              `yaml:"log_level"`
+	LogFile                 string              `yaml:"log_file"`
+	LogBodies               bool                `yaml:"log_bodies"`
+	StrictTools             *bool               `yaml:"strict_tools"`
+	UpdateChecks            *bool               `yaml:"update_checks"`
+	SessionEndSummaries     *bool               `yaml:"session_end_summaries"`
+}
+
+// providerKeyEnvs are the environment variables holding the API key of the
+// providers, taking precedence over the keys of OpenAI and Anthropic
+var providerKeyEnvs = map[string]string{
+	providerOpenRouter: "OPENROUTER_API_KEY",
+	providerDeepSeek:   "DEEPSEEK_API_KEY",
 }
 
 // LoadConfig loads configuration from a YAML file
 func LoadConfig(configPath string) (Config, error) {
 	config := Config{}
 
-	config.SystemFiles = []string{"AI.md", "CLAUDE.md"}
+	config.SystemFiles = []string{"AI.md", "AGENTS.md", "CLAUDE.md"}
 
-	// First check if the provided path exists
-	if _, err := os.Stat(configPath); os.IsNotExist(err) {
-		// If path doesn't exist, check in ~/.config/aicode/ directory
-		fileName := filepath.Base(configPath)
-		configName := strings.TrimSuffix(fileName, filepath.Ext(fileName))
-
-		// Try with yml extension
-		altPath := filepath.Join(expandHomeDir("~/.config/aicode"), configName+".yml")
-		if _, err := os.Stat(altPath); err == nil {
-			configPath = altPath
-		}
-	}
+	configPath = resolveConfigPath(configPath)
 
 	// Read config file
 	configData, err := os.ReadFile(configPath)
@@ -66,18 +121,40 @@ func LoadConfig(configPath string) (Config, error) {
 		config.ApiKey = strings.TrimSpace(aptiKey)
 	}
 
-	if envVal := os.Getenv("OPENAI_API_KEY"); envVal != "" {
+	if keyEnv, ok := providerKeyEnvs[config.Provider]; ok {
+		if envVal := os.Getenv(keyEnv); envVal != "" {
+			config.ApiKey = envVal
+		}
+	} else if envVal := os.Getenv("OPENAI_API_KEY"); envVal != "" {
 		config.ApiKey = envVal
 	} else if envVal := os.Getenv("ANTHROPIC_API_KEY"); envVal != "" {
 		config.ApiKey = envVal
 	}
 
+	// Several keys are rotated, starting with the first
+	if config.ApiKeys = expandKeys(config.ApiKeys); len(config.ApiKeys) > 0 {
+		config.ApiKey = config.ApiKeys[0]
+	}
+
 	if envVal := os.Getenv("OPENAI_MODEL"); envVal != "" {
 		config.Model = envVal
 	} else if envVal := os.Getenv("ANTHROPIC_MODEL"); envVal != "" {
 		config.Model = envVal
 	}
 
+	if err := applyEndpoint(&config); err != nil {
+		return config, err
+	}
+	applyOpenRouter(&config)
+	applyDeepSeek(&config)
+	if err := validateBuiltinTools(config); err != nil {
+		return config, err
+	}
+
+	if err := resolveCurrency(&config); err != nil {
+		return config, err
+	}
+
 	if config.Model == "" {
 		config.Model = "claude-sonnet-4-20250514"
 		if os.Getenv("OPENAI_API_KEY") != "" {
@@ -85,6 +162,23 @@ func LoadConfig(configPath string) (Config, error) {
 		}
 	}
 
+	// Default cheap model used for auxiliary requests such as session titles,
+	// one the provider serves. Self-hosted servers usually serve a single model.
+	if config.CheapModel == "" {
+		switch {
+		case config.Provider == providerOpenAICompatible:
+			config.CheapModel = config.Model
+		case config.Provider == providerDeepSeek:
+			config.CheapModel = "deepseek-chat"
+		case config.Provider == providerOpenRouter:
+			config.CheapModel = "openai/gpt-4.1-nano"
+		case usesClaudeAPI(config.Model, config):
+			config.CheapModel = "claude-3-5-haiku-latest"
+		default:
+			config.CheapModel = "gpt-4.1-nano"
+		}
+	}
+
 	if config.BaseUrl == "" {
 		config.BaseUrl = os.Getenv("BASE_URL")
 	}
@@ -94,7 +188,47 @@ func LoadConfig(configPath string) (Config, error) {
 		config.ReasoningEffort = "medium"
 	}
 
-	if config.ApiKey == "" || config.Model == "" {
+	if config.Verbosity == "" {
+		config.Verbosity = "medium"
+	}
+
+	if config.OutputFormat == "" {
+		config.OutputFormat = outputText
+	}
+
+	if config.ToolResultShare == 0 {
+		config.ToolResultShare = defaultToolResultShare
+	}
+
+	if config.MaxAgentDepth == 0 {
+		config.MaxAgentDepth = 2
+	}
+
+	if config.MaxRepeatedToolCalls == 0 {
+		config.MaxRepeatedToolCalls = 3
+	}
+
+	if config.TopP != nil && (*config.TopP <= 0 || *config.TopP > 1) {
+		return config, fmt.Errorf("invalid top_p %g, expected a number above 0 and up to 1", *config.TopP)
+	}
+	if config.MaxTokens < 0 {
+		return config, fmt.Errorf("invalid max_tokens %d", config.MaxTokens)
+	}
+	if _, err := parseLogLevel(config.LogLevel); err != nil {
+		return config, err
+	}
+	if err := checkPromptCache(config.PromptCache); err != nil {
+		return config, err
+	}
+	for _, endpoint := range config.Endpoints {
+		if err := checkPromptCache(endpoint.PromptCache); err != nil {
+			return config, err
+		}
+	}
+
+	// Local inference servers usually run without authentication
+	keyRequired := config.Provider != providerOpenAICompatible
+	if (config.ApiKey == "" && keyRequired) || config.Model == "" {
 
 		return config, errors.New("API key and model are required")
 	}
@@ -102,6 +236,22 @@ func LoadConfig(configPath string) (Config, error) {
 	return config, nil
 }
 
+// resolveConfigPath returns the profile file to load, falling back to a
+// profile of the same name in ~/.config/aicode/ when the path doesn't exist
+func resolveConfigPath(configPath string) string {
+	if _, err := os.Stat(configPath); os.IsNotExist(err) {
+		fileName := filepath.Base(configPath)
+		configName := strings.TrimSuffix(fileName, filepath.Ext(fileName))
+
+		// Try with yml extension
+		altPath := filepath.Join(expandHomeDir("~/.config/aicode"), configName+".yml")
+		if _, err := os.Stat(altPath); err == nil {
+			return altPath
+		}
+	}
+	return configPath
+}
+
 // executeShellCommand executes a shell command and returns the output
 func executeShellCommand(command string) (string, error) {
 	cmd := exec.Command("sh", "-c", command)
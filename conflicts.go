@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// conflictContextLines is the number of lines around a conflict sent to the model
+const conflictContextLines = 20
+
+// conflictHelp is shown in the status line while a resolution is proposed
+const conflictHelp = "Resolve conflict | a accept, e edit, r retry, s skip, esc stop"
+
+// conflictHunk is a conflict of a file, from its <<<<<<< line to its >>>>>>> line
+type conflictHunk struct {
+	start int // Index of the <<<<<<< line
+	end   int // Index of the >>>>>>> line
+	text  string
+}
+
+// parseConflicts returns the conflicts of a file split into lines with their newlines
+func parseConflicts(lines []string) []conflictHunk {
+	var hunks []conflictHunk
+	start := -1
+	for i, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "<<<<<<< ") || strings.TrimRight(line, "\r\n") == "<<<<<<<":
+			start = i
+		case start >= 0 && (strings.HasPrefix(line, ">>>>>>> ") || strings.TrimRight(line, "\r\n") == ">>>>>>>"):
+			hunks = append(hunks, conflictHunk{start: start, end: i, text: strings.Join(lines[start:i+1], "")})
+			start = -1
+		}
+	}
+	return hunks
+}
+
+// conflictResolution is a pending /resolve, going through the hunks of the
+// conflicted files one at a time
+type conflictResolution struct {
+	files        []string
+	file         int // Index of the current file
+	lines        []string
+	hunks        []conflictHunk
+	hunk         int            // Index of the current hunk
+	resolutions  map[int]string // Accepted replacement of each hunk
+	skipped      int            // Hunks of the current file left conflicted
+	proposal     string         // Proposed replacement of the current hunk
+	waiting      bool           // The proposal is being generated
+	resolved     int            // Files marked resolved
+	instructions string         // Instructions given to /resolve
+}
+
+// Message carrying the proposed resolution of a conflict
+type conflictProposalMsg struct {
+	file       int
+	hunk       int
+	resolution string
+	err        error
+}
+
+// conflictedFiles lists the files with unresolved conflicts
+func conflictedFiles() ([]string, error) {
+	output, err := gitOutput("diff", "--name-only", "--diff-filter=U")
+	if err != nil {
+		return nil, err
+	}
+	if output == "" {
+		return nil, nil
+	}
+	return strings.Split(output, "\n"), nil
+}
+
+// resolveHandler starts /resolve: each conflict is sent to the model with the
+// lines around it and the proposed resolution is shown as a diff for approval
+func resolveHandler(m *chatModel) error {
+	files, err := conflictedFiles()
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return errors.New("no conflicted files")
+	}
+
+	m.conflicts = &conflictResolution{files: files, instructions: strings.Join(m.commandArgs(), " ")}
+	m.outputs = append(m.outputs, fmt.Sprintf("Conflicted files:\n  %s", strings.Join(files, "\n  ")))
+	m.afterCmd = m.nextConflict()
+	return nil
+}
+
+// nextConflict moves to the next unresolved hunk and asks for its
+// resolution, finishing files as all their hunks are handled
+func (m *chatModel) nextConflict() tea.Cmd {
+	c := m.conflicts
+	for c.file < len(c.files) {
+		path := c.files[c.file]
+		if c.lines == nil {
+			content, err := os.ReadFile(path)
+			if err != nil {
+				m.outputs = append(m.outputs, fmt.Sprintf("Failed to read %s: %v", path, err))
+				c.file++
+				continue
+			}
+			c.lines = strings.SplitAfter(string(content), "\n")
+			c.hunks = parseConflicts(c.lines)
+			c.hunk, c.skipped = 0, 0
+			c.resolutions = map[int]string{}
+			if len(c.hunks) == 0 {
+				m.outputs = append(m.outputs, fmt.Sprintf("%s has no conflict markers, resolve it manually, e.g. with git rm or git checkout --ours/--theirs", path))
+				c.file++
+				c.lines = nil
+				continue
+			}
+		}
+		if c.hunk < len(c.hunks) {
+			return m.proposeResolution()
+		}
+		m.finishConflictFile()
+		c.file++
+		c.lines = nil
+	}
+
+	m.outputs = append(m.outputs, fmt.Sprintf("Conflict resolution finished: %d of %d files resolved", c.resolved, len(c.files)))
+	m.conflicts = nil
+	return nil
+}
+
+// proposeResolution asks the model to resolve the current hunk in the background
+func (m *chatModel) proposeResolution() tea.Cmd {
+	c := m.conflicts
+	c.waiting = true
+	c.proposal = ""
+	hunk := c.hunks[c.hunk]
+	path := c.files[c.file]
+	m.outputs = append(m.outputs, fmt.Sprintf("Resolving conflict %d/%d in %s...", c.hunk+1, len(c.hunks), path))
+
+	var prompt strings.Builder
+	fmt.Fprintf(&prompt, "File: %s\n", path)
+	if _, err := gitOutput("rev-parse", "-q", "--verify", "REBASE_HEAD"); err == nil {
+		prompt.WriteString("A rebase is in progress: the first side is the branch being rebased onto and the second side is the commit being replayed.\n")
+	}
+	if c.instructions != "" {
+		fmt.Fprintf(&prompt, "Instructions from the user: %s\n", c.instructions)
+	}
+	fmt.Fprintf(&prompt, "\nLines before the conflict:\n%s\nConflict:\n%s\nLines after the conflict:\n%s",
+		strings.Join(c.lines[max(hunk.start-conflictContextLines, 0):hunk.start], ""),
+		hunk.text,
+		strings.Join(c.lines[hunk.end+1:min(hunk.end+1+conflictContextLines, len(c.lines))], ""))
+
+	// Resolutions need the main model, the cheap one mangles code too often
+	config := m.config
+	config.CheapModel = config.Model
+	file, index := c.file, c.hunk
+	return func() tea.Msg {
+		answer, err := quickCompletion(context.Background(), config, conflictPrompt, prompt.String())
+		resolution := ""
+		if strings.TrimSpace(answer) != "" {
+			resolution = stripCodeFence(answer)
+		}
+		return conflictProposalMsg{file: file, hunk: index, resolution: resolution, err: err}
+	}
+}
+
+// handleConflictProposal shows the proposed resolution as a diff against the conflict
+func (m *chatModel) handleConflictProposal(msg conflictProposalMsg) {
+	c := m.conflicts
+	if c == nil || msg.file != c.file || msg.hunk != c.hunk {
+		return
+	}
+	c.waiting = false
+	if msg.err != nil {
+		m.outputs = append(m.outputs, fmt.Sprintf("Failed to resolve the conflict: %v\nPress r to retry, e to write the resolution yourself or s to skip it.", msg.err))
+		return
+	}
+	c.proposal = msg.resolution
+	m.showConflictProposal()
+}
+
+// showConflictProposal prints the diff of the pending proposal
+func (m *chatModel) showConflictProposal() {
+	c := m.conflicts
+	m.outputs = append(m.outputs, fmt.Sprintf("Proposed resolution of conflict %d/%d in %s:\n%s",
+		c.hunk+1, len(c.hunks), c.files[c.file], renderDiff(c.hunks[c.hunk].text, c.proposal)))
+	m.updateViewportContent()
+}
+
+// handleConflictKey processes a key press while /resolve is running
+func (m *chatModel) handleConflictKey(msg tea.KeyMsg) tea.Cmd {
+	c := m.conflicts
+	if msg.Type == tea.KeyEsc {
+		m.outputs = append(m.outputs, fmt.Sprintf("Conflict resolution stopped: %d of %d files resolved, %s left unchanged", c.resolved, len(c.files), c.files[c.file]))
+		m.conflicts = nil
+		m.updateViewportContent()
+		return nil
+	}
+	if c.waiting {
+		return nil
+	}
+
+	var cmd tea.Cmd
+	switch msg.String() {
+	case "a":
+		c.resolutions[c.hunk] = c.proposal
+		c.hunk++
+		cmd = m.nextConflict()
+	case "e":
+		proposal := c.proposal
+		if proposal == "" {
+			proposal = c.hunks[c.hunk].text
+		}
+		return editInEditor(proposal)
+	case "r":
+		cmd = m.proposeResolution()
+	case "s":
+		m.outputs = append(m.outputs, fmt.Sprintf("Skipped conflict %d/%d in %s", c.hunk+1, len(c.hunks), c.files[c.file]))
+		c.skipped++
+		c.hunk++
+		cmd = m.nextConflict()
+	default:
+		return nil
+	}
+	m.updateViewportContent()
+	return cmd
+}
+
+// finishConflictFile writes the accepted resolutions of the current file and
+// marks it resolved when no conflict was skipped
+func (m *chatModel) finishConflictFile() {
+	c := m.conflicts
+	path := c.files[c.file]
+	if len(c.resolutions) == 0 {
+		m.outputs = append(m.outputs, path+" left conflicted")
+		return
+	}
+
+	// Replace from the last hunk so the line indices of the others stay valid
+	lines := append([]string(nil), c.lines...)
+	for i := len(c.hunks) - 1; i >= 0; i-- {
+		resolution, ok := c.resolutions[i]
+		if !ok {
+			continue
+		}
+		hunk := c.hunks[i]
+		lines = append(lines[:hunk.start], append([]string{resolution}, lines[hunk.end+1:]...)...)
+	}
+	content := strings.Join(lines, "")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		m.outputs = append(m.outputs, fmt.Sprintf("Failed to write %s: %v", path, err))
+		return
+	}
+	GlobalFileTracker.RecordWrite(path, []byte(content))
+	GlobalChangeLedger.RecordWrite(path, true, "/resolve")
+
+	if c.skipped > 0 {
+		m.outputs = append(m.outputs, fmt.Sprintf("Wrote %s, %d skipped conflicts left to resolve", path, c.skipped))
+		return
+	}
+	if output, err := exec.Command("git", "add", "--", path).CombinedOutput(); err != nil {
+		m.outputs = append(m.outputs, fmt.Sprintf("Failed to mark %s resolved: %v\n%s", path, err, output))
+		return
+	}
+	c.resolved++
+	m.outputs = append(m.outputs, "Resolved "+path)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// copyCodeHandler lists the code blocks of the last answer, or copies block
+// N to the clipboard or writes it to a file
+func copyCodeHandler(m *chatModel) error {
+	blocks := codeBlocks(m.lastResponse)
+	if len(blocks) == 0 {
+		return fmt.Errorf("no code blocks in the last answer")
+	}
+
+	args := m.commandArgs()
+	if len(args) == 0 {
+		m.outputs = append(m.outputs, "Code blocks of the last answer, /copy-code N copies one and /copy-code N PATH writes it to a file:")
+		for i, block := range blocks {
+			m.outputs = append(m.outputs, "  "+describeCodeBlock(i+1, block))
+		}
+		return nil
+	}
+	index, err := strconv.Atoi(args[0])
+	if err != nil || index < 1 || index > len(blocks) {
+		return fmt.Errorf("no code block #%s, there are %d", args[0], len(blocks))
+	}
+	block := blocks[index-1]
+
+	if len(args) == 1 {
+		if err := writeClipboard(block.Code + "\n"); err != nil {
+			return err
+		}
+		m.outputs = append(m.outputs, fmt.Sprintf("Copied code block #%d to the clipboard (%d lines)", index, strings.Count(block.Code, "\n")+1))
+		return nil
+	}
+
+	path := absPath(strings.Join(args[1:], " "))
+	_, err = workspaceStat(path)
+	existed := err == nil
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("error checking %s: %v", path, err)
+	}
+	if err := workspaceMkdirAll(filepath.Dir(path)); err != nil {
+		return fmt.Errorf("error creating directory: %v", err)
+	}
+	content := []byte(block.Code + "\n")
+	if err := workspaceWriteFile(path, content); err != nil {
+		return fmt.Errorf("error writing to file: %v", err)
+	}
+	GlobalFileTracker.RecordWrite(path, content)
+	GlobalChangeLedger.RecordWrite(path, existed, "/copy-code")
+	m.outputs = append(m.outputs, fmt.Sprintf("Wrote code block #%d to %s", index, path))
+	return nil
+}
+
+// describeCodeBlock summarizes a code block on one line: its number,
+// language, length and first line
+func describeCodeBlock(number int, block codeBlock) string {
+	language := block.Language
+	if language == "" {
+		language = "text"
+	}
+	lines := strings.Split(block.Code, "\n")
+	first := strings.TrimSpace(lines[0])
+	if len(first) > 60 {
+		first = first[:57] + "..."
+	}
+	count := fmt.Sprintf("%d lines", len(lines))
+	if len(lines) == 1 {
+		count = "1 line"
+	}
+	return fmt.Sprintf("%d. %s, %s: %s", number, language, count, first)
+}
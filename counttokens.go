@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// countTokensTimeout bounds the count_tokens request sent before a request
+// to the model, the tokens are counted locally when it takes longer
+const countTokensTimeout = 10 * time.Second
+
+// countTokensRequest is the body of /v1/messages/count_tokens, the prompt of
+// a messages request without its generation parameters
+type countTokensRequest struct {
+	Model    string                `json:"model"`
+	Messages []claudeMessage       `json:"messages"`
+	System   []claudeSystemMessage `json:"system,omitempty"`
+	Tools    []claudeTool          `json:"tools,omitempty"`
+}
+
+// countContextTokens measures the prompt of the next request with the
+// count_tokens API of Anthropic when count_tokens is set in the profile, so
+// that the summarization trigger and the status bar use the exact size of
+// the conversation. The messages added since the last count are counted
+// locally when the API cannot be reached.
+func (c *Claude) countContextTokens(ctx context.Context) {
+	if !c.Config.CountTokens || len(c.conversationHistory) == 0 ||
+		(c.contextCount.tokens > 0 && c.contextCount.messages == len(c.conversationHistory)) {
+		return
+	}
+	tokens, err := c.countTokensAPI(ctx)
+	if err != nil {
+		slog.Debug("Failed to count tokens, counting locally", "error", err)
+		return
+	}
+	c.contextCount = contextCount{tokens: tokens, messages: len(c.conversationHistory)}
+}
+
+// countTokensAPI returns the input tokens of the system prompt, tools and
+// history as counted by Anthropic, which does not bill these requests
+func (c *Claude) countTokensAPI(ctx context.Context) (int, error) {
+	baseURL := c.Config.BaseUrl
+	if baseURL == "" {
+		baseURL = "https://api.anthropic.com"
+	}
+	body, err := json.Marshal(countTokensRequest{
+		Model:    c.Config.Model,
+		Messages: c.conversationHistory,
+		System:   c.systemMessages,
+		Tools:    c.tools,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, countTokensTimeout)
+	defer cancel()
+	req, err := c.newRequest(baseURL+"/v1/messages/count_tokens", body)
+	if err != nil {
+		return 0, err
+	}
+	client, err := httpClient(c.Config)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return 0, fmt.Errorf("count_tokens answered %s", resp.Status)
+	}
+
+	var out struct {
+		InputTokens int `json:"input_tokens"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return 0, err
+	}
+	return out.InputTokens, nil
+}
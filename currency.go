@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// exchangeRateURL serves the latest rates of the European Central Bank from USD to a currency
+const exchangeRateURL = "https://api.frankfurter.app/latest?from=USD&to=%s"
+
+// exchangeRateMaxAge is how long a fetched exchange rate is used before fetching it again
+const exchangeRateMaxAge = 24 * time.Hour
+
+// Currency is the currency costs are shown in, prices and the usage ledger stay in dollars
+type Currency struct {
+	Code      string  `yaml:"code"`       // ISO 4217 code such as EUR, dollars when not set
+	Rate      float64 `yaml:"rate"`       // Units of the currency per dollar, fetched once a day when not set
+	RateShell string  `yaml:"rate_shell"` // Command printing the rate, instead of fetching it
+	Locale    string  `yaml:"locale"`     // Formatting such as de_DE, from LC_ALL, LC_MONETARY or LANG when not set
+}
+
+// currencySymbols are the symbols of common currencies, others are shown with their code
+var currencySymbols = map[string]string{
+	"USD": "$", "EUR": "€", "GBP": "£", "JPY": "¥", "CNY": "¥", "INR": "₹", "KRW": "₩",
+	"RUB": "₽", "UAH": "₴", "TRY": "₺", "ILS": "₪", "BRL": "R$", "CAD": "CA$", "AUD": "A$",
+	"NZD": "NZ$", "MXN": "MX$", "CHF": "CHF", "SEK": "kr", "NOK": "kr", "DKK": "kr",
+	"PLN": "zł", "CZK": "Kč", "HUF": "Ft",
+}
+
+// zeroDecimalCurrencies have no minor unit in use
+var zeroDecimalCurrencies = map[string]bool{"JPY": true, "KRW": true, "ISK": true, "CLP": true, "VND": true}
+
+// numberFormat is how a locale writes amounts of money
+type numberFormat struct {
+	decimal string
+	group   string
+	suffix  bool // The symbol follows the amount
+}
+
+// localeFormats are the formats of languages not writing amounts like English
+var localeFormats = map[string]numberFormat{
+	"de": {",", ".", true}, "es": {",", ".", true}, "it": {",", ".", true}, "pt": {",", ".", true},
+	"da": {",", ".", true}, "tr": {",", ".", true}, "el": {",", ".", true}, "id": {",", ".", false},
+	"nl": {",", ".", false}, "fr": {",", " ", true}, "sv": {",", " ", true}, "fi": {",", " ", true},
+	"nb": {",", " ", true}, "no": {",", " ", true}, "cs": {",", " ", true}, "sk": {",", " ", true},
+	"pl": {",", " ", true}, "hu": {",", " ", true}, "ru": {",", " ", true}, "uk": {",", " ", true},
+}
+
+// Format returns a cost in dollars converted to the currency and written
+// the way the locale does, such as 1.234,56 € for EUR in de_DE
+func (c Currency) Format(usd float64) string {
+	code := strings.ToUpper(c.Code)
+	if code == "" || c.Rate == 0 {
+		return fmt.Sprintf("$%.2f", usd)
+	}
+
+	decimals := 2
+	if zeroDecimalCurrencies[code] {
+		decimals = 0
+	}
+	format := c.numberFormat()
+	amount := strconv.FormatFloat(usd*c.Rate, 'f', decimals, 64)
+	integer, fraction, _ := strings.Cut(amount, ".")
+	if len(integer) > 3 {
+		var grouped []string
+		for len(integer) > 3 {
+			grouped = append([]string{integer[len(integer)-3:]}, grouped...)
+			integer = integer[:len(integer)-3]
+		}
+		integer = strings.Join(append([]string{integer}, grouped...), format.group)
+	}
+	if fraction != "" {
+		integer += format.decimal + fraction
+	}
+
+	symbol, ok := currencySymbols[code]
+	if !ok {
+		symbol = code
+	}
+	if format.suffix {
+		return integer + " " + symbol
+	}
+	// Symbols ending with a letter, such as CHF, are separated from the amount
+	if last := []rune(symbol)[len([]rune(symbol))-1]; unicode.IsLetter(last) {
+		return symbol + " " + integer
+	}
+	return symbol + integer
+}
+
+// numberFormat returns the format of the locale of the profile or of the environment
+func (c Currency) numberFormat() numberFormat {
+	locale := c.Locale
+	for _, name := range []string{"LC_ALL", "LC_MONETARY", "LANG"} {
+		if locale == "" {
+			locale = os.Getenv(name)
+		}
+	}
+	language, _, _ := strings.Cut(strings.ToLower(locale), "_")
+	language, _, _ = strings.Cut(language, ".")
+	if format, ok := localeFormats[language]; ok {
+		return format
+	}
+	return numberFormat{decimal: ".", group: ","}
+}
+
+// resolveCurrency sets the exchange rate of the currency of the profile.
+// Costs are shown in dollars when the rate can't be fetched.
+func resolveCurrency(config *Config) error {
+	currency := &config.Currency
+	currency.Code = strings.ToUpper(currency.Code)
+	switch {
+	case currency.Code == "" || currency.Code == "USD":
+		currency.Rate = 1
+	case currency.Rate > 0:
+	case currency.RateShell != "":
+		output, err := executeShellCommand(currency.RateShell)
+		if err != nil {
+			return errors.New("failed to get the exchange rate from shell command: " + err.Error())
+		}
+		rate, err := strconv.ParseFloat(strings.TrimSpace(output), 64)
+		if err != nil || rate <= 0 {
+			return fmt.Errorf("the exchange rate command printed %q instead of a positive number", strings.TrimSpace(output))
+		}
+		currency.Rate = rate
+	default:
+		rate, err := exchangeRate(currency.Code)
+		if err != nil {
+			slog.Warn("Showing costs in dollars, failed to get the exchange rate", "currency", currency.Code, "error", err)
+		}
+		currency.Rate = rate
+	}
+	return nil
+}
+
+// exchangeRates are the fetched exchange rates, saved to avoid fetching them on every start
+type exchangeRates struct {
+	Fetched map[string]time.Time `json:"fetched"`
+	Rates   map[string]float64   `json:"rates"`
+}
+
+// exchangeRatesPath returns the file the fetched exchange rates are saved to
+func exchangeRatesPath() string {
+	return expandHomeDir("~/.config/aicode/exchange_rates.json")
+}
+
+// exchangeRate returns the units of code per dollar, from the rates fetched
+// in the last day or fetched now. A rate older than a day is used when the
+// fetch fails, working offline.
+func exchangeRate(code string) (float64, error) {
+	rates := exchangeRates{Fetched: map[string]time.Time{}, Rates: map[string]float64{}}
+	if data, err := os.ReadFile(exchangeRatesPath()); err == nil {
+		json.Unmarshal(data, &rates)
+	}
+	if time.Since(rates.Fetched[code]) < exchangeRateMaxAge {
+		return rates.Rates[code], nil
+	}
+
+	rate, err := fetchExchangeRate(code)
+	if err != nil {
+		return rates.Rates[code], err
+	}
+	rates.Rates[code] = rate
+	rates.Fetched[code] = time.Now()
+	if data, err := json.MarshalIndent(rates, "", "  "); err == nil {
+		if err := os.MkdirAll(filepath.Dir(exchangeRatesPath()), 0755); err == nil {
+			os.WriteFile(exchangeRatesPath(), data, 0644)
+		}
+	}
+	return rate, nil
+}
+
+// fetchExchangeRate fetches the latest rate from USD to code
+func fetchExchangeRate(code string) (float64, error) {
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get(fmt.Sprintf(exchangeRateURL, code))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("exchange rate service answered %d", resp.StatusCode)
+	}
+
+	var body struct {
+		Rates map[string]float64 `json:"rates"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return 0, err
+	}
+	rate, ok := body.Rates[code]
+	if !ok || rate <= 0 {
+		return 0, fmt.Errorf("no exchange rate for %s", code)
+	}
+	return rate, nil
+}
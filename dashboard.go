@@ -0,0 +1,196 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// dashboardView is one grouping of the usage dashboard
+type dashboardView struct {
+	Title string
+	Key   func(usageRecord) string
+}
+
+var dashboardViews = []dashboardView{
+	{"Daily", func(r usageRecord) string { return r.Time.Local().Format("2006-01-02") }},
+	{"Projects", func(r usageRecord) string { return r.Project }},
+	{"Models", func(r usageRecord) string { return r.Model }},
+}
+
+// dashboardBarWidth is the width of the cost bars
+const dashboardBarWidth = 30
+
+// dashboardModel is the bubbletea model of aicode usage dashboard
+type dashboardModel struct {
+	records []usageRecord
+	days    int
+	view    int
+	offset  int
+	height  int
+	status  string
+}
+
+func (m dashboardModel) Init() tea.Cmd {
+	return nil
+}
+
+func (m dashboardModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.height = msg.Height
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "q", "esc", "ctrl+c":
+			return m, tea.Quit
+		case "tab", "right", "l":
+			m.view = (m.view + 1) % len(dashboardViews)
+			m.offset = 0
+		case "shift+tab", "left", "h":
+			m.view = (m.view + len(dashboardViews) - 1) % len(dashboardViews)
+			m.offset = 0
+		case "down", "j":
+			m.offset++
+		case "up", "k":
+			if m.offset > 0 {
+				m.offset--
+			}
+		case "e":
+			name := fmt.Sprintf("aicode-usage-%s.csv", time.Now().Format("2006-01-02"))
+			if err := exportUsageCSV(name, m.records); err != nil {
+				m.status = fmt.Sprintf("Export failed: %v", err)
+			} else {
+				abs, _ := filepath.Abs(name)
+				m.status = "Exported to " + abs
+			}
+		}
+	}
+	return m, nil
+}
+
+func (m dashboardModel) View() string {
+	titleStyle := lipgloss.NewStyle().Bold(true)
+	activeStyle := lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("205"))
+	barStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("39"))
+	helpStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("39")).Italic(true)
+
+	var b strings.Builder
+	b.WriteString(titleStyle.Render(fmt.Sprintf("AiCode usage - last %d days", m.days)) + "\n\n")
+
+	var tabs []string
+	for i, view := range dashboardViews {
+		if i == m.view {
+			tabs = append(tabs, activeStyle.Render("["+view.Title+"]"))
+		} else {
+			tabs = append(tabs, " "+view.Title+" ")
+		}
+	}
+	b.WriteString(strings.Join(tabs, "  ") + "\n\n")
+
+	totals := groupUsage(m.records, dashboardViews[m.view].Key)
+	var maxCost, sum float64
+	var requests int
+	for _, total := range totals {
+		if total.Cost > maxCost {
+			maxCost = total.Cost
+		}
+		sum += total.Cost
+		requests += total.Requests
+	}
+
+	// Keep the header, tabs and footer visible
+	rows := len(totals)
+	if m.height > 10 {
+		rows = m.height - 10
+	}
+	offset := min(m.offset, max(len(totals)-rows, 0))
+
+	labelWidth := 10
+	for _, total := range totals {
+		labelWidth = max(labelWidth, min(len(total.Key), 50))
+	}
+	if len(totals) == 0 {
+		b.WriteString("No usage recorded yet.\n")
+	}
+	for _, total := range totals[offset:min(offset+rows, len(totals))] {
+		label := total.Key
+		if len(label) > 50 {
+			label = "..." + label[len(label)-47:]
+		}
+		width := 0
+		if maxCost > 0 {
+			width = int(total.Cost / maxCost * dashboardBarWidth)
+		}
+		bar := barStyle.Render(strings.Repeat("█", width)) + strings.Repeat(" ", dashboardBarWidth-width)
+		fmt.Fprintf(&b, "%-*s %s $%8.2f  %6s in %6s out  %d req\n", labelWidth, label, bar, total.Cost,
+			formatTokenCount(total.InputTokens), formatTokenCount(total.OutputTokens), total.Requests)
+	}
+
+	fmt.Fprintf(&b, "\nTotal: $%.2f over %d requests\n", sum, requests)
+	if m.status != "" {
+		b.WriteString(m.status + "\n")
+	}
+	b.WriteString(helpStyle.Render("tab/←/→ switch view | ↑/↓ scroll | e export CSV | q quit"))
+	return b.String()
+}
+
+// exportUsageCSV writes the records to a CSV file
+func exportUsageCSV(path string, records []usageRecord) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := writeUsageCSV(file, records); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// runUsage implements the usage subcommand
+func runUsage(args []string) int {
+	if len(args) == 0 || args[0] != "dashboard" {
+		fmt.Fprintln(os.Stderr, "Usage: aicode usage dashboard [--days N] [--csv file]")
+		return 2
+	}
+
+	flags := flag.NewFlagSet("usage dashboard", flag.ExitOnError)
+	days := flags.Int("days", 30, "Number of days shown")
+	csvPath := flags.String("csv", "", "Export the requests to a CSV file, - for stdout, instead of showing the dashboard")
+	flags.Parse(args[1:])
+
+	records, err := readUsage(time.Now().AddDate(0, 0, -*days))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading usage ledger: %v\n", err)
+		return 1
+	}
+
+	switch *csvPath {
+	case "":
+	case "-":
+		if err := writeUsageCSV(os.Stdout, records); err != nil {
+			fmt.Fprintf(os.Stderr, "Error exporting usage: %v\n", err)
+			return 1
+		}
+		return 0
+	default:
+		if err := exportUsageCSV(*csvPath, records); err != nil {
+			fmt.Fprintf(os.Stderr, "Error exporting usage: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	program := tea.NewProgram(dashboardModel{records: records, days: *days}, tea.WithAltScreen())
+	if _, err := program.Run(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error running dashboard: %v\n", err)
+		return 1
+	}
+	return 0
+}
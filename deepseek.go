@@ -0,0 +1,24 @@
+package main
+
+// providerDeepSeek is the DeepSeek API, which speaks the OpenAI API and
+// returns the reasoning of deepseek-reasoner in reasoning_content
+const providerDeepSeek = "deepseek"
+
+// deepSeekURL is the base URL of the DeepSeek API
+const deepSeekURL = "https://api.deepseek.com"
+
+// applyDeepSeek sets the defaults of the DeepSeek provider
+func applyDeepSeek(config *Config) {
+	if config.Provider != providerDeepSeek {
+		return
+	}
+	if config.BaseUrl == "" {
+		config.BaseUrl = deepSeekURL
+	}
+	if config.Model == "" {
+		config.Model = "deepseek-chat"
+	}
+	if config.CheapModel == "" {
+		config.CheapModel = "deepseek-chat"
+	}
+}
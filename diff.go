@@ -0,0 +1,134 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// diffContext is the number of unchanged lines shown around changes
+const diffContext = 3
+
+// diffLine is a line of a line-based diff, Op is ' ', '-' or '+'
+type diffLine struct {
+	Op   byte
+	Text string
+}
+
+// diffLines computes a line diff using the longest common subsequence, which
+// is fine for the small files reviewed in the UI
+func diffLines(oldText, newText string) []diffLine {
+	a := strings.Split(strings.TrimSuffix(oldText, "\n"), "\n")
+	b := strings.Split(strings.TrimSuffix(newText, "\n"), "\n")
+	if oldText == "" {
+		a = nil
+	}
+
+	// Edits usually touch a small part of a file, only the lines between the
+	// common head and tail need the quadratic table
+	head := 0
+	for head < len(a) && head < len(b) && a[head] == b[head] {
+		head++
+	}
+	tail := 0
+	for tail < len(a)-head && tail < len(b)-head && a[len(a)-1-tail] == b[len(b)-1-tail] {
+		tail++
+	}
+	var lines []diffLine
+	for _, line := range a[:head] {
+		lines = append(lines, diffLine{' ', line})
+	}
+	lines = append(lines, diffMiddle(a[head:len(a)-tail], b[head:len(b)-tail])...)
+	for _, line := range a[len(a)-tail:] {
+		lines = append(lines, diffLine{' ', line})
+	}
+	return lines
+}
+
+// diffMiddle diffs the lines between the common head and tail
+func diffMiddle(a, b []string) []diffLine {
+	// lcs[i][j] is the LCS length of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var lines []diffLine
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			lines = append(lines, diffLine{' ', a[i]})
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			lines = append(lines, diffLine{'-', a[i]})
+			i++
+		default:
+			lines = append(lines, diffLine{'+', b[j]})
+			j++
+		}
+	}
+	return lines
+}
+
+// renderDiff formats a diff with colors, eliding long runs of unchanged lines
+func renderDiff(oldText, newText string) string {
+	added := lipgloss.NewStyle().Foreground(lipgloss.Color("2"))
+	removed := lipgloss.NewStyle().Foreground(lipgloss.Color("1"))
+	faint := lipgloss.NewStyle().Faint(true)
+	return formatDiff(oldText, newText, added.Render, removed.Render, faint.Render)
+}
+
+// plainDiff formats a diff without colors, for the model
+func plainDiff(oldText, newText string) string {
+	plain := func(strs ...string) string { return strings.Join(strs, " ") }
+	return formatDiff(oldText, newText, plain, plain, plain)
+}
+
+// formatDiff formats a diff with the given styles, eliding long runs of unchanged lines
+func formatDiff(oldText, newText string, added, removed, faint func(...string) string) string {
+	lines := diffLines(oldText, newText)
+	near := func(index int) bool {
+		for k := max(0, index-diffContext); k <= min(len(lines)-1, index+diffContext); k++ {
+			if lines[k].Op != ' ' {
+				return true
+			}
+		}
+		return false
+	}
+
+	var out []string
+	skipped := false
+	for index, line := range lines {
+		switch line.Op {
+		case '+':
+			out = append(out, added("+ "+line.Text))
+		case '-':
+			out = append(out, removed("- "+line.Text))
+		default:
+			if !near(index) {
+				if !skipped {
+					out = append(out, faint("  ..."))
+					skipped = true
+				}
+				continue
+			}
+			out = append(out, "  "+line.Text)
+		}
+		skipped = false
+	}
+	if len(out) == 0 {
+		return faint("(no changes)")
+	}
+	return strings.Join(out, "\n")
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/goccy/go-yaml"
+	"github.com/mattn/go-isatty"
+)
+
+// checkStatus is the outcome of a doctor check
+type checkStatus int
+
+const (
+	checkOK checkStatus = iota
+	checkWarn
+	checkFail
+)
+
+// doctorCheck is the result of one verification along with how to fix it
+type doctorCheck struct {
+	Name        string
+	Status      checkStatus
+	Message     string
+	Remediation string
+}
+
+// doctorDependency is an external program used by aicode
+type doctorDependency struct {
+	Name     string
+	UsedBy   string
+	Required bool
+	Install  string
+}
+
+var doctorDependencies = []doctorDependency{
+	{"bash", "Bash tool and custom commands", true, "install bash with your package manager"},
+	{"rg", "Grep tool", false, "brew install ripgrep / apt install ripgrep"},
+	{"fd", "FindFiles tool", false, "brew install fd / apt install fd-find (and link fdfind to fd)"},
+	{"curl", "Fetch tool", false, "brew install curl / apt install curl"},
+	{"git", "/commit", false, "brew install git / apt install git"},
+	{"gh", "GitHub related prompts", false, "brew install gh / see https://cli.github.com"},
+}
+
+// runDoctor implements the doctor subcommand
+func runDoctor(args []string) int {
+	flags := flag.NewFlagSet("doctor", flag.ExitOnError)
+	configFlag := flags.String("p", defaultProfile, "Profile/config file")
+	offline := flags.Bool("offline", false, "Skip the API reachability check")
+	flags.Parse(args)
+
+	var checks []doctorCheck
+	checks = append(checks, checkDependencies()...)
+
+	config, configChecks := checkConfig(selectProfile(flags, *configFlag))
+	checks = append(checks, configChecks...)
+	if !*offline && config != nil {
+		checks = append(checks, checkAPI(*config))
+		if config.Remote.Host != "" {
+			checks = append(checks, checkRemote(*config))
+		}
+	}
+	checks = append(checks, checkTerminal()...)
+
+	failed := false
+	for _, check := range checks {
+		symbol := "✓"
+		switch check.Status {
+		case checkWarn:
+			symbol = "!"
+		case checkFail:
+			symbol = "✗"
+			failed = true
+		}
+		fmt.Printf("%s %-16s %s\n", symbol, check.Name, check.Message)
+		if check.Status != checkOK && check.Remediation != "" {
+			fmt.Printf("  %-16s → %s\n", "", check.Remediation)
+		}
+	}
+
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+// checkRemote verifies that the remote workspace is reachable over SSH
+func checkRemote(config Config) doctorCheck {
+	configureRemote(config)
+	defer configureRemote(Config{})
+
+	// The tools need these programs on the remote host
+	output, err := runWorkspace("command -v bash rg fd stat | wc -l", nil)
+	if err != nil {
+		return doctorCheck{Name: "remote", Status: checkFail, Message: err.Error(),
+			Remediation: "check that `ssh " + config.Remote.Host + "` works without a password prompt and that dir exists"}
+	}
+	if strings.TrimSpace(string(output)) != "4" {
+		return doctorCheck{Name: "remote", Status: checkWarn, Message: config.Remote.Host + " is reachable but misses bash, rg, fd or stat",
+			Remediation: "install them on the remote host"}
+	}
+	return doctorCheck{Name: "remote", Status: checkOK, Message: config.Remote.Host}
+}
+
+// checkDependencies verifies that the external programs used by the tools are installed
+func checkDependencies() []doctorCheck {
+	var checks []doctorCheck
+	for _, dep := range doctorDependencies {
+		path, err := exec.LookPath(dep.Name)
+		if err == nil {
+			checks = append(checks, doctorCheck{Name: dep.Name, Status: checkOK, Message: path})
+			continue
+		}
+		status := checkWarn
+		if dep.Required {
+			status = checkFail
+		}
+		checks = append(checks, doctorCheck{
+			Name:        dep.Name,
+			Status:      status,
+			Message:     "not found, needed by " + dep.UsedBy,
+			Remediation: dep.Install,
+		})
+	}
+	return checks
+}
+
+// checkConfig validates the profile strictly, reporting unknown keys and
+// invalid values that LoadConfig silently ignores
+func checkConfig(configPath string) (*Config, []doctorCheck) {
+	path := resolveConfigPath(configPath)
+	var checks []doctorCheck
+
+	data, err := os.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+		checks = append(checks, doctorCheck{Name: "profile", Status: checkWarn,
+			Message: path + " does not exist, using defaults", Remediation: "create it, see the Profiles section of the readme"})
+	case err != nil:
+		checks = append(checks, doctorCheck{Name: "profile", Status: checkFail, Message: err.Error()})
+	default:
+		var strict Config
+		if err := yaml.UnmarshalWithOptions(data, &strict, yaml.Strict()); err != nil {
+			checks = append(checks, doctorCheck{Name: "profile", Status: checkFail,
+				Message: "invalid " + path, Remediation: strings.ReplaceAll(err.Error(), "\n", " ")})
+		} else {
+			checks = append(checks, doctorCheck{Name: "profile", Status: checkOK, Message: path})
+		}
+	}
+
+	config, err := LoadConfig(configPath)
+	if err != nil {
+		checks = append(checks, doctorCheck{Name: "api key", Status: checkFail, Message: err.Error(),
+			Remediation: "set OPENAI_API_KEY or ANTHROPIC_API_KEY, or api_key/api_key_shell in the profile"})
+		return nil, checks
+	}
+	checks = append(checks, doctorCheck{Name: "model", Status: checkOK, Message: config.Model + " (cheap: " + config.CheapModel + ")"})
+
+	if !validLevels[config.ReasoningEffort] {
+		checks = append(checks, doctorCheck{Name: "reasoning_effort", Status: checkWarn,
+			Message: fmt.Sprintf("unknown value %q", config.ReasoningEffort), Remediation: "use low, medium or high"})
+	}
+	if !validLevels[config.Verbosity] {
+		checks = append(checks, doctorCheck{Name: "verbosity", Status: checkWarn,
+			Message: fmt.Sprintf("unknown value %q", config.Verbosity), Remediation: "use low, medium or high"})
+	}
+
+	for _, filter := range config.BashFilters {
+		if _, err := regexp.Compile(filter.Match); err != nil {
+			checks = append(checks, doctorCheck{Name: "bash_filters", Status: checkFail,
+				Message: fmt.Sprintf("invalid match %q: %v", filter.Match, err)})
+		}
+		for _, name := range filter.Filters {
+			if _, ok := builtinBashFilters[name]; !ok {
+				checks = append(checks, doctorCheck{Name: "bash_filters", Status: checkFail,
+					Message: fmt.Sprintf("unknown filter %q", name), Remediation: "use strip_ansi, collapse_stack_traces or go_test_failures"})
+			}
+		}
+	}
+
+	for ext, check := range config.SyntaxChecks {
+		if _, err := template.New(ext).Parse(check); err != nil {
+			checks = append(checks, doctorCheck{Name: "syntax_checks", Status: checkFail,
+				Message: fmt.Sprintf("invalid template for %s: %v", ext, err)})
+		}
+	}
+
+	for _, file := range config.SystemFiles {
+		if _, err := os.Stat(file); err == nil {
+			checks = append(checks, doctorCheck{Name: "rule file", Status: checkOK, Message: file})
+		}
+	}
+
+	return &config, checks
+}
+
+// checkAPI verifies that the provider is reachable and accepts the API key by listing models
+func checkAPI(config Config) doctorCheck {
+	baseURL := config.BaseUrl
+	claude := usesClaudeAPI(config.Model, config)
+	url := openaiURL(config, "/models")
+	if claude {
+		if baseURL == "" {
+			baseURL = "https://api.anthropic.com"
+		}
+		url = baseURL + "/v1/models"
+	} else if baseURL == "" {
+		baseURL = "https://api.openai.com"
+	}
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return doctorCheck{Name: "api", Status: checkFail, Message: err.Error()}
+	}
+	if claude {
+		req.Header.Set("x-api-key", config.ApiKey)
+		req.Header.Set("anthropic-version", "2023-06-01")
+	} else if config.ApiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+config.ApiKey)
+	}
+	setCustomHeaders(req, config.Headers)
+
+	shared, err := httpClient(config)
+	if err != nil {
+		return doctorCheck{Name: "api", Status: checkFail, Message: err.Error(),
+			Remediation: "check proxy and ca_cert in the profile"}
+	}
+	client := *shared
+	client.Timeout = 10 * time.Second
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return doctorCheck{Name: "api", Status: checkFail, Message: "cannot reach " + baseURL + ": " + err.Error(),
+			Remediation: "check your network connection, proxy, ca_cert and base_url"}
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return doctorCheck{Name: "api", Status: checkFail, Message: fmt.Sprintf("%s rejected the API key (%d)", baseURL, resp.StatusCode),
+			Remediation: "check the API key and that it belongs to the provider of " + config.Model}
+	case resp.StatusCode >= 400:
+		return doctorCheck{Name: "api", Status: checkWarn, Message: fmt.Sprintf("%s answered %d", baseURL, resp.StatusCode)}
+	}
+	return doctorCheck{Name: "api", Status: checkOK, Message: fmt.Sprintf("%s reachable in %s", baseURL, formatDuration(time.Since(start)))}
+}
+
+// focusReportingTerminals are the TERM_PROGRAM values of terminals known to report focus changes
+var focusReportingTerminals = map[string]bool{
+	"iTerm.app": true, "WezTerm": true, "ghostty": true, "vscode": true, "kitty": true, "Apple_Terminal": true,
+}
+
+// checkTerminal inspects the capabilities of the terminal used by the TUI
+func checkTerminal() []doctorCheck {
+	var checks []doctorCheck
+
+	if isatty.IsTerminal(os.Stdout.Fd()) {
+		checks = append(checks, doctorCheck{Name: "tty", Status: checkOK, Message: "stdout is a terminal"})
+	} else {
+		checks = append(checks, doctorCheck{Name: "tty", Status: checkWarn, Message: "stdout is not a terminal",
+			Remediation: "the interactive mode needs a terminal, use -n for scripts"})
+	}
+
+	colorTerm := os.Getenv("COLORTERM")
+	if colorTerm == "truecolor" || colorTerm == "24bit" {
+		checks = append(checks, doctorCheck{Name: "truecolor", Status: checkOK, Message: "COLORTERM=" + colorTerm})
+	} else {
+		checks = append(checks, doctorCheck{Name: "truecolor", Status: checkWarn, Message: "COLORTERM is not set to truecolor",
+			Remediation: "colors fall back to 256 colors; export COLORTERM=truecolor if your terminal supports it"})
+	}
+
+	termProgram := os.Getenv("TERM_PROGRAM")
+	switch {
+	case os.Getenv("TMUX") != "":
+		checks = append(checks, doctorCheck{Name: "focus reporting", Status: checkWarn, Message: "running inside tmux",
+			Remediation: "add 'set -g focus-events on' to ~/.tmux.conf for focus notifications"})
+	case focusReportingTerminals[termProgram] || strings.Contains(os.Getenv("TERM"), "kitty"):
+		checks = append(checks, doctorCheck{Name: "focus reporting", Status: checkOK, Message: "supported by " + termProgram})
+	default:
+		checks = append(checks, doctorCheck{Name: "focus reporting", Status: checkWarn, Message: "unknown support in this terminal",
+			Remediation: "notifications may be sent while the window is focused"})
+	}
+
+	if runtime.GOOS == "linux" {
+		if _, err := exec.LookPath("wl-paste"); err != nil {
+			if _, err := exec.LookPath("xclip"); err != nil {
+				checks = append(checks, doctorCheck{Name: "clipboard", Status: checkWarn, Message: "no clipboard tool found, Ctrl+V cannot paste images",
+					Remediation: "install wl-clipboard (Wayland) or xclip (X11)"})
+			}
+		}
+	}
+
+	return checks
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// editorStateEnv names the file where editor plugins list the open files,
+// .aicode/editor.json of the workspace when not set
+const editorStateEnv = "AICODE_EDITOR_STATE"
+
+// An editor state not updated for editorStateMaxAge is left over by an
+// editor that was closed
+const editorStateMaxAge = 12 * time.Hour
+
+// maxEditorSelectionLines is the number of selected lines sent to the model
+const maxEditorSelectionLines = 200
+
+// editorState is what an editor plugin tells about the files open in the
+// editor, rewriting the file whenever the focus or the selection changes:
+//
+//	{"files": [{"path": "/src/app/main.go", "active": true,
+//	  "selection": {"start_line": 10, "end_line": 24}}, {"path": "/src/app/util.go"}]}
+type editorState struct {
+	Files []editorFile `json:"files"`
+}
+
+// editorFile is a file open in the editor
+type editorFile struct {
+	Path      string           `json:"path"`
+	Active    bool             `json:"active"`    // The file with the focus
+	Selection *editorSelection `json:"selection"` // Selected lines, if any
+}
+
+// editorSelection is a range of lines, 1-based and inclusive. Text holds
+// the selection of an unsaved buffer, the lines are read from the file
+// otherwise.
+type editorSelection struct {
+	StartLine int    `json:"start_line"`
+	EndLine   int    `json:"end_line"`
+	Text      string `json:"text"`
+}
+
+// editorBridge sends the files open in the editor along with the prompts,
+// each state once so that unchanged context is not repeated every turn
+type editorBridge struct {
+	mu   sync.Mutex
+	path string // File written by the editor plugins
+	sent string // Editor context sent last
+}
+
+// GlobalEditor is the application-wide bridge with the editor
+var GlobalEditor = &editorBridge{}
+
+// SetPath sets the file written by the editor plugins, given with -editor-state
+func (e *editorBridge) SetPath(path string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.path = path
+}
+
+// statePath returns the file written by the editor plugins
+func (e *editorBridge) statePath() string {
+	if e.path != "" {
+		return expandHomeDir(e.path)
+	}
+	if path := os.Getenv(editorStateEnv); path != "" {
+		return expandHomeDir(path)
+	}
+	return filepath.Join(".aicode", "editor.json")
+}
+
+// Context returns the files open in the editor as context for the next
+// prompt, with a summary for the UI. Both are empty when no editor tells
+// its files, for sub-agents, or when the state was sent already.
+func (e *editorBridge) Context() (string, string, error) {
+	if agentDepth() > 0 {
+		return "", "", nil
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	path := e.statePath()
+	info, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && time.Since(info.ModTime()) > editorStateMaxAge) {
+		return "", "", nil
+	}
+	if err != nil {
+		return "", "", err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", "", err
+	}
+	var state editorState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return "", "", fmt.Errorf("invalid editor state %s: %v", path, err)
+	}
+
+	context, summary := state.format()
+	if context == "" || context == e.sent {
+		return "", "", nil
+	}
+	e.sent = context
+	return context, summary, nil
+}
+
+// format describes the open files, the active one first with its selection
+func (s editorState) format() (string, string) {
+	var files []editorFile
+	for _, file := range s.Files {
+		if file.Path == "" {
+			continue
+		}
+		if file.Active {
+			files = append([]editorFile{file}, files...)
+		} else {
+			files = append(files, file)
+		}
+	}
+	if len(files) == 0 {
+		return "", ""
+	}
+
+	var b strings.Builder
+	var names []string
+	b.WriteString("<editor_context>\nFiles open in the user's editor, \"this\" in the request most likely refers to the active file or its selection:\n")
+	for _, file := range files {
+		name := relativeToWorkspace(file.Path)
+		line := "- " + name
+		if file.Active {
+			line += " (active)"
+		}
+		selection := file.selectedText()
+		if selection != "" {
+			span := fmt.Sprintf("lines %d-%d", file.Selection.StartLine, file.Selection.EndLine)
+			line += ", selected " + span + ":\n" + selection
+			name += ":" + strings.TrimPrefix(span, "lines ")
+		}
+		b.WriteString(line + "\n")
+		names = append(names, name)
+	}
+	b.WriteString("</editor_context>")
+	return b.String(), strings.Join(names, ", ")
+}
+
+// selectedText returns the selected lines, read from the file unless the
+// plugin sent them, empty without a selection
+func (f editorFile) selectedText() string {
+	sel := f.Selection
+	if sel == nil || sel.StartLine <= 0 || sel.EndLine < sel.StartLine {
+		return ""
+	}
+	text := sel.Text
+	if text == "" {
+		content, err := os.ReadFile(f.Path)
+		if err != nil {
+			return ""
+		}
+		lines := strings.Split(string(content), "\n")
+		if sel.StartLine > len(lines) {
+			return ""
+		}
+		text = strings.Join(lines[sel.StartLine-1:min(sel.EndLine, len(lines))], "\n")
+	}
+	lines := strings.Split(strings.TrimRight(text, "\n"), "\n")
+	if len(lines) > maxEditorSelectionLines {
+		lines = append(lines[:maxEditorSelectionLines], fmt.Sprintf("... [%d more selected lines]", len(lines)-maxEditorSelectionLines))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// relativeToWorkspace returns path relative to the working directory when
+// it is inside it
+func relativeToWorkspace(path string) string {
+	wd, err := os.Getwd()
+	if err != nil || !filepath.IsAbs(path) {
+		return path
+	}
+	if rel, err := filepath.Rel(wd, path); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+	return path
+}
+
+// withEditorContext puts the files open in the editor before prompt, when
+// they changed since the last prompt
+func withEditorContext(prompt string) (string, string, error) {
+	context, summary, err := GlobalEditor.Context()
+	if err != nil || context == "" {
+		return prompt, "", err
+	}
+	return context + "\n\n" + prompt, summary, nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"strings"
+)
+
+// Endpoint is an OpenAI-compatible server declared in the profile, such as
+// vLLM, LiteLLM or Together, with what it supports and costs
+type Endpoint struct {
+	BaseUrl       string            `yaml:"base_url"`
+	ApiKey        string            `yaml:"api_key"`
+	ApiKeyShell   string            `yaml:"api_key_shell"`
+	ApiKeys       []string          `yaml:"api_keys"`
+	Price         *ModelPrice       `yaml:"price"`          // Dollars per million tokens, no cost is shown without it
+	ContextWindow int               `yaml:"context_window"` // Tokens, 200k when not set
+	MaxTokens     int               `yaml:"max_tokens"`     // Tokens of a response, a quarter of the context window up to 20k when not set
+	Tools         *bool             `yaml:"tools"`          // Whether the models can call tools, true when not set
+	Reasoning     bool              `yaml:"reasoning"`      // Whether to send reasoning_effort
+	Headers       map[string]string `yaml:"headers"`        // Sent with every request, besides the headers of the profile
+	PromptCache   string            `yaml:"prompt_cache"`   // Overrides the prompt_cache of the profile, e.g. cache_control for gateways in front of Claude
+}
+
+// activeEndpoint returns the endpoint selected with endpoint in the profile
+func (c Config) activeEndpoint() (Endpoint, bool) {
+	if c.Endpoint == "" {
+		return Endpoint{}, false
+	}
+	endpoint, ok := c.Endpoints[c.Endpoint]
+	return endpoint, ok
+}
+
+// applyEndpoint configures the OpenAI-compatible provider for the selected
+// endpoint. Only the key of the endpoint is sent, never the OpenAI or
+// Anthropic key of the environment.
+func applyEndpoint(config *Config) error {
+	if config.Endpoint == "" {
+		return nil
+	}
+	endpoint, ok := config.activeEndpoint()
+	if !ok {
+		return fmt.Errorf("endpoint %q is not declared in endpoints", config.Endpoint)
+	}
+	if endpoint.BaseUrl == "" {
+		return fmt.Errorf("endpoint %q has no base_url", config.Endpoint)
+	}
+
+	config.Provider = providerOpenAICompatible
+	config.BaseUrl = endpoint.BaseUrl
+	config.ApiKey = endpoint.ApiKey
+	if config.ApiKeys = expandKeys(endpoint.ApiKeys); len(config.ApiKeys) > 0 {
+		config.ApiKey = config.ApiKeys[0]
+	}
+	if len(endpoint.Headers) > 0 {
+		config.Headers = maps.Clone(config.Headers)
+		if config.Headers == nil {
+			config.Headers = map[string]string{}
+		}
+		maps.Copy(config.Headers, endpoint.Headers)
+	}
+	if endpoint.ApiKeyShell != "" {
+		key, err := executeShellCommand(endpoint.ApiKeyShell)
+		if err != nil {
+			return errors.New("failed to get the API key of the endpoint from shell command: " + err.Error())
+		}
+		config.ApiKey = strings.TrimSpace(key)
+	}
+	return nil
+}
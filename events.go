@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// Output formats of the non-interactive mode
+const (
+	outputText       = "text"
+	outputJSON       = "json"
+	outputStreamJSON = "stream-json"
+)
+
+// streamEvent is one line of the stream-json output
+type streamEvent struct {
+	Type         string          `json:"type"` // assistant_delta, tool_call, tool_result, usage, error or done, and prompt or note in transcripts
+	Text         string          `json:"text,omitempty"`
+	Answer       string          `json:"answer,omitempty"` // Deliverable marked in the final answer, with done
+	ID           string          `json:"id,omitempty"`
+	Name         string          `json:"name,omitempty"`
+	Input        json.RawMessage `json:"input,omitempty"`
+	Output       string          `json:"output,omitempty"`
+	InputTokens  int             `json:"input_tokens,omitempty"`
+	OutputTokens int             `json:"output_tokens,omitempty"`
+	Cost         float64         `json:"cost,omitempty"`
+	Error        string          `json:"error,omitempty"`
+	Turn         int             `json:"turn,omitempty"`           // Turn a note is attached to
+	Version      int             `json:"schema_version,omitempty"` // Version of the definition of the tool of a tool_call
+}
+
+// checkOutputFormat returns an error for unknown output formats
+func checkOutputFormat(format string) error {
+	if format != outputText && format != outputJSON && format != outputStreamJSON {
+		return fmt.Errorf("unknown output format %q, expected %s, %s or %s", format, outputText, outputJSON, outputStreamJSON)
+	}
+	return nil
+}
+
+var eventMu sync.Mutex
+
+// emitEvent writes an event as a line of JSON on stdout
+func emitEvent(event streamEvent) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	eventMu.Lock()
+	defer eventMu.Unlock()
+	os.Stdout.Write(append(data, '\n'))
+}
+
+// toolInputJSON returns the input of a tool call as a JSON object, as OpenAI
+// passes the arguments as a string holding JSON
+func toolInputJSON(input []byte) json.RawMessage {
+	var arguments string
+	if err := json.Unmarshal(input, &arguments); err == nil && json.Valid([]byte(arguments)) {
+		return json.RawMessage(arguments)
+	}
+	if !json.Valid(input) {
+		data, _ := json.Marshal(string(input))
+		return data
+	}
+	return input
+}
+
+// usageEvent reports the tokens used and the cost of the session so far
+func usageEvent(llm Llm) streamEvent {
+	info := llm.ProviderInfo()
+	return streamEvent{Type: "usage", InputTokens: info.InputTokens, OutputTokens: info.OutputTokens, Cost: llm.CalculatePrice()}
+}
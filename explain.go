@@ -0,0 +1,420 @@
+package main
+
+import (
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// Risk classes of a shell command, ordered from least to most dangerous
+const (
+	riskReads   = "reads"
+	riskWrites  = "writes"
+	riskNetwork = "network"
+	riskDeletes = "deletes"
+	// Interpreters, runners and unknown programs may do anything
+	riskArbitrary = "arbitrary/unknown effects"
+)
+
+// commandInfo describes a well-known program
+type commandInfo struct {
+	description string
+	risks       []string
+}
+
+var knownCommands = map[string]commandInfo{
+	"cat":     {"print files", []string{riskReads}},
+	"less":    {"page through files", []string{riskReads}},
+	"head":    {"print the start of files", []string{riskReads}},
+	"tail":    {"print the end of files", []string{riskReads}},
+	"ls":      {"list directory contents", []string{riskReads}},
+	"find":    {"search for files", []string{riskReads}},
+	"fd":      {"search for files", []string{riskReads}},
+	"grep":    {"search file contents", []string{riskReads}},
+	"rg":      {"search file contents", []string{riskReads}},
+	"wc":      {"count lines, words and bytes", []string{riskReads}},
+	"diff":    {"compare files", []string{riskReads}},
+	"echo":    {"print text", nil},
+	"pwd":     {"print the working directory", nil},
+	"cd":      {"change the working directory", nil},
+	"sort":    {"sort lines", nil},
+	"uniq":    {"filter repeated lines", nil},
+	"jq":      {"process JSON", nil},
+	"awk":     {"process text", nil},
+	"sed":     {"edit text streams", nil},
+	"xargs":   {"run a command for each input line", nil},
+	"mkdir":   {"create directories", []string{riskWrites}},
+	"touch":   {"create or update files", []string{riskWrites}},
+	"cp":      {"copy files", []string{riskWrites}},
+	"mv":      {"move or rename files", []string{riskWrites}},
+	"ln":      {"create links", []string{riskWrites}},
+	"chmod":   {"change file permissions", []string{riskWrites}},
+	"chown":   {"change file ownership", []string{riskWrites}},
+	"tee":     {"write input to files", []string{riskWrites}},
+	"rm":      {"delete files", []string{riskDeletes}},
+	"rmdir":   {"delete directories", []string{riskDeletes}},
+	"unlink":  {"delete a file", []string{riskDeletes}},
+	"shred":   {"overwrite and delete files", []string{riskDeletes}},
+	"curl":    {"transfer data from or to a URL", []string{riskNetwork}},
+	"wget":    {"download files", []string{riskNetwork, riskWrites}},
+	"ssh":     {"run commands on a remote host", []string{riskNetwork}},
+	"scp":     {"copy files over SSH", []string{riskNetwork, riskWrites}},
+	"rsync":   {"synchronize files", []string{riskNetwork, riskWrites}},
+	"nc":      {"open network connections", []string{riskNetwork}},
+	"ping":    {"check network reachability", []string{riskNetwork}},
+	"go":      {"run the Go toolchain", []string{riskArbitrary}},
+	"npm":     {"run the Node package manager", []string{riskArbitrary}},
+	"npx":     {"run a Node package", []string{riskArbitrary}},
+	"pip":     {"run the Python package manager", []string{riskReads}},
+	"make":    {"run build targets", []string{riskArbitrary}},
+	"python":  {"run a Python program", []string{riskArbitrary}},
+	"python3": {"run a Python program", []string{riskArbitrary}},
+	"node":    {"run a Node program", []string{riskArbitrary}},
+	"sh":      {"run a shell script", []string{riskArbitrary}},
+	"bash":    {"run a shell script", []string{riskArbitrary}},
+	"eval":    {"run a shell command", []string{riskArbitrary}},
+	"docker":  {"manage containers", []string{riskArbitrary}},
+	"kill":    {"terminate processes", []string{riskDeletes}},
+}
+
+// subcommandRisks refines the risks of programs depending on their subcommand
+var subcommandRisks = map[string]map[string][]string{
+	"git": {
+		"status": {riskReads}, "log": {riskReads}, "diff": {riskReads}, "show": {riskReads}, "blame": {riskReads},
+		"branch": {riskReads}, "add": {riskWrites}, "commit": {riskWrites}, "checkout": {riskWrites},
+		"switch": {riskWrites}, "merge": {riskWrites}, "rebase": {riskWrites}, "stash": {riskWrites},
+		"reset": {riskWrites, riskDeletes}, "clean": {riskDeletes}, "rm": {riskDeletes},
+		"push": {riskNetwork}, "pull": {riskNetwork, riskWrites}, "fetch": {riskNetwork}, "clone": {riskNetwork, riskWrites},
+	},
+	"go": {
+		"build": {riskReads, riskWrites}, "vet": {riskReads}, "fmt": {riskWrites}, "doc": {riskReads}, "env": {riskReads},
+		"test": {riskArbitrary}, "run": {riskArbitrary}, "generate": {riskArbitrary},
+		"get": {riskNetwork, riskWrites}, "install": {riskNetwork, riskWrites}, "mod": {riskNetwork, riskWrites},
+	},
+	"npm": {
+		"install": {riskNetwork, riskWrites, riskArbitrary}, "ci": {riskNetwork, riskWrites, riskDeletes, riskArbitrary},
+		"publish": {riskNetwork}, "ls": {riskReads}, "view": {riskNetwork},
+		"run": {riskArbitrary}, "test": {riskArbitrary}, "start": {riskArbitrary}, "exec": {riskArbitrary},
+	},
+	"pip": {
+		"install": {riskNetwork, riskWrites, riskArbitrary}, "uninstall": {riskDeletes},
+	},
+	"docker": {
+		"ps": {riskReads}, "images": {riskReads}, "logs": {riskReads}, "inspect": {riskReads},
+		"rm": {riskDeletes}, "rmi": {riskDeletes}, "pull": {riskNetwork}, "push": {riskNetwork},
+		"run": {riskArbitrary}, "exec": {riskArbitrary}, "build": {riskArbitrary},
+	},
+}
+
+var envAssignment = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*=`)
+
+// xargsValueFlags are the flags of xargs taking a value as the next field
+var xargsValueFlags = map[string]bool{
+	"-I": true, "-n": true, "-P": true, "-d": true, "-L": true, "-s": true, "-a": true, "-E": true,
+}
+
+// commandExplanation is a plain-English summary of a shell command and its risks
+type commandExplanation struct {
+	Summary string
+	Risks   []string
+}
+
+// String formats the explanation for display
+func (e commandExplanation) String() string {
+	if len(e.Risks) == 0 {
+		return e.Summary
+	}
+	return e.Summary + " [" + strings.Join(e.Risks, ", ") + "]"
+}
+
+// explainCommand statically analyzes a shell command line and describes what
+// each part of it does along with the kinds of side effects it may have
+func explainCommand(command string) commandExplanation {
+	riskSet := map[string]bool{}
+	var parts []string
+
+	for _, segment := range splitCommand(command) {
+		for _, target := range segment.outputs {
+			if target != "/dev/null" {
+				riskSet[riskWrites] = true
+			}
+		}
+
+		fields := segment.words
+		// Skip environment assignments and privilege escalation prefixes
+		asRoot := false
+		for len(fields) > 0 && (envAssignment.MatchString(fields[0]) || fields[0] == "sudo" || fields[0] == "env") {
+			if fields[0] == "sudo" {
+				asRoot = true
+			}
+			fields = fields[1:]
+		}
+		if len(fields) == 0 {
+			continue
+		}
+
+		description, risks := explainProgram(fields)
+		if asRoot {
+			description += " as root"
+		}
+		for _, risk := range risks {
+			riskSet[risk] = true
+		}
+		parts = append(parts, description)
+	}
+	explanation := commandExplanation{Summary: "Will " + strings.Join(parts, ", then ")}
+	if len(parts) == 0 {
+		explanation.Summary = "Empty command"
+	}
+	for _, risk := range []string{riskReads, riskWrites, riskNetwork, riskDeletes, riskArbitrary} {
+		if riskSet[risk] {
+			explanation.Risks = append(explanation.Risks, risk)
+		}
+	}
+	return explanation
+}
+
+// commandSegment is a simple command of a command line: its words without
+// quotes and the files its output is redirected to
+type commandSegment struct {
+	words   []string
+	outputs []string
+}
+
+// splitCommand splits a command line into its simple commands at the
+// operators ; & && | || and newlines found outside of quotes, so that
+// quoted text is never taken for a command, and adds the commands run by
+// $(...) and backquotes. Descriptor duplications such as 2>&1 are dropped
+// and redirections of the output are collected.
+func splitCommand(command string) []commandSegment {
+	var segments, substituted []commandSegment
+	var current commandSegment
+	var word strings.Builder
+	inWord := false
+	redirect := false // The next word is the target of an output redirection
+
+	endWord := func() {
+		if !inWord {
+			return
+		}
+		if redirect {
+			current.outputs = append(current.outputs, word.String())
+			redirect = false
+		} else {
+			current.words = append(current.words, word.String())
+		}
+		word.Reset()
+		inWord = false
+	}
+	endSegment := func() {
+		endWord()
+		// Substituted commands run before the command using their output
+		segments = append(segments, substituted...)
+		substituted = nil
+		if len(current.words) > 0 || len(current.outputs) > 0 {
+			segments = append(segments, current)
+		}
+		current = commandSegment{}
+	}
+
+	runes := []rune(command)
+	// substitution runs the command of a $(...) or `...` starting at i and
+	// returns the index of its end
+	substitution := func(i int) int {
+		start, depth := i+2, 1
+		if runes[i] == '`' {
+			start = i + 1
+		}
+		end := start
+		for ; end < len(runes); end++ {
+			if runes[i] == '`' {
+				if runes[end] == '`' {
+					break
+				}
+				continue
+			}
+			if runes[end] == '(' {
+				depth++
+			} else if runes[end] == ')' {
+				if depth--; depth == 0 {
+					break
+				}
+			}
+		}
+		substituted = append(substituted, splitCommand(string(runes[start:min(end, len(runes))]))...)
+		return end
+	}
+
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case r == '`' || (r == '$' && i+1 < len(runes) && runes[i+1] == '('):
+			i = substitution(i)
+			inWord = true
+		case r == '\\':
+			if i+1 < len(runes) && runes[i+1] != '\n' {
+				word.WriteRune(runes[i+1])
+				inWord = true
+			}
+			i++
+		case r == '\'':
+			inWord = true
+			for i++; i < len(runes) && runes[i] != '\''; i++ {
+				word.WriteRune(runes[i])
+			}
+		case r == '"':
+			inWord = true
+			for i++; i < len(runes) && runes[i] != '"'; i++ {
+				if runes[i] == '`' || (runes[i] == '$' && i+1 < len(runes) && runes[i+1] == '(') {
+					i = substitution(i)
+					continue
+				}
+				if runes[i] == '\\' && i+1 < len(runes) && strings.ContainsRune(`"\$`+"`", runes[i+1]) {
+					i++
+				}
+				word.WriteRune(runes[i])
+			}
+		case r == '#' && !inWord:
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+			endSegment()
+		case r == '>' || r == '<' || (r == '&' && i+1 < len(runes) && runes[i+1] == '>'):
+			// A word of digits before the operator is the descriptor redirected
+			if inWord && strings.Trim(word.String(), "0123456789") == "" {
+				word.Reset()
+				inWord = false
+			}
+			endWord()
+			if r == '&' {
+				i++
+			}
+			output := runes[i] == '>'
+			for i+1 < len(runes) && (runes[i+1] == '>' || runes[i+1] == '|') {
+				i++
+			}
+			if i+1 < len(runes) && runes[i+1] == '&' {
+				// Descriptor duplication such as 2>&1 or <&-
+				for i++; i+1 < len(runes) && (unicode.IsDigit(runes[i+1]) || runes[i+1] == '-'); i++ {
+				}
+				continue
+			}
+			redirect = output
+			if !output {
+				// The input file is read, not run
+				for i+1 < len(runes) && unicode.IsSpace(runes[i+1]) && runes[i+1] != '\n' {
+					i++
+				}
+				for i+1 < len(runes) && !unicode.IsSpace(runes[i+1]) && !strings.ContainsRune(";&|<>", runes[i+1]) {
+					i++
+				}
+			}
+		case r == ';' || r == '&' || r == '|' || r == '\n':
+			endSegment()
+			if i+1 < len(runes) && (runes[i+1] == r && r != ';' && r != '\n') {
+				i++
+			}
+		case unicode.IsSpace(r):
+			endWord()
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	endSegment()
+	return segments
+}
+
+// explainProgram describes what a program run with its arguments does and
+// its risks. Programs running other commands, like xargs or find -exec, take
+// the risks of these commands.
+func explainProgram(fields []string) (string, []string) {
+	program := filepath.Base(fields[0])
+	info, known := knownCommands[program]
+	risks := info.risks
+
+	if sub, ok := subcommandRisks[program]; ok && len(fields) > 1 {
+		if subRisks, ok := sub[fields[1]]; ok {
+			risks = subRisks
+			info.description = program + " " + fields[1]
+			known = true
+		}
+	}
+
+	switch {
+	case program == "sed" && hasFlag(fields, "-i"):
+		risks = []string{riskWrites}
+		info.description = "edit files in place"
+	case program == "rm" && (hasFlag(fields, "-r") || hasFlag(fields, "-R")):
+		info.description = "recursively delete files"
+	case program == "curl" && (hasFlag(fields, "-o") || hasFlag(fields, "-O")):
+		risks = append(risks, riskWrites)
+	case program == "xargs":
+		if target := xargsCommand(fields); len(target) > 0 {
+			description, targetRisks := explainProgram(target)
+			return description + " for each input line", targetRisks
+		}
+	case program == "find":
+		return explainFind(fields)
+	}
+
+	if !known {
+		return "run " + program, []string{riskArbitrary}
+	}
+	return info.description, risks
+}
+
+// xargsCommand returns the command run by xargs, nil when it runs echo by default
+func xargsCommand(fields []string) []string {
+	for i := 1; i < len(fields); i++ {
+		switch {
+		case xargsValueFlags[fields[i]]:
+			i++
+		case !strings.HasPrefix(fields[i], "-"):
+			return fields[i:]
+		}
+	}
+	return nil
+}
+
+// explainFind describes a find, which deletes the files it finds with -delete
+// and runs a command on each of them with -exec and its variants
+func explainFind(fields []string) (string, []string) {
+	description := knownCommands["find"].description
+	risks := slices.Clone(knownCommands["find"].risks)
+	for i := 1; i < len(fields); i++ {
+		switch fields[i] {
+		case "-delete":
+			description += " and delete them"
+			risks = append(risks, riskDeletes)
+		case "-exec", "-execdir", "-ok", "-okdir":
+			end := i + 1
+			for end < len(fields) && fields[end] != ";" && fields[end] != `\;` && fields[end] != "+" {
+				end++
+			}
+			if end > i+1 {
+				execDescription, execRisks := explainProgram(fields[i+1 : end])
+				description += " and " + execDescription + " for each"
+				risks = append(risks, execRisks...)
+			}
+			i = end
+		}
+	}
+	return description, risks
+}
+
+// hasFlag reports whether a short flag is present, alone or combined (e.g. -rf)
+func hasFlag(fields []string, flag string) bool {
+	letter := strings.TrimPrefix(flag, "-")
+	for _, field := range fields[1:] {
+		if field == flag {
+			return true
+		}
+		if strings.HasPrefix(field, "-") && !strings.HasPrefix(field, "--") && strings.Contains(field[1:], letter) {
+			return true
+		}
+	}
+	return false
+}
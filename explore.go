@@ -0,0 +1,285 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	maxExploreTerms         = 5  // Search terms taken from the question
+	maxExploreFiles         = 8  // Files in the evidence bundle
+	maxExploreSnippets      = 6  // Matching lines shown per file
+	maxExploreSymbols       = 12 // Outline entries shown per file
+	maxExploreNamedFiles    = 10 // Files listed because their name matches a term
+	exploreMultiTermBonus   = 2  // Added to a file's score for each additional term it matches
+	exploreSnippetMaxLength = 200
+)
+
+// ExploreToolParams represents the parameters for the Explore tool
+type ExploreToolParams struct {
+	Question string `json:"question"`
+	Path     string `json:"path,omitempty"`
+	Include  string `json:"include,omitempty"`
+}
+
+// exploreToken matches quoted phrases and identifier-like words of a question
+var exploreToken = regexp.MustCompile(`"[^"]+"|` + "`[^`]+`" + `|[A-Za-z_][A-Za-z0-9_.]*[A-Za-z0-9_]`)
+
+// exploreStopWords are frequent words of questions that make poor search terms
+var exploreStopWords = map[string]bool{
+	"about": true, "does": true, "done": true, "each": true, "find": true, "from": true,
+	"have": true, "here": true, "into": true, "what": true, "when": true, "where": true,
+	"which": true, "while": true, "with": true, "that": true, "this": true, "there": true,
+	"their": true, "them": true, "then": true, "they": true, "used": true, "uses": true,
+	"using": true, "work": true, "works": true, "code": true, "file": true, "files": true,
+	"function": true, "functions": true, "method": true, "implemented": true, "implement": true,
+	"defined": true, "handled": true, "handle": true, "called": true, "happens": true,
+	"should": true, "would": true, "could": true, "other": true, "some": true, "show": true,
+	"project": true, "repository": true, "between": true, "after": true, "before": true,
+}
+
+// exploreTerms extracts the search terms of a question: quoted phrases and
+// identifiers first, then the longest remaining words
+func exploreTerms(question string) []string {
+	var identifiers, words []string
+	seen := map[string]bool{}
+	for _, token := range exploreToken.FindAllString(question, -1) {
+		quoted := strings.Trim(token, "\"`")
+		switch {
+		case quoted != token:
+			identifiers = append(identifiers, quoted)
+		case strings.ContainsAny(token, "_.") || strings.ToLower(token[1:]) != token[1:]:
+			identifiers = append(identifiers, token)
+		case len(token) >= 4 && !exploreStopWords[strings.ToLower(token)]:
+			word := strings.ToLower(token)
+			// Plurals still match as substrings once the s is dropped
+			if len(word) > 5 && strings.HasSuffix(word, "s") && !strings.HasSuffix(word, "ss") {
+				word = strings.TrimSuffix(word, "s")
+			}
+			words = append(words, word)
+		}
+	}
+	sort.SliceStable(words, func(i, j int) bool { return len(words[i]) > len(words[j]) })
+
+	var terms []string
+	for _, term := range append(identifiers, words...) {
+		if !seen[term] && len(terms) < maxExploreTerms {
+			seen[term] = true
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+// exploreFile collects the evidence found for a file across terms
+type exploreFile struct {
+	path  string
+	score float64
+	terms []string
+	lines map[string]bool
+	order []string
+}
+
+// ExecuteExploreTool answers an exploration question in one call: it greps
+// for the terms of the question, ranks the files, and returns the best ones
+// with their matching lines and outline
+func ExecuteExploreTool(paramsJSON json.RawMessage) (string, error) {
+	params, err := parseToolParams[ExploreToolParams](paramsJSON, "Question")
+	if err != nil {
+		return "", fmt.Errorf("failed to parse explore tool parameters: %v", err)
+	}
+	if params.Question == "" {
+		return "", fmt.Errorf("question parameter is required")
+	}
+	terms := exploreTerms(params.Question)
+	if len(terms) == 0 {
+		return "", fmt.Errorf("no search terms found in the question, name the identifiers, strings or topics to look for")
+	}
+
+	wd, err := workspaceDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get current directory: %v", err)
+	}
+	if params.Path == "" {
+		params.Path = wd
+	}
+
+	files := map[string]*exploreFile{}
+	var named []string
+	namedSeen := map[string]bool{}
+	for _, term := range terms {
+		output, err := searchContent(regexp.QuoteMeta(term), params.Path, params.Include)
+		if err != nil {
+			return "", fmt.Errorf("searching for %q: %v", term, err)
+		}
+		for _, ranked := range rankGrepMatches(output, term) {
+			file, ok := files[ranked.path]
+			if !ok {
+				file = &exploreFile{path: ranked.path, lines: map[string]bool{}}
+				files[ranked.path] = file
+			} else {
+				file.score += exploreMultiTermBonus
+			}
+			file.score += ranked.score
+			file.terms = append(file.terms, term)
+			for _, line := range ranked.lines {
+				if !file.lines[line] {
+					file.lines[line] = true
+					file.order = append(file.order, line)
+				}
+			}
+		}
+
+		// Files named after the term, even when their content doesn't mention it
+		if strings.ContainsAny(term, " /") {
+			continue
+		}
+		found, err := findFiles("*"+term+"*", params.Path)
+		if err != nil || strings.HasPrefix(found, "Error executing command:") {
+			continue
+		}
+		for _, ranked := range rankFoundPaths(found, term) {
+			if !namedSeen[ranked.path] {
+				namedSeen[ranked.path] = true
+				named = append(named, ranked.path)
+			}
+		}
+	}
+
+	ranked := make([]*exploreFile, 0, len(files))
+	for _, file := range files {
+		ranked = append(ranked, file)
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].score != ranked[j].score {
+			return ranked[i].score > ranked[j].score
+		}
+		return ranked[i].path < ranked[j].path
+	})
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Search terms: %s\n", strings.Join(terms, ", "))
+	if len(ranked) == 0 && len(named) == 0 {
+		b.WriteString("No files match any of the terms. Try other names for the concepts of the question.\n")
+		return b.String(), nil
+	}
+
+	for _, file := range ranked[:min(len(ranked), maxExploreFiles)] {
+		fmt.Fprintf(&b, "\n## %s (matches %s)\n", exploreRelPath(wd, file.path), strings.Join(file.terms, ", "))
+		snippets := exploreSnippets(file.order, terms)
+		if content, err := workspaceReadFile(file.path); err == nil {
+			lines := strings.Split(string(content), "\n")
+			symbols := exploreSymbols(buildOutline(file.path, lines), terms, snippets)
+			if len(symbols) > 0 {
+				b.WriteString("Symbols:\n")
+				for _, entry := range symbols {
+					fmt.Fprintf(&b, "  %d: %s\n", entry.Line, entry.Text)
+				}
+			}
+		}
+		b.WriteString("Matches:\n")
+		for _, line := range snippets {
+			number, text, _ := strings.Cut(line, ":")
+			text = strings.TrimSpace(text)
+			if len(text) > exploreSnippetMaxLength {
+				text = text[:exploreSnippetMaxLength] + "..."
+			}
+			fmt.Fprintf(&b, "  %s: %s\n", number, text)
+		}
+		if len(file.order) > maxExploreSnippets {
+			fmt.Fprintf(&b, "  ... %d more matching lines\n", len(file.order)-maxExploreSnippets)
+		}
+	}
+	if len(ranked) > maxExploreFiles {
+		var rest []string
+		for _, file := range ranked[maxExploreFiles:min(len(ranked), maxExploreFiles+15)] {
+			rest = append(rest, exploreRelPath(wd, file.path))
+		}
+		fmt.Fprintf(&b, "\nOther matching files (%d): %s\n", len(ranked)-maxExploreFiles, strings.Join(rest, ", "))
+	}
+
+	var extraNamed []string
+	for _, path := range named {
+		if _, ok := files[path]; !ok && len(extraNamed) < maxExploreNamedFiles {
+			extraNamed = append(extraNamed, exploreRelPath(wd, path))
+		}
+	}
+	if len(extraNamed) > 0 {
+		fmt.Fprintf(&b, "\nFiles named after the terms:\n  %s\n", strings.Join(extraNamed, "\n  "))
+	}
+	return b.String(), nil
+}
+
+// exploreSnippets picks the matching lines shown for a file, preferring lines
+// with several terms and declarations, in line order
+func exploreSnippets(matches []string, terms []string) []string {
+	weight := func(match string) int {
+		_, text, _ := strings.Cut(match, ":")
+		lower := strings.ToLower(text)
+		n := 0
+		for _, term := range terms {
+			if strings.Contains(lower, strings.ToLower(term)) {
+				n++
+			}
+		}
+		if definitionLine.MatchString(text) {
+			n++
+		}
+		return n
+	}
+	picked := append([]string(nil), matches...)
+	sort.SliceStable(picked, func(i, j int) bool { return weight(picked[i]) > weight(picked[j]) })
+	picked = picked[:min(len(picked), maxExploreSnippets)]
+	sort.SliceStable(picked, func(i, j int) bool { return matchLine(picked[i]) < matchLine(picked[j]) })
+	return picked
+}
+
+// exploreSymbols keeps the outline entries mentioning a term or enclosing one
+// of the shown matches
+func exploreSymbols(outline []outlineEntry, terms []string, snippets []string) []outlineEntry {
+	keep := map[int]bool{}
+	for i, entry := range outline {
+		lower := strings.ToLower(entry.Text)
+		for _, term := range terms {
+			if strings.Contains(lower, strings.ToLower(term)) {
+				keep[i] = true
+				break
+			}
+		}
+	}
+	for _, snippet := range snippets {
+		line := matchLine(snippet)
+		// The last declaration starting before the match encloses it
+		i := sort.Search(len(outline), func(i int) bool { return outline[i].Line > line }) - 1
+		if i >= 0 {
+			keep[i] = true
+		}
+	}
+
+	var relevant []outlineEntry
+	for i, entry := range outline {
+		if keep[i] && len(relevant) < maxExploreSymbols {
+			relevant = append(relevant, entry)
+		}
+	}
+	return relevant
+}
+
+// matchLine returns the line number of a line:text match
+func matchLine(match string) int {
+	var line int
+	fmt.Sscanf(match, "%d:", &line)
+	return line
+}
+
+// exploreRelPath shortens paths inside the working directory
+func exploreRelPath(wd, path string) string {
+	if rel, err := filepath.Rel(wd, path); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+	return path
+}
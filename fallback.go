@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Message telling that the conversation moved to the fallback model
+type modelFallbackMsg struct {
+	from, to string
+}
+
+// fallbackLlm serves the conversation with the primary model and moves it,
+// converted to the format of the fallback provider, to the fallback model
+// when the primary keeps failing with server errors or overload after the
+// retries of postWithRetry. The conversation stays with the fallback model
+// for the rest of the session.
+type fallbackLlm struct {
+	Llm                // Provider serving the conversation
+	primary   Llm      // Provider of the model of the profile
+	fallback  Llm      // Created when the conversation moves to it
+	config    Config   // Configuration of the fallback provider
+	toolNames []string // Tool subset to offer the fallback model
+}
+
+// newFallbackLlm wraps the provider of the primary model, checking that the
+// fallback model can be reached
+func newFallbackLlm(primary Llm, config Config) (*fallbackLlm, error) {
+	fallbackConfig, err := fallbackConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return &fallbackLlm{Llm: primary, primary: primary, config: fallbackConfig}, nil
+}
+
+// fallbackConfig returns the configuration of the fallback model: the
+// endpoint named by fallback_endpoint, OpenRouter when it serves the primary
+// model, or else the OpenAI or Anthropic API chosen from the model name, with
+// the key of the primary model when it uses the same API
+func fallbackConfig(config Config) (Config, error) {
+	fallback := config
+	fallback.Model = config.FallbackModel
+	fallback.FallbackModel = ""
+	fallback.FallbackEndpoint = ""
+	if config.FallbackEndpoint != "" {
+		fallback.Endpoint = config.FallbackEndpoint
+		return fallback, applyEndpoint(&fallback)
+	}
+	if config.Provider == providerOpenRouter {
+		return fallback, nil
+	}
+
+	fallback.Provider = ""
+	fallback.Endpoint = ""
+	claudeAPI := usesClaudeAPI(fallback.Model, fallback)
+	if config.Provider == "" && claudeAPI == usesClaudeAPI(config.Model, config) {
+		return fallback, nil
+	}
+	fallback, err := nativeAPIConfig(fallback)
+	if err != nil {
+		return fallback, fmt.Errorf("fallback model %v, or a fallback_endpoint", err)
+	}
+	return fallback, nil
+}
+
+// nativeAPIConfig returns config for the OpenAI or Anthropic API chosen from
+// its model, with the key of the environment variable of that API
+func nativeAPIConfig(config Config) (Config, error) {
+	config.Provider, config.Endpoint, config.BaseUrl, config.Headers, config.ApiKeys = "", "", "", nil, nil
+	keyEnv := "OPENAI_API_KEY"
+	if usesClaudeAPI(config.Model, config) {
+		keyEnv = "ANTHROPIC_API_KEY"
+	}
+	config.ApiKey = os.Getenv(keyEnv)
+	if config.ApiKey == "" {
+		return config, fmt.Errorf("%s needs the %s environment variable", config.Model, keyEnv)
+	}
+	return config, nil
+}
+
+// modelUnavailable tells whether err is a server error, an overloaded model
+// or a provider that stopped answering, which another model may not have, as
+// opposed to a refused request
+func modelUnavailable(err error) bool {
+	var timeout requestTimeoutError
+	if errors.As(err, &timeout) {
+		return true
+	}
+	var apiErr *apiError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	return apiErr.StatusCode >= 500 || strings.Contains(strings.ToLower(apiErr.Message), "overloaded")
+}
+
+// Inference moves the conversation to the fallback model when the request
+// to the primary model still fails with a server error or overload once retried
+func (f *fallbackLlm) Inference(ctx context.Context, prompt string) (InferenceResponse, error) {
+	if f.fallback != nil {
+		return f.fallback.Inference(ctx, prompt)
+	}
+	response, err := f.primary.Inference(ctx, prompt)
+	if err == nil || !modelUnavailable(err) {
+		return response, err
+	}
+	// The prompt is in the history of the primary model already
+	f.switchToFallback(err)
+	return f.fallback.Inference(ctx, "")
+}
+
+// switchToFallback moves the conversation to the fallback model and tells the user
+func (f *fallbackLlm) switchToFallback(cause error) {
+	if usesClaudeAPI(f.config.Model, f.config) {
+		f.fallback = NewClaude(f.config)
+	} else {
+		f.fallback = NewOpenAI(f.config)
+	}
+	f.fallback.SetToolSubset(f.toolNames)
+	f.fallback.SetHistory(f.primary.History())
+	f.Llm = f.fallback
+
+	from, to := f.primary.GetModel(), f.fallback.GetModel()
+	slog.Warn("Moving the conversation to the fallback model", "model", from, "fallback", to, "error", cause)
+	switch {
+	case programRef != nil:
+		programRef.Send(modelFallbackMsg{from: from, to: to})
+	case os.Getenv(agentProgressEnv) != "":
+		reportAgentProgress("%s is unavailable, continuing with %s", from, to)
+	default:
+		fmt.Fprintf(os.Stderr, "%s is unavailable (%v), continuing with %s\n", from, cause, to)
+	}
+}
+
+// SetConfig replaces the configuration of both models, the fallback keeping
+// its own model and provider
+func (f *fallbackLlm) SetConfig(config Config) {
+	f.primary.SetConfig(config)
+	fallback := config
+	fallback.Model, fallback.Provider, fallback.Endpoint = f.config.Model, f.config.Provider, f.config.Endpoint
+	fallback.BaseUrl, fallback.ApiKey, fallback.Headers = f.config.BaseUrl, f.config.ApiKey, f.config.Headers
+	fallback.FallbackModel, fallback.FallbackEndpoint = "", ""
+	f.config = fallback
+	if f.fallback != nil {
+		f.fallback.SetConfig(fallback)
+	}
+}
+
+// SetToolSubset restricts the tools offered to the model serving the conversation
+func (f *fallbackLlm) SetToolSubset(toolNames []string) {
+	f.toolNames = toolNames
+	f.Llm.SetToolSubset(toolNames)
+}
+
+// CalculatePrice calculates the cost of the conversation on both models
+func (f *fallbackLlm) CalculatePrice() float64 {
+	if f.fallback == nil {
+		return f.primary.CalculatePrice()
+	}
+	return f.primary.CalculatePrice() + f.fallback.CalculatePrice()
+}
+
+// ProviderInfo describes the model serving the conversation, with the
+// tokens used by both models in the session totals
+func (f *fallbackLlm) ProviderInfo() ProviderInfo {
+	info := f.Llm.ProviderInfo()
+	if f.fallback != nil {
+		primary := f.primary.ProviderInfo()
+		info.TotalInputTokens += primary.TotalInputTokens
+		info.CachedInputTokens += primary.CachedInputTokens
+		info.TotalOutputTokens += primary.TotalOutputTokens
+	}
+	return info
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// fetchMetaMarker separates the response body from the metadata curl writes after it
+const fetchMetaMarker = "\n__AICODE_FETCH_META__"
+
+// fetchWriteOut is the curl --write-out format producing the response metadata
+const fetchWriteOut = fetchMetaMarker + "%{http_code}\t%{url_effective}\t%{content_type}\t%{time_total}"
+
+// maxFetchBytes is the size of the body of a Fetch kept for the model, the
+// rest of a larger body is dropped
+const maxFetchBytes = 5 << 20
+
+// maxFetchDownloadBytes is the size above which curl refuses to download a
+// body whose size the server announced
+const maxFetchDownloadBytes = 64 << 20
+
+// fetchTailBytes is the size of the end of the output of curl kept after a
+// truncated body, enough to hold the metadata
+const fetchTailBytes = 16 << 10
+
+// curlErrors describes the curl exit codes of common network failures
+var curlErrors = map[int]string{
+	3:  "malformed URL",
+	6:  "could not resolve host",
+	7:  "failed to connect to host",
+	28: "timed out",
+	35: "TLS handshake failed",
+	47: "too many redirects",
+	52: "empty reply from server",
+	56: "connection reset while receiving data",
+	60: "TLS certificate verification failed",
+	63: fmt.Sprintf("the response is larger than %d MB", maxFetchDownloadBytes>>20),
+}
+
+// fetchMetadata describes the HTTP response of a Fetch call
+type fetchMetadata struct {
+	StatusCode  int
+	FinalURL    string
+	ContentType string
+	Duration    time.Duration
+	Error       string // Network error when no response was received
+	BodySize    int    // Size of the body when it was truncated to maxFetchBytes
+}
+
+// fetchOutput collects the output of curl without holding more than
+// maxFetchBytes of the body: it keeps the start of the output, and its end
+// where curl writes the metadata
+type fetchOutput struct {
+	head  []byte
+	tail  []byte
+	total int
+}
+
+// Write keeps the start of the output and the last fetchTailBytes after it
+func (o *fetchOutput) Write(p []byte) (int, error) {
+	o.total += len(p)
+	rest := p
+	if room := maxFetchBytes - len(o.head); room > 0 {
+		n := min(room, len(rest))
+		o.head = append(o.head, rest[:n]...)
+		rest = rest[n:]
+	}
+	o.tail = append(o.tail, rest...)
+	if excess := len(o.tail) - fetchTailBytes; excess > 0 {
+		o.tail = append(o.tail[:0], o.tail[excess:]...)
+	}
+	return len(p), nil
+}
+
+// split separates the body from the metadata appended by fetchWriteOut,
+// noting the size of a body that did not fit in maxFetchBytes
+func (o *fetchOutput) split() ([]byte, fetchMetadata) {
+	if o.total == len(o.head)+len(o.tail) {
+		return splitFetchOutput(append(o.head, o.tail...))
+	}
+	tailBody, meta := splitFetchOutput(o.tail)
+	meta.BodySize = o.total - len(o.tail) + len(tailBody)
+	return o.head, meta
+}
+
+// splitFetchOutput separates the body from the metadata appended by fetchWriteOut
+func splitFetchOutput(output []byte) ([]byte, fetchMetadata) {
+	var meta fetchMetadata
+	index := bytes.LastIndex(output, []byte(fetchMetaMarker))
+	if index < 0 {
+		return output, meta
+	}
+
+	fields := strings.Split(string(output[index+len(fetchMetaMarker):]), "\t")
+	if len(fields) == 4 {
+		meta.StatusCode, _ = strconv.Atoi(fields[0])
+		meta.FinalURL = fields[1]
+		meta.ContentType = fields[2]
+		if seconds, err := strconv.ParseFloat(strings.TrimSpace(fields[3]), 64); err == nil {
+			meta.Duration = time.Duration(seconds * float64(time.Second))
+		}
+	}
+	return output[:index], meta
+}
+
+// curlError describes the failure of a curl run from its exit code
+func curlError(exitCode int, stderr []byte) string {
+	if description, ok := curlErrors[exitCode]; ok {
+		return description
+	}
+	if message := strings.TrimSpace(string(stderr)); message != "" {
+		return message
+	}
+	return fmt.Sprintf("curl exited with code %d", exitCode)
+}
+
+// String formats the metadata as the header of the Fetch result
+func (m fetchMetadata) String() string {
+	var b strings.Builder
+	if m.Error != "" {
+		fmt.Fprintf(&b, "Status: error (%s)\n", m.Error)
+	} else {
+		fmt.Fprintf(&b, "Status: %d %s\n", m.StatusCode, http.StatusText(m.StatusCode))
+	}
+	if m.FinalURL != "" {
+		fmt.Fprintf(&b, "URL: %s\n", m.FinalURL)
+	}
+	if m.ContentType != "" {
+		fmt.Fprintf(&b, "Content-Type: %s\n", m.ContentType)
+	}
+	fmt.Fprintf(&b, "Time: %s\n", formatDuration(m.Duration))
+	if m.BodySize > 0 {
+		fmt.Fprintf(&b, "Truncated: only the first %d of the %d bytes of the body were kept\n", maxFetchBytes, m.BodySize)
+	}
+	return b.String()
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// fileTracker coordinates writes to files and remembers what the model last
+// read, so edits based on an outdated view of a file can be refused
+type fileTracker struct {
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex
+	reads map[string][sha256.Size]byte
+	// Content of each file as the model last saw it, the base of the
+	// three-way view of edits to files changed since
+	viewed map[string][]byte
+	// Files written by tools during the session
+	written map[string]bool
+	// File most recently read or written
+	lastTouched string
+}
+
+// GlobalFileTracker is the application-wide file tracker
+var GlobalFileTracker = &fileTracker{
+	locks:   map[string]*sync.Mutex{},
+	reads:   map[string][sha256.Size]byte{},
+	viewed:  map[string][]byte{},
+	written: map[string]bool{},
+}
+
+// absPath normalizes a path so the same file always maps to the same key
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
+
+// Lock acquires an exclusive lock on path, shared with other goroutines and
+// with other aicode processes such as sub-agents. The returned function
+// releases the lock.
+func (t *fileTracker) Lock(path string) (func(), error) {
+	key := absPath(path)
+
+	t.mu.Lock()
+	lock, ok := t.locks[key]
+	if !ok {
+		lock = &sync.Mutex{}
+		t.locks[key] = lock
+	}
+	t.mu.Unlock()
+	lock.Lock()
+
+	// Lock a file named after the path so files that don't exist yet can be locked too
+	lockDir := filepath.Join(os.TempDir(), "aicode-locks")
+	if err := os.MkdirAll(lockDir, 0755); err != nil {
+		lock.Unlock()
+		return nil, fmt.Errorf("failed to create lock directory: %v", err)
+	}
+	sum := sha256.Sum256([]byte(key))
+	lockFile, err := os.OpenFile(filepath.Join(lockDir, hex.EncodeToString(sum[:8])+".lock"), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		lock.Unlock()
+		return nil, fmt.Errorf("failed to open lock file: %v", err)
+	}
+	if err := lockExclusive(lockFile); err != nil {
+		lockFile.Close()
+		lock.Unlock()
+		return nil, fmt.Errorf("failed to lock %s: %v", path, err)
+	}
+
+	return func() {
+		_ = unlockFile(lockFile)
+		lockFile.Close()
+		lock.Unlock()
+	}, nil
+}
+
+// updateLockedJSON applies update to the JSON state stored in path under a
+// file lock, shared by every aicode process, writing it back if update
+// returns true. An unreadable state is started over.
+func updateLockedJSON[T any](path string, update func(state *T) bool) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := lockExclusive(file); err != nil {
+		return err
+	}
+	defer unlockFile(file)
+
+	var state T
+	data, _ := io.ReadAll(file)
+	_ = json.Unmarshal(data, &state)
+	if !update(&state) {
+		return nil
+	}
+
+	data, err = json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if err := file.Truncate(0); err != nil {
+		return err
+	}
+	_, err = file.WriteAt(data, 0)
+	return err
+}
+
+// RecordRead remembers the content of path as seen by the model
+func (t *fileTracker) RecordRead(path string, content []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.reads[absPath(path)] = sha256.Sum256(content)
+	t.viewed[absPath(path)] = content
+	t.lastTouched = absPath(path)
+}
+
+// LastTouched returns the file most recently read or written by tools
+func (t *fileTracker) LastTouched() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.lastTouched
+}
+
+// RecordWrite remembers content written by a tool as the latest known state of path
+func (t *fileTracker) RecordWrite(path string, content []byte) {
+	t.RecordRead(path, content)
+	t.mu.Lock()
+	t.written[absPath(path)] = true
+	t.mu.Unlock()
+}
+
+// Written returns the files written by tools during the session
+func (t *fileTracker) Written() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	paths := make([]string, 0, len(t.written))
+	for path := range t.written {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// Touched returns the files read or written by tools during the session
+func (t *fileTracker) Touched() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	paths := make([]string, 0, len(t.reads))
+	for path := range t.reads {
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// CheckStale returns an error if path changed on disk since the model last
+// read or wrote it. Files the model never read are not checked.
+func (t *fileTracker) CheckStale(path string) error {
+	if _, stale, err := t.Stale(path); err != nil || stale {
+		return staleError(path, err)
+	}
+	return nil
+}
+
+// Stale tells whether path changed on disk since the model last read or
+// wrote it and returns the content the model saw then. It returns an error
+// when the file was deleted since. Files the model never read are not stale.
+func (t *fileTracker) Stale(path string) ([]byte, bool, error) {
+	t.mu.Lock()
+	known, ok := t.reads[absPath(path)]
+	viewed := t.viewed[absPath(path)]
+	t.mu.Unlock()
+	if !ok {
+		return nil, false, nil
+	}
+
+	content, err := workspaceReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, false, fmt.Errorf("stale read: %s was deleted since it was last viewed", path)
+		}
+		return nil, false, nil
+	}
+	return viewed, sha256.Sum256(content) != known, nil
+}
+
+// staleError returns the error refusing an edit of path based on an outdated view
+func staleError(path string, err error) error {
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("stale read: %s changed on disk since it was last viewed, View it again before editing", path)
+}
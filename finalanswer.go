@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Tags around the deliverable of a non-interactive run, such as a commit
+// message or a patch, which -q and -output json print without the narration
+// around it
+const (
+	finalAnswerOpen  = "<final_answer>"
+	finalAnswerClose = "</final_answer>"
+)
+
+// finalAnswerInstructions tell the model of a non-interactive run how to
+// mark its deliverable
+const finalAnswerInstructions = `
+# Final answer
+You are running non-interactively: your last response is read by a script or piped to another program. When the request asks for an artifact, such as a commit message, a patch, a file or a command, put exactly that artifact, and nothing else, between ` + finalAnswerOpen + ` and ` + finalAnswerClose + ` in your last response. Do not wrap it in a code fence inside the tags. Explanations go outside of the tags. Leave the tags out when the answer is the explanation itself.
+`
+
+// finalOutput is the result of a non-interactive run with -output json
+type finalOutput struct {
+	Answer       string  `json:"answer"`   // The deliverable, or the whole response when the model marked none
+	Response     string  `json:"response"` // The last response of the model, tags removed
+	InputTokens  int     `json:"input_tokens"`
+	OutputTokens int     `json:"output_tokens"`
+	Cost         float64 `json:"cost"`
+}
+
+// splitFinalAnswer returns the deliverable marked in a response, the last one
+// when several are, and whether there is one
+func splitFinalAnswer(response string) (string, bool) {
+	start := strings.LastIndex(response, finalAnswerOpen)
+	if start < 0 {
+		return "", false
+	}
+	answer := response[start+len(finalAnswerOpen):]
+	end := strings.Index(answer, finalAnswerClose)
+	if end < 0 {
+		// Answers cut by max_tokens keep what was written
+		end = len(answer)
+	}
+	return strings.Trim(answer[:end], "\r\n"), true
+}
+
+// finalAnswer returns the deliverable of a response, the whole response when
+// the model marked none
+func finalAnswer(response string) string {
+	if answer, ok := splitFinalAnswer(response); ok {
+		return answer
+	}
+	return response
+}
+
+// stripFinalAnswerTags returns the response as read by a person, without the
+// tags around the deliverable
+func stripFinalAnswerTags(response string) string {
+	return strings.NewReplacer(finalAnswerOpen, "", finalAnswerClose, "").Replace(response)
+}
+
+// printFinalOutput prints the result of a non-interactive run as one JSON object
+func printFinalOutput(llm Llm, response string) {
+	info := llm.ProviderInfo()
+	data, _ := json.Marshal(finalOutput{
+		Answer:       finalAnswer(response),
+		Response:     stripFinalAnswerTags(response),
+		InputTokens:  info.InputTokens,
+		OutputTokens: info.OutputTokens,
+		Cost:         llm.CalculatePrice(),
+	})
+	fmt.Println(string(data))
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// maxFollowUpTranscript is the number of trailing transcript characters sent
+// to the cheap model when looking for unfinished tasks
+const maxFollowUpTranscript = 12000
+
+// todoMarker matches TODO-style comments left in source files
+var todoMarker = regexp.MustCompile(`\b(TODO|FIXME|XXX|HACK)\b[:(]?`)
+
+// projectKey names the state of the project directory dir kept under
+// ~/.config/aicode
+func projectKey(dir string) string {
+	sum := sha256.Sum256([]byte(dir))
+	return hex.EncodeToString(sum[:8])
+}
+
+// followUpsPath returns the file follow-ups of the current directory are stored in
+func followUpsPath() string {
+	cwd, _ := os.Getwd()
+	return filepath.Join(expandHomeDir("~/.config/aicode/followups"), projectKey(cwd)+".md")
+}
+
+// fileTodos lists the TODO comments in the files modified during the session
+func fileTodos() []string {
+	var todos []string
+	for _, path := range GlobalFileTracker.Written() {
+		file, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for line := 1; scanner.Scan(); line++ {
+			text := scanner.Text()
+			if todoMarker.MatchString(text) {
+				rel, err := filepath.Rel(".", path)
+				if err != nil || strings.HasPrefix(rel, "..") {
+					rel = path
+				}
+				todos = append(todos, fmt.Sprintf("- %s:%d: %s", rel, line, strings.TrimSpace(text)))
+			}
+		}
+		file.Close()
+	}
+	return todos
+}
+
+// conversationTranscript returns the trailing limit characters of the
+// conversation without tool calls and results
+func conversationTranscript(llm Llm, limit int) string {
+	var transcript []string
+	for _, entry := range llm.GetFormattedHistory() {
+		// Tool calls and results are too verbose and rarely state intentions
+		if strings.Contains(entry, "[Tool Result:") || strings.Contains(entry, "[Tool Use:") {
+			continue
+		}
+		transcript = append(transcript, entry)
+	}
+	text := strings.Join(transcript, "\n")
+	if len(text) > limit {
+		text = text[len(text)-limit:]
+	}
+	return text
+}
+
+// unfinishedTasks asks the cheap model for the tasks left open in the transcript
+func unfinishedTasks(ctx context.Context, llm Llm, config Config) ([]string, error) {
+	text := conversationTranscript(llm, maxFollowUpTranscript)
+	answer, err := quickCompletion(ctx, config, followUpsPrompt, text)
+	if err != nil {
+		return nil, err
+	}
+
+	var tasks []string
+	for _, line := range strings.Split(answer, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "- ") {
+			tasks = append(tasks, line)
+		}
+	}
+	return tasks, nil
+}
+
+// collectFollowUps builds the follow-up list for the session, made of the
+// unfinished tasks of the transcript and the TODOs of the modified files
+func collectFollowUps(ctx context.Context, llm Llm, config Config) (string, error) {
+	if len(llm.UserMessages()) == 0 {
+		return "", nil
+	}
+
+	tasks, err := unfinishedTasks(ctx, llm, config)
+	if err != nil {
+		return "", err
+	}
+	todos := fileTodos()
+	if len(tasks) == 0 && len(todos) == 0 {
+		return "", nil
+	}
+
+	var list strings.Builder
+	if len(tasks) > 0 {
+		list.WriteString("Unfinished tasks:\n")
+		list.WriteString(strings.Join(tasks, "\n"))
+		list.WriteString("\n")
+	}
+	if len(todos) > 0 {
+		if list.Len() > 0 {
+			list.WriteString("\n")
+		}
+		list.WriteString("TODOs in modified files:\n")
+		list.WriteString(strings.Join(todos, "\n"))
+		list.WriteString("\n")
+	}
+	return list.String(), nil
+}
+
+// saveFollowUps collects the follow-ups of the session, prints them and
+// stores them so the next session can pick them up with -continue
+func saveFollowUps(ctx context.Context, llm Llm, config Config) {
+	followUps, err := collectFollowUps(ctx, llm, config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to collect follow-ups: %v\n", err)
+		return
+	}
+
+	path := followUpsPath()
+	if followUps == "" {
+		os.Remove(path)
+		return
+	}
+
+	fmt.Printf("\nFollow-ups:\n%s", followUps)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to save follow-ups: %v\n", err)
+		return
+	}
+	if err := os.WriteFile(path, []byte(followUps), 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to save follow-ups: %v\n", err)
+		return
+	}
+	fmt.Println("Run aicode -continue to pick them up in the next session.")
+}
+
+// sessionEndSummariesEnabled tells whether the follow-ups and the summary of
+// the session are written when it ends, on unless session_end_summaries is false
+func sessionEndSummariesEnabled(config Config) bool {
+	return config.SessionEndSummaries == nil || *config.SessionEndSummaries
+}
+
+// endSession collects the follow-ups and writes the summary of the session
+// once the UI is closed, within sessionEndTimeout. Ctrl+C skips what is left.
+func endSession(llm Llm, config Config, title string) {
+	if !sessionEndSummariesEnabled(config) {
+		return
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	ctx, cancel := context.WithTimeout(ctx, sessionEndTimeout)
+	defer cancel()
+
+	if len(llm.UserMessages()) > 0 {
+		fmt.Fprintln(os.Stderr, "Collecting follow-ups and summarizing the session (Ctrl+C to skip)...")
+	}
+	saveFollowUps(ctx, llm, config)
+	saveSessionSummary(ctx, llm, config, title)
+}
+
+// continuePrompt prepends the follow-ups saved by the previous session in
+// this directory to prompt
+func continuePrompt(prompt string) (string, error) {
+	followUps, err := os.ReadFile(followUpsPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return prompt, fmt.Errorf("no follow-ups saved for this directory")
+		}
+		return prompt, err
+	}
+
+	seed := "Follow-ups left from the previous session:\n\n" + string(followUps)
+	if prompt == "" {
+		return seed + "\nContinue with these follow-ups.", nil
+	}
+	return seed + "\n" + prompt, nil
+}
+
+// quitHandler exits the application, follow-ups are collected once the UI is closed
+func quitHandler(m *chatModel) error {
+	m.afterCmd = tea.Quit
+	return nil
+}
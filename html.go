@@ -0,0 +1,418 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+)
+
+// htmlNode is an element or text node of a parsed HTML document
+type htmlNode struct {
+	Tag      string // Lowercase tag name, empty for text nodes
+	Attrs    map[string]string
+	Text     string
+	Children []*htmlNode
+	Parent   *htmlNode
+}
+
+var (
+	htmlToken     = regexp.MustCompile(`(?s)<!--.*?-->|<!\[CDATA\[.*?\]\]>|<![^>]*>|<\?[^>]*>|</?[a-zA-Z][^>]*>|[^<]+|<`)
+	htmlTagName   = regexp.MustCompile(`^</?([a-zA-Z][a-zA-Z0-9-]*)`)
+	htmlAttribute = regexp.MustCompile(`([^\s=/>"']+)(?:\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s>]+)))?`)
+	spaceRun      = regexp.MustCompile(`[ \t\r\n]+`)
+	blankLines    = regexp.MustCompile(`\n{3,}`)
+)
+
+// htmlVoidElements never have children or closing tags
+var htmlVoidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true, "hr": true, "img": true,
+	"input": true, "link": true, "meta": true, "param": true, "source": true, "track": true, "wbr": true,
+}
+
+// htmlRawTextElements contain text that is not parsed as markup
+var htmlRawTextElements = map[string]bool{"script": true, "style": true, "textarea": true, "title": true}
+
+// htmlBlockElements start on a new line when rendered as text
+var htmlBlockElements = map[string]bool{
+	"address": true, "article": true, "aside": true, "blockquote": true, "dd": true, "div": true, "dl": true,
+	"dt": true, "fieldset": true, "figcaption": true, "figure": true, "footer": true, "form": true, "h1": true,
+	"h2": true, "h3": true, "h4": true, "h5": true, "h6": true, "header": true, "hr": true, "li": true,
+	"main": true, "nav": true, "ol": true, "p": true, "pre": true, "section": true, "table": true,
+	"tr": true, "ul": true,
+}
+
+// htmlAutoClosed elements are implicitly closed by a sibling of the same kind, e.g. <li>one<li>two
+var htmlAutoClosed = map[string]bool{
+	"li": true, "p": true, "tr": true, "td": true, "th": true, "dt": true, "dd": true, "option": true,
+}
+
+// htmlBoilerplateElements are dropped when extracting the main content of a page
+var htmlBoilerplateElements = map[string]bool{
+	"script": true, "style": true, "noscript": true, "nav": true, "header": true, "footer": true,
+	"aside": true, "form": true, "iframe": true, "svg": true, "button": true, "template": true,
+}
+
+// parseHTML builds a lenient document tree, closing unbalanced elements the way
+// browsers mostly do. It is meant for extracting text, not for full HTML5 compliance.
+func parseHTML(source string) *htmlNode {
+	root := &htmlNode{Tag: "#document"}
+	current := root
+
+	for pos := 0; pos < len(source); {
+		loc := htmlToken.FindStringIndex(source[pos:])
+		if loc == nil {
+			break
+		}
+		token := source[pos+loc[0] : pos+loc[1]]
+		pos += loc[1]
+
+		switch {
+		case strings.HasPrefix(token, "<!") || strings.HasPrefix(token, "<?"):
+			continue
+		case token == "<" || !strings.HasPrefix(token, "<"):
+			current.Children = append(current.Children, &htmlNode{Text: html.UnescapeString(token), Parent: current})
+		case strings.HasPrefix(token, "</"):
+			name := strings.ToLower(htmlTagName.FindStringSubmatch(token)[1])
+			// Close the nearest open element with this name, if any
+			for node := current; node != root; node = node.Parent {
+				if node.Tag == name {
+					current = node.Parent
+					break
+				}
+			}
+		default:
+			name := strings.ToLower(htmlTagName.FindStringSubmatch(token)[1])
+			if (htmlAutoClosed[name] && current.Tag == name) || (htmlBlockElements[name] && current.Tag == "p") {
+				current = current.Parent
+			}
+			node := &htmlNode{Tag: name, Attrs: parseHTMLAttributes(token[len(name)+1:]), Parent: current}
+			current.Children = append(current.Children, node)
+
+			if htmlRawTextElements[name] {
+				end := indexFold(source[pos:], "</"+name)
+				if end < 0 {
+					end = len(source) - pos
+				}
+				node.Children = append(node.Children, &htmlNode{Text: html.UnescapeString(source[pos : pos+end]), Parent: node})
+				pos += end
+				if closing := strings.IndexByte(source[pos:], '>'); closing >= 0 {
+					pos += closing + 1
+				}
+				continue
+			}
+			if !htmlVoidElements[name] && !strings.HasSuffix(token, "/>") {
+				current = node
+			}
+		}
+	}
+	return root
+}
+
+// indexFold returns the index of the first instance of substr in s ignoring
+// case, or -1. Unlike searching a lowered copy of s, the index is valid in s
+// whatever the characters before it.
+func indexFold(s, substr string) int {
+	for i := 0; i+len(substr) <= len(s); i++ {
+		if strings.EqualFold(s[i:i+len(substr)], substr) {
+			return i
+		}
+	}
+	return -1
+}
+
+// parseHTMLAttributes parses the attributes of a start tag
+func parseHTMLAttributes(source string) map[string]string {
+	attrs := map[string]string{}
+	source = strings.TrimSuffix(strings.TrimSuffix(source, ">"), "/")
+	for _, match := range htmlAttribute.FindAllStringSubmatch(source, -1) {
+		attrs[strings.ToLower(match[1])] = html.UnescapeString(match[2] + match[3] + match[4])
+	}
+	return attrs
+}
+
+// textLength returns the length of the visible text below the node
+func (n *htmlNode) textLength() int {
+	if n.Tag == "" {
+		return len(strings.TrimSpace(n.Text))
+	}
+	if htmlBoilerplateElements[n.Tag] {
+		return 0
+	}
+	length := 0
+	for _, child := range n.Children {
+		length += child.textLength()
+	}
+	return length
+}
+
+// walk calls fn for the node and all its descendants in document order
+func (n *htmlNode) walk(fn func(*htmlNode)) {
+	fn(n)
+	for _, child := range n.Children {
+		child.walk(fn)
+	}
+}
+
+// mainContent finds the node holding the main content of a page, similar to
+// readability: semantic elements first, then the container with the most paragraph text
+func (n *htmlNode) mainContent() *htmlNode {
+	var article, main *htmlNode
+	n.walk(func(node *htmlNode) {
+		if node.Tag == "article" && article == nil {
+			article = node
+		}
+		if (node.Tag == "main" || node.Attrs["role"] == "main") && main == nil {
+			main = node
+		}
+	})
+	if article != nil && article.textLength() > 200 {
+		return article
+	}
+	if main != nil {
+		return main
+	}
+
+	// Score containers by the text of their direct paragraphs
+	best, bestScore := n, 0
+	n.walk(func(node *htmlNode) {
+		if node.Tag == "" || htmlBoilerplateElements[node.Tag] {
+			return
+		}
+		score := 0
+		for _, child := range node.Children {
+			if child.Tag == "p" || child.Tag == "pre" || child.Tag == "blockquote" {
+				score += child.textLength()
+			}
+		}
+		if score > bestScore {
+			best, bestScore = node, score
+		}
+	})
+	return best
+}
+
+// renderText converts the node to readable plain text with minimal markdown
+// for headings, list items and code blocks
+func (n *htmlNode) renderText(skipBoilerplate bool) string {
+	var b strings.Builder
+	n.renderTo(&b, skipBoilerplate, false)
+	lines := strings.Split(b.String(), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " ")
+	}
+	text := blankLines.ReplaceAllString(strings.Join(lines, "\n"), "\n\n")
+	return strings.TrimSpace(text)
+}
+
+func (n *htmlNode) renderTo(b *strings.Builder, skipBoilerplate, preformatted bool) {
+	if n.Tag == "" {
+		if preformatted {
+			b.WriteString(n.Text)
+		} else {
+			b.WriteString(spaceRun.ReplaceAllString(n.Text, " "))
+		}
+		return
+	}
+	if n.Tag == "script" || n.Tag == "style" || n.Tag == "head" || (skipBoilerplate && htmlBoilerplateElements[n.Tag]) {
+		return
+	}
+
+	block := htmlBlockElements[n.Tag]
+	if block {
+		b.WriteString("\n")
+	}
+	switch n.Tag {
+	case "br":
+		b.WriteString("\n")
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		b.WriteString("\n" + strings.Repeat("#", int(n.Tag[1]-'0')) + " ")
+	case "li":
+		b.WriteString("- ")
+	case "pre":
+		b.WriteString("```\n")
+		preformatted = true
+	case "td", "th":
+		b.WriteString(" | ")
+	}
+
+	for _, child := range n.Children {
+		child.renderTo(b, skipBoilerplate, preformatted)
+	}
+
+	switch n.Tag {
+	case "pre":
+		b.WriteString("\n```")
+	case "p", "h1", "h2", "h3", "h4", "h5", "h6", "table", "blockquote":
+		b.WriteString("\n")
+	}
+	// List items only need to start on a new line to stay compact
+	if block && n.Tag != "li" {
+		b.WriteString("\n")
+	}
+}
+
+// cssSelector is one compound selector such as div.content#main[data-x=1]
+type cssSelector struct {
+	Tag     string
+	ID      string
+	Classes []string
+	Attrs   map[string]string // Value is empty when only the presence is checked
+}
+
+var cssSelectorPart = regexp.MustCompile(`([#.]?[a-zA-Z0-9_-]+|\*|\[[^\]]+\])`)
+
+// parseCSSSelector parses a selector list of compound selectors joined by the
+// descendant combinator, e.g. "article .content p, main h1"
+func parseCSSSelector(selector string) [][]cssSelector {
+	var groups [][]cssSelector
+	for _, group := range strings.Split(selector, ",") {
+		var chain []cssSelector
+		for _, compound := range strings.Fields(strings.ReplaceAll(group, ">", " ")) {
+			sel := cssSelector{Attrs: map[string]string{}}
+			for _, part := range cssSelectorPart.FindAllString(compound, -1) {
+				switch {
+				case strings.HasPrefix(part, "#"):
+					sel.ID = part[1:]
+				case strings.HasPrefix(part, "."):
+					sel.Classes = append(sel.Classes, part[1:])
+				case strings.HasPrefix(part, "["):
+					name, value, _ := strings.Cut(strings.Trim(part, "[]"), "=")
+					sel.Attrs[strings.ToLower(strings.TrimSpace(name))] = strings.Trim(value, `"'`)
+				case part != "*":
+					sel.Tag = strings.ToLower(part)
+				}
+			}
+			chain = append(chain, sel)
+		}
+		if len(chain) > 0 {
+			groups = append(groups, chain)
+		}
+	}
+	return groups
+}
+
+// matches reports whether the node matches the compound selector
+func (s cssSelector) matches(n *htmlNode) bool {
+	if n.Tag == "" || (s.Tag != "" && n.Tag != s.Tag) || (s.ID != "" && n.Attrs["id"] != s.ID) {
+		return false
+	}
+	classes := strings.Fields(n.Attrs["class"])
+	for _, class := range s.Classes {
+		found := false
+		for _, c := range classes {
+			if c == class {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	for name, value := range s.Attrs {
+		actual, ok := n.Attrs[name]
+		if !ok || (value != "" && actual != value) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesChain reports whether the node matches the last selector of the chain
+// and its ancestors match the preceding ones
+func matchesChain(n *htmlNode, chain []cssSelector) bool {
+	if !chain[len(chain)-1].matches(n) {
+		return false
+	}
+	i := len(chain) - 2
+	for node := n.Parent; node != nil && i >= 0; node = node.Parent {
+		if chain[i].matches(node) {
+			i--
+		}
+	}
+	return i < 0
+}
+
+// querySelectorAll returns the nodes matching the selector in document order
+func (n *htmlNode) querySelectorAll(selector string) []*htmlNode {
+	groups := parseCSSSelector(selector)
+	var result []*htmlNode
+	n.walk(func(node *htmlNode) {
+		for _, chain := range groups {
+			if matchesChain(node, chain) {
+				result = append(result, node)
+				return
+			}
+		}
+	})
+	return result
+}
+
+// defaultFetchLimit is the number of characters Fetch returns when no limit is given
+const defaultFetchLimit = 30000
+
+// extractFetchContent reduces a fetched document to the requested content:
+// "raw" keeps the body as is, "text" renders the whole page as text and "main"
+// keeps only the main content. A CSS selector restricts the result to the
+// matching elements.
+func extractFetchContent(body, extract, selector string) (string, error) {
+	if extract == "" {
+		extract = "raw"
+		if selector != "" {
+			extract = "text"
+		}
+	}
+	if extract == "raw" && selector == "" {
+		return body, nil
+	}
+
+	document := parseHTML(body)
+	root := document
+	if extract == "main" {
+		root = document.mainContent()
+	} else if extract != "text" && extract != "raw" {
+		return "", fmt.Errorf("invalid extract value %q, expected raw, text or main", extract)
+	}
+
+	if selector == "" {
+		return root.renderText(extract == "main"), nil
+	}
+
+	nodes := root.querySelectorAll(selector)
+	if len(nodes) == 0 {
+		return fmt.Sprintf("No elements match selector %q", selector), nil
+	}
+	var parts []string
+	for _, node := range nodes {
+		if text := node.renderText(false); text != "" {
+			parts = append(parts, text)
+		}
+	}
+	return strings.Join(parts, "\n\n---\n\n"), nil
+}
+
+// paginate returns the window of content starting at offset with at most limit
+// characters, noting where to continue when the content is cut
+func paginate(content string, offset, limit int) string {
+	runes := []rune(content)
+	if limit <= 0 {
+		limit = defaultFetchLimit
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	if offset >= len(runes) {
+		return fmt.Sprintf("[Offset %d is past the end of the content (%d characters)]", offset, len(runes))
+	}
+	end := min(offset+limit, len(runes))
+	page := string(runes[offset:end])
+	if offset > 0 || end < len(runes) {
+		page += fmt.Sprintf("\n\n[Showing characters %d-%d of %d", offset, end, len(runes))
+		if end < len(runes) {
+			page += fmt.Sprintf(", use offset=%d to read more", end)
+		}
+		page += "]"
+	}
+	return page
+}
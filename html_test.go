@@ -0,0 +1,125 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const testPage = `<html><head><title>Docs</title><style>p { color: red }</style></head>
+<body>
+<nav><a href="/">Home</a></nav>
+<article id="post" class="entry featured" data-kind="guide">
+<h1>Install</h1>
+<p class="lead">Run the installer.</p>
+<div class="content"><p>First step.</p><p data-step="2">Second step.</p></div>
+</article>
+<footer><p>Copyright</p></footer>
+</body></html>`
+
+func TestQuerySelectorAll(t *testing.T) {
+	tests := []struct {
+		selector string
+		want     []string
+	}{
+		{"h1", []string{"# Install"}},
+		{"#post h1", []string{"# Install"}},
+		{".lead", []string{"Run the installer."}},
+		{"article.entry.featured h1", []string{"# Install"}},
+		{"p.lead.missing", nil},
+		{"[data-step=2]", []string{"Second step."}},
+		{`[data-step="2"]`, []string{"Second step."}},
+		{"[data-kind] h1", []string{"# Install"}},
+		{"article .content p", []string{"First step.", "Second step."}},
+		{"article > h1", []string{"# Install"}},
+		{"footer p, nav a", []string{"Home", "Copyright"}},
+		{"* .lead", []string{"Run the installer."}},
+		{"section p", nil},
+	}
+	document := parseHTML(testPage)
+	for _, tt := range tests {
+		t.Run(tt.selector, func(t *testing.T) {
+			var got []string
+			for _, node := range document.querySelectorAll(tt.selector) {
+				got = append(got, node.renderText(false))
+			}
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractFetchContent(t *testing.T) {
+	main, err := extractFetchContent(testPage, "main", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(main, "Second step.") || strings.Contains(main, "Copyright") || strings.Contains(main, "Home") {
+		t.Fatalf("main content = %q, want the article only", main)
+	}
+	if raw, _ := extractFetchContent(testPage, "", ""); raw != testPage {
+		t.Fatal("the body is not kept as is without extract and selector")
+	}
+	if none, _ := extractFetchContent(testPage, "", "table"); !strings.HasPrefix(none, "No elements match") {
+		t.Fatalf("got %q for a selector matching nothing", none)
+	}
+	if _, err := extractFetchContent(testPage, "markdown", ""); err == nil {
+		t.Fatal("an invalid extract value is accepted")
+	}
+}
+
+func TestParseHTMLRawText(t *testing.T) {
+	tests := []struct {
+		name string
+		page string
+	}{
+		{"markup in a script", `<script>if (a < b) { el.innerHTML = "<p>Hidden</p></div>"; }</script><p>Visible</p>`},
+		{"uppercase end tag", `<SCRIPT>var s = "<p>Hidden</p>";</SCRIPT><p>Visible</p>`},
+		{"style", `<style>p::before { content: "<b>Hidden</b>" }</style><p>Visible</p>`},
+		{"text changing length when lowered", `<script>var s = "İİİİİİİİİİİİİİİİİİİİ <p>Hidden</p>";</script><p>Visible</p>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text := parseHTML(tt.page).renderText(false)
+			if strings.Contains(text, "Hidden") || !strings.Contains(text, "Visible") {
+				t.Fatalf("text = %q, want the script and style content left out", text)
+			}
+		})
+	}
+
+	script := parseHTML(`<script>a = "</div>";</script>`).Children[0]
+	if script.Tag != "script" || len(script.Children) != 1 || script.Children[0].Text != `a = "</div>";` {
+		t.Fatalf("the script content is parsed as markup: %+v", script.Children)
+	}
+	if unclosed := parseHTML(`<p>Visible</p><script>var s = "<p>Hidden</p>"`).renderText(false); unclosed != "Visible" {
+		t.Fatalf("text = %q with an unclosed script", unclosed)
+	}
+}
+
+func TestPaginate(t *testing.T) {
+	tests := []struct {
+		name          string
+		content       string
+		offset, limit int
+		want          string
+	}{
+		{"whole content", "abcdef", 0, 0, "abcdef"},
+		{"first page", "abcdef", 0, 4, "abcd\n\n[Showing characters 0-4 of 6, use offset=4 to read more]"},
+		{"last page", "abcdef", 4, 4, "ef\n\n[Showing characters 4-6 of 6]"},
+		{"negative offset", "abcdef", -3, 2, "ab\n\n[Showing characters 0-2 of 6, use offset=2 to read more]"},
+		{"past the end", "abcdef", 6, 2, "[Offset 6 is past the end of the content (6 characters)]"},
+		{"characters rather than bytes", "ééééé", 1, 2, "éé\n\n[Showing characters 1-3 of 5, use offset=3 to read more]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := paginate(tt.content, tt.offset, tt.limit); got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	long := strings.Repeat("a", defaultFetchLimit+10)
+	if got := paginate(long, 0, 0); !strings.HasSuffix(got, "use offset=30000 to read more]") {
+		t.Fatalf("the default limit is not applied: %q", got[len(got)-60:])
+	}
+}
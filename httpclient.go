@@ -0,0 +1,71 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+)
+
+// httpClients are the clients built by httpClient, by their settings, so
+// that requests of the same profile reuse their connections
+var httpClients sync.Map
+
+// httpClientKey holds the settings of the profile a client depends on
+type httpClientKey struct {
+	proxy              string
+	caCert             string
+	insecureSkipVerify bool
+}
+
+// httpClient returns the client sending the requests to the provider: through
+// the proxy of the profile, or else HTTPS_PROXY, HTTP_PROXY and NO_PROXY,
+// trusting the CA bundle of the profile besides the system certificates
+func httpClient(config Config) (*http.Client, error) {
+	key := httpClientKey{proxy: config.Proxy, caCert: config.CACert, insecureSkipVerify: config.InsecureSkipVerify}
+	if client, ok := httpClients.Load(key); ok {
+		return client.(*http.Client), nil
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if config.Proxy != "" {
+		proxy, err := url.Parse(config.Proxy)
+		if err != nil || proxy.Host == "" {
+			return nil, fmt.Errorf("invalid proxy %q", config.Proxy)
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+	if config.CACert != "" || config.InsecureSkipVerify {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: config.InsecureSkipVerify}
+	}
+	if config.CACert != "" {
+		pem, err := os.ReadFile(expandHomeDir(config.CACert))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ca_cert: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("ca_cert holds no PEM certificate: " + config.CACert)
+		}
+		transport.TLSClientConfig.RootCAs = pool
+	}
+
+	client, _ := httpClients.LoadOrStore(key, &http.Client{Transport: transport})
+	return client.(*http.Client), nil
+}
+
+// setCustomHeaders adds the headers of the profile to a request to the
+// provider, such as the tenant or routing headers of an API gateway, with
+// environment variables expanded so that secrets stay out of the profile
+func setCustomHeaders(req *http.Request, headers map[string]string) {
+	for name, value := range headers {
+		req.Header.Set(name, os.ExpandEnv(value))
+	}
+}
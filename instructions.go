@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// instructionFileNames are the instruction files looked up in the
+// subdirectories the model works in, besides those of system_files
+var instructionFileNames = []string{"AI.md", "AGENTS.md", "CLAUDE.md"}
+
+// maxInstructionBytes is the size of an instruction file given to the model,
+// the rest is left out
+const maxInstructionBytes = 32 * 1024
+
+// nestedInstructions gives the model the instruction files of the
+// subdirectories when a tool first works in them, the files of the working
+// directory being in the system prompt already
+type nestedInstructions struct {
+	mu   sync.Mutex
+	sent map[string]bool // Files given to the model in the session, by path
+}
+
+// GlobalInstructions is the application-wide tracker of nested instruction files
+var GlobalInstructions = &nestedInstructions{sent: map[string]bool{}}
+
+// toolPathParams are the parameters of the tools naming the files they work on
+type toolPathParams struct {
+	FilePath    string            `json:"file_path"`
+	Path        string            `json:"path"`
+	Invocations []BatchInvocation `json:"invocations"`
+}
+
+// toolPaths returns the files and directories a tool call works on,
+// including those of the invocations of a Batch
+func toolPaths(call ToolCall) []string {
+	params, err := parseToolParams[toolPathParams](call.Input, "")
+	if err != nil {
+		return nil
+	}
+	var paths []string
+	for _, p := range []string{params.FilePath, params.Path} {
+		if p != "" {
+			paths = append(paths, p)
+		}
+	}
+	for _, inv := range params.Invocations {
+		for _, key := range []string{"file_path", "path"} {
+			if p, ok := inv.Input[key].(string); ok && p != "" {
+				paths = append(paths, p)
+			}
+		}
+	}
+	return paths
+}
+
+// For returns the instruction files not given to the model yet of the
+// directories from the working directory, excluded, down to the given
+// paths, outermost first, to follow the result of the tool working there
+func (n *nestedInstructions) For(paths []string, config Config) string {
+	if len(paths) == 0 {
+		return ""
+	}
+	wd, err := workspaceDir()
+	if err != nil {
+		return ""
+	}
+	names := slices.Clone(instructionFileNames)
+	for _, file := range config.SystemFiles {
+		if name := filepath.Base(file); !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	var b strings.Builder
+	for _, p := range paths {
+		for _, dir := range subdirectories(wd, p) {
+			for _, name := range names {
+				file := path.Join(dir, name)
+				if n.sent[file] {
+					continue
+				}
+				content, err := workspaceReadFile(filepath.Join(wd, filepath.FromSlash(file)))
+				if err != nil {
+					continue
+				}
+				n.sent[file] = true
+				if len(content) > maxInstructionBytes {
+					content = append(content[:maxInstructionBytes], "\n... [rest of the file left out]"...)
+				}
+				fmt.Fprintf(&b, "\n\n<instructions path=%q>\nInstructions for the files under %s/, follow them while working there:\n%s\n</instructions>", file, dir, strings.TrimSpace(string(content)))
+			}
+		}
+	}
+	return b.String()
+}
+
+// subdirectories returns the directories between wd, excluded, and the
+// directory of p, relative to wd with slashes, outermost first. Paths
+// outside of wd have none.
+func subdirectories(wd, p string) []string {
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(wd, p)
+	}
+	rel, err := filepath.Rel(wd, p)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return nil
+	}
+	// A file lies in its parent, a directory such as the path of Ls or Grep
+	// is worked in itself
+	if info, err := workspaceStat(p); err != nil || !info.IsDir() {
+		rel = filepath.Dir(rel)
+	}
+
+	var dirs []string
+	dir := ""
+	for _, part := range strings.Split(filepath.ToSlash(rel), "/") {
+		if part == "." || part == "" {
+			continue
+		}
+		dir = path.Join(dir, part)
+		dirs = append(dirs, dir)
+	}
+	return dirs
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// keyCreditPause is how long a key whose credits or quota ran out is left
+// aside, there is no telling when they are topped up
+const keyCreditPause = time.Hour
+
+// creditErrors are parts of the errors of exhausted credits or quotas
+var creditErrors = [][]byte{[]byte("insufficient_quota"), []byte("credit balance"), []byte("billing_hard_limit")}
+
+// keyUsage is what was sent with one API key in the session
+type keyUsage struct {
+	Requests     int
+	InputTokens  int
+	OutputTokens int
+	Cost         float64
+}
+
+// keyRotation chooses among the api_keys of the profile, moving to the next
+// key when one is rate limited or out of credits, and counts the usage of
+// each key for /cost
+type keyRotation struct {
+	mu        sync.Mutex
+	current   map[string]int       // Index of the key in use, by the keys of the profile
+	exhausted map[string]time.Time // Keys left aside, until when
+	usage     map[string]*keyUsage
+	order     []string // Keys in the order they were first used
+}
+
+// GlobalKeys is the application-wide rotation of API keys
+var GlobalKeys = &keyRotation{current: map[string]int{}, exhausted: map[string]time.Time{}, usage: map[string]*keyUsage{}}
+
+// expandKeys expands the environment variables of the api_keys of a
+// profile, such as $OPENAI_KEY_TEAM, dropping empty keys
+func expandKeys(keys []string) []string {
+	var expanded []string
+	for _, key := range keys {
+		if key = strings.TrimSpace(os.ExpandEnv(key)); key != "" {
+			expanded = append(expanded, key)
+		}
+	}
+	return expanded
+}
+
+// Select returns the key to send the next request with: the key in use
+// unless it was left aside, or else the next available one, or when all of
+// them are, the one available first
+func (k *keyRotation) Select(keys []string) string {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	id := strings.Join(keys, "\x00")
+	now := time.Now()
+	start := k.current[id]
+	for i := range keys {
+		index := (start + i) % len(keys)
+		if k.exhausted[keys[index]].Before(now) {
+			k.current[id] = index
+			return keys[index]
+		}
+	}
+	first := start
+	for i, key := range keys {
+		if k.exhausted[key].Before(k.exhausted[keys[first]]) {
+			first = i
+		}
+	}
+	return keys[first]
+}
+
+// Exhaust leaves key aside until the given time and tells whether another
+// key of keys can be used at once
+func (k *keyRotation) Exhaust(keys []string, key string, until time.Time) bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.exhausted[key] = until
+	now := time.Now()
+	for i, other := range keys {
+		if other != key && k.exhausted[other].Before(now) {
+			k.current[strings.Join(keys, "\x00")] = i
+			return true
+		}
+	}
+	return false
+}
+
+// Record counts a request sent with key
+func (k *keyRotation) Record(key string, inputTokens, outputTokens int, cost float64) {
+	if key == "" {
+		return
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	usage, ok := k.usage[key]
+	if !ok {
+		usage = &keyUsage{}
+		k.usage[key] = usage
+		k.order = append(k.order, key)
+	}
+	usage.Requests++
+	usage.InputTokens += inputTokens
+	usage.OutputTokens += outputTokens
+	usage.Cost += cost
+}
+
+// Report lists the usage of each key for /cost, empty unless several keys
+// were used in the session
+func (k *keyRotation) Report(currency Currency) []string {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if len(k.order) < 2 {
+		return nil
+	}
+	keys := append([]string{}, k.order...)
+	sort.SliceStable(keys, func(i, j int) bool { return k.usage[keys[i]].Cost > k.usage[keys[j]].Cost })
+
+	var lines []string
+	now := time.Now()
+	for _, key := range keys {
+		usage := k.usage[key]
+		line := fmt.Sprintf("  Key %s: %d requests, %s input, %s output, %s", keyLabel(key), usage.Requests,
+			formatTokenCount(usage.InputTokens), formatTokenCount(usage.OutputTokens), currency.Format(usage.Cost))
+		if until := k.exhausted[key]; until.After(now) {
+			line += fmt.Sprintf(" (set aside for %s)", until.Sub(now).Round(time.Second))
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// keyLabel names a key by its last characters, never showing it whole
+func keyLabel(key string) string {
+	if len(key) <= 8 {
+		return "…"
+	}
+	return "…" + key[len(key)-4:]
+}
+
+// requestKey returns the API key a request was sent with
+func requestKey(req *http.Request) string {
+	if req == nil {
+		return ""
+	}
+	if key := req.Header.Get("x-api-key"); key != "" {
+		return key
+	}
+	return strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+}
+
+// setRequestKey replaces the API key of a request built with the first key
+// of the profile
+func setRequestKey(req *http.Request, key string) {
+	if req.Header.Get("x-api-key") != "" {
+		req.Header.Set("x-api-key", key)
+	} else if req.Header.Get("Authorization") != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+}
+
+// keyExhausted tells whether a response refused the request for the key it
+// was sent with: a rate limit, or credits or quota that ran out, and how
+// long to leave the key aside
+func keyExhausted(resp *http.Response, body []byte, attempt int) (time.Duration, bool) {
+	if resp.StatusCode/100 == 2 {
+		return 0, false
+	}
+	for _, text := range creditErrors {
+		if bytes.Contains(bytes.ToLower(body), text) {
+			return keyCreditPause, true
+		}
+	}
+	if resp.StatusCode == http.StatusPaymentRequired {
+		return keyCreditPause, true
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return retryDelay(attempt, resp.Header), true
+	}
+	return 0, false
+}
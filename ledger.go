@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxLedgerScanFiles bounds the workspace scans detecting the files changed
+// by commands, larger workspaces only record the writes of Edit and Replace
+const maxLedgerScanFiles = 20000
+
+// ledgerSkippedDirs are not scanned for changes made by commands
+var ledgerSkippedDirs = map[string]bool{
+	".git": true, ".hg": true, ".svn": true, ".aicode": true, "node_modules": true,
+	"__pycache__": true, ".venv": true, ".mypy_cache": true, ".pytest_cache": true,
+}
+
+// fileChange is a file created, modified or deleted during the session
+type fileChange struct {
+	Path   string // Absolute path
+	Kind   string // created, modified or deleted
+	Tool   string // Tool or command that changed the file
+	Turn   int    // Turn of the conversation, 0 outside of a turn
+	Prompt string // Prompt of the turn
+	Time   time.Time
+}
+
+// relativePath returns the path relative to the working directory when it is inside it
+func (c fileChange) relativePath() string {
+	wd, _ := os.Getwd()
+	path, err := filepath.Rel(wd, c.Path)
+	if err != nil || strings.HasPrefix(path, "..") {
+		return c.Path
+	}
+	return path
+}
+
+// changeLedger records every file changed during the session, whether by
+// Edit and Replace, by commands run with Bash or by sub-agents
+type changeLedger struct {
+	mu      sync.Mutex
+	changes []fileChange
+}
+
+// GlobalChangeLedger is the application-wide ledger of file changes
+var GlobalChangeLedger = &changeLedger{}
+
+// Record adds a change of path made by tool in the current turn
+func (l *changeLedger) Record(path, kind, tool string) {
+	turn, prompt := GlobalTiming.CurrentTurn()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.changes = append(l.changes, fileChange{Path: absPath(path), Kind: kind, Tool: tool, Turn: turn, Prompt: prompt, Time: time.Now()})
+}
+
+// RecordWrite adds a write of path, existed telling whether it modified a file or created it
+func (l *changeLedger) RecordWrite(path string, existed bool, tool string) {
+	if existed {
+		l.Record(path, "modified", tool)
+	} else {
+		l.Record(path, "created", tool)
+	}
+}
+
+// Changes returns the changes of the session, oldest first
+func (l *changeLedger) Changes() []fileChange {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]fileChange(nil), l.changes...)
+}
+
+// Len returns the number of changes recorded in the session
+func (l *changeLedger) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.changes)
+}
+
+// recordedSince tells whether a change of path was recorded after start
+func (l *changeLedger) recordedSince(path string, start time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i := len(l.changes) - 1; i >= 0 && !l.changes[i].Time.Before(start); i-- {
+		if l.changes[i].Path == path {
+			return true
+		}
+	}
+	return false
+}
+
+// workspaceSnapshot is the size and modification time of the files of the workspace
+type workspaceSnapshot struct {
+	files map[string]fs.FileInfo
+	taken time.Time
+}
+
+// snapshotWorkspace records the files of the working directory before a
+// command runs, nil when the workspace is remote or too large to scan
+func snapshotWorkspace() *workspaceSnapshot {
+	if activeRemote != nil {
+		return nil
+	}
+	snapshot := &workspaceSnapshot{files: map[string]fs.FileInfo{}, taken: time.Now()}
+	err := filepath.WalkDir(".", func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if entry.IsDir() {
+			if ledgerSkippedDirs[entry.Name()] {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if len(snapshot.files) == maxLedgerScanFiles {
+			return fs.ErrInvalid
+		}
+		if info, err := entry.Info(); err == nil && info.Mode().IsRegular() {
+			snapshot.files[path] = info
+		}
+		return nil
+	})
+	if err != nil {
+		return nil
+	}
+	return snapshot
+}
+
+// RecordCommandChanges compares the workspace with the snapshot taken before
+// a command and records the files it created, modified or deleted. Changes
+// recorded meanwhile by Edit or Replace running alongside are not repeated.
+func (l *changeLedger) RecordCommandChanges(before *workspaceSnapshot, tool string) {
+	if before == nil {
+		return
+	}
+	after := snapshotWorkspace()
+	if after == nil {
+		return
+	}
+	record := func(path, kind string) {
+		if !l.recordedSince(absPath(path), before.taken) {
+			l.Record(path, kind, tool)
+		}
+	}
+	for _, path := range slices.Sorted(maps.Keys(after.files)) {
+		info, previous := after.files[path], before.files[path]
+		switch {
+		case previous == nil:
+			record(path, "created")
+		case previous.Size() != info.Size() || !previous.ModTime().Equal(info.ModTime()):
+			record(path, "modified")
+		}
+	}
+	for _, path := range slices.Sorted(maps.Keys(before.files)) {
+		if _, ok := after.files[path]; !ok {
+			record(path, "deleted")
+		}
+	}
+}
+
+// Format lists the changes grouped by the turn that made them
+func (l *changeLedger) Format() string {
+	changes := l.Changes()
+	if len(changes) == 0 {
+		return "No files changed in this session"
+	}
+
+	var b strings.Builder
+	turn := -1
+	for _, change := range changes {
+		if change.Turn != turn {
+			turn = change.Turn
+			if turn == 0 {
+				b.WriteString("Outside of a turn:\n")
+			} else {
+				prompt := strings.ReplaceAll(change.Prompt, "\n", " ")
+				if len([]rune(prompt)) > 60 {
+					prompt = string([]rune(prompt)[:57]) + "..."
+				}
+				fmt.Fprintf(&b, "Turn %d (%q):\n", turn, prompt)
+			}
+		}
+		fmt.Fprintf(&b, "  %s  %-8s  %s (%s)\n", change.Time.Format("15:04:05"), change.Kind, change.relativePath(), change.Tool)
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// Markdown lists the changes for the session summary
+func (l *changeLedger) Markdown() string {
+	changes := l.Changes()
+	if len(changes) == 0 {
+		return "None\n"
+	}
+	var b strings.Builder
+	for _, change := range changes {
+		fmt.Fprintf(&b, "- %s %s by %s at %s", change.relativePath(), change.Kind, change.Tool, change.Time.Format("15:04:05"))
+		if change.Turn > 0 {
+			fmt.Fprintf(&b, " in turn %d", change.Turn)
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// changesHandler shows the files changed during the session
+func changesHandler(m *chatModel) error {
+	m.outputs = append(m.outputs, GlobalChangeLedger.Format())
+	return nil
+}
@@ -7,6 +7,7 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -21,6 +22,7 @@ type ToolCall struct {
 type InferenceResponse struct {
 	Content   string
 	ToolCalls []ToolCall
+	Reasoning string // Reasoning given before the answer, not kept in the history
 }
 
 // Llm interface defines methods for LLM providers
@@ -31,13 +33,53 @@ type Llm interface {
 	AddMessage(content string, role string)
 	// AddToolResult adds a tool result to the conversation history
 	AddToolResult(toolUseID string, result string)
+	// AttachImage attaches an image to the next user message
+	AttachImage(mediaType string, data []byte)
 	// GetFormattedHistory returns the conversation history formatted for display
 	GetFormattedHistory() []string
 	// CalculatePrice calculates the total cost of the conversation
 	CalculatePrice() float64
 	// Clear clears the conversation history and preserves the system prompt
 	Clear()
+	// UserMessages returns the prompts entered by the user still present in the history
+	UserMessages() []string
+	// Rewind drops the history starting at the user message with the given index
+	Rewind(index int)
+	// SetToolSubset restricts the tools offered to the model, nil offers all enabled tools
+	SetToolSubset(toolNames []string)
+	// SetConfig replaces the configuration used for the next requests
+	SetConfig(config Config)
+	// RefreshSystemPrompt rebuilds the system prompt, e.g. after the environment changed
+	RefreshSystemPrompt()
+	// GetModel returns the model serving the conversation
 	GetModel() string
+	// ProviderInfo describes the provider, its model and the tokens used so far
+	ProviderInfo() ProviderInfo
+	// RestoreUsage adds the tokens used by a previous session to the session
+	// totals, and the cost it saved to the cost of the session
+	RestoreUsage(input, cachedInput, output int, cost float64)
+	// History returns the conversation in the format shared by all providers,
+	// without the system prompt and images
+	History() []Message
+	// SetHistory replaces the conversation with one in the shared format,
+	// e.g. taken over from another provider
+	SetHistory(messages []Message)
+}
+
+// ProviderInfo describes a provider and its usage, for the displays that
+// must not depend on the concrete provider
+type ProviderInfo struct {
+	Provider          string // anthropic, openai, openai_compatible, openrouter or deepseek
+	Model             string
+	ContextWindow     int        // Context window of the model in tokens
+	Price             ModelPrice // Dollars per million tokens
+	Priced            bool       // Self-hosted models have no price unless the profile sets one
+	InputTokens       int        // Input tokens since the conversation was last summarized
+	OutputTokens      int        // Output tokens since the conversation was last summarized
+	ContextTokens     int        // Tokens of the conversation at the last request, as counted by the provider
+	TotalInputTokens  int
+	CachedInputTokens int // Part of TotalInputTokens read from the cache
+	TotalOutputTokens int
 }
 
 // ContentBlock represents a block of content in a message (text or tool related)
@@ -63,25 +105,72 @@ func GetSystemPrompt(config Config) string {
 	b.WriteString(defaultSystemPrompt)
 	b.WriteString("\n\nHere is useful information about the environment you are running in:\n<env>\n")
 
-	wd, _ := os.Getwd()
+	wd, _ := workspaceDir()
+	if activeRemote != nil {
+		b.WriteString("Remote host (tools run there over SSH): " + activeRemote.Host + "\n")
+	}
 	b.WriteString("Working directory: " + wd + "\n")
 
 	// Platform
 	b.WriteString("Platform: " + runtime.GOOS + "\n")
 
 	// Date
-	b.WriteString("Today's date: " + time.Now().Format("1/2/2006") + "\n")
+	b.WriteString("Today's date: " + time.Now().Format("2006-01-02") + "\n")
 
 	// Model
 	b.WriteString("Model: " + config.Model + "\n")
 	b.WriteString("</env>\n\n")
 
+	// Sub-agents get their task from the parent and explore with their tools
+	if agentDepth() == 0 {
+		b.WriteString(environmentContext())
+	}
+
+	// Scripts read the deliverable of a run, sub-agents report to their parent in full
+	if config.NonInteractive && agentDepth() == 0 {
+		b.WriteString(finalAnswerInstructions)
+	}
+
+	for _, fname := range config.SystemFiles {
+		if content, err := os.ReadFile(fname); err == nil {
+			b.WriteString("\nContents of " + fname + "\n\n")
+			b.WriteString(string(content))
+			b.WriteString("\n\n")
+		}
+	}
+	return b.String()
+}
+
+// environmentCache holds the project context of the system prompt, which is
+// built once and shared by every provider of the process
+var environmentCache struct {
+	sync.Mutex
+	context string
+	built   bool
+}
+
+// environmentContext returns the directory structure, project facts and git
+// status for the system prompt, computing them on first use only
+func environmentContext() string {
+	environmentCache.Lock()
+	defer environmentCache.Unlock()
+	if environmentCache.built {
+		return environmentCache.context
+	}
+
+	var b strings.Builder
 	b.WriteString("As you answer the user's questions, you can use the following context:\n\n")
 
 	b.WriteString(`<context name="directoryStructure">Below is a snapshot of this project's file structure at the start of the conversation. This snapshot will NOT update during the conversation.`)
 	b.WriteString(listProjectFiles())
 	b.WriteString("</context>\n")
 
+	if facts := projectFacts(); facts != "" {
+		b.WriteString(`<context name="projectFacts">Facts read from the manifests of the project at the start of the conversation. Rely on them instead of guessing the toolchain and commands.` + "\n")
+		b.WriteString(facts)
+		b.WriteString("</context>\n")
+	}
+
 	// Add git status if available
 	gitCurrentBranch, err := ExecuteCommand("git branch --show-current")
 	if err == nil && gitCurrentBranch != "" {
@@ -109,17 +198,23 @@ func GetSystemPrompt(config Config) string {
 		b.WriteString("</context>\n")
 	}
 
-	for _, fname := range config.SystemFiles {
-		if content, err := os.ReadFile(fname); err == nil {
-			b.WriteString("\nContents of " + fname + "\n\n")
-			b.WriteString(string(content))
-			b.WriteString("\n\n")
-		}
-	}
-	return b.String()
+	environmentCache.context = b.String()
+	environmentCache.built = true
+	return environmentCache.context
+}
+
+// refreshEnvironmentContext drops the cached project context so the next
+// system prompt reflects the current files and git status
+func refreshEnvironmentContext() {
+	environmentCache.Lock()
+	defer environmentCache.Unlock()
+	environmentCache.built = false
 }
 
 func listProjectFiles() string {
+	if activeRemote != nil {
+		return listRemoteFiles()
+	}
 	wd, err := os.Getwd()
 	if err != nil {
 		return ""
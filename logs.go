@@ -17,21 +17,46 @@ const (
 	MaxLogSize = 10 * 1024 * 1024 // 10MB default max log size
 )
 
-// InitLogger initializes the application logger
-func InitLogger(debug bool) {
-	// Create logs directory in user's data directory if it doesn't exist
+var (
+	logLevel        = new(slog.LevelVar) // Level of the logger, changed live by /debug
+	profileLogLevel slog.Level           // Level set by the profile, restored when /debug turns debug logging off
+	logPath         string               // File the logger writes to
+)
+
+// parseLogLevel returns the level named by log_level, info when empty
+func parseLogLevel(name string) (slog.Level, error) {
+	var level slog.Level
+	if name == "" {
+		return slog.LevelInfo, nil
+	}
+	if err := level.UnmarshalText([]byte(name)); err != nil {
+		return level, fmt.Errorf("invalid log_level %q, expected debug, info, warn or error", name)
+	}
+	return level, nil
+}
+
+// defaultLogPath returns the log file shared by the profiles that set none
+func defaultLogPath() string {
 	usr, err := user.Current()
 	if err != nil {
 		panic(err)
 	}
-	logDir := filepath.Join(usr.HomeDir, ".local", "share", "aicode")
-	err = os.MkdirAll(logDir, 0755)
+	return filepath.Join(usr.HomeDir, ".local", "share", "aicode", "aicode.log")
+}
+
+// InitLogger initializes the application logger with the level and the file
+// of the profile, debug forcing the debug level
+func InitLogger(config Config) {
+	logPath = defaultLogPath()
+	if config.LogFile != "" {
+		logPath = expandHomeDir(config.LogFile)
+	}
+	// Create the directory of the log if it doesn't exist
+	err := os.MkdirAll(filepath.Dir(logPath), 0755)
 	if err != nil {
 		panic(err)
 	}
 
-	logPath := filepath.Join(logDir, "aicode.log")
-
 	// Check if log needs truncation
 	TruncateLogIfNeeded(logPath, MaxLogSize)
 
@@ -40,10 +65,11 @@ func InitLogger(debug bool) {
 		panic(err)
 	}
 
-	// Set up the handler with appropriate log level based on debug flag
-	logLevel := slog.LevelInfo
-	if debug {
-		logLevel = slog.LevelDebug
+	// LoadConfig has validated the level already
+	profileLogLevel, _ = parseLogLevel(config.LogLevel)
+	logLevel.Set(profileLogLevel)
+	if config.Debug {
+		logLevel.Set(slog.LevelDebug)
 	}
 
 	handler := slog.NewTextHandler(LogFile, &slog.HandlerOptions{
@@ -55,6 +81,19 @@ func InitLogger(debug bool) {
 	slog.Info("AiCode started", "version", "0.1")
 }
 
+// toggleDebugLogging switches between debug logging and the level of the
+// profile, returning whether debug logging is on
+func toggleDebugLogging() bool {
+	if logLevel.Level() == slog.LevelDebug {
+		logLevel.Set(max(profileLogLevel, slog.LevelInfo))
+		slog.Info("Debug logging turned off")
+		return false
+	}
+	logLevel.Set(slog.LevelDebug)
+	slog.Debug("Debug logging turned on")
+	return true
+}
+
 // TruncateLogIfNeeded checks if the log file exceeds maxSize and truncates it if needed
 // It keeps the most recent portion of the log and adds a truncation message
 func TruncateLogIfNeeded(logPath string, maxSize int64) {
@@ -126,3 +165,12 @@ func TruncateLogIfNeeded(logPath string, maxSize int64) {
 		return
 	}
 }
+
+func debugHandler(m *chatModel) error {
+	if toggleDebugLogging() {
+		m.outputs = append(m.outputs, "Debug logging on, writing to "+logPath)
+	} else {
+		m.outputs = append(m.outputs, "Debug logging off")
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// agentDepthEnv holds the nesting level of a sub-agent, unset for the main agent
+const agentDepthEnv = "AICODE_AGENT_DEPTH"
+
+// errToolLoop ends a turn in which the model keeps repeating the same tool call
+var errToolLoop = errors.New("tool call loop detected, stopping the turn")
+
+// agentDepth returns how deep this process is in the chain of sub-agents
+func agentDepth() int {
+	depth, _ := strconv.Atoi(os.Getenv(agentDepthEnv))
+	return depth
+}
+
+// checkAgentDepth returns an error if this process may not dispatch another sub-agent
+func checkAgentDepth(config Config) error {
+	if depth := agentDepth(); depth >= config.MaxAgentDepth {
+		return fmt.Errorf("sub-agent depth limit of %d reached, do the work with your own tools", config.MaxAgentDepth)
+	}
+	return nil
+}
+
+// readOnlyTools are the tools that do not change the workspace
+var readOnlyTools = map[string]bool{
+	"View":          true,
+	"Grep":          true,
+	"FindFiles":     true,
+	"Ls":            true,
+	"Explore":       true,
+	"SummarizeFile": true,
+}
+
+// loopDetector counts identical tool calls within a turn and keeps the
+// results of calls reading a file so repeated ones can be answered without
+// running them while the file is unchanged
+type loopDetector struct {
+	mu      sync.Mutex
+	calls   map[string]int
+	results map[string]cachedResult // Results of read-only calls since the workspace last changed
+}
+
+// cachedResult is the result of a call with the state of the file it read
+type cachedResult struct {
+	output  string
+	path    string
+	modTime time.Time
+	size    int64
+}
+
+// GlobalLoopDetector is the application-wide loop detector
+var GlobalLoopDetector = &loopDetector{calls: map[string]int{}, results: map[string]cachedResult{}}
+
+// callKey identifies a call by its tool and input, ignoring the formatting
+// and key order of the JSON input
+func callKey(call ToolCall) string {
+	input := string(call.Input)
+	var value any
+	if err := json.Unmarshal(call.Input, &value); err == nil {
+		if normalized, err := json.Marshal(value); err == nil {
+			input = string(normalized)
+		}
+	}
+	return call.Name + "\x00" + input
+}
+
+// Reset forgets the calls of the previous turn
+func (d *loopDetector) Reset() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.calls = map[string]int{}
+	d.results = map[string]cachedResult{}
+}
+
+// Record counts a call and returns how many times it was made in this turn
+func (d *loopDetector) Record(call ToolCall) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	key := callKey(call)
+	d.calls[key]++
+	return d.calls[key]
+}
+
+// CachedResult returns the result of an identical call made earlier in the
+// turn, when no tool changed the workspace since and the file it read has the
+// same modification time and size, as it may have been edited outside aicode.
+// The file is checked in the workspace, on the remote host in remote mode.
+func (d *loopDetector) CachedResult(call ToolCall) (string, bool) {
+	key := callKey(call)
+	d.mu.Lock()
+	result, ok := d.results[key]
+	d.mu.Unlock()
+	if !ok {
+		return "", false
+	}
+
+	// Checking a remote file takes a round trip, made without holding the lock
+	info, err := workspaceStat(result.path)
+	if err == nil && info.ModTime().Equal(result.modTime) && info.Size() == result.size {
+		return result.output, true
+	}
+	d.mu.Lock()
+	if d.results[key] == result {
+		delete(d.results, key)
+	}
+	d.mu.Unlock()
+	return "", false
+}
+
+// Remember stores the result of a successful call reading a file, and
+// forgets all results when a call may have changed the workspace, even if it
+// failed
+func (d *loopDetector) Remember(call ToolCall, result string, succeeded bool) {
+	switch {
+	case readOnlyTools[call.Name]:
+		path := cachedFile(call)
+		if !succeeded || path == "" {
+			return
+		}
+		info, err := workspaceStat(path)
+		if err != nil {
+			return
+		}
+		d.mu.Lock()
+		d.results[callKey(call)] = cachedResult{output: result, path: path, modTime: info.ModTime(), size: info.Size()}
+		d.mu.Unlock()
+	case mayChangeWorkspace(call.Name):
+		d.mu.Lock()
+		d.results = map[string]cachedResult{}
+		d.mu.Unlock()
+	}
+}
+
+// cachedFile returns the file read by a call whose result can be reused while
+// the file is unchanged, empty for the calls always run again, such as
+// searches whose files cannot all be checked
+func cachedFile(call ToolCall) string {
+	if call.Name != "View" && call.Name != "SummarizeFile" {
+		return ""
+	}
+	var params struct {
+		FilePath string `json:"file_path"`
+	}
+	if err := json.Unmarshal(call.Input, &params); err != nil {
+		return ""
+	}
+	return params.FilePath
+}
+
+// mayChangeWorkspace tells whether a tool may modify files
+func mayChangeWorkspace(toolName string) bool {
+	return !readOnlyTools[toolName] && toolName != "Fetch"
+}
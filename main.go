@@ -1,46 +1,149 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log/slog"
 	"os"
-	"runtime/debug"
+	"slices"
 	"strings"
+	"time"
 )
 
 // runSimpleMode processes a single prompt in non-interactive mode
 func runSimpleMode(llm Llm, config Config) {
+	finalResponse, err := runTurn(llm, config, config.InitialPrompt)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	switch {
+	case config.OutputFormat == outputStreamJSON:
+		return
+	case config.OutputFormat == outputJSON:
+		printFinalOutput(llm, finalResponse)
+		return
+	case config.Quiet:
+		// In quiet mode, only print the deliverable of the final response
+		fmt.Println(finalAnswer(finalResponse))
+		return
+	}
+
+	fmt.Println(stripFinalAnswerTags(finalResponse))
+	info := llm.ProviderInfo()
+	if info.Priced {
+		fmt.Printf("Tokens: %s input, %s output. Cost: %s\n", formatTokenCount(info.InputTokens), formatTokenCount(info.OutputTokens), config.Currency.Format(llm.CalculatePrice()))
+	} else {
+		fmt.Printf("Tokens: %s input, %s output\n", formatTokenCount(info.InputTokens), formatTokenCount(info.OutputTokens))
+	}
+	if summary := GlobalTiming.Summary(); summary != "" {
+		fmt.Println(summary)
+	}
+}
+
+// runTurn sends a prompt and runs the requested tools until the model gives
+// its final answer, which is returned
+func runTurn(llm Llm, config Config, prompt string) (string, error) {
 	var finalResponse string
+	streamJSON := config.OutputFormat == outputStreamJSON
 
 	// Create a fresh context for this operation
 	GlobalAppContext.Reset()
-	ctx := GlobalAppContext.Context()
+	ctx, cancel := withTurnDeadline(GlobalAppContext.Context(), config)
+	defer cancel()
+
+	if _, err := attachImageMentions(llm, prompt); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+	prompt, _, err := withEditorContext(prompt)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+
+	GlobalTiming.StartTurn(prompt)
+	defer GlobalTiming.EndTurn()
+	defer func() {
+		if err := saveSessionUsage(llm); err != nil {
+			slog.Warn("Failed to save the session usage", "error", err)
+		}
+	}()
+	resetTurnGuards()
+
+	// Events of the conversation go to the transcript, and to stdout with stream-json
+	emit := func(event streamEvent) {
+		recordTranscript(event)
+		if streamJSON {
+			emitEvent(event)
+		}
+	}
+	recordTranscript(streamEvent{Type: "prompt", Text: prompt})
+	deadline := newTurnDeadline(config.TurnTimeLimit)
 
 	// Process the initial request and any tool calls
 	for {
 		// Get response from LLM with context
-		inferenceResponse, err := llm.Inference(ctx, config.InitialPrompt)
+		inferenceStart := time.Now()
+		inferenceResponse, err := llm.Inference(ctx, prompt)
+		GlobalTiming.RecordModel(time.Since(inferenceStart))
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-			os.Exit(1)
+			emit(streamEvent{Type: "error", Error: err.Error()})
+			return "", err
 		}
 
+		// The prompt is part of the history now, follow-up requests only add tool results
+		prompt = ""
+
 		// Store the response content for later output
 		finalResponse = inferenceResponse.Content
+		if inferenceResponse.Content != "" {
+			emit(streamEvent{Type: "assistant_delta", Text: inferenceResponse.Content})
+		}
+		for _, toolCall := range inferenceResponse.ToolCalls {
+			emit(streamEvent{Type: "tool_call", ID: toolCall.ID, Name: toolCall.Name, Input: toolInputJSON(toolCall.Input), Version: toolSchemaVersion(toolCall.Name)})
+		}
+		if streamJSON {
+			emitEvent(usageEvent(llm))
+		}
 
 		// Check if we have tool calls
 		if len(inferenceResponse.ToolCalls) == 0 {
 			// No tool calls, we'll print the response outside the loop
 			break
 		}
+		if deadline.reached {
+			refuseToolCalls(llm, inferenceResponse.ToolCalls)
+			break
+		}
+
+		// Share the findings made so far with the parent agent, if any
+		if inferenceResponse.Content != "" {
+			reportAgentProgress("%s", inferenceResponse.Content)
+		}
 
 		// Process tool calls with context
 		_, toolResults, err := HandleToolCallsWithResultsContext(ctx, inferenceResponse.ToolCalls, config)
+		for _, result := range toolResults {
+			emit(streamEvent{Type: "tool_result", ID: result.CallID, Output: result.Output})
+		}
+		if err != nil && ctx.Err() != nil {
+			// Out of time or interrupted while the tools ran
+			err = context.Cause(ctx)
+			emit(streamEvent{Type: "error", Error: err.Error()})
+			return "", err
+		}
 		if err != nil {
-			if config.Debug {
+			emit(streamEvent{Type: "error", Error: err.Error()})
+			if config.Debug || errors.Is(err, errToolLoop) {
 				fmt.Fprintf(os.Stderr, "Error handling tool calls: %v\n", err)
 			}
+			// Keep the history valid for the next turns
+			if errors.Is(err, errToolLoop) {
+				for _, result := range toolResults {
+					llm.AddToolResult(result.CallID, result.Output)
+				}
+			}
 			break
 		}
 
@@ -48,40 +151,91 @@ func runSimpleMode(llm Llm, config Config) {
 		for _, result := range toolResults {
 			llm.AddToolResult(result.CallID, result.Output)
 		}
-	}
-
-	// In quiet mode, only print the final response content
-	fmt.Println(finalResponse)
 
-	// Print token usage and price if NOT in quiet mode
-	if !config.Quiet {
-		switch provider := llm.(type) {
-		case *Claude:
-			price := provider.CalculatePrice()
-			inputDisplay := formatTokenCount(provider.InputTokens)
-			outputDisplay := formatTokenCount(provider.OutputTokens)
-			fmt.Printf("Tokens: %s input, %s output. Cost: $%.2f\n", inputDisplay, outputDisplay, price)
-		case *OpenAI:
-			price := provider.CalculatePrice()
-			inputDisplay := formatTokenCount(provider.InputTokens)
-			outputDisplay := formatTokenCount(provider.OutputTokens)
-			fmt.Printf("Tokens: %s input, %s output. Cost: $%.2f\n", inputDisplay, outputDisplay, price)
+		// Out of time, the next request asks for a summary
+		if deadline.Expired() {
+			reportTimeLimit(config.TurnTimeLimit)
+			prompt = deadline.Prompt()
+			recordTranscript(streamEvent{Type: "prompt", Text: prompt})
 		}
 	}
+
+	if streamJSON {
+		info := llm.ProviderInfo()
+		answer, _ := splitFinalAnswer(finalResponse)
+		emitEvent(streamEvent{Type: "done", Text: finalResponse, Answer: answer, InputTokens: info.InputTokens, OutputTokens: info.OutputTokens, Cost: llm.CalculatePrice()})
+	}
+	return finalResponse, nil
 }
 
 // initLLM initializes the appropriate LLM provider based on configuration
+// providers are the values of provider in the profile, OpenAI and Anthropic
+// are chosen from the model when it is not set
+var providers = []string{providerOpenAICompatible, providerOpenRouter, providerDeepSeek}
+
 func initLLM(config Config) (Llm, error) {
 	var llm Llm
 
 	// Choose provider based on configuration or available API keys
-	if strings.HasPrefix(config.Model, "claude") {
+	if config.Provider != "" && !slices.Contains(providers, config.Provider) {
+		return nil, fmt.Errorf("unknown provider %q, use %s or leave it empty", config.Provider, strings.Join(providers, ", "))
+	}
+	if config.Provider == providerOpenAICompatible && config.BaseUrl == "" {
+		return nil, fmt.Errorf("provider %s needs the base_url of the server, e.g. http://localhost:8000/v1", providerOpenAICompatible)
+	}
+	detectModelLimits(config)
+	if usesClaudeAPI(config.Model, config) {
 		llm = NewClaude(config)
 	} else {
 		llm = NewOpenAI(config)
 	}
 
-	return llm, nil
+	if config.FallbackModel != "" {
+		fallback, err := newFallbackLlm(llm, config)
+		if err != nil {
+			return nil, err
+		}
+		llm = fallback
+	}
+	return newSwitchingLlm(llm, config), nil
+}
+
+// usesClaudeAPI tells whether model is served by the Anthropic API, self-hosted
+// servers and OpenRouter speak the OpenAI API whatever the name of their models
+func usesClaudeAPI(model string, config Config) bool {
+	return config.Provider == "" && strings.HasPrefix(model, "claude")
+}
+
+// newCheapLlm creates a provider for the cheap model without tools and with
+// the given system prompt, suited for short auxiliary requests
+func newCheapLlm(config Config, systemPrompt string) Llm {
+	config.Model = config.CheapModel
+	contextWindow, maxTokens := contextLimits(config, config.Model)
+	maxTokens = min(maxTokens, 4096)
+	if usesClaudeAPI(config.Model, config) {
+		return &Claude{
+			Config:              config,
+			ContextWindowSize:   contextWindow,
+			MaxTokens:           maxTokens,
+			conversationHistory: []claudeMessage{},
+			systemMessages:      []claudeSystemMessage{{Type: "text", Text: systemPrompt}},
+		}
+	}
+	return &OpenAI{
+		Config:              config,
+		ContextWindowSize:   contextWindow,
+		MaxTokens:           maxTokens,
+		conversationHistory: []openaiMessage{{Role: "system", Content: systemPrompt, Type: "text"}},
+	}
+}
+
+// quickCompletion sends a single prompt to the cheap model and returns its answer
+func quickCompletion(ctx context.Context, config Config, systemPrompt, prompt string) (string, error) {
+	response, err := newCheapLlm(config, systemPrompt).Inference(ctx, prompt)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(response.Content), nil
 }
 
 // initializeTools sets up the enabled tools based on user input and updates the config
@@ -144,22 +298,57 @@ func initializeTools(toolsFlag string, config *Config) {
 	}
 }
 
+// setupSession initializes the logger, the enabled tools, the workspace and
+// the LLM provider of config. The caller closes LogFile.
+func setupSession(config *Config, toolsFlag string) (Llm, error) {
+	InitLogger(*config)
+	initializeTools(toolsFlag, config)
+	configureToolScheduler(*config)
+	configureRemote(*config)
+	configureTranscript(*config)
+	return initLLM(*config)
+}
+
+// subcommands are run instead of a chat session when named by the first argument
+var subcommands = map[string]func(args []string) int{
+	"doctor":      runDoctor,
+	"render":      runRender,
+	"run":         runRun,
+	"self-update": runSelfUpdate,
+	"serve":       runServe,
+	"usage":       runUsage,
+}
+
 func main() {
+	// Subcommands parse their own flags
+	if len(os.Args) > 1 {
+		if run, ok := subcommands[os.Args[1]]; ok {
+			os.Exit(run(os.Args[2:]))
+		}
+	}
+
 	quietFlag := flag.Bool("q", false, "Run in simple mode with a single prompt")
 	nonInteractiveFlag := flag.Bool("n", false, "Run in non-interactive mode")
-	configFlag := flag.String("p", "~/.config/aicode/config.yml", "Profile/config file")
+	configFlag := flag.String("p", defaultProfile, "Profile/config file")
 	toolsFlag := flag.String("tools", "", "Comma-separated list of tools to enable (default: all tools)")
 	debugFlag := flag.Bool("d", false, "Enable debug logging")
 	versionFlag := flag.Bool("version", false, "Display the application version and exit")
+	continueFlag := flag.Bool("continue", false, "Start with the follow-ups left by the previous session in this directory")
+	stdinFlag := flag.Bool("stdin", false, "Read user turns from stdin, one per line, and answer each in turn")
+	outputFlag := flag.String("output", "", "Output format of the non-interactive mode: text, json or stream-json")
+	auditFlag := flag.Bool("audit", false, "Write every request to the provider and its response to .aicode/sessions/<id>/api.jsonl")
+	recordFlag := flag.Bool("record", false, "Record the transcript of the session for aicode serve")
+	editorStateFlag := flag.String("editor-state", "", "JSON file where editor plugins list the open files and selections (default .aicode/editor.json)")
+	timeLimitFlag := flag.Duration("time-limit", 0, "Wall-clock budget of a turn, e.g. 10m, after which the model sums up its progress")
+	scriptFlag := flag.String("script", "", "Run the interactive UI headless with the keystrokes of a script file, or - for stdin, printing the frames it asks for")
 	flag.Parse()
 
 	if *versionFlag {
-		info, _ := debug.ReadBuildInfo()
-		fmt.Println(info.Main.Version)
+		fmt.Println(versionLabel(currentVersion()))
 		os.Exit(0)
 	}
 
-	configPath := expandHomeDir(*configFlag)
+	configPath := selectProfile(flag.CommandLine, *configFlag)
 
 	// Load configuration
 	config, err := LoadConfig(configPath)
@@ -171,7 +360,22 @@ func main() {
 	// Set config.Quiet to CLI flag if present
 	config.Quiet = config.Quiet || *quietFlag
 	config.Debug = config.Debug || *debugFlag
+	config.AuditLog = config.AuditLog || *auditFlag
+	config.RecordTranscript = config.RecordTranscript || *recordFlag
 	config.NonInteractive = config.NonInteractive || *nonInteractiveFlag
+	if *outputFlag != "" {
+		config.OutputFormat = *outputFlag
+	}
+	if *editorStateFlag != "" {
+		GlobalEditor.SetPath(*editorStateFlag)
+	}
+	if *timeLimitFlag > 0 {
+		config.TurnTimeLimit = *timeLimitFlag
+	}
+	if err := checkOutputFormat(config.OutputFormat); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
 	if config.InitialPrompt == "" {
 		args := flag.Args()
 		if len(args) != 0 {
@@ -179,19 +383,33 @@ func main() {
 		}
 	}
 
-	// Initialize the logger
-	InitLogger(config.Debug)
-	defer LogFile.Close()
-
-	// Initialize enabled tools
-	initializeTools(*toolsFlag, &config)
+	// The follow-ups are sent with the prompt of non-interactive runs, and
+	// left in the input of the UI to be edited first
+	if *continueFlag && (*stdinFlag || config.NonInteractive) {
+		config.InitialPrompt, err = continuePrompt(config.InitialPrompt)
+	} else if *continueFlag {
+		config.InputPrefill, err = continuePrompt("")
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
 
-	// Initialize LLM provider with configuration
-	llm, err := initLLM(config)
+	llm, err := setupSession(&config, *toolsFlag)
+	defer LogFile.Close()
 	if err != nil {
 		slog.Error("Failed to initialize LLM provider", "error", err)
 		os.Exit(1)
 	}
+	if *continueFlag {
+		if err := restoreSessionUsage(llm); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to restore the usage of the previous session: %v\n", err)
+		}
+	}
+
+	if *stdinFlag {
+		runStdinMode(llm, config)
+		return
+	}
 
 	if config.NonInteractive {
 		if config.InitialPrompt == "" {
@@ -202,5 +420,13 @@ func main() {
 		return
 	}
 
+	if *scriptFlag != "" {
+		if err := runScriptMode(llm, config, *scriptFlag); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	runInteractiveMode(llm, config)
 }
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// How long the limits listed by a server are trusted before asking it again,
+// sparing every sub-agent a request at startup
+const modelLimitsMaxAge = 24 * time.Hour
+
+// modelLimitsTimeout bounds the request listing the models at startup
+const modelLimitsTimeout = 5 * time.Second
+
+// modelListing is an entry of the /models endpoint of the OpenAI API. Servers
+// add the limits of the model under names of their own.
+type modelListing struct {
+	ID            string `json:"id"`
+	ContextLength int    `json:"context_length"` // OpenRouter, Together
+	MaxModelLen   int    `json:"max_model_len"`  // vLLM
+	ContextWindow int    `json:"context_window"` // Groq, LiteLLM
+	TopProvider   struct {
+		ContextLength       int `json:"context_length"`
+		MaxCompletionTokens int `json:"max_completion_tokens"`
+	} `json:"top_provider"` // OpenRouter
+	MaxCompletionTokens int `json:"max_completion_tokens"`
+}
+
+// limits returns the context window and the longest response of the model,
+// zero when the server does not tell
+func (l modelListing) limits() (contextWindow, maxTokens int) {
+	for _, n := range []int{l.TopProvider.ContextLength, l.ContextLength, l.MaxModelLen, l.ContextWindow} {
+		if n > 0 {
+			contextWindow = n
+			break
+		}
+	}
+	return contextWindow, max(l.TopProvider.MaxCompletionTokens, l.MaxCompletionTokens)
+}
+
+// modelLimitsCache is what a server told of a model, kept on disk
+type modelLimitsCache struct {
+	Checked       time.Time `json:"checked"`
+	ContextWindow int       `json:"context_window"`
+	MaxTokens     int       `json:"max_tokens"`
+}
+
+var (
+	detectedModelsMu sync.Mutex
+	detectedModels   = ModelRegistry{} // Limits reported by the servers, by exact model name
+)
+
+// detectedModelInfo returns the limits the server reported for model
+func detectedModelInfo(model string) (ModelInfo, bool) {
+	detectedModelsMu.Lock()
+	defer detectedModelsMu.Unlock()
+	info, ok := detectedModels[model]
+	return info, ok
+}
+
+// modelLimitsPath returns the file caching the limits of model as listed by
+// the server at baseURL
+func modelLimitsPath(baseURL, model string) string {
+	sum := sha256.Sum256([]byte(baseURL + "\x00" + model))
+	return filepath.Join(os.TempDir(), "aicode-models", hex.EncodeToString(sum[:8])+".json")
+}
+
+// detectModelLimits asks the server for the context window and the longest
+// response of the model of the profile, for providers whose /models endpoint
+// tells them: OpenRouter and self-hosted servers. The registry is used for
+// the others, when the profile sets the limits itself, or when the server
+// does not answer.
+func detectModelLimits(config Config) {
+	if config.Provider != providerOpenRouter && config.Provider != providerOpenAICompatible {
+		return
+	}
+	if override, ok := lookupByPrefix(config.Models, config.Model); ok && override.ContextWindow > 0 {
+		return
+	}
+	if endpoint, ok := config.activeEndpoint(); ok && endpoint.ContextWindow > 0 {
+		return
+	}
+	if _, ok := detectedModelInfo(config.Model); ok {
+		return
+	}
+
+	url := openaiURL(config, "/models")
+	path := modelLimitsPath(url, config.Model)
+	var cached modelLimitsCache
+	if data, err := os.ReadFile(path); err == nil && json.Unmarshal(data, &cached) == nil && time.Since(cached.Checked) < modelLimitsMaxAge {
+		storeModelLimits(config.Model, cached.ContextWindow, cached.MaxTokens)
+		return
+	}
+
+	contextWindow, maxTokens, err := fetchModelLimits(config, url)
+	if err != nil {
+		slog.Debug("Failed to get the limits of the model from the provider", "model", config.Model, "error", err)
+		return
+	}
+	slog.Debug("Limits of the model reported by the provider", "model", config.Model, "context_window", contextWindow, "max_tokens", maxTokens)
+	storeModelLimits(config.Model, contextWindow, maxTokens)
+
+	data, _ := json.Marshal(modelLimitsCache{Checked: time.Now(), ContextWindow: contextWindow, MaxTokens: maxTokens})
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err == nil {
+		os.WriteFile(path, data, 0644)
+	}
+}
+
+// fetchModelLimits lists the models of the server and returns the limits of
+// the model of the profile, zero when it is not listed or has none
+func fetchModelLimits(config Config, url string) (contextWindow, maxTokens int, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), modelLimitsTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	if config.ApiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+config.ApiKey)
+	}
+	setCustomHeaders(req, config.Headers)
+
+	client, err := httpClient(config)
+	if err != nil {
+		return 0, 0, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, 0, fmt.Errorf("%s answered %d", url, resp.StatusCode)
+	}
+
+	var listing struct {
+		Data []modelListing `json:"data"`
+	}
+	if err := json.Unmarshal(body, &listing); err != nil {
+		return 0, 0, fmt.Errorf("invalid list of models: %v", err)
+	}
+	for _, model := range listing.Data {
+		if model.ID == config.Model {
+			contextWindow, maxTokens = model.limits()
+			return contextWindow, maxTokens, nil
+		}
+	}
+	return 0, 0, nil
+}
+
+// storeModelLimits records the limits a server reported for model. The
+// longest response it allows only caps the room kept for the response, which
+// otherwise leaves most of the context window to the conversation.
+func storeModelLimits(model string, contextWindow, maxTokens int) {
+	if contextWindow <= 0 {
+		return
+	}
+	info := ModelInfo{ContextWindow: contextWindow}
+	if maxTokens > 0 {
+		info.MaxTokens = min(maxTokens, defaultMaxTokens, contextWindow/4)
+	}
+	detectedModelsMu.Lock()
+	defer detectedModelsMu.Unlock()
+	detectedModels[model] = info
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	_ "embed"
+
+	"github.com/goccy/go-yaml"
+)
+
+// Defaults of models missing from the registry
+const (
+	defaultContextWindow = 200_000
+	defaultMaxTokens     = 20_000
+)
+
+//go:embed models.yml
+var defaultModelsYAML []byte
+
+// ModelInfo is what is known of a model: its price, its context window and
+// the tokens kept for its responses. Zero fields are unknown.
+type ModelInfo struct {
+	ModelPrice    `yaml:",inline"`
+	ContextWindow int `yaml:"context_window"`
+	MaxTokens     int `yaml:"max_tokens"`
+}
+
+// ModelRegistry maps models, or prefixes of model names, to what is known of them
+type ModelRegistry map[string]ModelInfo
+
+// defaultModels is the registry of known models embedded from models.yml
+var defaultModels = func() ModelRegistry {
+	var models ModelRegistry
+	if err := yaml.Unmarshal(defaultModelsYAML, &models); err != nil {
+		panic("invalid models.yml: " + err.Error())
+	}
+	return models
+}()
+
+// modelInfo returns what is known of a model, the limits reported by the
+// provider and then the models and prices of the profile overriding the
+// embedded registry field by field
+func modelInfo(config Config, model string) ModelInfo {
+	info, _ := lookupByPrefix(defaultModels, model)
+	if detected, ok := detectedModelInfo(model); ok {
+		info.ContextWindow = detected.ContextWindow
+		if detected.MaxTokens > 0 {
+			info.MaxTokens = detected.MaxTokens
+		}
+	}
+	if override, ok := lookupByPrefix(config.Models, model); ok {
+		if override.ModelPrice != (ModelPrice{}) {
+			info.ModelPrice = override.ModelPrice
+		}
+		if override.ContextWindow > 0 {
+			info.ContextWindow = override.ContextWindow
+		}
+		if override.MaxTokens > 0 {
+			info.MaxTokens = override.MaxTokens
+		}
+	}
+	if price, ok := lookupPrice(config.Prices, model); ok {
+		info.ModelPrice = price
+	}
+	return info
+}
+
+// contextLimits returns the context window of a model and the tokens kept
+// for its responses, the max_tokens of the profile or else what is known of
+// the model, with defaults for unknown models
+func contextLimits(config Config, model string) (contextWindow, maxTokens int) {
+	info := modelInfo(config, model)
+	contextWindow = defaultContextWindow
+	if info.ContextWindow > 0 {
+		contextWindow = info.ContextWindow
+	}
+	if config.MaxTokens > 0 {
+		return contextWindow, config.MaxTokens
+	}
+	if info.MaxTokens > 0 {
+		return contextWindow, info.MaxTokens
+	}
+	return contextWindow, min(defaultMaxTokens, contextWindow/4)
+}
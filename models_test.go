@@ -0,0 +1,42 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestClaudeSetConfigModel(t *testing.T) {
+	config := Config{Model: "claude-sonnet-4"}
+	c := NewClaude(config)
+	c.TotalInputTokens, c.TotalOutputTokens = 1000000, 1000000
+	spent := c.CalculatePrice()
+
+	config.Model = "claude-3-5-haiku"
+	c.SetConfig(config)
+	if c.MaxTokens != 8192 {
+		t.Errorf("MaxTokens = %d, want the 8192 of the new model", c.MaxTokens)
+	}
+	if c.InputPricePerMillion != 0.8 || c.OutputPricePerMillion != 4 {
+		t.Errorf("prices = %v/%v, want those of the new model", c.InputPricePerMillion, c.OutputPricePerMillion)
+	}
+	if got := c.CalculatePrice(); math.Abs(got-spent) > 1e-9 {
+		t.Errorf("cost after the switch = %v, want the %v spent before it", got, spent)
+	}
+
+	c.TotalOutputTokens += 1000000
+	if got := c.CalculatePrice(); math.Abs(got-spent-4) > 1e-9 {
+		t.Errorf("cost = %v, want %v with the new tokens at the new prices", got, spent+4)
+	}
+}
+
+func TestClaudeUnknownModelIsUnpriced(t *testing.T) {
+	c := NewClaude(Config{Model: "claude-future-9"})
+	if info := c.ProviderInfo(); info.Priced || info.Price != (ModelPrice{}) {
+		t.Errorf("unknown model priced at %v", info.Price)
+	}
+
+	c = NewClaude(Config{Model: "claude-future-9", Prices: ModelPrices{"claude-future": {Input: 1, Output: 2}}})
+	if info := c.ProviderInfo(); !info.Priced || info.Price.Output != 2 {
+		t.Errorf("model priced in the profile shows %v, priced %v", info.Price, info.Priced)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import "log/slog"
+
+// switchingLlm moves the conversation to another provider when the model
+// chosen with /set model or the front matter of a custom command is served
+// by another API than the current one, such as from claude-sonnet-4 to
+// o4-mini. The history is converted through the shared format, keeping the
+// tool calls and their results, and the providers left keep their usage for
+// the cost of the session.
+type switchingLlm struct {
+	Llm                       // Provider serving the conversation
+	providers    map[bool]Llm // Providers created so far, by whether they use the Anthropic API
+	profileModel string       // Model of the profile, whose key and endpoint serve its API
+	toolNames    []string     // Tool subset to offer a provider the conversation moves to
+}
+
+// newSwitchingLlm wraps the provider of the model of the profile
+func newSwitchingLlm(llm Llm, config Config) *switchingLlm {
+	return &switchingLlm{
+		Llm:          llm,
+		providers:    map[bool]Llm{usesClaudeAPI(config.Model, config): llm},
+		profileModel: config.Model,
+	}
+}
+
+// switchConfig returns the configuration serving the model of config: the
+// profile itself when the API of its model serves it as well, or else the
+// OpenAI or Anthropic API with the key of its environment variable
+func switchConfig(config Config, profileModel string) (Config, error) {
+	if usesClaudeAPI(config.Model, config) == usesClaudeAPI(profileModel, config) {
+		return config, nil
+	}
+	config.FallbackModel, config.FallbackEndpoint = "", ""
+	return nativeAPIConfig(config)
+}
+
+// SetConfig moves the conversation to the provider of the model of config
+// when another API serves it, then configures that provider
+func (s *switchingLlm) SetConfig(config Config) {
+	config, err := switchConfig(config, s.profileModel)
+	if err != nil {
+		slog.Warn("Staying with the current model", "error", err)
+		return
+	}
+
+	claudeAPI := usesClaudeAPI(config.Model, config)
+	provider, ok := s.providers[claudeAPI]
+	if !ok {
+		if claudeAPI {
+			provider = NewClaude(config)
+		} else {
+			provider = NewOpenAI(config)
+		}
+		s.providers[claudeAPI] = provider
+	}
+	if provider != s.Llm {
+		slog.Info("Moving the conversation to another provider", "model", s.Llm.GetModel(), "to", config.Model)
+		provider.RefreshSystemPrompt()
+		provider.SetToolSubset(s.toolNames)
+		provider.SetHistory(s.Llm.History())
+		s.Llm = provider
+	}
+	s.Llm.SetConfig(config)
+}
+
+// SetToolSubset restricts the tools offered to the model serving the conversation
+func (s *switchingLlm) SetToolSubset(toolNames []string) {
+	s.toolNames = toolNames
+	s.Llm.SetToolSubset(toolNames)
+}
+
+// CalculatePrice calculates the cost of the conversation on every provider
+func (s *switchingLlm) CalculatePrice() float64 {
+	price := 0.0
+	for _, provider := range s.providers {
+		price += provider.CalculatePrice()
+	}
+	return price
+}
+
+// ProviderInfo describes the model serving the conversation, with the
+// tokens used by every provider in the session totals
+func (s *switchingLlm) ProviderInfo() ProviderInfo {
+	info := s.Llm.ProviderInfo()
+	for _, provider := range s.providers {
+		if provider == s.Llm {
+			continue
+		}
+		other := provider.ProviderInfo()
+		info.TotalInputTokens += other.TotalInputTokens
+		info.CachedInputTokens += other.CachedInputTokens
+		info.TotalOutputTokens += other.TotalOutputTokens
+	}
+	return info
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// noteHandler attaches a note to a turn of the session, e.g. /note 12 "this
+// approach was wrong", or to the last turn without a number, and lists the
+// notes without arguments. Notes are kept for the session summary, and in
+// the transcript of the session for aicode serve when it is recorded.
+func noteHandler(m *chatModel) error {
+	args := m.commandArgs()
+	turns := GlobalTiming.Turns()
+	if len(args) == 0 {
+		notes := sessionNotes()
+		if len(notes) == 0 {
+			m.outputs = append(m.outputs, "No notes yet, /note N TEXT attaches one to turn N")
+			return nil
+		}
+		m.outputs = append(m.outputs, "Notes of the session:")
+		for _, note := range notes {
+			m.outputs = append(m.outputs, fmt.Sprintf("  Turn %d: %s", note.Turn, note.Text))
+		}
+		return nil
+	}
+
+	turn := turns
+	if n, err := strconv.Atoi(args[0]); err == nil {
+		turn = n
+		args = args[1:]
+	}
+	text := strings.Trim(strings.Join(args, " "), `"'`)
+	if text == "" {
+		return fmt.Errorf("usage: /note [N] TEXT")
+	}
+	if turn < 1 || turn > turns {
+		return fmt.Errorf("no turn #%d, there are %d", turn, turns)
+	}
+
+	note := streamEvent{Type: "note", Turn: turn, Text: text}
+	sessionNoteList.Lock()
+	sessionNoteList.entries = append(sessionNoteList.entries, transcriptEntry{Time: time.Now(), streamEvent: note})
+	sessionNoteList.Unlock()
+	recordTranscript(note)
+	m.outputs = append(m.outputs, fmt.Sprintf("Note attached to turn %d", turn))
+	return nil
+}
+
+// sessionNoteList holds the notes attached with /note, in the order given
+var sessionNoteList struct {
+	sync.Mutex
+	entries []transcriptEntry
+}
+
+// sessionNotes returns the notes attached to the turns of the session
+func sessionNotes() []transcriptEntry {
+	sessionNoteList.Lock()
+	defer sessionNoteList.Unlock()
+	return append([]transcriptEntry(nil), sessionNoteList.entries...)
+}
+
+// notesMarkdown lists the notes of the session for its summary
+func notesMarkdown(notes []transcriptEntry) string {
+	var b strings.Builder
+	for _, note := range notes {
+		fmt.Fprintf(&b, "- Turn %d: %s\n", note.Turn, note.Text)
+	}
+	return b.String()
+}
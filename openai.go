@@ -3,22 +3,70 @@ package main
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"log/slog"
 	"net/http"
-	"os"
 	"strings"
 )
 
+// providerOpenAICompatible is the provider of self-hosted servers speaking
+// the OpenAI API, such as vLLM, LM Studio, llama.cpp or Ollama
+const providerOpenAICompatible = "openai_compatible"
+
+// openaiURL returns the URL of an endpoint of the OpenAI API. Self-hosted
+// servers are often configured with a base_url already ending in /v1.
+func openaiURL(config Config, endpoint string) string {
+	baseURL := strings.TrimRight(config.BaseUrl, "/")
+	if baseURL == "" {
+		baseURL = "https://api.openai.com"
+	}
+	if !strings.HasSuffix(baseURL, "/v1") {
+		baseURL += "/v1"
+	}
+	return baseURL + endpoint
+}
+
 type openaiMessage struct {
-	Role       string           `json:"role"`
-	Content    string           `json:"content,omitempty"`
-	ToolCalls  []openaiToolCall `json:"tool_calls,omitempty"`
-	ToolCallID string           `json:"tool_call_id,omitempty"`
-	Type       string           `json:"type,omitempty"` // For determining message type internally
+	Role         string              `json:"role"`
+	Content      string              `json:"content,omitempty"`
+	ToolCalls    []openaiToolCall    `json:"tool_calls,omitempty"`
+	ToolCallID   string              `json:"tool_call_id,omitempty"`
+	Type         string              `json:"type,omitempty"` // For determining message type internally
+	Images       []openaiContentPart `json:"-"`              // Image parts sent along with the text content
+	Items        []json.RawMessage   `json:"-"`              // Reasoning and built-in tool items of a Responses API answer
+	CacheControl bool                `json:"-"`              // Cache the request up to this message, with prompt_cache: cache_control
+}
+
+type openaiContentPart struct {
+	Type         string              `json:"type"`
+	Text         string              `json:"text,omitempty"`
+	ImageURL     *openaiImageURL     `json:"image_url,omitempty"`
+	CacheControl *claudeCacheControl `json:"cache_control,omitempty"`
+}
+
+type openaiImageURL struct {
+	URL string `json:"url"`
+}
+
+// MarshalJSON sends the content as a list of parts when images are attached
+// or the message is a cache breakpoint
+func (m openaiMessage) MarshalJSON() ([]byte, error) {
+	type plainMessage openaiMessage
+	if len(m.Images) == 0 && !m.CacheControl {
+		return json.Marshal(plainMessage(m))
+	}
+
+	parts := append([]openaiContentPart{{Type: "text", Text: m.Content}}, m.Images...)
+	if m.CacheControl {
+		parts[len(parts)-1].CacheControl = &claudeCacheControl{Type: "ephemeral"}
+	}
+	return json.Marshal(struct {
+		plainMessage
+		Content []openaiContentPart `json:"content"`
+	}{plainMessage(m), parts})
 }
 
 type openaiToolCall struct {
@@ -36,8 +84,18 @@ type openaiRequest struct {
 	Messages    []openaiMessage  `json:"messages"`
 	Tools       []openaiTool     `json:"tools,omitempty"`
 	MaxTokens   int              `json:"max_tokens,omitempty"`
-	Temperature float64          `json:"temperature,omitempty"`
+	Temperature *float64         `json:"temperature,omitempty"`
+	TopP        *float64         `json:"top_p,omitempty"`
+	Stop        []string         `json:"stop,omitempty"`
 	Reasoning   *openaiReasoning `json:"reasoning,omitempty"`
+	Verbosity   string           `json:"verbosity,omitempty"`
+
+	// OpenAI only
+	PromptCacheKey string `json:"prompt_cache_key,omitempty"`
+
+	// OpenRouter only
+	Provider *OpenRouterPreferences `json:"provider,omitempty"`
+	Usage    *openRouterUsage       `json:"usage,omitempty"`
 }
 
 type openaiTool struct {
@@ -50,14 +108,16 @@ type openaiFunction struct {
 	Description string          `json:"description"`
 	Parameters  json.RawMessage `json:"parameters,omitempty"`
 	Arguments   json.RawMessage `json:"arguments,omitempty"`
+	Strict      bool            `json:"strict,omitempty"` // Arguments are generated to match the parameters exactly
 }
 
 type openaiResponse struct {
 	Choices []struct {
 		Message struct {
-			Role      string     `json:"role"`
-			Content   string     `json:"content"`
-			ToolCalls []toolCall `json:"tool_calls,omitempty"`
+			Role             string     `json:"role"`
+			Content          string     `json:"content"`
+			ReasoningContent string     `json:"reasoning_content,omitempty"` // DeepSeek and self-hosted reasoning models
+			ToolCalls        []toolCall `json:"tool_calls,omitempty"`
 		} `json:"message"`
 	} `json:"choices"`
 	Usage struct {
@@ -66,47 +126,53 @@ type openaiResponse struct {
 		PromptTokensDetails struct {
 			CachedTokens int `json:"cached_tokens"`
 		} `json:"prompt_tokens_details,omitempty"`
+		PromptCacheHitTokens int     `json:"prompt_cache_hit_tokens"` // Cached tokens as reported by DeepSeek
+		CacheReadInputTokens int     `json:"cache_read_input_tokens"` // Cached tokens as reported by gateways passing on the usage of Anthropic
+		Cost                 float64 `json:"cost"`                    // Dollars, reported by OpenRouter
 	} `json:"usage"`
 	Error *struct {
 		Message string `json:"message"`
 	} `json:"error,omitempty"`
 }
 
-// loadOpenAITools loads tools using the schema constants defined in tools.go
-func loadOpenAITools() []openaiTool {
+// loadOpenAITools loads the schemas of the given tools, defined in tools.go.
+// With strict, the tools whose parameters strict mode can express are
+// declared strict.
+func loadOpenAITools(toolNames []string, strict bool) []openaiTool {
 	var toolsList []openaiTool
-
-	// Process each tool
-	for toolName, toolInfo := range ToolData {
-		// Parse the JSON schema
-		var toolSchema struct {
-			Name        string          `json:"name"`
-			Description string          `json:"description"`
-			Parameters  json.RawMessage `json:"parameters"`
+	for _, toolName := range toolNames {
+		function := openaiFunction{
+			Name:        toolName,
+			Description: ToolData[toolName].Description,
+			Parameters:  toolSchema(toolName, toolDialectOpenAI),
 		}
-
-		err := json.Unmarshal([]byte(toolInfo.Schema), &toolSchema)
-		if err != nil {
-			slog.Error("Failed to unmarshal tool schema", "tool", toolName, "error", err)
-			os.Exit(1)
+		if strict {
+			if parameters, ok := strictToolSchema(toolName); ok {
+				function.Parameters, function.Strict = parameters, true
+			}
 		}
-
-		toolsList = append(toolsList, openaiTool{
-			Type: "function",
-			Function: openaiFunction{
-				Name:        toolSchema.Name,
-				Description: toolInfo.Description, // Use the markdown description
-				Parameters:  toolSchema.Parameters,
-			},
-		})
-
+		toolsList = append(toolsList, openaiTool{Type: "function", Function: function})
 	}
-
 	return toolsList
 }
 
+// strictTools tells whether the tools are declared strict: as set with
+// strict_tools, or else for the API of OpenAI only, other servers rejecting
+// or ignoring it
+func (o *OpenAI) strictTools() bool {
+	if o.Config.StrictTools != nil {
+		return *o.Config.StrictTools
+	}
+	return o.Config.Provider == ""
+}
+
 // Inference implements the Llm interface for OpenAI
 func (o *OpenAI) Inference(ctx context.Context, prompt string) (InferenceResponse, error) {
+	// Images returned by tools need a user message of their own
+	if prompt == "" && len(o.pendingImages) > 0 {
+		prompt = "Images read by the tools:"
+	}
+
 	// Add the user's prompt to the conversation
 	o.AddMessage(prompt, "user")
 
@@ -120,14 +186,14 @@ func (o *OpenAI) inferenceWithRetry(ctx context.Context, isRetry bool) (Inferenc
 	if o.shouldSummarizeConversation() || isRetry {
 		slog.Debug("Context usage approaching limit. Summarizing conversation...")
 		beforeCount := len(o.conversationHistory)
-		beforeTokens := o.InputTokens
+		beforeTokens := o.currentContextTokens()
 
 		err := o.summarizeConversation()
 		if err != nil {
 			slog.Warn("Failed to summarize conversation", "error", err)
 		} else {
 			afterCount := len(o.conversationHistory)
-			afterTokens := o.InputTokens
+			afterTokens := o.currentContextTokens()
 			reductionPercent := 100 - (float64(afterTokens) * 100 / float64(beforeTokens))
 			slog.Debug("Conversation summarized",
 				"beforeCount", beforeCount,
@@ -136,87 +202,75 @@ func (o *OpenAI) inferenceWithRetry(ctx context.Context, isRetry bool) (Inferenc
 		}
 	}
 
-	// Get base URL from environment variable or use default
-	baseURL := o.Config.BaseUrl
-	if baseURL == "" {
-		baseURL = "https://api.openai.com"
+	url := openaiURL(o.Config, "/chat/completions")
+	bodyBytes := o.chatRequestBody()
+	chained := false
+	if o.usesResponses() {
+		url = openaiURL(o.Config, "/responses")
+		bodyBytes, chained = o.responsesRequestBody()
 	}
 
-	url := baseURL + "/v1/chat/completions"
-	reqBody := openaiRequest{
-		Model:     o.Config.Model,
-		Messages:  o.conversationHistory,
-		Tools:     o.tools,
-		MaxTokens: o.MaxTokens,
+	// Prune the largest earlier tool results of a request the provider would refuse
+	if limit := maxRequestBytes(o.Config.Model, o.Config); len(bodyBytes) > limit {
+		pruned := pruneLargestToolResults(o.historicalToolResults(), len(bodyBytes)-limit)
+		if len(pruned) == 0 {
+			return InferenceResponse{}, &requestTooLargeError{Bytes: len(bodyBytes), Limit: limit}
+		}
+		slog.Warn("Pruned tool results to fit the request size limit", "size", len(bodyBytes), "limit", limit, "pruned", strings.Join(pruned, ", "))
+		return o.inferenceWithRetry(ctx, isRetry)
 	}
 
-	// Add reasoning effort parameter for OpenAI models that support it
-	if strings.HasPrefix(o.Config.Model, "o") {
-		reqBody.Reasoning = &openaiReasoning{
-			Effort: o.Config.ReasoningEffort,
+	// Compact or refuse before uploading a request the model cannot accept
+	if err := checkContextFits(bodyBytes, o.Config.Model, o.ContextWindowSize, o.MaxTokens); err != nil {
+		if isRetry {
+			return InferenceResponse{}, err
 		}
+		slog.Debug("Request exceeds context window. Summarizing conversation...", "error", err)
+		return o.inferenceWithRetry(ctx, true)
 	}
-	bodyBytes, _ := json.Marshal(&reqBody)
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(bodyBytes))
-	if err != nil {
-		return InferenceResponse{}, err
-	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+o.Config.ApiKey)
 
-	// Use the context for cancellation
-	req = req.WithContext(ctx)
-
-	resp, err := http.DefaultClient.Do(req)
+	resp, body, err := postWithRetry(ctx, o.Config, "OpenAI", func() (*http.Request, error) {
+		return o.newRequest(url, bodyBytes)
+	})
 	if err != nil {
 		return InferenceResponse{}, err
 	}
-	defer resp.Body.Close()
-
-	// Check for rate limit error (HTTP 429)
-	if resp.StatusCode == 429 && !isRetry {
-		slog.Debug("Received rate limit (429) error. Summarizing conversation and retrying...")
-		return o.inferenceWithRetry(ctx, true)
+	if o.usesResponses() {
+		return o.handleResponsesAnswer(ctx, isRetry, resp, body, chained)
 	}
 
-	body, _ := io.ReadAll(resp.Body)
-
 	var out openaiResponse
 	if err := json.Unmarshal(body, &out); err != nil {
-		return InferenceResponse{}, fmt.Errorf("error unmarshaling response: %v\nResponse body: %s", err, string(body))
+		return InferenceResponse{}, newAPIError("OpenAI", resp, body, "")
 	}
 	if out.Error != nil {
-		// Check if the error is about rate limiting and we haven't retried yet
-		slog.Error("Inference error", "url", url, "error", out.Error.Message)
+		// Rate limits answered with another status were not retried yet,
+		// a shorter conversation may fit the limit
 		if (strings.Contains(strings.ToLower(out.Error.Message), "rate limit") ||
-			strings.Contains(strings.ToLower(out.Error.Message), "too many requests")) && !isRetry {
+			strings.Contains(strings.ToLower(out.Error.Message), "too many requests")) && !isRetry && !retryableStatus(resp.StatusCode) {
 			slog.Debug("Received rate limit error in response. Summarizing conversation and retrying...")
 			return o.inferenceWithRetry(ctx, true)
 		}
-		return InferenceResponse{}, errors.New(out.Error.Message)
+		return InferenceResponse{}, newAPIError("OpenAI", resp, body, out.Error.Message)
+	}
+	if resp.StatusCode/100 != 2 {
+		return InferenceResponse{}, newAPIError("OpenAI", resp, body, "")
 	}
 	if len(out.Choices) == 0 {
 		return InferenceResponse{}, errors.New("no choices in OpenAI response")
 	}
-
-	// Accumulate token usage
-	o.InputTokens += out.Usage.PromptTokens
-	o.TotalInputTokens += out.Usage.PromptTokens
-	o.OutputTokens += out.Usage.CompletionTokens
-	o.TotalOutputTokens += out.Usage.CompletionTokens
-
-	// Track cached tokens if available
-	if out.Usage.PromptTokensDetails.CachedTokens > 0 {
-		o.CachedInputTokens += out.Usage.PromptTokensDetails.CachedTokens
-	}
+	o.completeResponse(&out, bodyBytes)
+	o.addUsage(requestKey(resp.Request), out.Usage.PromptTokens, out.Usage.PromptTokensDetails.CachedTokens, out.Usage.CompletionTokens, out.Usage.Cost)
 
 	// Convert to our unified response format
 	response := InferenceResponse{
 		Content:   out.Choices[0].Message.Content,
 		ToolCalls: []ToolCall{},
+		Reasoning: out.Choices[0].Message.ReasoningContent,
 	}
 
-	// Create assistant message for conversation history
+	// Create assistant message for conversation history, without the
+	// reasoning: it costs input tokens and DeepSeek rejects it in requests
 	assistantMessage := openaiMessage{
 		Role:    "assistant",
 		Content: out.Choices[0].Message.Content,
@@ -253,11 +307,134 @@ func (o *OpenAI) inferenceWithRetry(ctx context.Context, isRetry bool) (Inferenc
 
 	// Add the assistant message to conversation history
 	o.conversationHistory = append(o.conversationHistory, assistantMessage)
+	o.contextCount = contextCount{tokens: out.Usage.PromptTokens + out.Usage.CompletionTokens, messages: len(o.conversationHistory)}
 
 	return response, nil
 }
 
-// Function removed since it was unused
+// chatRequestBody builds the request of the next chat completion
+func (o *OpenAI) chatRequestBody() []byte {
+	reqBody := openaiRequest{
+		Model:     o.Config.Model,
+		Messages:  o.conversationHistory,
+		Tools:     o.tools,
+		MaxTokens: o.MaxTokens,
+	}
+
+	reqBody.Temperature = o.Config.Temperature
+	reqBody.TopP = o.Config.TopP
+	reqBody.Stop = o.Config.Stop
+
+	// Add reasoning effort parameter for OpenAI models that support it
+	if o.sendsReasoning() {
+		reqBody.Reasoning = &openaiReasoning{
+			Effort: o.Config.ReasoningEffort,
+		}
+	}
+
+	// GPT-5 models take the verbosity as a parameter, others get an instruction
+	if o.openaiParams() && strings.HasPrefix(o.Config.Model, "gpt-5") {
+		reqBody.Verbosity = o.Config.Verbosity
+	} else if instruction, ok := verbosityInstructions[o.Config.Verbosity]; ok {
+		reqBody.Messages = append(append([]openaiMessage{}, o.conversationHistory...), openaiMessage{Role: "system", Content: instruction, Type: "text"})
+	}
+	switch o.promptCacheMode() {
+	case promptCacheControl:
+		reqBody.Messages = withCacheBreakpoints(reqBody.Messages)
+	case promptCacheKey:
+		reqBody.PromptCacheKey = "aicode-" + sessionID
+	}
+	o.setOpenRouterOptions(&reqBody)
+	bodyBytes, _ := json.Marshal(&reqBody)
+	return bodyBytes
+}
+
+// addUsage accumulates the tokens of a response sent with key and records its cost
+func (o *OpenAI) addUsage(key string, input, cachedInput, output int, reportedCost float64) {
+	costBefore := o.CalculatePrice()
+	o.InputTokens += input
+	o.TotalInputTokens += input
+	o.OutputTokens += output
+	o.TotalOutputTokens += output
+	o.reportedCost += reportedCost
+
+	// Track cached tokens if available
+	if cachedInput > 0 {
+		o.CachedInputTokens += cachedInput
+	}
+	recordUsage(o.Config.Model, input, cachedInput, output, o.CalculatePrice()-costBefore)
+	GlobalKeys.Record(key, input, output, o.CalculatePrice()-costBefore)
+}
+
+// openaiParams tells whether the parameters specific to OpenAI models, such
+// as the reasoning effort, can be sent: self-hosted servers and DeepSeek may
+// reject them and their model names may look like OpenAI ones
+func (o *OpenAI) openaiParams() bool {
+	return o.Config.Provider != providerOpenAICompatible && o.Config.Provider != providerDeepSeek
+}
+
+// sendsReasoning tells whether the reasoning effort is sent: to OpenAI
+// reasoning models, to endpoints declaring support for it and to
+// OpenRouter, which ignores it for models that don't reason
+func (o *OpenAI) sendsReasoning() bool {
+	if endpoint, ok := o.Config.activeEndpoint(); ok {
+		return endpoint.Reasoning
+	}
+	if o.Config.Provider == providerOpenRouter {
+		return true
+	}
+	return o.openaiParams() && strings.HasPrefix(o.Config.Model, "o")
+}
+
+// newRequest builds a request to the API, local servers usually need no API
+// key and OpenRouter attributes the requests to the app of the headers
+func (o *OpenAI) newRequest(url string, body []byte) (*http.Request, error) {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if o.Config.ApiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+o.Config.ApiKey)
+	}
+	if o.Config.Provider == providerOpenRouter {
+		req.Header.Set("HTTP-Referer", o.Config.OpenRouter.Referer)
+		req.Header.Set("X-Title", o.Config.OpenRouter.Title)
+	}
+	setCustomHeaders(req, o.Config.Headers)
+	return req, nil
+}
+
+// completeResponse fills in what self-hosted servers leave out of responses:
+// the usage is estimated from the request and the answer, tool calls get an
+// ID and their arguments are sent back as a string as the API expects
+func (o *OpenAI) completeResponse(out *openaiResponse, request []byte) {
+	message := &out.Choices[0].Message
+	if out.Usage.PromptTokens == 0 && out.Usage.CompletionTokens == 0 {
+		out.Usage.PromptTokens = estimateRequestTokens(request)
+		answer, _ := json.Marshal(message)
+		out.Usage.CompletionTokens = estimateRequestTokens(answer)
+	}
+	if out.Usage.PromptTokensDetails.CachedTokens == 0 {
+		out.Usage.PromptTokensDetails.CachedTokens = max(out.Usage.PromptCacheHitTokens, out.Usage.CacheReadInputTokens)
+	}
+	for i := range message.ToolCalls {
+		call := &message.ToolCalls[i]
+		if call.ID == "" {
+			call.ID = fmt.Sprintf("call_%d_%d", len(o.conversationHistory), i)
+		}
+		arguments := bytes.TrimSpace(call.Function.Arguments)
+		if len(arguments) == 0 || string(arguments) == "null" {
+			arguments = []byte("{}")
+		}
+		var encoded string
+		if arguments[0] == '"' && json.Unmarshal(arguments, &encoded) == nil {
+			arguments = []byte(encoded)
+		}
+		arguments, _ = json.Marshal(string(dropNullArguments(arguments)))
+		call.Function.Arguments = arguments
+	}
+}
 
 // OpenAI struct implements Llm interface
 type OpenAI struct {
@@ -275,21 +452,73 @@ type OpenAI struct {
 	conversationHistory        []openaiMessage // Internal conversation history
 	tools                      []openaiTool
 	MaxTokens                  int
+	pendingImages              []openaiContentPart // Images attached to the next user message
+	unpriced                   bool                // Self-hosted model without a price in the profile
+	reportedCost               float64             // Dollars spent according to OpenRouter
+	restored                   restoredUsage       // Usage of the previous session and earlier models, part of the totals
+	chain                      responsesChain      // Last response stored by the Responses API
+	contextCount               contextCount        // Size of the conversation in the last response
+}
+
+// RefreshSystemPrompt rebuilds the system prompt from the current environment
+func (o *OpenAI) RefreshSystemPrompt() {
+	system := openaiMessage{Role: "system", Content: GetSystemPrompt(o.Config), Type: "text"}
+	if len(o.conversationHistory) > 0 && o.conversationHistory[0].Role == "system" {
+		o.conversationHistory[0] = system
+		return
+	}
+	o.conversationHistory = append([]openaiMessage{system}, o.conversationHistory...)
+	o.contextCount = contextCount{}
 }
 
 func (o *OpenAI) Clear() {
 	o.conversationHistory = make([]openaiMessage, 0)
+	o.contextCount = contextCount{}
+}
+
+// UserMessages returns the prompts entered by the user still present in the history
+func (o *OpenAI) UserMessages() []string {
+	var messages []string
+	for _, msg := range o.conversationHistory {
+		if msg.Role == "user" && msg.Type == "text" {
+			messages = append(messages, msg.Content)
+		}
+	}
+	return messages
+}
+
+// Rewind drops the history starting at the user message with the given index
+func (o *OpenAI) Rewind(index int) {
+	n := 0
+	for i, msg := range o.conversationHistory {
+		if msg.Role == "user" && msg.Type == "text" {
+			if n == index {
+				o.conversationHistory = o.conversationHistory[:i]
+				o.contextCount = contextCount{}
+				return
+			}
+			n++
+		}
+	}
 }
 
 // shouldSummarizeConversation checks if the conversation needs to be summarized
-// based on the actual token usage compared to the context window size
+// based on the tokens of the current history compared to the context window size
 func (o *OpenAI) shouldSummarizeConversation() bool {
-	// Use the actual token count from previous API calls
-	usedTokens := o.InputTokens
-
 	// Check if we're using more than 80% of the context window
 	contextThreshold := int(float64(o.ContextWindowSize) * 0.8)
-	return usedTokens > contextThreshold
+	return o.currentContextTokens() > contextThreshold
+}
+
+// currentContextTokens returns the tokens the history, system message included, takes
+func (o *OpenAI) currentContextTokens() int {
+	return contextTokens(o.Config, o.contextCount, nil, o.conversationHistory)
+}
+
+// remainingContext estimates the tokens left in the context window for the
+// history to grow, after the reserve for the response
+func (o *OpenAI) remainingContext() int {
+	return o.ContextWindowSize - o.MaxTokens - o.currentContextTokens()
 }
 
 // summarizeConversation creates a summary of the conversation history
@@ -300,8 +529,14 @@ func (o *OpenAI) summarizeConversation() error {
 		return nil
 	}
 
-	// Save the last few messages (typically user messages that need responses)
-	lastMessages := o.conversationHistory[len(o.conversationHistory)-2:]
+	// Keep the last messages, with the tool calls and results they belong to,
+	// and the system message
+	tail := openaiHistoryShape(o.conversationHistory).tailStart(compactKeptMessages)
+	if tail <= 1 {
+		// Nothing older than the kept messages to summarize
+		return nil
+	}
+	lastMessages := o.conversationHistory[tail:]
 
 	// Copy the current conversation for the summarization request
 	summaryMessages := make([]openaiMessage, len(o.conversationHistory))
@@ -314,55 +549,15 @@ func (o *OpenAI) summarizeConversation() error {
 		Type:    "text",
 	})
 
-	// Create a request to summarize the conversation
-	url := "https://api.openai.com/v1/chat/completions"
-	reqBody := openaiRequest{
-		Model:       o.Config.Model,
-		Messages:    summaryMessages,
-		MaxTokens:   o.MaxTokens,
-		Temperature: 0.2, // Lower temperature for more consistent summaries
-	}
-
-	// Add reasoning effort parameter for OpenAI models that support it
-	if strings.HasPrefix(o.Config.Model, "o") {
-		reqBody.Reasoning = &openaiReasoning{
-			Effort: o.Config.ReasoningEffort,
-		}
-	}
-
-	// Create request
-	bodyBytes, _ := json.Marshal(&reqBody)
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(bodyBytes))
-	if err != nil {
-		return err
+	// Ask for the summary with the API of the conversation
+	summary := o.chatSummary
+	if o.usesResponses() {
+		summary = o.responsesSummary
 	}
-
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+o.Config.ApiKey)
-
-	resp, err := http.DefaultClient.Do(req)
+	summaryText, err := summary(summaryMessages)
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
-	body, _ := io.ReadAll(resp.Body)
-
-	var out openaiResponse
-	if err := json.Unmarshal(body, &out); err != nil {
-		return fmt.Errorf("error unmarshaling response: %v", err)
-	}
-
-	if out.Error != nil {
-		fmt.Printf("Inference error: url=%s, error=%s\n", url, out.Error.Message)
-		return errors.New(out.Error.Message)
-	}
-
-	if len(out.Choices) == 0 {
-		return errors.New("no choices in OpenAI summary response")
-	}
-
-	// Extract the summary text
-	summaryText := out.Choices[0].Message.Content
 
 	// Clean up any extra whitespace and ensure the summary is not empty
 	summaryText = strings.TrimSpace(summaryText)
@@ -385,42 +580,13 @@ func (o *OpenAI) summarizeConversation() error {
 		},
 	}
 
-	// Check if the last message is a tool response that needs its corresponding tool call
-	toolCallNeeded := false
-	var toolCallID string
-
-	// If we have at least 1 message and it's a tool message
-	if len(lastMessages) > 0 && lastMessages[len(lastMessages)-1].Role == "tool" {
-		// Check if it's a tool result message
-		if lastMessages[len(lastMessages)-1].Type == "tool_result" {
-			toolCallNeeded = true
-			toolCallID = lastMessages[len(lastMessages)-1].ToolCallID
-		}
-	}
-
-	// If we need to find a matching tool call, look through history
-	if toolCallNeeded {
-		// Find the corresponding assistant message with the tool call
-		for i := len(o.conversationHistory) - 3; i >= 0; i-- {
-			if o.conversationHistory[i].Role == "assistant" && len(o.conversationHistory[i].ToolCalls) > 0 {
-				for _, toolCall := range o.conversationHistory[i].ToolCalls {
-					if toolCall.ID == toolCallID {
-						// Found the matching tool call, include it in preserved messages
-						lastMessages = append([]openaiMessage{o.conversationHistory[i]}, lastMessages...)
-						break
-					}
-				}
-			}
-			// Once we found the tool call, stop searching
-			if len(lastMessages) > 2 {
-				break
-			}
-		}
-	}
-
-	// Add back the most recent messages
+	// Add back the most recent messages, an invalid history would be rejected by every later request
 	newHistory = append(newHistory, lastMessages...)
+	if err := openaiHistoryShape(newHistory).validate(); err != nil {
+		return fmt.Errorf("keeping the full conversation, its summary would be invalid: %v", err)
+	}
 	o.conversationHistory = newHistory
+	o.contextCount = contextCount{}
 
 	// Reset the token counter since we've summarized the conversation
 	o.InputTokens = 0
@@ -429,16 +595,70 @@ func (o *OpenAI) summarizeConversation() error {
 	return nil
 }
 
-// CalculatePrice calculates the price for OpenAI API usage
+// chatSummary asks chat completions for the summary of the messages
+func (o *OpenAI) chatSummary(summaryMessages []openaiMessage) (string, error) {
+	// Create a request to summarize the conversation
+	url := openaiURL(o.Config, "/chat/completions")
+	reqBody := openaiRequest{
+		Model:       o.Config.Model,
+		Messages:    summaryMessages,
+		MaxTokens:   o.MaxTokens,
+		Temperature: floatPtr(0.2), // Lower temperature for more consistent summaries
+	}
+
+	// Add reasoning effort parameter for OpenAI models that support it
+	if o.sendsReasoning() {
+		reqBody.Reasoning = &openaiReasoning{
+			Effort: o.Config.ReasoningEffort,
+		}
+	}
+
+	o.setOpenRouterOptions(&reqBody)
+
+	// Create request
+	bodyBytes, _ := json.Marshal(&reqBody)
+	resp, body, err := postWithRetry(context.Background(), o.Config, "OpenAI", func() (*http.Request, error) {
+		return o.newRequest(url, bodyBytes)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var out openaiResponse
+	if err := json.Unmarshal(body, &out); err != nil {
+		return "", newAPIError("OpenAI", resp, body, "")
+	}
+
+	if out.Error != nil {
+		return "", newAPIError("OpenAI", resp, body, out.Error.Message)
+	}
+	if resp.StatusCode/100 != 2 {
+		return "", newAPIError("OpenAI", resp, body, "")
+	}
+
+	if len(out.Choices) == 0 {
+		return "", errors.New("no choices in OpenAI summary response")
+	}
+
+	return out.Choices[0].Message.Content, nil
+}
+
+// CalculatePrice calculates the price for OpenAI API usage, OpenRouter
+// reports the price of each request including the markup of its providers
 func (o *OpenAI) CalculatePrice() float64 {
-	// Calculate uncached input tokens
-	nonCachedInputTokens := o.TotalInputTokens - o.CachedInputTokens
+	if o.Config.Provider == providerOpenRouter {
+		return o.restored.cost + o.reportedCost
+	}
+
+	// The tokens of a previous session are counted at the cost it saved
+	cachedInputTokens := o.CachedInputTokens - o.restored.cachedInput
+	nonCachedInputTokens := o.TotalInputTokens - o.restored.input - cachedInputTokens
 	nonCachedInputPrice := float64(nonCachedInputTokens) * o.InputPricePerMillion / 1000000.0
-	cachedInputPrice := float64(o.CachedInputTokens) * o.CachedInputPricePerMillion / 1000000.0
+	cachedInputPrice := float64(cachedInputTokens) * o.CachedInputPricePerMillion / 1000000.0
 	inputPrice := nonCachedInputPrice + cachedInputPrice
-	outputPrice := float64(o.TotalOutputTokens) * o.OutputPricePerMillion / 1000000.0
+	outputPrice := float64(o.TotalOutputTokens-o.restored.output) * o.OutputPricePerMillion / 1000000.0
 
-	return inputPrice + outputPrice
+	return o.restored.cost + inputPrice + outputPrice
 }
 
 // AddMessage adds a message to the conversation history
@@ -446,10 +666,25 @@ func (o *OpenAI) AddMessage(content string, role string) {
 	if content == "" {
 		return
 	}
-	o.conversationHistory = append(o.conversationHistory, openaiMessage{
+	message := openaiMessage{
 		Role:    role,
 		Content: content,
 		Type:    "text",
+	}
+	if role == "user" {
+		message.Images = o.pendingImages
+		o.pendingImages = nil
+	}
+	o.conversationHistory = append(o.conversationHistory, message)
+}
+
+// AttachImage attaches an image to the next user message
+func (o *OpenAI) AttachImage(mediaType string, data []byte) {
+	o.pendingImages = append(o.pendingImages, openaiContentPart{
+		Type: "image_url",
+		ImageURL: &openaiImageURL{
+			URL: "data:" + mediaType + ";base64," + base64.StdEncoding.EncodeToString(data),
+		},
 	})
 }
 
@@ -458,6 +693,12 @@ func (o *OpenAI) AddToolResult(toolUseID string, result string) {
 	if result == "" {
 		result = "No result"
 	}
+	// Tool messages are text only, the images read by the tool are sent in
+	// a user message after the results
+	for _, image := range GlobalToolImages.Take(result) {
+		o.AttachImage(image.mediaType, image.data)
+	}
+	result = guardToolResult(result, o.remainingContext(), o.Config.ToolResultShare)
 
 	o.conversationHistory = append(o.conversationHistory, openaiMessage{
 		Role:       "tool",
@@ -467,6 +708,30 @@ func (o *OpenAI) AddToolResult(toolUseID string, result string) {
 	})
 }
 
+// historicalToolResults returns the tool results answering earlier tool
+// calls, those of the last response are kept whole for the model to read
+func (o *OpenAI) historicalToolResults() []toolResultRef {
+	last := -1
+	tools := map[string]string{}
+	for i, msg := range o.conversationHistory {
+		if msg.Role != "assistant" {
+			continue
+		}
+		last = i
+		for _, call := range msg.ToolCalls {
+			tools[call.ID] = call.Function.Name
+		}
+	}
+
+	var results []toolResultRef
+	for i := 0; i < last; i++ {
+		if msg := &o.conversationHistory[i]; msg.Role == "tool" {
+			results = append(results, toolResultRef{tool: tools[msg.ToolCallID], id: msg.ToolCallID, content: &msg.Content})
+		}
+	}
+	return results
+}
+
 // GetFormattedHistory returns the conversation history formatted for display
 func (o *OpenAI) GetFormattedHistory() []string {
 	var outputs []string
@@ -497,10 +762,135 @@ func (o *OpenAI) GetFormattedHistory() []string {
 	return outputs
 }
 
+// SetConfig replaces the configuration used for the next requests. When the
+// model changes, the tokens used so far keep the cost of the previous model.
+func (o *OpenAI) SetConfig(config Config) {
+	modelChanged := config.Model != o.Config.Model
+	if modelChanged {
+		o.restored = restoredUsage{
+			input:       o.TotalInputTokens,
+			cachedInput: o.CachedInputTokens,
+			output:      o.TotalOutputTokens,
+			cost:        o.CalculatePrice(),
+		}
+		o.reportedCost = 0
+	}
+	o.Config = config
+	if modelChanged {
+		o.applyModel()
+	}
+}
+
+// SetToolSubset restricts the tools sent with the next requests, nil sends all enabled tools
+func (o *OpenAI) SetToolSubset(toolNames []string) {
+	o.tools = loadOpenAITools(activeTools(o.Config.EnabledTools, toolNames), o.strictTools())
+	if endpoint, ok := o.Config.activeEndpoint(); ok && endpoint.Tools != nil && !*endpoint.Tools {
+		o.tools = nil
+	}
+}
+
 func (o *OpenAI) GetModel() string {
 	return o.Config.Model
 }
 
+// RestoreUsage adds the tokens used by a previous session to the session totals
+func (o *OpenAI) RestoreUsage(input, cachedInput, output int, cost float64) {
+	o.TotalInputTokens += input
+	o.CachedInputTokens += cachedInput
+	o.TotalOutputTokens += output
+	o.restored.input += input
+	o.restored.cachedInput += cachedInput
+	o.restored.output += output
+	o.restored.cost += cost
+}
+
+// History returns the conversation in the format shared by all providers,
+// tool calls and their results as tool_use and tool_result blocks
+func (o *OpenAI) History() []Message {
+	var messages []Message
+	for _, msg := range o.conversationHistory {
+		switch {
+		case msg.Role == "system":
+			continue
+		case msg.Role == "tool":
+			messages = append(messages, Message{Role: "user", Content: []ContentBlock{
+				{Type: "tool_result", ToolUseID: msg.ToolCallID, Content: msg.Content},
+			}})
+		case len(msg.ToolCalls) > 0:
+			var blocks []ContentBlock
+			if msg.Content != "" {
+				blocks = append(blocks, ContentBlock{Type: "text", Text: msg.Content})
+			}
+			for _, call := range msg.ToolCalls {
+				blocks = append(blocks, ContentBlock{Type: "tool_use", ID: call.ID, Name: call.Function.Name, Input: toolInputJSON(call.Function.Arguments)})
+			}
+			messages = append(messages, Message{Role: msg.Role, Content: blocks})
+		case msg.Content != "":
+			messages = append(messages, Message{Role: msg.Role, Content: msg.Content})
+		}
+	}
+	return messages
+}
+
+// SetHistory replaces the conversation with one in the shared format,
+// keeping the system prompt
+func (o *OpenAI) SetHistory(messages []Message) {
+	history := []openaiMessage{}
+	if len(o.conversationHistory) > 0 && o.conversationHistory[0].Role == "system" {
+		history = append(history, o.conversationHistory[0])
+	}
+	for _, msg := range messages {
+		if content, ok := msg.Content.(string); ok {
+			history = append(history, openaiMessage{Role: msg.Role, Content: content, Type: "text"})
+			continue
+		}
+		blocks, _ := msg.Content.([]ContentBlock)
+		message := openaiMessage{Role: msg.Role, Type: "text"}
+		for _, block := range blocks {
+			switch block.Type {
+			case "text":
+				message.Content += block.Text
+			case "tool_use":
+				arguments, _ := json.Marshal(string(block.Input))
+				message.ToolCalls = append(message.ToolCalls, openaiToolCall{
+					ID:       block.ID,
+					Type:     "function",
+					Function: openaiFunction{Name: block.Name, Arguments: arguments},
+				})
+			case "tool_result":
+				history = append(history, openaiMessage{Role: "tool", ToolCallID: block.ToolUseID, Content: block.Content, Type: "tool_result"})
+			}
+		}
+		if message.Content != "" || len(message.ToolCalls) > 0 {
+			history = append(history, message)
+		}
+	}
+	o.conversationHistory = history
+	o.chain = responsesChain{}
+	o.contextCount = contextCount{}
+}
+
+// ProviderInfo describes the provider, its model and the tokens used so far
+func (o *OpenAI) ProviderInfo() ProviderInfo {
+	provider := "openai"
+	if o.Config.Provider != "" {
+		provider = o.Config.Provider
+	}
+	return ProviderInfo{
+		Provider:          provider,
+		Model:             o.Config.Model,
+		ContextWindow:     o.ContextWindowSize,
+		Price:             ModelPrice{Input: o.InputPricePerMillion, CachedInput: o.CachedInputPricePerMillion, Output: o.OutputPricePerMillion},
+		Priced:            !o.unpriced,
+		InputTokens:       o.InputTokens,
+		OutputTokens:      o.OutputTokens,
+		ContextTokens:     o.contextCount.tokens,
+		TotalInputTokens:  o.TotalInputTokens,
+		CachedInputTokens: o.CachedInputTokens,
+		TotalOutputTokens: o.TotalOutputTokens,
+	}
+}
+
 // NewOpenAI creates a new OpenAI provider
 func NewOpenAI(config Config) *OpenAI {
 	conversationHistory := []openaiMessage{
@@ -511,18 +901,51 @@ func NewOpenAI(config Config) *OpenAI {
 		},
 	}
 
-	tools := loadOpenAITools()
+	o := &OpenAI{
+		Config:              config,
+		InputTokens:         0,
+		OutputTokens:        0,
+		conversationHistory: conversationHistory,
+	}
+	o.SetToolSubset(nil)
+	o.applyModel()
+	return o
+}
 
-	return &OpenAI{
-		Config:                     config,
-		InputTokens:                0,
-		OutputTokens:               0,
-		InputPricePerMillion:       2,
-		CachedInputPricePerMillion: 0.5,
-		OutputPricePerMillion:      8,
-		ContextWindowSize:          200_000,
-		conversationHistory:        conversationHistory,
-		tools:                      tools,
-		MaxTokens:                  20_000,
+// applyModel sets the prices and limits of the model of the configuration
+func (o *OpenAI) applyModel() {
+	config := o.Config
+	// Models missing from the registry are priced as GPT-4.1
+	price, ok := modelPrice(config, config.Model)
+	if !ok {
+		price = ModelPrice{Input: 2, CachedInput: 0.5, Output: 8}
+	}
+	o.InputPricePerMillion, o.CachedInputPricePerMillion, o.OutputPricePerMillion = price.Input, price.CachedInput, price.Output
+	o.ContextWindowSize, o.MaxTokens = contextLimits(config, config.Model)
+	o.unpriced = false
+
+	if config.Provider == providerOpenRouter {
+		// OpenRouter reports the cost of each response
+		o.InputPricePerMillion, o.CachedInputPricePerMillion, o.OutputPricePerMillion = 0, 0, 0
+	}
+	if config.Provider == providerDeepSeek {
+		o.unpriced = !ok
+	}
+	if config.Provider == providerOpenAICompatible {
+		price, ok := lookupPrice(config.Prices, config.Model)
+		endpoint, _ := config.activeEndpoint()
+		if endpoint.Price != nil {
+			price, ok = *endpoint.Price, true
+		}
+		o.InputPricePerMillion, o.CachedInputPricePerMillion, o.OutputPricePerMillion = price.Input, price.CachedInput, price.Output
+		o.unpriced = !ok
+
+		if endpoint.ContextWindow > 0 {
+			o.ContextWindowSize = endpoint.ContextWindow
+			o.MaxTokens = min(o.MaxTokens, endpoint.ContextWindow/4)
+		}
+		if endpoint.MaxTokens > 0 {
+			o.MaxTokens = endpoint.MaxTokens
+		}
 	}
 }
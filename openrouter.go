@@ -0,0 +1,72 @@
+package main
+
+// providerOpenRouter routes the requests through OpenRouter, which serves
+// the models of many providers with the OpenAI API and reports their cost
+const providerOpenRouter = "openrouter"
+
+// openRouterURL is the base URL of the OpenRouter API
+const openRouterURL = "https://openrouter.ai/api"
+
+// OpenRouterConfig configures the requests sent to OpenRouter
+type OpenRouterConfig struct {
+	Referer  string                 `yaml:"referer"` // Sent as HTTP-Referer, identifies the app on openrouter.ai
+	Title    string                 `yaml:"title"`   // Sent as X-Title, the name of the app on openrouter.ai
+	Provider *OpenRouterPreferences `yaml:"provider"`
+}
+
+// OpenRouterPreferences choose the upstream providers OpenRouter routes the
+// requests to, see https://openrouter.ai/docs/features/provider-routing
+type OpenRouterPreferences struct {
+	Order             []string            `yaml:"order" json:"order,omitempty"`   // Providers tried first, in order
+	Only              []string            `yaml:"only" json:"only,omitempty"`     // Providers allowed
+	Ignore            []string            `yaml:"ignore" json:"ignore,omitempty"` // Providers never used
+	AllowFallbacks    *bool               `yaml:"allow_fallbacks" json:"allow_fallbacks,omitempty"`
+	RequireParameters bool                `yaml:"require_parameters" json:"require_parameters,omitempty"` // Only providers supporting all the parameters, such as tools
+	DataCollection    string              `yaml:"data_collection" json:"data_collection,omitempty"`       // allow or deny providers storing the data
+	Quantizations     []string            `yaml:"quantizations" json:"quantizations,omitempty"`
+	Sort              string              `yaml:"sort" json:"sort,omitempty"` // price, throughput or latency
+	MaxPrice          *openRouterMaxPrice `yaml:"max_price" json:"max_price,omitempty"`
+}
+
+// openRouterMaxPrice is the highest price accepted, in dollars per million tokens
+type openRouterMaxPrice struct {
+	Prompt     float64 `yaml:"prompt" json:"prompt,omitempty"`
+	Completion float64 `yaml:"completion" json:"completion,omitempty"`
+}
+
+// openRouterUsage asks OpenRouter to report the cost of the request in its usage
+type openRouterUsage struct {
+	Include bool `json:"include"`
+}
+
+// applyOpenRouter sets the defaults of the OpenRouter provider, whose models
+// are named after their provider such as anthropic/claude-sonnet-4
+func applyOpenRouter(config *Config) {
+	if config.Provider != providerOpenRouter {
+		return
+	}
+	if config.BaseUrl == "" {
+		config.BaseUrl = openRouterURL
+	}
+	if config.Model == "" {
+		config.Model = "anthropic/claude-sonnet-4"
+	}
+	if config.CheapModel == "" {
+		config.CheapModel = "openai/gpt-4.1-nano"
+	}
+	if config.OpenRouter.Referer == "" {
+		config.OpenRouter.Referer = "https://github.com/paul-nameless/aicode"
+	}
+	if config.OpenRouter.Title == "" {
+		config.OpenRouter.Title = "AiCode"
+	}
+}
+
+// setOpenRouterOptions adds the routing preferences and the cost reporting to a request
+func (o *OpenAI) setOpenRouterOptions(request *openaiRequest) {
+	if o.Config.Provider != providerOpenRouter {
+		return
+	}
+	request.Provider = o.Config.OpenRouter.Provider
+	request.Usage = &openRouterUsage{Include: true}
+}
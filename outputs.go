@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Message carrying the full output of a finished tool call
+type toolResultMsg struct {
+	output  string
+	grouped bool // The tool was shown as a group of its invocations as it ran, such as a Batch
+}
+
+// Message sent when the external editor or pager is closed
+type editorClosedMsg struct {
+	err error
+}
+
+// addToolOutput stores the full tool output and displays its first lines
+// along with a hint on how to open the rest
+func (m *chatModel) addToolOutput(output string) {
+	m.toolOutputs = append(m.toolOutputs, output)
+	index := len(m.toolOutputs)
+
+	chunks := chunkOutput(output, 4)
+	if len(chunks) > 1 {
+		chunks[len(chunks)-1] += fmt.Sprintf(" (/open %d for full output)", index)
+	}
+	m.outputs = append(m.outputs, chunks...)
+}
+
+// commandArgs returns the arguments typed after the slash command
+func (m *chatModel) commandArgs() []string {
+	fields := strings.Fields(m.textarea.Value())
+	if len(fields) < 2 {
+		return nil
+	}
+	return fields[1:]
+}
+
+func openOutputHandler(m *chatModel) error {
+	args := m.commandArgs()
+	if len(args) != 1 {
+		return fmt.Errorf("usage: /open N")
+	}
+	index, err := strconv.Atoi(args[0])
+	if err != nil || index < 1 || index > len(m.toolOutputs) {
+		return fmt.Errorf("no tool output #%s, there are %d", args[0], len(m.toolOutputs))
+	}
+
+	file, err := os.CreateTemp("", fmt.Sprintf("aicode-output-%d-*.txt", index))
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %v", err)
+	}
+	defer file.Close()
+	if _, err := file.WriteString(m.toolOutputs[index-1]); err != nil {
+		return fmt.Errorf("failed to write output: %v", err)
+	}
+
+	m.afterCmd = openInEditor(file.Name())
+	return nil
+}
+
+// openInEditor suspends the UI and opens path in $EDITOR, falling back to $PAGER and less
+func openInEditor(path string) tea.Cmd {
+	program := os.Getenv("EDITOR")
+	if program == "" {
+		program = os.Getenv("PAGER")
+	}
+	if program == "" {
+		program = "less"
+	}
+
+	// The variables may contain arguments, e.g. EDITOR="code --wait"
+	args := append(strings.Fields(program), path)
+	cmd := exec.Command(args[0], args[1:]...)
+	return tea.ExecProcess(cmd, func(err error) tea.Msg {
+		return editorClosedMsg{err: err}
+	})
+}
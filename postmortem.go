@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"slices"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// maxPostmortemTranscript is the number of trailing transcript characters
+// sent to the cheap model along with the failures of the session
+const maxPostmortemTranscript = 12000
+
+// maxPostmortemFailures bounds the failed tool calls and commands listed,
+// keeping the most recent
+const maxPostmortemFailures = 30
+
+// lessonsHeading is the section of the project memory lessons are appended to
+const lessonsHeading = "## Lessons learned"
+
+// Message carrying the result of /postmortem
+type postmortemMsg struct {
+	path    string
+	lessons string
+	err     error
+}
+
+// postmortemHandler asks the cheap model what went wrong in the session and
+// appends the lessons to the project memory, read by the next sessions
+func postmortemHandler(m *chatModel) error {
+	if len(m.llm.UserMessages()) == 0 {
+		return fmt.Errorf("nothing to analyze yet")
+	}
+
+	m.outputs = append(m.outputs, "Looking for the lessons of the failed attempts...")
+	llm, config := m.llm, m.config
+	m.afterCmd = func() tea.Msg {
+		lessons, err := sessionLessons(llm, config)
+		if err != nil || lessons == "" {
+			return postmortemMsg{err: err}
+		}
+		path, err := appendLessons(memoryFile(config), lessons)
+		return postmortemMsg{path: path, lessons: lessons, err: err}
+	}
+	return nil
+}
+
+// handlePostmortem reports the result of /postmortem and gives the lessons
+// to the model of the session as well
+func (m *chatModel) handlePostmortem(msg postmortemMsg) {
+	switch {
+	case msg.err != nil:
+		m.outputs = append(m.outputs, fmt.Sprintf("Failed to write the post-mortem: %v", msg.err))
+	case msg.lessons == "":
+		m.outputs = append(m.outputs, "No failed attempt worth a lesson in this session")
+	default:
+		m.outputs = append(m.outputs, "Lessons appended to "+msg.path+":", msg.lessons)
+		m.llm.RefreshSystemPrompt()
+	}
+}
+
+// sessionLessons returns the lessons of the failures of the session as a
+// markdown list, empty when the model found none
+func sessionLessons(llm Llm, config Config) (string, error) {
+	report := failureReport() + "### Conversation\n\n" + conversationTranscript(llm, maxPostmortemTranscript)
+	answer, err := quickCompletion(context.Background(), config, postmortemPrompt, report)
+	if err != nil {
+		return "", err
+	}
+
+	var lessons []string
+	for _, line := range strings.Split(answer, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "- ") {
+			lessons = append(lessons, line)
+		}
+	}
+	return strings.Join(lessons, "\n"), nil
+}
+
+// failureReport lists the evidence of failed attempts in the session: tool
+// calls answered with an error, failed commands, files changed in several
+// turns and the notes of the user
+func failureReport() string {
+	var b strings.Builder
+
+	failed := GlobalSessionActivity.FailedTools()
+	if len(failed) > 0 {
+		b.WriteString("### Failed tool calls\n\n")
+		b.WriteString(strings.Join(failed[max(len(failed)-maxPostmortemFailures, 0):], "\n") + "\n\n")
+	}
+
+	var commands []string
+	for _, command := range GlobalSessionActivity.Commands() {
+		if command.Failed {
+			commands = append(commands, "- `"+strings.Join(strings.Fields(command.Command), " ")+"`")
+		}
+	}
+	if len(commands) > 0 {
+		b.WriteString("### Failed commands\n\n")
+		b.WriteString(strings.Join(commands[max(len(commands)-maxPostmortemFailures, 0):], "\n") + "\n\n")
+	}
+
+	turns := map[string][]int{}
+	var paths []string
+	for _, change := range GlobalChangeLedger.Changes() {
+		path := change.relativePath()
+		if _, ok := turns[path]; !ok {
+			paths = append(paths, path)
+		}
+		if !slices.Contains(turns[path], change.Turn) {
+			turns[path] = append(turns[path], change.Turn)
+		}
+	}
+	var reworked []string
+	for _, path := range paths {
+		if len(turns[path]) > 1 {
+			reworked = append(reworked, fmt.Sprintf("- %s: turns %s", path, strings.Trim(fmt.Sprint(turns[path]), "[]")))
+		}
+	}
+	if len(reworked) > 0 {
+		b.WriteString("### Files changed in several turns\n\n")
+		b.WriteString(strings.Join(reworked, "\n") + "\n\n")
+	}
+
+	if notes := sessionNotes(); len(notes) > 0 {
+		b.WriteString("### Notes of the user\n\n" + notesMarkdown(notes) + "\n")
+	}
+	return b.String()
+}
+
+// describeToolCall names a tool call by its tool and main argument
+func describeToolCall(name string, input json.RawMessage) string {
+	var params map[string]any
+	json.Unmarshal(input, &params)
+	for _, key := range []string{"file_path", "command", "pattern", "path", "url"} {
+		if value, ok := params[key].(string); ok && value != "" {
+			value = strings.Join(strings.Fields(value), " ")
+			if len(value) > 120 {
+				value = value[:120] + "..."
+			}
+			return name + " " + value
+		}
+	}
+	return name
+}
+
+// memoryFile returns the rule file lessons are written to: the first of
+// system_files that exists, or else the first to be created
+func memoryFile(config Config) string {
+	for _, file := range config.SystemFiles {
+		if _, err := os.Stat(file); err == nil {
+			return file
+		}
+	}
+	if len(config.SystemFiles) > 0 {
+		return config.SystemFiles[0]
+	}
+	return "AI.md"
+}
+
+// appendLessons adds lessons at the end of the lessons section of the rule
+// file at path, creating the section or the file when missing
+func appendLessons(path, lessons string) (string, error) {
+	data, err := os.ReadFile(path)
+	existed := err == nil
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+
+	content := string(data)
+	start := strings.Index(content, lessonsHeading+"\n")
+	if start < 0 {
+		if content != "" {
+			content = strings.TrimRight(content, "\n") + "\n\n"
+		}
+		content += lessonsHeading + "\n\n" + lessons + "\n"
+	} else {
+		// The section ends at the next heading of the same level
+		end := len(content)
+		if next := strings.Index(content[start+len(lessonsHeading):], "\n## "); next >= 0 {
+			end = start + len(lessonsHeading) + next + 1
+		}
+		section := strings.TrimRight(content[:end], "\n") + "\n" + lessons + "\n"
+		if end < len(content) {
+			section += "\n"
+		}
+		content = section + content[end:]
+	}
+
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		return "", err
+	}
+	GlobalFileTracker.RecordWrite(absPath(path), []byte(content))
+	GlobalChangeLedger.RecordWrite(path, existed, "/postmortem")
+	return path, nil
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"io/fs"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	maxPrefetchFiles   = 8          // Related files read ahead after a View
+	maxPrefetchSize    = 256 * 1024 // Larger files are not read ahead
+	maxPrefetchEntries = 200        // Files kept in the cache
+	maxGoPackageFiles  = 4          // Files read ahead for each imported Go package
+)
+
+// prefetchEntry is the content of a file with the stat it was read with
+type prefetchEntry struct {
+	content []byte
+	size    int64
+	modTime time.Time
+}
+
+// prefetchCache holds the files read ahead after a View, so the test file
+// and the imports the model usually views next are served without touching
+// a slow disk or the SSH connection again
+type prefetchCache struct {
+	mu      sync.Mutex
+	entries map[string]prefetchEntry
+	order   []string // Keys in insertion order, the oldest is evicted first
+}
+
+// GlobalPrefetch is the application-wide read-ahead cache
+var GlobalPrefetch = &prefetchCache{entries: map[string]prefetchEntry{}}
+
+// Read returns the content of a file of the workspace, from the cache when
+// the file has the same size and modification time as when it was read ahead
+func (c *prefetchCache) Read(path string, info fs.FileInfo) ([]byte, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[absPath(path)]
+	c.mu.Unlock()
+	if ok && entry.size == info.Size() && entry.modTime.Equal(info.ModTime()) {
+		return entry.content, nil
+	}
+	return workspaceReadFile(path)
+}
+
+// Clear forgets all the files, after a tool that may have changed them
+// within the resolution of their modification time
+func (c *prefetchCache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = map[string]prefetchEntry{}
+	c.order = nil
+}
+
+// Prefetch reads ahead the files related to a viewed file in the background
+func (c *prefetchCache) Prefetch(path string, content []byte) {
+	go func() {
+		loaded := 0
+		for _, file := range relatedFiles(path, content) {
+			if loaded == maxPrefetchFiles {
+				return
+			}
+			if c.load(file) {
+				loaded++
+			}
+		}
+	}()
+}
+
+// load reads a file into the cache unless it is already there, and tells
+// whether the file is cached
+func (c *prefetchCache) load(path string) bool {
+	key := absPath(path)
+	c.mu.Lock()
+	_, cached := c.entries[key]
+	c.mu.Unlock()
+	if cached {
+		return true
+	}
+
+	// Stat before reading so a concurrent change leaves an outdated entry
+	// that Read rejects rather than a fresh-looking stale one
+	info, err := workspaceStat(path)
+	if err != nil || info.IsDir() || info.Size() > maxPrefetchSize {
+		return false
+	}
+	content, err := workspaceReadFile(path)
+	if err != nil {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; !ok {
+		c.order = append(c.order, key)
+	}
+	c.entries[key] = prefetchEntry{content: content, size: info.Size(), modTime: info.ModTime()}
+	for len(c.order) > maxPrefetchEntries {
+		delete(c.entries, c.order[0])
+		c.order = c.order[1:]
+	}
+	return true
+}
+
+var (
+	goImport     = regexp.MustCompile(`(?m)^\s*(?:import\s+)?(?:[\w.]+\s+)?"([^"]+)"\s*$`)
+	goModule     = regexp.MustCompile(`(?m)^module\s+(\S+)`)
+	pythonImport = regexp.MustCompile(`(?m)^\s*(?:from\s+(\.*)([\w.]*)\s+import|import\s+([\w.]+))`)
+	jsImport     = regexp.MustCompile(`(?:\bfrom|\bimport|\brequire\()\s*['"](\.{1,2}/[^'"]+)['"]`)
+	rustModule   = regexp.MustCompile(`(?m)^\s*(?:pub(?:\([\w:]+\))?\s+)?mod\s+(\w+)\s*;`)
+	cInclude     = regexp.MustCompile(`(?m)^\s*#\s*include\s+"([^"]+)"`)
+)
+
+// jsExtensions are tried in order to resolve extensionless JavaScript and TypeScript imports
+var jsExtensions = []string{".ts", ".tsx", ".js", ".jsx", ".mjs"}
+
+// relatedFiles lists the files the model is likely to view after path: its
+// test file or the file it tests first, then the files it imports
+func relatedFiles(path string, content []byte) []string {
+	dir := filepath.Dir(path)
+	base := filepath.Base(path)
+	ext := filepath.Ext(base)
+	stem := strings.TrimSuffix(base, ext)
+
+	var files []string
+	switch ext {
+	case ".go":
+		if strings.HasSuffix(stem, "_test") {
+			files = append(files, filepath.Join(dir, strings.TrimSuffix(stem, "_test")+ext))
+		} else {
+			files = append(files, filepath.Join(dir, stem+"_test"+ext))
+		}
+		files = append(files, goImportedFiles(content)...)
+	case ".py":
+		if tested, ok := strings.CutPrefix(stem, "test_"); ok {
+			files = append(files, filepath.Join(dir, tested+ext), filepath.Join(filepath.Dir(dir), tested+ext))
+		} else {
+			files = append(files, filepath.Join(dir, "test_"+stem+ext), filepath.Join(dir, "tests", "test_"+stem+ext))
+		}
+		for _, match := range pythonImport.FindAllStringSubmatch(string(content), -1) {
+			files = append(files, pythonModuleFiles(dir, match[1], match[2]+match[3])...)
+		}
+	case ".js", ".jsx", ".ts", ".tsx", ".mjs":
+		tested := strings.TrimSuffix(strings.TrimSuffix(stem, ".test"), ".spec")
+		if tested != stem {
+			files = append(files, filepath.Join(dir, tested+ext))
+		} else {
+			files = append(files, filepath.Join(dir, stem+".test"+ext), filepath.Join(dir, stem+".spec"+ext), filepath.Join(dir, "__tests__", stem+".test"+ext))
+		}
+		for _, match := range jsImport.FindAllStringSubmatch(string(content), -1) {
+			target := filepath.Join(dir, match[1])
+			if filepath.Ext(target) != "" {
+				files = append(files, target)
+				continue
+			}
+			for _, jsExt := range jsExtensions {
+				files = append(files, target+jsExt)
+			}
+			files = append(files, filepath.Join(target, "index.ts"), filepath.Join(target, "index.js"))
+		}
+	case ".rs":
+		// Submodules of foo.rs live in foo/, those of mod.rs, lib.rs and main.rs next to them
+		modDir := dir
+		if stem != "mod" && stem != "lib" && stem != "main" {
+			modDir = filepath.Join(dir, stem)
+		}
+		for _, match := range rustModule.FindAllStringSubmatch(string(content), -1) {
+			files = append(files, filepath.Join(modDir, match[1]+".rs"), filepath.Join(modDir, match[1], "mod.rs"))
+		}
+	case ".c", ".cc", ".cpp", ".h", ".hpp":
+		for _, match := range cInclude.FindAllStringSubmatch(string(content), -1) {
+			files = append(files, filepath.Join(dir, match[1]))
+		}
+	}
+
+	// Candidates that don't exist are skipped when loaded
+	var related []string
+	seen := map[string]bool{path: true}
+	for _, file := range files {
+		if !seen[file] {
+			seen[file] = true
+			related = append(related, file)
+		}
+	}
+	return related
+}
+
+// goImportedFiles lists the files of the packages of the module imported by a Go file
+func goImportedFiles(content []byte) []string {
+	text := string(content)
+	// Only the import declarations, which come before the first function
+	if end := strings.Index(text, "\nfunc "); end >= 0 {
+		text = text[:end]
+	}
+	imports := goImport.FindAllStringSubmatch(text, -1)
+	if len(imports) == 0 {
+		return nil
+	}
+	goMod, err := workspaceReadFile("go.mod")
+	if err != nil {
+		return nil
+	}
+	module := goModule.FindSubmatch(goMod)
+	if module == nil {
+		return nil
+	}
+
+	var files []string
+	for _, match := range imports {
+		pkg, ok := strings.CutPrefix(match[1], string(module[1])+"/")
+		if !ok {
+			continue
+		}
+		names, err := workspaceReadDir(pkg)
+		if err != nil {
+			continue
+		}
+		n := 0
+		for _, name := range names {
+			if strings.HasSuffix(name, ".go") && !strings.HasSuffix(name, "_test.go") && n < maxGoPackageFiles {
+				files = append(files, filepath.Join(pkg, name))
+				n++
+			}
+		}
+	}
+	return files
+}
+
+// pythonModuleFiles lists the files that may hold a Python module, imported
+// relatively with leading dots or absolutely from the project root
+func pythonModuleFiles(dir, dots, module string) []string {
+	root := "."
+	if dots != "" {
+		root = dir
+		for range len(dots) - 1 {
+			root = filepath.Dir(root)
+		}
+	}
+	if module == "" {
+		return nil
+	}
+	path := filepath.Join(root, strings.ReplaceAll(module, ".", string(filepath.Separator)))
+	return []string{path + ".py", filepath.Join(path, "__init__.py")}
+}
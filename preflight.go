@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+// imageTokenEstimate is the approximate cost of an attached image, which is
+// billed by its dimensions rather than by the size of its base64 encoding
+const imageTokenEstimate = 1600
+
+// base64Run matches inline base64 payloads such as attached images
+var base64Run = regexp.MustCompile(`[A-Za-z0-9+/]{1000,}={0,2}`)
+
+// contextOverflowError is returned when a request cannot fit in the model's context window
+type contextOverflowError struct {
+	Model         string
+	Estimated     int
+	ContextWindow int
+	MaxTokens     int
+}
+
+func (e *contextOverflowError) Error() string {
+	return fmt.Sprintf("request of ~%d tokens does not fit in the %d token context window of %s (with %d tokens reserved for the response); clear the conversation or shorten the last message",
+		e.Estimated, e.ContextWindow, e.Model, e.MaxTokens)
+}
+
+// estimateRequestTokens approximates the token count of a serialized request
+// at roughly 4 characters per token, counting inline images at a flat rate
+func estimateRequestTokens(body []byte) int {
+	chars := len(body)
+	images := 0
+	for _, loc := range base64Run.FindAllIndex(body, -1) {
+		chars -= loc[1] - loc[0]
+		images++
+	}
+	return chars/4 + images*imageTokenEstimate
+}
+
+// estimateTextTokens approximates the token count of plain text such as a
+// tool result at roughly 4 characters per token. Base64 in text is billed as
+// text, unlike the inline images estimateRequestTokens counts at a flat rate.
+func estimateTextTokens(text string) int {
+	return len(text) / 4
+}
+
+// checkContextFits verifies that a request leaves room for the response
+// before it is uploaded to the provider
+func checkContextFits(body []byte, model string, contextWindow, maxTokens int) error {
+	if contextWindow <= 0 {
+		return nil
+	}
+	estimated := estimateRequestTokens(body)
+	if estimated+maxTokens <= contextWindow {
+		return nil
+	}
+	return &contextOverflowError{Model: model, Estimated: estimated, ContextWindow: contextWindow, MaxTokens: maxTokens}
+}
+
+// Request size limits of the providers, a request above them is refused
+// before the model sees it. Unknown providers get the smaller limit.
+const (
+	anthropicMaxRequestBytes = 32 << 20
+	openaiMaxRequestBytes    = 50 << 20
+)
+
+// maxRequestBytes returns the largest serialized request the provider of
+// the model accepts, max_request_bytes in the profile taking precedence
+func maxRequestBytes(model string, config Config) int {
+	switch {
+	case config.MaxRequestBytes > 0:
+		return config.MaxRequestBytes
+	case config.Provider == "" && !usesClaudeAPI(model, config):
+		return openaiMaxRequestBytes
+	default:
+		return anthropicMaxRequestBytes
+	}
+}
+
+// requestTooLargeError is returned when a request exceeds the size limit of
+// the provider and no tool result is left to prune
+type requestTooLargeError struct {
+	Bytes int
+	Limit int
+}
+
+func (e *requestTooLargeError) Error() string {
+	return fmt.Sprintf("request of %s exceeds the %s request size limit of the provider even with the tool results pruned; clear the conversation or remove attached images",
+		formatBytes(e.Bytes), formatBytes(e.Limit))
+}
+
+// toolResultRef points at the content of a tool result in the conversation history
+type toolResultRef struct {
+	tool    string
+	id      string
+	content *string
+}
+
+// prunedToolResultPlaceholder replaces the content of a pruned tool result
+const prunedToolResultPlaceholder = "[Tool result of %s pruned to fit the request size limit of the provider, run the tool again if it is still needed]"
+
+// pruneLargestToolResults replaces the largest tool results with a
+// placeholder until excess bytes are saved. It returns a description of
+// each pruned result, none when nothing was left to prune.
+func pruneLargestToolResults(results []toolResultRef, excess int) []string {
+	sort.SliceStable(results, func(i, j int) bool { return len(*results[i].content) > len(*results[j].content) })
+
+	var pruned []string
+	for _, result := range results {
+		if excess <= 0 {
+			break
+		}
+		size := len(*result.content)
+		placeholder := fmt.Sprintf(prunedToolResultPlaceholder, formatBytes(size))
+		if size <= len(placeholder) {
+			break
+		}
+		*result.content = placeholder
+		excess -= size - len(placeholder)
+		pruned = append(pruned, fmt.Sprintf("%s result %s (%s)", result.tool, result.id, formatBytes(size)))
+	}
+	return pruned
+}
+
+// formatBytes renders a byte count in KB or MB
+func formatBytes(n int) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+	default:
+		return fmt.Sprintf("%d bytes", n)
+	}
+}
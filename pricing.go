@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+)
+
+// ModelPrice is the price of a model in dollars per million tokens
+type ModelPrice struct {
+	Input       float64 `yaml:"input"`
+	CachedInput float64 `yaml:"cached_input"`
+	Output      float64 `yaml:"output"`
+}
+
+// Cost returns the price of the given token counts, cachedInput being part of input
+func (p ModelPrice) Cost(input, cachedInput, output int) float64 {
+	return (float64(input-cachedInput)*p.Input + float64(cachedInput)*p.CachedInput + float64(output)*p.Output) / 1000000.0
+}
+
+// ModelPrices maps models, or prefixes of model names, to their price
+type ModelPrices map[string]ModelPrice
+
+// modelPrice returns the price of a model from the profile or the model
+// registry, telling whether any price is known
+func modelPrice(config Config, model string) (ModelPrice, bool) {
+	if price, ok := lookupPrice(config.Prices, model); ok {
+		return price, true
+	}
+	price := modelInfo(config, model).ModelPrice
+	return price, price != (ModelPrice{})
+}
+
+// lookupPrice returns the price of a model from prices, using the longest matching prefix
+func lookupPrice(prices ModelPrices, model string) (ModelPrice, bool) {
+	return lookupByPrefix(prices, model)
+}
+
+// lookupByPrefix returns the entry of a model, using the longest matching prefix
+func lookupByPrefix[V any](entries map[string]V, model string) (V, bool) {
+	if entry, ok := entries[model]; ok {
+		return entry, true
+	}
+	best := ""
+	for prefix := range entries {
+		if strings.HasPrefix(model, prefix) && len(prefix) > len(best) {
+			best = prefix
+		}
+	}
+	if best != "" {
+		return entries[best], true
+	}
+	var zero V
+	return zero, false
+}
+
+// configuredModels lists the models of the profiles in ~/.config/aicode and
+// of the current profile, with where they are configured
+func configuredModels(config Config) map[string]string {
+	models := map[string]string{}
+	add := func(model, source string) {
+		if _, ok := models[model]; model != "" && !ok {
+			models[model] = source
+		}
+	}
+
+	add(config.CheapModel, "cheap_model")
+	for model := range config.Prices {
+		add(model, "prices")
+	}
+	for model, info := range config.Models {
+		if info.ModelPrice != (ModelPrice{}) {
+			add(model, "models")
+		}
+	}
+	paths, _ := filepath.Glob(filepath.Join(expandHomeDir("~/.config/aicode"), "*.yml"))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var profile Config
+		if err := yaml.Unmarshal(data, &profile); err != nil {
+			continue
+		}
+		name := strings.TrimSuffix(filepath.Base(path), ".yml")
+		add(profile.Model, "profile "+name)
+		add(profile.CheapModel, "profile "+name)
+	}
+	return models
+}
+
+// costCompareHandler implements /cost compare: it recomputes the tokens of
+// the session with the prices of other models
+func costCompareHandler(m *chatModel, models []string) error {
+	info := m.llm.ProviderInfo()
+	input, cached, output := info.TotalInputTokens, info.CachedInputTokens, info.TotalOutputTokens
+	current := m.llm.CalculatePrice()
+	currentModel := info.Model
+
+	sources := map[string]string{}
+	for _, model := range models {
+		sources[model] = "requested"
+	}
+	if len(models) == 0 {
+		sources = configuredModels(m.config)
+		delete(sources, currentModel)
+		// Without other configured models, compare with all the known ones
+		if len(sources) == 0 {
+			for model := range defaultModels {
+				sources[model] = "list price"
+			}
+		}
+	}
+	delete(sources, currentModel)
+
+	type row struct {
+		model, source string
+		cost          float64
+		known         bool
+	}
+	var rows []row
+	for model, source := range sources {
+		price, ok := modelPrice(m.config, model)
+		rows = append(rows, row{model: model, source: source, cost: price.Cost(input, cached, output), known: ok})
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].known != rows[j].known {
+			return rows[i].known
+		}
+		if rows[i].cost != rows[j].cost {
+			return rows[i].cost < rows[j].cost
+		}
+		return rows[i].model < rows[j].model
+	})
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Session tokens: %s input (%s cached), %s output\n",
+		formatTokenCount(input), formatTokenCount(cached), formatTokenCount(output))
+	fmt.Fprintf(&b, "  %-28s %9s  (current)\n", currentModel, m.config.Currency.Format(current))
+	for _, r := range rows {
+		if !r.known {
+			fmt.Fprintf(&b, "  %-28s %9s  no price known, add it to prices in the profile (%s)\n", r.model, "-", r.source)
+			continue
+		}
+		change := ""
+		if current > 0 {
+			change = fmt.Sprintf("%+.0f%%", (r.cost-current)/current*100)
+		}
+		fmt.Fprintf(&b, "  %-28s %9s  %6s  (%s)\n", r.model, m.config.Currency.Format(r.cost), change, r.source)
+	}
+	b.WriteString("Token counts differ between providers' tokenizers, so costs for other providers are estimates.")
+	m.outputs = append(m.outputs, b.String())
+	return nil
+}
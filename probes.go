@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// projectProbe extracts facts from a manifest file of the project
+type projectProbe struct {
+	File  string
+	Probe func(content string) []string
+}
+
+// projectProbes are run against the working directory when the session starts
+var projectProbes = []projectProbe{
+	{"go.mod", probeGoMod},
+	{"package.json", probePackageJSON},
+	{"pyproject.toml", probePyproject},
+	{"Cargo.toml", probeCargo},
+	{"Makefile", probeMakefile},
+}
+
+// maxProbeItems bounds the number of scripts or targets listed per file
+const maxProbeItems = 15
+
+// projectFacts returns a compact list of facts about the project, one line
+// per fact, or an empty string when no manifest was found
+func projectFacts() string {
+	var b strings.Builder
+	for _, probe := range projectProbes {
+		content, err := workspaceReadFile(probe.File)
+		if err != nil {
+			continue
+		}
+		for _, fact := range probe.Probe(string(content)) {
+			b.WriteString("- " + probe.File + ": " + fact + "\n")
+		}
+	}
+	return b.String()
+}
+
+// joinLimited joins items, cutting the list at maxProbeItems
+func joinLimited(items []string) string {
+	if len(items) > maxProbeItems {
+		return strings.Join(items[:maxProbeItems], ", ") + ", ..."
+	}
+	return strings.Join(items, ", ")
+}
+
+func probeGoMod(content string) []string {
+	var facts []string
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		switch fields[0] {
+		case "module":
+			facts = append(facts, "module "+fields[1])
+		case "go":
+			facts = append(facts, "Go version "+fields[1])
+		case "toolchain":
+			facts = append(facts, "toolchain "+fields[1])
+		}
+	}
+	return facts
+}
+
+func probePackageJSON(content string) []string {
+	var pkg struct {
+		Name           string            `json:"name"`
+		Type           string            `json:"type"`
+		PackageManager string            `json:"packageManager"`
+		Scripts        map[string]string `json:"scripts"`
+		Workspaces     json.RawMessage   `json:"workspaces"`
+	}
+	if err := json.Unmarshal([]byte(content), &pkg); err != nil {
+		return []string{"invalid JSON"}
+	}
+
+	var facts []string
+	if pkg.Name != "" {
+		facts = append(facts, "name "+pkg.Name)
+	}
+	if pkg.Type != "" {
+		facts = append(facts, "module type "+pkg.Type)
+	}
+	if pkg.PackageManager != "" {
+		facts = append(facts, "package manager "+pkg.PackageManager)
+	} else {
+		for _, lock := range [][2]string{{"pnpm-lock.yaml", "pnpm"}, {"yarn.lock", "yarn"}, {"bun.lockb", "bun"}} {
+			if _, err := workspaceStat(lock[0]); err == nil {
+				facts = append(facts, "package manager "+lock[1]+" ("+lock[0]+")")
+			}
+		}
+	}
+	if len(pkg.Workspaces) > 0 {
+		facts = append(facts, "uses workspaces")
+	}
+	if len(pkg.Scripts) > 0 {
+		var scripts []string
+		for name := range pkg.Scripts {
+			scripts = append(scripts, name)
+		}
+		sort.Strings(scripts)
+		facts = append(facts, "scripts "+joinLimited(scripts))
+	}
+	return facts
+}
+
+// tomlKey matches a simple key = "value" line of a TOML file
+var tomlKey = regexp.MustCompile(`^([A-Za-z0-9_-]+)\s*=\s*"([^"]*)"`)
+
+// tomlSection matches a [section] header of a TOML file
+var tomlSection = regexp.MustCompile(`^\[([^\]]+)\]`)
+
+// probeToml returns the wanted keys of the wanted sections and the names of all sections
+func probeToml(content string, keys map[string][]string) (map[string]string, []string) {
+	values := map[string]string{}
+	var sections []string
+	section := ""
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if m := tomlSection.FindStringSubmatch(line); m != nil {
+			section = m[1]
+			sections = append(sections, section)
+			continue
+		}
+		if m := tomlKey.FindStringSubmatch(line); m != nil {
+			for _, key := range keys[section] {
+				if key == m[1] {
+					values[key] = m[2]
+				}
+			}
+		}
+	}
+	return values, sections
+}
+
+func probePyproject(content string) []string {
+	values, sections := probeToml(content, map[string][]string{
+		"project":     {"name", "requires-python"},
+		"tool.poetry": {"name"},
+	})
+
+	var facts []string
+	if values["name"] != "" {
+		facts = append(facts, "name "+values["name"])
+	}
+	if values["requires-python"] != "" {
+		facts = append(facts, "Python "+values["requires-python"])
+	}
+	// Tool sections tell which build system, linters and test runner are configured
+	var tools []string
+	seen := map[string]bool{}
+	for _, section := range sections {
+		name, ok := strings.CutPrefix(section, "tool.")
+		if !ok {
+			continue
+		}
+		name, _, _ = strings.Cut(name, ".")
+		if !seen[name] {
+			seen[name] = true
+			tools = append(tools, name)
+		}
+	}
+	if len(tools) > 0 {
+		facts = append(facts, "configured tools "+joinLimited(tools))
+	}
+	return facts
+}
+
+func probeCargo(content string) []string {
+	values, sections := probeToml(content, map[string][]string{
+		"package": {"name", "edition", "rust-version"},
+	})
+
+	var facts []string
+	if values["name"] != "" {
+		facts = append(facts, "crate "+values["name"])
+	}
+	if values["edition"] != "" {
+		facts = append(facts, "edition "+values["edition"])
+	}
+	if values["rust-version"] != "" {
+		facts = append(facts, "Rust "+values["rust-version"])
+	}
+	for _, section := range sections {
+		if section == "workspace" {
+			facts = append(facts, "cargo workspace")
+		}
+	}
+	return facts
+}
+
+// makeTarget matches a target defined at the start of a Makefile line
+var makeTarget = regexp.MustCompile(`^([A-Za-z0-9][A-Za-z0-9_./-]*)\s*:([^=]|$)`)
+
+func probeMakefile(content string) []string {
+	var targets []string
+	for _, line := range strings.Split(content, "\n") {
+		if m := makeTarget.FindStringSubmatch(line); m != nil {
+			targets = append(targets, m[1])
+		}
+	}
+	if len(targets) == 0 {
+		return nil
+	}
+	return []string{"targets " + joinLimited(targets)}
+}
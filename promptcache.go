@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Values of prompt_cache, telling how requests of the OpenAI API hint the
+// provider to cache the prefix they share with the previous request
+const (
+	promptCacheAuto    = ""              // Chosen from the provider and the model
+	promptCacheControl = "cache_control" // Breakpoints of the Anthropic API, for gateways in front of Claude or Gemini
+	promptCacheKey     = "key"           // prompt_cache_key of OpenAI, routing the requests of a session to the same cache
+	promptCacheOff     = "off"           // No hint, DeepSeek and most servers cache on their own
+)
+
+// checkPromptCache returns an error for an unknown value of prompt_cache
+func checkPromptCache(mode string) error {
+	switch mode {
+	case promptCacheAuto, promptCacheControl, promptCacheKey, promptCacheOff:
+		return nil
+	}
+	return fmt.Errorf("invalid prompt_cache %q, expected %s, %s or %s", mode, promptCacheControl, promptCacheKey, promptCacheOff)
+}
+
+// promptCacheMode returns how the requests hint the provider to cache their
+// prefix: as set for the endpoint or the profile, or else cache_control
+// breakpoints for Claude and Gemini on OpenRouter and prompt_cache_key for OpenAI
+func (o *OpenAI) promptCacheMode() string {
+	mode := o.Config.PromptCache
+	if endpoint, ok := o.Config.activeEndpoint(); ok && endpoint.PromptCache != "" {
+		mode = endpoint.PromptCache
+	}
+	if mode != promptCacheAuto {
+		return mode
+	}
+	switch {
+	case o.Config.Provider == providerOpenRouter:
+		if strings.HasPrefix(o.Config.Model, "anthropic/") || strings.HasPrefix(o.Config.Model, "google/gemini") {
+			return promptCacheControl
+		}
+	case o.openaiParams():
+		return promptCacheKey
+	}
+	return promptCacheOff
+}
+
+// withCacheBreakpoints returns a copy of the messages of a request with
+// cache_control breakpoints on the system prompt and on the last message
+// with text, so that the provider caches the conversation up to them
+func withCacheBreakpoints(messages []openaiMessage) []openaiMessage {
+	marked := append([]openaiMessage{}, messages...)
+	for i := range marked {
+		if marked[i].Role == "system" && marked[i].Content != "" {
+			marked[i].CacheControl = true
+			break
+		}
+	}
+	for i := len(marked) - 1; i >= 0; i-- {
+		if marked[i].Content != "" {
+			marked[i].CacheControl = true
+			break
+		}
+	}
+	return marked
+}
@@ -15,3 +15,21 @@ var summaryPrompt string
 
 //go:embed prompts/commit.md
 var defaultCommitPrompt string
+
+//go:embed prompts/title.md
+var titlePrompt string
+
+//go:embed prompts/summarize_section.md
+var summarizeSectionPrompt string
+
+//go:embed prompts/followups.md
+var followUpsPrompt string
+
+//go:embed prompts/changes.md
+var changesPrompt string
+
+//go:embed prompts/conflict.md
+var conflictPrompt string
+
+//go:embed prompts/postmortem.md
+var postmortemPrompt string
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxGrepFiles is the number of files listed by Grep
+const maxGrepFiles = 40
+
+// maxGrepLinesPerFile is the number of matching lines shown for each file
+const maxGrepLinesPerFile = 20
+
+// maxFoundFiles is the number of paths listed by FindFiles
+const maxFoundFiles = 100
+
+// recentChange is the age under which a file counts as recently modified
+const recentChange = 24 * time.Hour
+
+// noisePath matches directories and files that rarely hold the relevant hit
+var noisePath = regexp.MustCompile(`(^|/)(vendor|node_modules|third_party|testdata|fixtures|dist|build|target|\.venv|__pycache__)/|\.min\.(js|css)$|(\.lock|-lock\.json|\.sum|\.map|\.pb\.go|_gen\.go)$`)
+
+// definitionLine matches lines declaring a symbol in common languages
+var definitionLine = regexp.MustCompile(`^\s*(export\s+)?(pub\s+)?(async\s+)?(func|def|class|type|interface|struct|enum|trait|fn|function|const|var|let|module)\b`)
+
+// literalTerm matches search patterns that are plain words, usable for path matching
+var literalTerm = regexp.MustCompile(`^[\w.-]+$`)
+
+// rankedFile is a search result with its relevance score
+type rankedFile struct {
+	path  string
+	lines []string
+	score float64
+}
+
+// searchRanker scores search results. It takes a snapshot of the files the
+// model touched so concurrent searches don't share state.
+type searchRanker struct {
+	term      string
+	touched   []string
+	now       time.Time
+	rootDepth int // Directories of the working directory, shared by all paths
+}
+
+// newSearchRanker creates a ranker for a search for term, which may be empty
+func newSearchRanker(term string) *searchRanker {
+	if !literalTerm.MatchString(term) {
+		term = ""
+	}
+	wd, _ := os.Getwd()
+	return &searchRanker{
+		term:      strings.ToLower(term),
+		touched:   GlobalFileTracker.Touched(),
+		now:       time.Now(),
+		rootDepth: len(strings.Split(wd, string(filepath.Separator))),
+	}
+}
+
+// score rates how likely path is the file the model is looking for: how well
+// its name matches, how recently it changed and how close it is to the files
+// the model already read or wrote
+func (r *searchRanker) score(path string) float64 {
+	score := 0.0
+	lower := strings.ToLower(filepath.ToSlash(path))
+	base := filepath.Base(lower)
+	if r.term != "" {
+		switch {
+		case strings.TrimSuffix(base, filepath.Ext(base)) == r.term:
+			score += 3
+		case strings.Contains(base, r.term):
+			score += 2
+		case strings.Contains(lower, r.term):
+			score += 1
+		}
+	}
+	if noisePath.MatchString(lower) {
+		score -= 3
+	}
+	// Shallow paths are usually the main code rather than helpers
+	score -= 0.1 * float64(strings.Count(lower, "/"))
+
+	// Stat over SSH would cost a round trip per file
+	if activeRemote == nil {
+		if info, err := os.Stat(path); err == nil {
+			if age := r.now.Sub(info.ModTime()); age < recentChange {
+				score += 1.5 * (1 - float64(age)/float64(recentChange))
+			}
+		}
+	}
+
+	abs := absPath(path)
+	proximity := 0.0
+	for _, touched := range r.touched {
+		if filepath.Dir(touched) == filepath.Dir(abs) {
+			proximity = 2
+			break
+		}
+		shared := commonDirs(touched, abs) - r.rootDepth
+		proximity = max(proximity, 0.3*float64(min(shared, 5)))
+	}
+	return score + proximity
+}
+
+// commonDirs counts the leading directories shared by two paths
+func commonDirs(a, b string) int {
+	as := strings.Split(filepath.Dir(a), string(filepath.Separator))
+	bs := strings.Split(filepath.Dir(b), string(filepath.Separator))
+	n := 0
+	for n < len(as) && n < len(bs) && as[n] == bs[n] {
+		n++
+	}
+	return n
+}
+
+// rankFiles sorts files by decreasing score, keeping the original order on ties
+func rankFiles(files []rankedFile) {
+	sort.SliceStable(files, func(i, j int) bool { return files[i].score > files[j].score })
+}
+
+// rankGrepMatches groups the output of rg --null --line-number by file and
+// returns the files, most relevant first
+func rankGrepMatches(output, pattern string) []rankedFile {
+	ranker := newSearchRanker(pattern)
+	var files []rankedFile
+	index := map[string]int{}
+	for _, line := range strings.Split(strings.TrimRight(output, "\n"), "\n") {
+		path, match, ok := strings.Cut(line, "\x00")
+		if !ok {
+			continue
+		}
+		i, seen := index[path]
+		if !seen {
+			i = len(files)
+			index[path] = i
+			files = append(files, rankedFile{path: path})
+		}
+		files[i].lines = append(files[i].lines, match)
+	}
+
+	for i := range files {
+		file := &files[i]
+		file.score = ranker.score(file.path) + 0.3*math.Log2(1+float64(len(file.lines)))
+		for _, match := range file.lines {
+			_, text, _ := strings.Cut(match, ":")
+			if definitionLine.MatchString(text) && (ranker.term == "" || strings.Contains(strings.ToLower(text), ranker.term)) {
+				file.score += 1.5
+				break
+			}
+		}
+	}
+	rankFiles(files)
+	return files
+}
+
+// rankGrepOutput ranks the output of rg --null --line-number and caps the
+// number of files and lines shown
+func rankGrepOutput(output, pattern string) string {
+	files := rankGrepMatches(output, pattern)
+	if len(files) == 0 {
+		return "No matches found."
+	}
+
+	var b strings.Builder
+	for _, file := range files[:min(len(files), maxGrepFiles)] {
+		b.WriteString(file.path + "\n")
+		for _, match := range file.lines[:min(len(file.lines), maxGrepLinesPerFile)] {
+			b.WriteString(match + "\n")
+		}
+		if len(file.lines) > maxGrepLinesPerFile {
+			fmt.Fprintf(&b, "... %d more matches in this file\n", len(file.lines)-maxGrepLinesPerFile)
+		}
+		b.WriteString("\n")
+	}
+	if len(files) > maxGrepFiles {
+		fmt.Fprintf(&b, "[Showing %d of %d files, ranked by relevance. Narrow the pattern, path or include to see the rest.]\n", maxGrepFiles, len(files))
+	}
+	return b.String()
+}
+
+// rankFoundPaths returns the paths output by fd, most relevant first
+func rankFoundPaths(output, pattern string) []rankedFile {
+	// The last element of the glob without wildcards is what names usually match
+	term := strings.Trim(filepath.Base(pattern), "*?[]{}")
+	term = strings.TrimSuffix(term, filepath.Ext(term))
+	ranker := newSearchRanker(term)
+
+	var files []rankedFile
+	for _, path := range strings.Split(strings.TrimRight(output, "\n"), "\n") {
+		if path != "" {
+			files = append(files, rankedFile{path: path, score: ranker.score(path)})
+		}
+	}
+	rankFiles(files)
+	return files
+}
+
+// rankFoundFiles ranks the paths output by fd and caps their number
+func rankFoundFiles(output, pattern string) string {
+	files := rankFoundPaths(output, pattern)
+	var b strings.Builder
+	for _, file := range files[:min(len(files), maxFoundFiles)] {
+		b.WriteString(file.path + "\n")
+	}
+	if len(files) > maxFoundFiles {
+		fmt.Fprintf(&b, "[Showing %d of %d files, ranked by relevance. Narrow the pattern or path to see the rest.]\n", maxFoundFiles, len(files))
+	}
+	return b.String()
+}
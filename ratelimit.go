@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync/atomic"
+	"time"
+)
+
+// rateLimitWindow is the period the limits of rate_limit apply to
+const rateLimitWindow = time.Minute
+
+// rateLimitPoll is how often a request queued behind requests of other
+// sessions checks whether its turn came
+const rateLimitPoll = time.Second
+
+// rateLimitGrace is how long a queued request may stay past its announced
+// wait before it is considered abandoned and dropped from the queue
+const rateLimitGrace = 10 * time.Second
+
+// RateLimit caps the requests sent with an API key, shared by every aicode
+// process using the key such as sub-agents dispatched in parallel
+type RateLimit struct {
+	RequestsPerMinute int `yaml:"requests_per_minute"`
+	TokensPerMinute   int `yaml:"tokens_per_minute"` // Estimated input tokens
+}
+
+// rateLimitEntry is a request sent during the last window
+type rateLimitEntry struct {
+	Time   time.Time `json:"time"`
+	Tokens int       `json:"tokens"`
+}
+
+// rateLimitWaiter is a request waiting for its turn, served in the order
+// they arrived so that no session starves the others
+type rateLimitWaiter struct {
+	ID      string    `json:"id"`
+	Pid     int       `json:"pid"`
+	Expires time.Time `json:"expires"`
+}
+
+// rateLimitState is shared by the processes sending requests with a key
+type rateLimitState struct {
+	Entries     []rateLimitEntry  `json:"entries"`
+	Waiting     []rateLimitWaiter `json:"waiting"`
+	PausedUntil time.Time         `json:"paused_until"` // Set when the provider answers 429
+}
+
+var rateLimitWaiterID atomic.Int64
+
+// rateLimitPath returns the file recording the requests sent to host with
+// key, next to the file locks so that all processes find it
+func rateLimitPath(host, key string) string {
+	sum := sha256.Sum256([]byte(host + "\x00" + key))
+	return filepath.Join(os.TempDir(), "aicode-locks", "ratelimit-"+hex.EncodeToString(sum[:8])+".json")
+}
+
+// waitRateLimit waits until req fits the rate limit of the profile, its turn
+// came among the requests of other sessions and any 429 received with the
+// key has passed, and records it. A request estimated above the tokens limit
+// is sent alone.
+func waitRateLimit(ctx context.Context, config Config, req *http.Request) error {
+	limit := config.RateLimit
+	tokens := 0
+	if limit.TokensPerMinute > 0 && req.GetBody != nil {
+		if body, err := req.GetBody(); err == nil {
+			data, _ := io.ReadAll(body)
+			tokens = estimateRequestTokens(data)
+		}
+	}
+	path := rateLimitPath(req.URL.Host, config.ApiKey)
+	waiter := rateLimitWaiter{ID: fmt.Sprintf("%d-%d", os.Getpid(), rateLimitWaiterID.Add(1)), Pid: os.Getpid()}
+	for {
+		wait, ahead, err := reserveRateLimit(path, limit, waiter, tokens, time.Now())
+		if err != nil {
+			// Better to risk a 429 than to stop working
+			slog.Warn("Failed to apply the rate limit", "error", err)
+			return nil
+		}
+		if wait <= 0 {
+			return nil
+		}
+		slog.Debug("Waiting for the rate limit", "delay", wait, "tokens", tokens, "ahead", ahead)
+		if ahead > 0 {
+			reportRetry(fmt.Sprintf("Rate limit, waiting behind %d requests of other sessions", ahead))
+		} else {
+			reportRetry(fmt.Sprintf("Rate limit, waiting %s", wait.Round(time.Second)))
+		}
+		select {
+		case <-ctx.Done():
+			reportRetry("")
+			leaveRateLimit(path, waiter.ID)
+			return context.Cause(ctx)
+		case <-time.After(wait):
+		}
+		reportRetry("")
+	}
+}
+
+// pauseRateLimit makes every process using the key of config wait until
+// the delay asked by a 429 of host has passed, rather than each of them
+// getting its own 429 in turn
+func pauseRateLimit(config Config, host string, delay time.Duration) {
+	until := time.Now().Add(delay)
+	err := updateRateLimit(rateLimitPath(host, config.ApiKey), func(state *rateLimitState) bool {
+		if until.After(state.PausedUntil) {
+			state.PausedUntil = until
+			return true
+		}
+		return false
+	})
+	if err != nil {
+		slog.Warn("Failed to share the rate limit pause", "error", err)
+	}
+}
+
+// leaveRateLimit removes an abandoned request from the queue
+func leaveRateLimit(path, id string) {
+	updateRateLimit(path, func(state *rateLimitState) bool {
+		state.Waiting = slices.DeleteFunc(state.Waiting, func(w rateLimitWaiter) bool { return w.ID == id })
+		return true
+	})
+}
+
+// reserveRateLimit records a request of tokens at now if no 429 pause is
+// running, no earlier request is queued and it fits the limit. Otherwise it
+// queues the request and returns how long to wait before asking again and
+// the number of requests queued before it.
+func reserveRateLimit(path string, limit RateLimit, waiter rateLimitWaiter, tokens int, now time.Time) (time.Duration, int, error) {
+	var wait time.Duration
+	ahead := 0
+	err := updateRateLimit(path, func(state *rateLimitState) bool {
+		recent := state.Entries[:0]
+		used := 0
+		for _, entry := range state.Entries {
+			if now.Sub(entry.Time) < rateLimitWindow {
+				recent = append(recent, entry)
+				used += entry.Tokens
+			}
+		}
+		state.Entries = recent
+		state.Waiting = slices.DeleteFunc(state.Waiting, func(w rateLimitWaiter) bool {
+			return w.ID != waiter.ID && (now.After(w.Expires) || !processAlive(w.Pid))
+		})
+
+		wait = max(0, state.PausedUntil.Sub(now))
+		entries := state.Entries
+		if limit.RequestsPerMinute > 0 && len(entries) >= limit.RequestsPerMinute {
+			wait = max(wait, entries[len(entries)-limit.RequestsPerMinute].Time.Add(rateLimitWindow).Sub(now))
+		}
+		if limit.TokensPerMinute > 0 && used > 0 && used+tokens > limit.TokensPerMinute {
+			// Wait for the oldest requests to leave the window until the request fits
+			freed := 0
+			for _, entry := range entries {
+				freed += entry.Tokens
+				if used-freed+tokens <= limit.TokensPerMinute || freed == used {
+					wait = max(wait, entry.Time.Add(rateLimitWindow).Sub(now))
+					break
+				}
+			}
+		}
+		ahead = slices.IndexFunc(state.Waiting, func(w rateLimitWaiter) bool { return w.ID == waiter.ID })
+		if ahead < 0 {
+			ahead = len(state.Waiting)
+		}
+		if ahead > 0 {
+			wait = max(wait, rateLimitPoll)
+		}
+
+		if wait > 0 {
+			waiter.Expires = now.Add(wait + rateLimitGrace)
+			if i := slices.IndexFunc(state.Waiting, func(w rateLimitWaiter) bool { return w.ID == waiter.ID }); i >= 0 {
+				state.Waiting[i] = waiter
+			} else {
+				state.Waiting = append(state.Waiting, waiter)
+			}
+			return true
+		}
+		state.Waiting = slices.DeleteFunc(state.Waiting, func(w rateLimitWaiter) bool { return w.ID == waiter.ID })
+		state.Entries = append(state.Entries, rateLimitEntry{Time: now, Tokens: tokens})
+		return true
+	})
+	return wait, ahead, err
+}
+
+// updateRateLimit applies update to the shared state under a file lock,
+// writing it back if update returns true
+func updateRateLimit(path string, update func(state *rateLimitState) bool) error {
+	return updateLockedJSON(path, update)
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RemoteConfig describes a host on which the tools run over SSH
+type RemoteConfig struct {
+	Host    string   `yaml:"host"`     // SSH destination, e.g. user@devbox or a Host of ~/.ssh/config
+	Dir     string   `yaml:"dir"`      // Working directory on the host, the login directory otherwise
+	SSHArgs []string `yaml:"ssh_args"` // Extra arguments for ssh, e.g. [-p, "2222"]
+}
+
+// activeRemote is the remote workspace of the session, nil to work locally
+var activeRemote *RemoteConfig
+
+// configureRemote makes the tools run on the remote host of the profile, if any
+func configureRemote(config Config) {
+	if config.Remote.Host == "" {
+		activeRemote = nil
+		return
+	}
+	remote := config.Remote
+	activeRemote = &remote
+}
+
+// shellQuote quotes s for a POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "'\\''") + "'"
+}
+
+// workspaceCommand returns a command running a bash command line in the
+// workspace, locally or on the remote host
+func workspaceCommand(ctx context.Context, command string) *exec.Cmd {
+	if activeRemote == nil {
+		return exec.CommandContext(ctx, "bash", "-c", command)
+	}
+
+	remoteCommand := "bash -c " + shellQuote(command)
+	if activeRemote.Dir != "" {
+		remoteCommand = "cd " + shellQuote(activeRemote.Dir) + " && " + remoteCommand
+	}
+	args := append([]string{}, activeRemote.SSHArgs...)
+	// Never prompt for a password, the TUI owns the terminal
+	args = append(args, "-o", "BatchMode=yes", activeRemote.Host, "--", remoteCommand)
+	return exec.CommandContext(ctx, "ssh", args...)
+}
+
+// runWorkspace runs a command line in the workspace with stdin and returns its stdout
+func runWorkspace(command string, stdin []byte) ([]byte, error) {
+	cmd := workspaceCommand(GlobalAppContext.Context(), command)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return output, fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return output, nil
+}
+
+// workspaceDir returns the working directory of the workspace
+func workspaceDir() (string, error) {
+	if activeRemote == nil {
+		return os.Getwd()
+	}
+	output, err := runWorkspace("pwd", nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to get remote directory: %v", err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// remoteFileInfo is the fs.FileInfo of a file on the remote host
+type remoteFileInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func (i remoteFileInfo) Name() string       { return i.name }
+func (i remoteFileInfo) Size() int64        { return i.size }
+func (i remoteFileInfo) Mode() fs.FileMode  { return i.mode }
+func (i remoteFileInfo) ModTime() time.Time { return i.modTime }
+func (i remoteFileInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i remoteFileInfo) Sys() any           { return nil }
+
+// workspaceStat is os.Stat for the workspace. Remote hosts need GNU stat.
+func workspaceStat(filePath string) (fs.FileInfo, error) {
+	if activeRemote == nil {
+		return os.Stat(filePath)
+	}
+
+	quoted := shellQuote(filePath)
+	output, err := runWorkspace(fmt.Sprintf("test -e %s || exit 3; stat -L -c '%%F|%%s|%%a|%%Y' %s", quoted, quoted), nil)
+	if err != nil {
+		// ssh exits with the status of the remote command
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == 3 {
+			return nil, &fs.PathError{Op: "stat", Path: filePath, Err: fs.ErrNotExist}
+		}
+		return nil, &fs.PathError{Op: "stat", Path: filePath, Err: err}
+	}
+
+	fields := strings.Split(strings.TrimSpace(string(output)), "|")
+	if len(fields) != 4 {
+		return nil, &fs.PathError{Op: "stat", Path: filePath, Err: fmt.Errorf("unexpected stat output %q", output)}
+	}
+	info := remoteFileInfo{name: path.Base(filePath)}
+	info.size, _ = strconv.ParseInt(fields[1], 10, 64)
+	perm, _ := strconv.ParseUint(fields[2], 8, 32)
+	info.mode = fs.FileMode(perm)
+	if fields[0] == "directory" {
+		info.mode |= fs.ModeDir
+	}
+	modTime, _ := strconv.ParseInt(fields[3], 10, 64)
+	info.modTime = time.Unix(modTime, 0)
+	return info, nil
+}
+
+// workspaceReadFile is os.ReadFile for the workspace
+func workspaceReadFile(filePath string) ([]byte, error) {
+	if activeRemote == nil {
+		return os.ReadFile(filePath)
+	}
+	if _, err := workspaceStat(filePath); err != nil {
+		return nil, err
+	}
+	output, err := runWorkspace("cat -- "+shellQuote(filePath), nil)
+	if err != nil {
+		return nil, &fs.PathError{Op: "read", Path: filePath, Err: err}
+	}
+	return output, nil
+}
+
+// workspaceWriteFile writes data to a file of the workspace, creating it
+// with mode 0644 if needed and keeping the mode of existing files
+func workspaceWriteFile(filePath string, data []byte) error {
+	if activeRemote == nil {
+		return os.WriteFile(filePath, data, 0644)
+	}
+	if _, err := runWorkspace("cat > "+shellQuote(filePath), data); err != nil {
+		return &fs.PathError{Op: "write", Path: filePath, Err: err}
+	}
+	return nil
+}
+
+// workspaceMkdirAll is os.MkdirAll for the workspace
+func workspaceMkdirAll(dir string) error {
+	if activeRemote == nil {
+		return os.MkdirAll(dir, 0755)
+	}
+	if _, err := runWorkspace("mkdir -p -- "+shellQuote(dir), nil); err != nil {
+		return fmt.Errorf("mkdir %s: %v", dir, err)
+	}
+	return nil
+}
+
+// workspaceReadDir returns the names of the entries of a directory of the workspace
+func workspaceReadDir(dir string) ([]string, error) {
+	if activeRemote == nil {
+		entries, err := os.ReadDir(dir)
+		names := make([]string, 0, len(entries))
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		return names, err
+	}
+	output, err := runWorkspace("ls -1A -- "+shellQuote(dir), nil)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: dir, Err: err}
+	}
+	if len(output) == 0 {
+		return nil, nil
+	}
+	return strings.Split(strings.TrimRight(string(output), "\n"), "\n"), nil
+}
+
+// listRemoteFiles lists the top level of the remote working directory like listProjectFiles
+func listRemoteFiles() string {
+	wd, err := workspaceDir()
+	if err != nil {
+		return ""
+	}
+	output, err := runWorkspace("ls -1Ap", nil)
+	if err != nil {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString(wd + "/\n")
+	for _, name := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		if name == "" || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") {
+			continue
+		}
+		b.WriteString("  - " + name + "\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// cmdTemplatePath returns the file of a custom command, accepting "/cmd:name" or "name"
+func cmdTemplatePath(name string) string {
+	name = strings.TrimPrefix(name, "/cmd:")
+	return filepath.Join(os.Getenv("HOME"), ".config/aicode/cmds", name+".md")
+}
+
+// runRender implements the render subcommand, printing the prompt a custom
+// command expands to without calling the API
+func runRender(args []string) int {
+	flags := flag.NewFlagSet("render", flag.ExitOnError)
+	cmdArgs := flags.String("args", "", "Arguments substituted for {{.ARGS}}")
+	vars := templateVars{}
+	flags.Var(vars, "var", "Template variable as key=value, available as {{.key}} (repeatable)")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: aicode render /cmd:name [--args \"...\"] [--var key=value]...")
+		flags.PrintDefaults()
+	}
+
+	// Allow the command name before the flags, as in the interactive mode
+	var name string
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	flags.Parse(args)
+	if name == "" && flags.NArg() > 0 {
+		name = flags.Arg(0)
+	}
+	if name == "" {
+		flags.Usage()
+		return 2
+	}
+
+	var content string
+	switch name {
+	case "/init":
+		content = initPrompt
+	case "/commit":
+		content = defaultCommitPrompt
+	default:
+		data, err := os.ReadFile(cmdTemplatePath(name))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading command file: %v\n", err)
+			return 1
+		}
+		content = string(data)
+	}
+
+	if *cmdArgs != "" && !strings.Contains(content, "{{.ARGS}}") {
+		fmt.Fprintf(os.Stderr, "Warning: %s doesn't use {{.ARGS}}, the arguments are ignored\n", name)
+	}
+
+	settings, content, err := parseFrontMatter(content)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading command file: %v\n", err)
+		return 1
+	}
+	if overrides := settings.String(); overrides != "" {
+		fmt.Fprintf(os.Stderr, "Settings: %s\n", overrides)
+	}
+
+	prompt, err := processCommandTemplate(content, *cmdArgs, vars)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error processing command template: %v\n", err)
+		return 1
+	}
+
+	fmt.Print(prompt)
+	if !strings.HasSuffix(prompt, "\n") {
+		fmt.Println()
+	}
+	return 0
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// builtinTools are the tools OpenAI runs itself when the Responses API is
+// used, their calls and results come back as items of the answer
+var builtinTools = map[string]json.RawMessage{
+	"web_search":       json.RawMessage(`{"type":"web_search"}`),
+	"code_interpreter": json.RawMessage(`{"type":"code_interpreter","container":{"type":"auto"}}`),
+}
+
+// validateBuiltinTools checks the built-in tools of the profile, which only
+// the Responses API of OpenAI serves
+func validateBuiltinTools(config Config) error {
+	if len(config.BuiltinTools) == 0 {
+		return nil
+	}
+	if !config.ResponsesAPI || config.Provider != "" {
+		return errors.New("builtin_tools need responses_api: true and the OpenAI provider")
+	}
+	for _, name := range config.BuiltinTools {
+		if _, ok := builtinTools[name]; !ok {
+			return fmt.Errorf("unknown built-in tool %q, expected web_search or code_interpreter", name)
+		}
+	}
+	return nil
+}
+
+type responsesRequest struct {
+	Model              string              `json:"model"`
+	Instructions       string              `json:"instructions,omitempty"`
+	Input              []any               `json:"input"`
+	Tools              []json.RawMessage   `json:"tools,omitempty"`
+	MaxOutputTokens    int                 `json:"max_output_tokens,omitempty"`
+	Temperature        *float64            `json:"temperature,omitempty"`
+	TopP               *float64            `json:"top_p,omitempty"`
+	Reasoning          *responsesReasoning `json:"reasoning,omitempty"`
+	Text               *responsesText      `json:"text,omitempty"`
+	PreviousResponseID string              `json:"previous_response_id,omitempty"`
+	Store              bool                `json:"store"`
+	PromptCacheKey     string              `json:"prompt_cache_key,omitempty"`
+}
+
+type responsesReasoning struct {
+	Effort  string `json:"effort,omitempty"`
+	Summary string `json:"summary,omitempty"`
+}
+
+type responsesText struct {
+	Verbosity string `json:"verbosity,omitempty"`
+}
+
+type responsesMessage struct {
+	Role    string `json:"role"`
+	Content any    `json:"content"`
+}
+
+type responsesContentPart struct {
+	Type     string `json:"type"`
+	Text     string `json:"text,omitempty"`
+	ImageURL string `json:"image_url,omitempty"`
+}
+
+type responsesFunctionCall struct {
+	Type      string `json:"type"`
+	CallID    string `json:"call_id"`
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+type responsesFunctionOutput struct {
+	Type   string `json:"type"`
+	CallID string `json:"call_id"`
+	Output string `json:"output"`
+}
+
+type responsesResponse struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	Output []struct {
+		Type      string `json:"type"`
+		CallID    string `json:"call_id"`
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+		Content   []struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		} `json:"content"`
+		Summary []struct {
+			Text string `json:"text"`
+		} `json:"summary"`
+	} `json:"output"`
+	Usage struct {
+		InputTokens        int `json:"input_tokens"`
+		OutputTokens       int `json:"output_tokens"`
+		InputTokensDetails struct {
+			CachedTokens int `json:"cached_tokens"`
+		} `json:"input_tokens_details"`
+	} `json:"usage"`
+	IncompleteDetails *struct {
+		Reason string `json:"reason"`
+	} `json:"incomplete_details"`
+	Error *struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+// responsesChain is the last response stored by OpenAI, later requests send
+// only the messages added since and refer to it by its ID
+type responsesChain struct {
+	id     string
+	length int      // Messages of the history the response holds
+	digest [32]byte // Digest of those messages, which summaries and rewinds change
+}
+
+// usesResponses tells whether requests go to the Responses API instead of
+// chat completions, which only OpenAI serves
+func (o *OpenAI) usesResponses() bool {
+	return o.Config.ResponsesAPI && o.Config.Provider == ""
+}
+
+// splitInstructions separates the system prompt, sent as the instructions
+// of every request as they are not carried over by previous_response_id
+func splitInstructions(history []openaiMessage) (string, []openaiMessage) {
+	if len(history) > 0 && history[0].Role == "system" {
+		return history[0].Content, history[1:]
+	}
+	return "", history
+}
+
+// historyDigest identifies the messages of the history
+func historyDigest(messages []openaiMessage) [32]byte {
+	data, _ := json.Marshal(messages)
+	for _, msg := range messages {
+		for _, item := range msg.Items {
+			data = append(data, item...)
+		}
+	}
+	return sha256.Sum256(data)
+}
+
+// responsesInput converts messages of the history to items of the Responses API
+func responsesInput(messages []openaiMessage) []any {
+	var input []any
+	for _, msg := range messages {
+		switch msg.Role {
+		case "tool":
+			input = append(input, responsesFunctionOutput{Type: "function_call_output", CallID: msg.ToolCallID, Output: msg.Content})
+		case "assistant":
+			for _, item := range msg.Items {
+				input = append(input, item)
+			}
+			if msg.Content != "" {
+				input = append(input, responsesMessage{Role: "assistant", Content: msg.Content})
+			}
+			for _, call := range msg.ToolCalls {
+				var arguments string
+				if err := json.Unmarshal(call.Function.Arguments, &arguments); err != nil {
+					arguments = string(call.Function.Arguments)
+				}
+				input = append(input, responsesFunctionCall{Type: "function_call", CallID: call.ID, Name: call.Function.Name, Arguments: arguments})
+			}
+		default:
+			if len(msg.Images) == 0 {
+				input = append(input, responsesMessage{Role: msg.Role, Content: msg.Content})
+				continue
+			}
+			parts := []responsesContentPart{{Type: "input_text", Text: msg.Content}}
+			for _, image := range msg.Images {
+				parts = append(parts, responsesContentPart{Type: "input_image", ImageURL: image.ImageURL.URL})
+			}
+			input = append(input, responsesMessage{Role: msg.Role, Content: parts})
+		}
+	}
+	return input
+}
+
+// responsesTools returns the function tools in the flat shape of the
+// Responses API along with the built-in tools of the profile
+func (o *OpenAI) responsesTools() []json.RawMessage {
+	var tools []json.RawMessage
+	for _, tool := range o.tools {
+		data, _ := json.Marshal(struct {
+			Type        string          `json:"type"`
+			Name        string          `json:"name"`
+			Description string          `json:"description"`
+			Parameters  json.RawMessage `json:"parameters,omitempty"`
+			Strict      bool            `json:"strict"` // True when not sent
+		}{"function", tool.Function.Name, tool.Function.Description, tool.Function.Parameters, tool.Function.Strict})
+		tools = append(tools, data)
+	}
+	for _, name := range o.Config.BuiltinTools {
+		tools = append(tools, builtinTools[name])
+	}
+	return tools
+}
+
+// responsesRequestBody builds the request of the next response. It chains
+// to the previous response when the history it holds is unchanged, and
+// tells whether it did.
+func (o *OpenAI) responsesRequestBody() ([]byte, bool) {
+	instructions, history := splitInstructions(o.conversationHistory)
+	reqBody := responsesRequest{
+		Model:           o.Config.Model,
+		Instructions:    instructions,
+		Tools:           o.responsesTools(),
+		MaxOutputTokens: o.MaxTokens,
+		Temperature:     o.Config.Temperature,
+		TopP:            o.Config.TopP,
+		Store:           true,
+	}
+
+	chained := o.chain.id != "" && len(history) >= o.chain.length && historyDigest(history[:o.chain.length]) == o.chain.digest
+	if chained {
+		reqBody.PreviousResponseID = o.chain.id
+		reqBody.Input = responsesInput(history[o.chain.length:])
+	} else {
+		reqBody.Input = responsesInput(history)
+	}
+
+	if o.sendsReasoning() {
+		reqBody.Reasoning = &responsesReasoning{Effort: o.Config.ReasoningEffort}
+		// Summaries of the reasoning may need a verified organization
+		if o.Config.ShowReasoning {
+			reqBody.Reasoning.Summary = "auto"
+		}
+	}
+
+	// GPT-5 models take the verbosity as a parameter, others get an instruction
+	if strings.HasPrefix(o.Config.Model, "gpt-5") {
+		reqBody.Text = &responsesText{Verbosity: o.Config.Verbosity}
+	} else if instruction, ok := verbosityInstructions[o.Config.Verbosity]; ok {
+		reqBody.Instructions += "\n\n" + instruction
+	}
+	if o.promptCacheMode() == promptCacheKey {
+		reqBody.PromptCacheKey = "aicode-" + sessionID
+	}
+
+	bodyBytes, _ := json.Marshal(&reqBody)
+	return bodyBytes, chained
+}
+
+// handleResponsesAnswer reads an answer of the Responses API into the
+// history, retrying without the chain when OpenAI no longer has the
+// previous response
+func (o *OpenAI) handleResponsesAnswer(ctx context.Context, isRetry bool, resp *http.Response, body []byte, chained bool) (InferenceResponse, error) {
+	var out responsesResponse
+	if err := json.Unmarshal(body, &out); err != nil {
+		return InferenceResponse{}, newAPIError("OpenAI", resp, body, "")
+	}
+	if out.Error != nil {
+		if chained && out.Error.Code == "previous_response_not_found" {
+			slog.Debug("Previous response not found, sending the whole conversation", "response", o.chain.id)
+			o.chain = responsesChain{}
+			return o.inferenceWithRetry(ctx, isRetry)
+		}
+		if (strings.Contains(strings.ToLower(out.Error.Message), "rate limit") ||
+			strings.Contains(strings.ToLower(out.Error.Message), "too many requests")) && !isRetry && !retryableStatus(resp.StatusCode) {
+			slog.Debug("Received rate limit error in response. Summarizing conversation and retrying...")
+			return o.inferenceWithRetry(ctx, true)
+		}
+		return InferenceResponse{}, newAPIError("OpenAI", resp, body, out.Error.Message)
+	}
+	if resp.StatusCode/100 != 2 {
+		return InferenceResponse{}, newAPIError("OpenAI", resp, body, "")
+	}
+	if out.Status == "incomplete" && out.IncompleteDetails != nil {
+		slog.Warn("Incomplete response", "reason", out.IncompleteDetails.Reason)
+	}
+
+	o.addUsage(requestKey(resp.Request), out.Usage.InputTokens, out.Usage.InputTokensDetails.CachedTokens, out.Usage.OutputTokens, 0)
+
+	// Reasoning and built-in tool items are sent back as they came, the
+	// messages and function calls are kept in the history as usual
+	var raw struct {
+		Output []json.RawMessage `json:"output"`
+	}
+	json.Unmarshal(body, &raw)
+
+	response := InferenceResponse{ToolCalls: []ToolCall{}}
+	assistantMessage := openaiMessage{Role: "assistant", Type: "text"}
+	var text, reasoning []string
+	for i, item := range out.Output {
+		switch item.Type {
+		case "message":
+			for _, part := range item.Content {
+				if part.Type == "output_text" {
+					text = append(text, part.Text)
+				}
+			}
+		case "function_call":
+			arguments, _ := json.Marshal(string(dropNullArguments([]byte(item.Arguments))))
+			response.ToolCalls = append(response.ToolCalls, ToolCall{ID: item.CallID, Name: item.Name, Input: arguments})
+			assistantMessage.ToolCalls = append(assistantMessage.ToolCalls, openaiToolCall{
+				ID:       item.CallID,
+				Type:     "function",
+				Function: openaiFunction{Name: item.Name, Arguments: arguments},
+			})
+		default:
+			for _, summary := range item.Summary {
+				reasoning = append(reasoning, summary.Text)
+			}
+			if i < len(raw.Output) {
+				assistantMessage.Items = append(assistantMessage.Items, raw.Output[i])
+			}
+		}
+	}
+	response.Content = strings.Join(text, "\n\n")
+	response.Reasoning = strings.Join(reasoning, "\n\n")
+	assistantMessage.Content = response.Content
+
+	o.conversationHistory = append(o.conversationHistory, assistantMessage)
+	o.contextCount = contextCount{tokens: out.Usage.InputTokens + out.Usage.OutputTokens, messages: len(o.conversationHistory)}
+
+	_, history := splitInstructions(o.conversationHistory)
+	o.chain = responsesChain{id: out.ID, length: len(history), digest: historyDigest(history)}
+
+	return response, nil
+}
+
+// responsesSummary asks the Responses API for the summary of the messages,
+// without storing it nor chaining to the conversation
+func (o *OpenAI) responsesSummary(messages []openaiMessage) (string, error) {
+	instructions, history := splitInstructions(messages)
+	reqBody := responsesRequest{
+		Model:           o.Config.Model,
+		Instructions:    instructions,
+		Input:           responsesInput(history),
+		MaxOutputTokens: o.MaxTokens,
+	}
+	if o.sendsReasoning() {
+		reqBody.Reasoning = &responsesReasoning{Effort: o.Config.ReasoningEffort}
+	} else {
+		reqBody.Temperature = floatPtr(0.2) // Lower temperature for more consistent summaries
+	}
+
+	bodyBytes, _ := json.Marshal(&reqBody)
+	resp, body, err := postWithRetry(context.Background(), o.Config, "OpenAI", func() (*http.Request, error) {
+		return o.newRequest(openaiURL(o.Config, "/responses"), bodyBytes)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var out responsesResponse
+	if err := json.Unmarshal(body, &out); err != nil {
+		return "", newAPIError("OpenAI", resp, body, "")
+	}
+	if out.Error != nil {
+		return "", newAPIError("OpenAI", resp, body, out.Error.Message)
+	}
+	if resp.StatusCode/100 != 2 {
+		return "", newAPIError("OpenAI", resp, body, "")
+	}
+
+	var text []string
+	for _, item := range out.Output {
+		for _, part := range item.Content {
+			if item.Type == "message" && part.Type == "output_text" {
+				text = append(text, part.Text)
+			}
+		}
+	}
+	return strings.Join(text, "\n\n"), nil
+}
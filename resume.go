@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Tool result given to the calls a cancel cut short, for the model to run
+// them again if it still needs them
+const canceledToolResult = "Canceled by the user before it finished"
+
+// resumeHelp is shown in the status line while resuming is offered
+const resumeHelp = "Resume the canceled turn | y go on from its tool results, n or esc start over"
+
+// submittedTurn is a prompt sent from the UI, kept so that the turn can be
+// resumed once it was canceled
+type submittedTurn struct {
+	input      string // As typed by the user
+	userIndex  int    // Index of its prompt among the user messages of the history
+	config     Config
+	toolSubset []string
+}
+
+// turnProgress looks for the prompt with the given index among the user
+// messages of history, and counts the tool results that followed it. It
+// also returns the tool calls left without results, and false when the
+// prompt is not there or the turn ended with an answer.
+func turnProgress(history []Message, userIndex int) (int, []string, bool) {
+	start, n := -1, 0
+	for i, msg := range history {
+		if isUserPrompt(msg) {
+			if n == userIndex {
+				start = i
+				break
+			}
+			n++
+		}
+	}
+	if start < 0 {
+		return 0, nil, false
+	}
+
+	results := 0
+	answered := map[string]bool{}
+	var calls []string
+	for _, msg := range history[start+1:] {
+		blocks, _ := msg.Content.([]ContentBlock)
+		for _, block := range blocks {
+			switch block.Type {
+			case "tool_use":
+				calls = append(calls, block.ID)
+			case "tool_result":
+				answered[block.ToolUseID] = true
+				results++
+			}
+		}
+	}
+	if last := history[len(history)-1]; last.Role == "assistant" && !hasToolCalls(last) {
+		return results, nil, false
+	}
+
+	var pending []string
+	for _, id := range calls {
+		if !answered[id] {
+			pending = append(pending, id)
+		}
+	}
+	return results, pending, true
+}
+
+// isUserPrompt tells whether a message of the shared history was entered by
+// the user, as opposed to tool results which are also sent with the user role
+func isUserPrompt(msg Message) bool {
+	if msg.Role != "user" {
+		return false
+	}
+	if _, ok := msg.Content.(string); ok {
+		return true
+	}
+	blocks, _ := msg.Content.([]ContentBlock)
+	for _, block := range blocks {
+		if block.Type == "tool_result" {
+			return false
+		}
+	}
+	return len(blocks) > 0
+}
+
+// hasToolCalls tells whether a message of the shared history calls tools
+func hasToolCalls(msg Message) bool {
+	blocks, _ := msg.Content.([]ContentBlock)
+	for _, block := range blocks {
+		if block.Type == "tool_use" {
+			return true
+		}
+	}
+	return false
+}
+
+// offerResume handles the prompt of the canceled turn submitted again,
+// a common reflex after a cancel. When the turn had gathered tool results,
+// it offers to go on from them rather than redo the exploration and
+// returns true. Otherwise the canceled prompt is dropped from the history
+// so that it is not sent twice, and the input is submitted as usual.
+func (m *chatModel) offerResume(input string) bool {
+	turn := m.canceled
+	if input != turn.input {
+		m.canceled = nil
+		return false
+	}
+	results, _, ok := turnProgress(m.llm.History(), turn.userIndex)
+	if !ok {
+		m.canceled = nil
+		return false
+	}
+	if results == 0 {
+		m.llm.Rewind(turn.userIndex)
+		m.canceled = nil
+		return false
+	}
+
+	m.resumeOffered = true
+	m.textarea.Reset()
+	m.outputs = append(m.outputs, fmt.Sprintf("The canceled turn had gathered %d tool results. Resume it? (y/n)", results))
+	m.updateViewportContent()
+	return true
+}
+
+// handleResumeKey answers the offer to resume the canceled turn: y goes on
+// from its tool results, n drops them and sends the prompt again
+func (m chatModel) handleResumeKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	turn := m.canceled
+	switch msg.String() {
+	case "y", "Y":
+		m.canceled, m.resumeOffered = nil, false
+		return m, m.resumeTurn(*turn)
+	case "n", "N", "esc":
+		m.canceled, m.resumeOffered = nil, false
+		m.llm.Rewind(turn.userIndex)
+		m.textarea.SetValue(turn.input)
+		return m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	}
+	return m, nil
+}
+
+// resumeTurn continues a canceled turn from the tool results in the history,
+// answering the tool calls the cancel cut short
+func (m *chatModel) resumeTurn(turn submittedTurn) tea.Cmd {
+	llm := m.llm
+	llm.SetConfig(turn.config)
+	llm.SetToolSubset(turn.toolSubset)
+	_, pending, _ := turnProgress(llm.History(), turn.userIndex)
+	for _, id := range pending {
+		llm.AddToolResult(id, canceledToolResult)
+	}
+
+	m.processing = true
+	m.lastResponse = ""
+	m.lastTurn = turn
+	m.outputs = append(m.outputs, "> "+turn.input, "Resuming the canceled turn")
+	m.updateViewportContent()
+	return m.startTurn(llm, turn.config, "")
+}
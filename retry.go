@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultMaxAttempts is the number of times a request is sent when
+// max_attempts is not set in the profile
+const defaultMaxAttempts = 5
+
+// defaultRequestTimeout is how long a request may wait for the response of
+// the provider when request_timeout is not set in the profile
+const defaultRequestTimeout = 10 * time.Minute
+
+// requestTimeoutError cuts a request the provider did not answer in time,
+// which is retried like a failed connection
+type requestTimeoutError struct {
+	timeout time.Duration
+}
+
+func (e requestTimeoutError) Error() string {
+	return fmt.Sprintf("no response from the provider within %s", e.timeout)
+}
+
+// Delays between attempts double from retryBaseDelay up to retryMaxDelay,
+// unless the provider tells how long to wait with Retry-After
+const (
+	retryBaseDelay = time.Second
+	retryMaxDelay  = time.Minute
+)
+
+// Message showing that a request waits before being sent again, an empty
+// text once it is sent
+type retryStatusMsg struct {
+	text string
+}
+
+// retryableStatus tells whether a request failing with status may succeed
+// later: rate limits, server errors and overloaded models (529)
+func retryableStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+// postWithRetry sends the request built by newRequest once the rate limit
+// of the profile allows it, retrying rate limits, server errors and failed
+// connections with exponential backoff and jitter, or after the delay asked
+// by the provider. Each attempt gets request_timeout to be answered. A 429
+// pauses the other sessions using the key as well.
+// With several api_keys, a key that is rate limited or out of credits is
+// left aside and the request sent again at once with the next one.
+// It returns the last response with its body once the attempts are
+// exhausted, for the caller to report.
+func postWithRetry(ctx context.Context, config Config, provider string, newRequest func() (*http.Request, error)) (*http.Response, []byte, error) {
+	maxAttempts := config.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = defaultMaxAttempts
+	}
+	timeout := config.RequestTimeout
+	if timeout <= 0 {
+		timeout = defaultRequestTimeout
+	}
+	client, err := httpClient(config)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for attempt := 1; ; attempt++ {
+		req, err := newRequest()
+		if err != nil {
+			return nil, nil, err
+		}
+		keyConfig := config
+		if len(config.ApiKeys) > 1 {
+			keyConfig.ApiKey = GlobalKeys.Select(config.ApiKeys)
+			setRequestKey(req, keyConfig.ApiKey)
+		}
+		if err := waitRateLimit(ctx, keyConfig, req); err != nil {
+			return nil, nil, err
+		}
+		var sentBody []byte
+		if config.AuditLog || config.LogBodies {
+			sentBody = requestBody(req)
+		}
+		start := time.Now()
+		attemptCtx, cancel := context.WithTimeoutCause(ctx, timeout, requestTimeoutError{timeout})
+		resp, err := client.Do(req.WithContext(attemptCtx))
+		var body []byte
+		if err == nil {
+			body, err = io.ReadAll(resp.Body)
+			resp.Body.Close()
+		}
+		if err != nil && attemptCtx.Err() != nil {
+			err = context.Cause(attemptCtx)
+		}
+		cancel()
+		if config.AuditLog {
+			recordAudit(keyConfig, provider, attempt, req, sentBody, resp, body, err, time.Since(start))
+		}
+		if config.LogBodies {
+			logBodies(keyConfig, provider, attempt, req, sentBody, resp, body)
+		}
+		if ctx.Err() != nil {
+			return nil, nil, context.Cause(ctx)
+		}
+		if err == nil && len(config.ApiKeys) > 1 && attempt < maxAttempts {
+			if pause, ok := keyExhausted(resp, body, attempt); ok && GlobalKeys.Exhaust(config.ApiKeys, keyConfig.ApiKey, time.Now().Add(pause)) {
+				slog.Warn("Switching to the next API key", "provider", provider, "key", keyLabel(keyConfig.ApiKey), "status", resp.Status, "pause", pause)
+				continue
+			}
+		}
+		if err == nil && !retryableStatus(resp.StatusCode) {
+			return resp, body, nil
+		}
+		if attempt == maxAttempts {
+			return resp, body, err
+		}
+
+		reason := ""
+		delay := retryDelay(attempt, nil)
+		if err != nil {
+			reason = err.Error()
+		} else {
+			reason = strings.TrimSpace(resp.Status)
+			delay = retryDelay(attempt, resp.Header)
+			if resp.StatusCode == http.StatusTooManyRequests {
+				pauseRateLimit(keyConfig, req.URL.Host, delay)
+			}
+		}
+		slog.Warn("Request failed, retrying", "provider", provider, "reason", reason, "delay", delay, "attempt", attempt, "max_attempts", maxAttempts)
+		reportRetry(fmt.Sprintf("%s: %s, retrying in %s (attempt %d of %d)", provider, reason, delay.Round(time.Second), attempt+1, maxAttempts))
+		select {
+		case <-ctx.Done():
+			reportRetry("")
+			return nil, nil, context.Cause(ctx)
+		case <-time.After(delay):
+		}
+		reportRetry("")
+	}
+}
+
+// retryDelay returns how long to wait before attempt+1: the delay given by
+// the retry-after-ms or Retry-After headers, in seconds or as a date, or
+// else a doubling delay with jitter so that clients do not retry together
+func retryDelay(attempt int, header http.Header) time.Duration {
+	if header != nil {
+		if ms, err := strconv.Atoi(header.Get("retry-after-ms")); err == nil && ms > 0 {
+			return time.Duration(ms) * time.Millisecond
+		}
+		value := header.Get("Retry-After")
+		if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+			return time.Duration(seconds) * time.Second
+		}
+		if date, err := http.ParseTime(value); err == nil && time.Until(date) > 0 {
+			return time.Until(date)
+		}
+	}
+
+	delay := min(retryBaseDelay<<(attempt-1), retryMaxDelay)
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// reportRetry shows the retry status in the UI, to the parent agent or on stderr
+func reportRetry(text string) {
+	switch {
+	case programRef != nil:
+		programRef.Send(retryStatusMsg{text: text})
+	case text == "":
+	case os.Getenv(agentProgressEnv) != "":
+		reportAgentProgress("%s", text)
+	default:
+		fmt.Fprintln(os.Stderr, text)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// fileReview holds a file content proposed by the model until the user
+// accepts, edits or discards it
+type fileReview struct {
+	path     string
+	original string // Empty when the file doesn't exist yet
+	proposed string
+}
+
+// Message sent when the proposed content was edited in $EDITOR
+type reviewEditedMsg struct {
+	content string
+	err     error
+}
+
+// initReviewInstructions asks the model to reply with the file instead of writing it
+const initReviewInstructions = "\n\nDo not write the file yourself: reply with the complete content of the proposed AI.md only, without code fences or any other commentary."
+
+// reviewHelp is shown in the status line while a review is pending
+const reviewHelp = "Review AI.md | a accept, c accept and commit, e edit, esc discard"
+
+// startReview shows the proposal as a diff against the current file content
+func (m *chatModel) startReview(path, proposed string) {
+	original, _ := os.ReadFile(path)
+	m.review = &fileReview{path: path, original: string(original), proposed: stripCodeFence(proposed)}
+	m.showReviewDiff()
+}
+
+// showReviewDiff prints the diff of the pending review
+func (m *chatModel) showReviewDiff() {
+	header := "Proposed " + m.review.path
+	if m.review.original != "" {
+		header += " (changes against the existing file)"
+	}
+	m.outputs = append(m.outputs, header+":\n"+renderDiff(m.review.original, m.review.proposed))
+	m.updateViewportContent()
+}
+
+// handleReviewKey processes a key press while a review is pending
+func (m *chatModel) handleReviewKey(msg tea.KeyMsg) tea.Cmd {
+	switch {
+	case msg.String() == "a":
+		m.applyReview(false)
+	case msg.String() == "c":
+		m.applyReview(true)
+	case msg.String() == "e":
+		return editInEditor(m.review.proposed)
+	case msg.Type == tea.KeyEsc:
+		m.outputs = append(m.outputs, "Discarded proposed "+m.review.path)
+		m.review = nil
+		m.updateViewportContent()
+	}
+	return nil
+}
+
+// applyReview writes the proposed content and optionally commits it
+func (m *chatModel) applyReview(commit bool) {
+	review := m.review
+	m.review = nil
+	defer m.updateViewportContent()
+
+	_, statErr := os.Stat(review.path)
+	if err := os.WriteFile(review.path, []byte(review.proposed), 0644); err != nil {
+		m.outputs = append(m.outputs, fmt.Sprintf("Failed to write %s: %v", review.path, err))
+		return
+	}
+	GlobalFileTracker.RecordWrite(review.path, []byte(review.proposed))
+	GlobalChangeLedger.RecordWrite(review.path, statErr == nil, "/init")
+	m.outputs = append(m.outputs, "Wrote "+review.path)
+
+	if !commit {
+		return
+	}
+	message := "Add " + review.path
+	if review.original != "" {
+		message = "Update " + review.path
+	}
+	if output, err := exec.Command("git", "add", "--", review.path).CombinedOutput(); err != nil {
+		m.outputs = append(m.outputs, fmt.Sprintf("Failed to stage %s: %v\n%s", review.path, err, output))
+		return
+	}
+	output, err := exec.Command("git", "commit", "-m", message, "--", review.path).CombinedOutput()
+	if err != nil {
+		m.outputs = append(m.outputs, fmt.Sprintf("Failed to commit %s: %v\n%s", review.path, err, output))
+		return
+	}
+	m.outputs = append(m.outputs, strings.TrimSpace(string(output)))
+}
+
+// editInEditor suspends the UI to edit content in $EDITOR and sends back the result
+func editInEditor(content string) tea.Cmd {
+	file, err := os.CreateTemp("", "aicode-review-*.md")
+	if err != nil {
+		return func() tea.Msg { return reviewEditedMsg{err: err} }
+	}
+	_, err = file.WriteString(content)
+	file.Close()
+	if err != nil {
+		return func() tea.Msg { return reviewEditedMsg{err: err} }
+	}
+
+	editor := os.Getenv("EDITOR")
+	if editor == "" {
+		editor = "vi"
+	}
+	args := append(strings.Fields(editor), file.Name())
+	return tea.ExecProcess(exec.Command(args[0], args[1:]...), func(err error) tea.Msg {
+		defer os.Remove(file.Name())
+		if err != nil {
+			return reviewEditedMsg{err: err}
+		}
+		edited, err := os.ReadFile(file.Name())
+		return reviewEditedMsg{content: string(edited), err: err}
+	})
+}
+
+// stripCodeFence removes a code fence wrapping the whole text and the blank
+// lines around it, keeping the indentation of the first line
+func stripCodeFence(text string) string {
+	lines := strings.Split(text, "\n")
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) >= 2 && strings.HasPrefix(strings.TrimSpace(lines[0]), "```") && strings.TrimSpace(lines[len(lines)-1]) == "```" {
+		lines = lines[1 : len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
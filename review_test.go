@@ -0,0 +1,23 @@
+package main
+
+import "testing"
+
+func TestStripCodeFence(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"indented hunk", "\n    return x\n  }\n\n", "    return x\n  }\n"},
+		{"fenced", "```go\n\tif ok {\n\t\treturn\n\t}\n```\n", "\tif ok {\n\t\treturn\n\t}\n"},
+		{"fence after blank lines", "\n\n```\n  pass\n```", "  pass\n"},
+		{"empty", "  \n", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := stripCodeFence(tt.text); got != tt.want {
+				t.Fatalf("stripCodeFence(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
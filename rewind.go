@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/mattn/go-runewidth"
+)
+
+// doubleEscInterval is the maximum delay between two ESC presses to count as a double-ESC
+const doubleEscInterval = time.Second
+
+// messagePicker lets the user select one of the previous prompts to edit and resubmit
+type messagePicker struct {
+	messages []string
+	selected int
+}
+
+// handleIdleEsc implements the double-ESC behaviour when no operation is running:
+// it clears the input if there is any, otherwise opens the previous message picker
+func (m *chatModel) handleIdleEsc() {
+	now := time.Now().UnixNano()
+	if now-m.lastEscTimestamp >= int64(doubleEscInterval) {
+		m.lastEscTimestamp = now
+		return
+	}
+	m.lastEscTimestamp = 0
+
+	if strings.TrimSpace(m.textarea.Value()) != "" {
+		m.textarea.Reset()
+		return
+	}
+
+	messages := m.llm.UserMessages()
+	if len(messages) == 0 {
+		return
+	}
+	m.picker = &messagePicker{messages: messages, selected: len(messages) - 1}
+}
+
+// handlePickerKey processes a key press while the previous message picker is open
+func (m *chatModel) handlePickerKey(msg tea.KeyMsg) {
+	switch msg.Type {
+	case tea.KeyUp:
+		if m.picker.selected > 0 {
+			m.picker.selected--
+		}
+	case tea.KeyDown:
+		if m.picker.selected < len(m.picker.messages)-1 {
+			m.picker.selected++
+		}
+	case tea.KeyEsc:
+		m.picker = nil
+	case tea.KeyEnter:
+		m.rewindTo(m.picker.selected)
+		m.picker = nil
+	}
+}
+
+// rewindTo drops the conversation from the user message with the given index
+// onwards and puts that message back into the input for editing
+func (m *chatModel) rewindTo(index int) {
+	message := m.picker.messages[index]
+	m.llm.Rewind(index)
+
+	// The provider history may have been summarized, so match prompts
+	// from the end of the transcript rather than from the beginning. A
+	// history restored with -continue or summarized holds messages the
+	// transcript never showed, then every turn shown is dropped.
+	dropped := len(m.picker.messages) - index
+	if dropped <= len(m.promptOutputs) {
+		cut := len(m.promptOutputs) - dropped
+		m.outputs = m.outputs[:m.promptOutputs[cut]]
+		m.promptOutputs = m.promptOutputs[:cut]
+	} else {
+		if len(m.promptOutputs) > 0 {
+			m.outputs = m.outputs[:m.promptOutputs[0]]
+		} else {
+			m.outputs = getInitialMsgs(&m.llm)
+		}
+		m.promptOutputs = nil
+		m.outputs = append(m.outputs, fmt.Sprintf("[Rewound to message %d of %d, the earlier messages are not shown]", index+1, len(m.picker.messages)))
+	}
+	m.dropThinking()
+	m.dropBatches()
+
+	m.textarea.SetValue(message)
+	m.updateViewportContent()
+}
+
+// View renders the picker in place of the input area
+func (p *messagePicker) View(width, height int) string {
+	selectedStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("205")).Bold(true)
+
+	start := p.selected - height + 1
+	if start < 0 {
+		start = 0
+	}
+	end := start + height
+	if end > len(p.messages) {
+		end = len(p.messages)
+	}
+
+	var lines []string
+	for i := start; i < end; i++ {
+		line := strings.ReplaceAll(p.messages[i], "\n", " ")
+		if width > 10 {
+			line = runewidth.Truncate(line, width-4, "...")
+		}
+		if i == p.selected {
+			lines = append(lines, selectedStyle.Render("> "+line))
+		} else {
+			lines = append(lines, "  "+line)
+		}
+	}
+	for len(lines) < height {
+		lines = append(lines, "")
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// templateVars collects the repeated --var key=value flags
+type templateVars map[string]string
+
+func (v templateVars) String() string {
+	var pairs []string
+	for key, value := range v {
+		pairs = append(pairs, key+"="+value)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (v templateVars) Set(pair string) error {
+	key, value, ok := strings.Cut(pair, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("expected key=value, got %q", pair)
+	}
+	v[key] = value
+	return nil
+}
+
+// templatePath returns the file of a prompt template, taken as a path when it
+// exists and looked up in ~/.config/aicode/templates otherwise
+func templatePath(name string) string {
+	if _, err := os.Stat(name); err == nil {
+		return name
+	}
+	if filepath.Ext(name) == "" {
+		name += ".md"
+	}
+	return filepath.Join(os.Getenv("HOME"), ".config/aicode/templates", name)
+}
+
+// runRun implements the run subcommand, running a prompt template in
+// non-interactive mode
+func runRun(args []string) int {
+	flags := flag.NewFlagSet("run", flag.ExitOnError)
+	templateFlag := flags.String("template", "", "Prompt template, a path or a name in ~/.config/aicode/templates")
+	vars := templateVars{}
+	flags.Var(vars, "var", "Template variable as key=value, available as {{.key}} (repeatable)")
+	configFlag := flags.String("p", defaultProfile, "Profile/config file")
+	toolsFlag := flags.String("tools", "", "Comma-separated list of tools to enable (default: all tools)")
+	quietFlag := flags.Bool("q", false, "Only print the final response")
+	outputFlag := flags.String("output", "", "Output format: text, json or stream-json")
+	debugFlag := flags.Bool("d", false, "Enable debug logging")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: aicode run --template name [--var key=value]... [args]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if *templateFlag == "" {
+		flags.Usage()
+		return 2
+	}
+	content, err := os.ReadFile(templatePath(*templateFlag))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading template: %v\n", err)
+		return 1
+	}
+	settings, body, err := parseFrontMatter(string(content))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading template: %v\n", err)
+		return 1
+	}
+	// The remaining arguments are available as {{.ARGS}}, as for custom commands
+	prompt, err := processCommandTemplate(body, strings.Join(flags.Args(), " "), vars)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error processing template: %v\n", err)
+		return 1
+	}
+
+	config, err := LoadConfig(selectProfile(flags, *configFlag))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
+		return 1
+	}
+	settings.apply(&config)
+	config.Quiet = config.Quiet || *quietFlag
+	config.Debug = config.Debug || *debugFlag
+	config.NonInteractive = true
+	if *outputFlag != "" {
+		config.OutputFormat = *outputFlag
+	}
+	if err := checkOutputFormat(config.OutputFormat); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	config.InitialPrompt = prompt
+
+	llm, err := setupSession(&config, *toolsFlag)
+	defer LogFile.Close()
+	if err != nil {
+		slog.Error("Failed to initialize LLM provider", "error", err)
+		return 1
+	}
+	runSimpleMode(llm, config)
+	return 0
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultToolConcurrency limits how many calls of each tool may run at once
+var defaultToolConcurrency = map[string]int{
+	"View":          4,
+	"Grep":          4,
+	"FindFiles":     4,
+	"Ls":            4,
+	"SummarizeFile": 2,
+	"Explore":       2,
+	"Fetch":         2,
+	"Simulacrum":    2,
+	"Bash":          1,
+	"Edit":          1,
+	"Replace":       1,
+}
+
+// defaultMaxParallelTools caps the number of tool calls running at once across all tools
+const defaultMaxParallelTools = 8
+
+// toolSlotsEnv names the file in which a session and its sub-agents count
+// the tool calls they run, set by the session for the sub-agents it starts
+const toolSlotsEnv = "AICODE_TOOL_SLOTS"
+
+// toolSlotsPoll is how often a tool call waiting for a slot taken by
+// another process of the session checks again
+const toolSlotsPoll = 50 * time.Millisecond
+
+// agentTools start sub-agents, which take slots for their own tool calls.
+// They are limited within their process only, so that a sub-agent never
+// waits for the slot held by the call that started it.
+var agentTools = map[string]bool{"Simulacrum": true}
+
+// toolSlotHolder is a tool call running in a process of the session
+type toolSlotHolder struct {
+	ID   string `json:"id"`
+	Tool string `json:"tool"`
+	Pid  int    `json:"pid"`
+}
+
+// toolSlotsState is shared by a session and its sub-agents
+type toolSlotsState struct {
+	Holders []toolSlotHolder `json:"holders"`
+}
+
+var toolSlotID atomic.Int64
+
+// toolScheduler enforces per-tool and global concurrency limits on tool
+// execution, shared by the main tool loop, Batch and sub-agents
+type toolScheduler struct {
+	mu     sync.Mutex
+	global chan struct{}
+	limits map[string]int
+	slots  map[string]chan struct{}
+	path   string // Slots shared with the other processes of the session, if any
+}
+
+// GlobalToolScheduler is the application-wide tool scheduler, configured by configureToolScheduler
+var GlobalToolScheduler = newToolScheduler(defaultMaxParallelTools, nil)
+
+// newToolScheduler creates a scheduler, limits override the default per-tool limits
+func newToolScheduler(maxParallel int, limits map[string]int) *toolScheduler {
+	if maxParallel <= 0 {
+		maxParallel = defaultMaxParallelTools
+	}
+	merged := map[string]int{}
+	for tool, limit := range defaultToolConcurrency {
+		merged[tool] = limit
+	}
+	for tool, limit := range limits {
+		merged[tool] = limit
+	}
+	return &toolScheduler{
+		global: make(chan struct{}, maxParallel),
+		limits: merged,
+		slots:  map[string]chan struct{}{},
+	}
+}
+
+// configureToolScheduler applies the concurrency limits of the profile and
+// shares them with the sub-agents of the session
+func configureToolScheduler(config Config) {
+	GlobalToolScheduler = newToolScheduler(config.MaxParallelTools, config.ToolConcurrency)
+	GlobalToolScheduler.path = toolSlotsPath()
+}
+
+// toolSlotsPath returns the slots file of the session, given by the parent
+// to sub-agents and named after the process otherwise, when the files of
+// sessions that are gone are removed
+func toolSlotsPath() string {
+	if path := os.Getenv(toolSlotsEnv); path != "" {
+		return path
+	}
+	dir := filepath.Join(os.TempDir(), "aicode-locks")
+	paths, _ := filepath.Glob(filepath.Join(dir, "toolslots-*.json"))
+	for _, path := range paths {
+		pid, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), "toolslots-"), ".json"))
+		if err == nil && !processAlive(pid) {
+			os.Remove(path)
+		}
+	}
+	return filepath.Join(dir, fmt.Sprintf("toolslots-%d.json", os.Getpid()))
+}
+
+// toolSlots returns the semaphore of a tool, tools without a limit only use the global one
+func (s *toolScheduler) toolSlots(tool string) chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	limit, ok := s.limits[tool]
+	if !ok || limit <= 0 {
+		return nil
+	}
+	slots, ok := s.slots[tool]
+	if !ok {
+		slots = make(chan struct{}, limit)
+		s.slots[tool] = slots
+	}
+	return slots
+}
+
+// Acquire waits for a free slot for tool and returns the function releasing it
+func (s *toolScheduler) Acquire(ctx context.Context, tool string) (func(), error) {
+	slots := s.toolSlots(tool)
+	if slots != nil {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	select {
+	case s.global <- struct{}{}:
+	case <-ctx.Done():
+		if slots != nil {
+			<-slots
+		}
+		return nil, ctx.Err()
+	}
+
+	releaseShared, err := s.acquireShared(ctx, tool)
+	if err != nil {
+		<-s.global
+		if slots != nil {
+			<-slots
+		}
+		return nil, err
+	}
+
+	return func() {
+		releaseShared()
+		<-s.global
+		if slots != nil {
+			<-slots
+		}
+	}, nil
+}
+
+// acquireShared waits until the tool calls running in all processes of the
+// session leave a slot for tool and takes it
+func (s *toolScheduler) acquireShared(ctx context.Context, tool string) (func(), error) {
+	if s.path == "" || agentTools[tool] {
+		return func() {}, nil
+	}
+	holder := toolSlotHolder{ID: fmt.Sprintf("%d-%d", os.Getpid(), toolSlotID.Add(1)), Tool: tool, Pid: os.Getpid()}
+	limit := s.limits[tool]
+	for {
+		taken := false
+		err := updateLockedJSON(s.path, func(state *toolSlotsState) bool {
+			state.Holders = slices.DeleteFunc(state.Holders, func(h toolSlotHolder) bool { return !processAlive(h.Pid) })
+			running := 0
+			for _, h := range state.Holders {
+				if h.Tool == tool {
+					running++
+				}
+			}
+			if len(state.Holders) >= cap(s.global) || (limit > 0 && running >= limit) {
+				return true
+			}
+			state.Holders = append(state.Holders, holder)
+			taken = true
+			return true
+		})
+		if err != nil {
+			// Better to run beyond the limits of the session than to stop working
+			slog.Warn("Failed to share the tool slots", "error", err)
+			return func() {}, nil
+		}
+		if taken {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(toolSlotsPoll):
+		}
+	}
+
+	return func() {
+		updateLockedJSON(s.path, func(state *toolSlotsState) bool {
+			state.Holders = slices.DeleteFunc(state.Holders, func(h toolSlotHolder) bool { return h.ID == holder.ID })
+			return true
+		})
+	}, nil
+}
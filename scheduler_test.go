@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestToolSchedulerSharesSlots(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "toolslots.json")
+	parent := newToolScheduler(2, nil)
+	parent.path = path
+	agent := newToolScheduler(2, nil)
+	agent.path = path
+
+	release, err := parent.Acquire(context.Background(), "Bash")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if _, err := agent.Acquire(ctx, "Bash"); err == nil {
+		t.Fatal("sub-agent ran Bash while the session ran it, want it to wait")
+	}
+	releaseView, err := agent.Acquire(context.Background(), "View")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The global limit of 2 is reached by the Bash and the View
+	ctx, cancel = context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if _, err := parent.Acquire(ctx, "Grep"); err == nil {
+		t.Fatal("ran a third tool call with max_parallel_tools 2")
+	}
+
+	// Sub-agent launches only count in their own process
+	releaseAgent, err := parent.Acquire(context.Background(), "Simulacrum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	releaseAgent()
+
+	release()
+	releaseBash, err := agent.Acquire(context.Background(), "Bash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	releaseBash()
+	releaseView()
+}
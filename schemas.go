@@ -63,3 +63,15 @@ var FetchToolSchema string
 
 //go:embed tools/grep.json
 var GrepSchema string
+
+//go:embed tools/summarize_file.md
+var SummarizeFileDescription string
+
+//go:embed tools/summarize_file.json
+var SummarizeFileSchema string
+
+//go:embed tools/explore.md
+var ExploreDescription string
+
+//go:embed tools/explore.json
+var ExploreSchema string
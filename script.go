@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// scriptWaitTimeout bounds the wait of a script for the end of a turn
+const scriptWaitTimeout = 2 * time.Minute
+
+// scriptPoll is how often a waiting script checks whether the turn ended
+const scriptPoll = 50 * time.Millisecond
+
+// Message asking the script model to print the current frame
+type scriptFrameMsg struct {
+	label string
+	done  chan struct{}
+}
+
+// Message asking the script model to tell when no turn is running
+type scriptIdleMsg struct {
+	done chan struct{}
+}
+
+// scriptModel wraps the chat model of a script run, printing its frames on
+// request instead of drawing them on a terminal
+type scriptModel struct {
+	model  chatModel
+	out    io.Writer
+	frames int
+}
+
+func (s scriptModel) Init() tea.Cmd {
+	return s.model.Init()
+}
+
+func (s scriptModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case scriptFrameMsg:
+		s.frames++
+		s.model.updateViewportContent()
+		header := fmt.Sprintf("--- frame %d", s.frames)
+		if msg.label != "" {
+			header += " " + msg.label
+		}
+		fmt.Fprintf(s.out, "%s ---\n%s\n", header, stripAnsi(s.model.View()))
+		close(msg.done)
+		return s, nil
+	case scriptIdleMsg:
+		if !s.model.processing {
+			close(msg.done)
+			return s, nil
+		}
+		return s, tea.Tick(scriptPoll, func(time.Time) tea.Msg { return msg })
+	}
+	model, cmd := s.model.Update(msg)
+	s.model = model.(chatModel)
+	return s, cmd
+}
+
+func (s scriptModel) View() string {
+	return ""
+}
+
+// scriptKeys are the keys a script can press, by the names bubbletea gives them
+var scriptKeys = func() map[string]tea.KeyType {
+	keys := map[string]tea.KeyType{}
+	for k := tea.KeyType(-100); k <= 127; k++ {
+		if name := k.String(); name != "" && k != tea.KeyRunes {
+			keys[name] = k
+		}
+	}
+	return keys
+}()
+
+// runScriptMode runs the interactive UI without a terminal, feeding it the
+// keystrokes of a script and printing the frames it asks for, so that the
+// UI can be tested automatically. A script has one command per line:
+//
+//	type TEXT            types TEXT into the input
+//	key NAME...          presses keys, e.g. enter, tab, up, pgdown, ctrl+c, alt+enter
+//	resize WIDTH HEIGHT  resizes the window, 80x24 at the start
+//	sleep DURATION       waits, e.g. 500ms
+//	wait [DURATION]      waits for the running turn to end, 2 minutes at most
+//	frame [LABEL]        prints the screen without colors
+//
+// Empty lines and lines starting with # are ignored.
+func runScriptMode(llm Llm, config Config, path string) error {
+	var input io.Reader = os.Stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		input = file
+	}
+	commands := map[int]string{}
+	var lines []int
+	scanner := bufio.NewScanner(input)
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			commands[number] = line
+			lines = append(lines, number)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	p := tea.NewProgram(scriptModel{model: initialChatModel(llm, config), out: os.Stdout},
+		tea.WithInput(nil), tea.WithOutput(io.Discard))
+	programRef = p
+
+	scriptErr := make(chan error, 1)
+	finished := make(chan struct{})
+	go func() {
+		defer p.Quit()
+		p.Send(tea.WindowSizeMsg{Width: 80, Height: 24})
+		for _, number := range lines {
+			if err := runScriptCommand(p, finished, commands[number]); err != nil {
+				scriptErr <- fmt.Errorf("line %d: %s: %v", number, commands[number], err)
+				return
+			}
+		}
+		scriptErr <- nil
+	}()
+
+	_, err := p.Run()
+	removePastedImages()
+	close(finished)
+	if err != nil {
+		return err
+	}
+	return <-scriptErr
+}
+
+// runScriptCommand sends the messages of one command of a script, failing
+// when the UI exits before answering them
+func runScriptCommand(p *tea.Program, finished <-chan struct{}, command string) error {
+	name, args, _ := strings.Cut(command, " ")
+	switch name {
+	case "type":
+		p.Send(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(args)})
+	case "key":
+		for _, key := range strings.Fields(args) {
+			alt := strings.HasPrefix(key, "alt+")
+			keyType, ok := scriptKeys[strings.TrimPrefix(key, "alt+")]
+			if !ok {
+				return fmt.Errorf("unknown key %q", key)
+			}
+			p.Send(tea.KeyMsg{Type: keyType, Alt: alt})
+		}
+	case "resize":
+		fields := strings.Fields(args)
+		if len(fields) != 2 {
+			return fmt.Errorf("expected a width and a height")
+		}
+		width, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return err
+		}
+		height, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return err
+		}
+		p.Send(tea.WindowSizeMsg{Width: width, Height: height})
+	case "sleep":
+		delay, err := time.ParseDuration(args)
+		if err != nil {
+			return err
+		}
+		time.Sleep(delay)
+	case "wait":
+		timeout := scriptWaitTimeout
+		if args != "" {
+			var err error
+			if timeout, err = time.ParseDuration(args); err != nil {
+				return err
+			}
+		}
+		done := make(chan struct{})
+		p.Send(scriptIdleMsg{done: done})
+		select {
+		case <-done:
+		case <-finished:
+			return fmt.Errorf("the UI exited")
+		case <-time.After(timeout):
+			return fmt.Errorf("the turn did not end within %s", timeout)
+		}
+	case "frame":
+		done := make(chan struct{})
+		p.Send(scriptFrameMsg{label: args, done: done})
+		select {
+		case <-done:
+		case <-finished:
+			return fmt.Errorf("the UI exited")
+		}
+	default:
+		return fmt.Errorf("unknown command, expected type, key, resize, sleep, wait or frame")
+	}
+	return nil
+}
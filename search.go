@@ -0,0 +1,275 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/x/ansi"
+)
+
+// transcriptSearch holds the state of a search within the rendered conversation
+type transcriptSearch struct {
+	active  bool   // Search mode is on and matches are highlighted
+	editing bool   // The query is still being typed
+	query   string // Current search query
+	matches []int  // Viewport line numbers containing a match
+	current int    // Index into matches of the focused match
+}
+
+// searchMatchStart starts the highlight of a match, reversing its colors
+var searchMatchStart = ansi.Style{}.Reverse().String()
+
+// startSearch enters search mode and starts capturing the query
+func (m *chatModel) startSearch() {
+	m.search = transcriptSearch{active: true, editing: true}
+	m.updateViewportContent()
+}
+
+// stopSearch leaves search mode and removes highlights
+func (m *chatModel) stopSearch() {
+	m.search = transcriptSearch{}
+	m.updateViewportContent()
+}
+
+// handleSearchKey processes a key press while search mode is active.
+// It returns false if the key should be handled by the regular key bindings.
+func (m *chatModel) handleSearchKey(msg tea.KeyMsg) bool {
+	switch msg.Type {
+	case tea.KeyCtrlC, tea.KeyCtrlQ:
+		return false
+	case tea.KeyEsc:
+		m.stopSearch()
+		return true
+	}
+
+	if m.search.editing {
+		switch msg.Type {
+		case tea.KeyRunes, tea.KeySpace:
+			m.search.query += string(msg.Runes)
+		case tea.KeyBackspace:
+			runes := []rune(m.search.query)
+			if len(runes) > 0 {
+				m.search.query = string(runes[:len(runes)-1])
+			}
+		case tea.KeyEnter:
+			if m.search.query == "" {
+				// An empty query matches every line and highlights none
+				m.stopSearch()
+				return true
+			}
+			m.search.editing = false
+			m.search.current = 0
+			m.updateViewportContent()
+			m.scrollToMatch()
+		}
+		return true
+	}
+
+	switch msg.String() {
+	case "n":
+		m.nextMatch(1)
+		return true
+	case "N":
+		m.nextMatch(-1)
+		return true
+	case "/":
+		m.search.editing = true
+		m.search.query = ""
+		m.updateViewportContent()
+		return true
+	}
+	return false
+}
+
+// nextMatch moves the focus to the next (dir > 0) or previous (dir < 0) match
+func (m *chatModel) nextMatch(dir int) {
+	if len(m.search.matches) == 0 {
+		return
+	}
+	m.search.current = (m.search.current + dir + len(m.search.matches)) % len(m.search.matches)
+	m.scrollToMatch()
+}
+
+// scrollToMatch positions the viewport so the focused match is visible
+func (m *chatModel) scrollToMatch() {
+	if len(m.search.matches) == 0 {
+		return
+	}
+	line := m.search.matches[m.search.current]
+	m.showWindow(line - m.viewport.Height/2)
+}
+
+// searchStatus returns the status line text describing the current search
+func (m *chatModel) searchStatus() string {
+	if m.search.editing {
+		return "Search: " + m.search.query + "█"
+	}
+	if len(m.search.matches) == 0 {
+		return fmt.Sprintf("Search: %s (no matches) | / new search, esc exit", m.search.query)
+	}
+	return fmt.Sprintf("Search: %s (%d/%d) | n/N next/prev, / new search, esc exit",
+		m.search.query, m.search.current+1, len(m.search.matches))
+}
+
+// matchingLines returns the transcript lines whose text matches query. The
+// lines of each output are searched once per query, and again only when the
+// output changes, so that streaming does not search the whole transcript.
+func (r *renderCache) matchingLines(query string) []int {
+	if r.query != query {
+		r.query = query
+		r.matches = nil
+	}
+	var re *regexp.Regexp
+	var matches []int
+	for i, lines := range r.lines {
+		if i == len(r.matches) {
+			r.matches = append(r.matches, nil)
+		}
+		if r.matches[i] == nil {
+			if re == nil {
+				re = searchPattern(query)
+			}
+			r.matches[i] = []int{}
+			for j, line := range lines {
+				if _, plain := splitEscapes(line); re.MatchString(plain) {
+					r.matches[i] = append(r.matches[i], j)
+				}
+			}
+		}
+		for _, j := range r.matches[i] {
+			matches = append(matches, r.starts[i]+j)
+		}
+	}
+	return matches
+}
+
+// highlightMatches highlights every occurrence of query in content and returns
+// the highlighted content together with the line numbers containing a match.
+// Matching is case-insensitive unless the query contains uppercase letters.
+// The query is matched against the text without its escape sequences, which
+// are kept around the highlights.
+func highlightMatches(content, query string) (string, []int) {
+	if query == "" {
+		return content, nil
+	}
+	re := searchPattern(query)
+
+	var matches []int
+	lines := strings.Split(content, "\n")
+	for i, line := range lines {
+		segments, plain := splitEscapes(line)
+		locs := re.FindAllStringIndex(plain, -1)
+		if len(locs) == 0 {
+			continue
+		}
+		lines[i] = highlightSegments(segments, locs)
+		matches = append(matches, i)
+	}
+
+	return strings.Join(lines, "\n"), matches
+}
+
+// searchPattern returns the expression matching query, ignoring case unless
+// the query contains uppercase letters
+func searchPattern(query string) *regexp.Regexp {
+	pattern := regexp.QuoteMeta(query)
+	if !strings.ContainsFunc(query, unicode.IsUpper) {
+		// Case folding keeps the offsets of the matches in the original line,
+		// unlike searching a lowered copy of lines such as "İ" or "ẞ"
+		pattern = "(?i)" + pattern
+	}
+	return regexp.MustCompile(pattern)
+}
+
+// styledSegment is a run of text or an escape sequence of a styled line,
+// at offset in the text of the line without escape sequences
+type styledSegment struct {
+	text   string
+	escape bool
+	offset int
+}
+
+// splitEscapes splits a styled line into text and escape sequences and
+// returns them with the text of the line without escape sequences
+func splitEscapes(line string) ([]styledSegment, string) {
+	var segments []styledSegment
+	var plain strings.Builder
+	var state byte
+	for len(line) > 0 {
+		seq, _, n, newState := ansi.DecodeSequence(line, state, nil)
+		state = newState
+		line = line[n:]
+		if strings.HasPrefix(seq, "\x1b") {
+			segments = append(segments, styledSegment{text: seq, escape: true, offset: plain.Len()})
+			continue
+		}
+		if last := len(segments) - 1; last >= 0 && !segments[last].escape {
+			segments[last].text += seq
+		} else {
+			segments = append(segments, styledSegment{text: seq, offset: plain.Len()})
+		}
+		plain.WriteString(seq)
+	}
+	return segments, plain.String()
+}
+
+// highlightSegments rebuilds a styled line highlighting the ranges locs of
+// its text. Each highlight ends with a reset followed by the styles active
+// at that point, so the rest of the line keeps its colors.
+func highlightSegments(segments []styledSegment, locs [][]int) string {
+	var b strings.Builder
+	var active []string // Styles set since the last reset
+	loc := 0
+	inMatch := false
+	for _, segment := range segments {
+		if segment.escape {
+			b.WriteString(segment.text)
+			active = trackStyle(active, segment.text)
+			if inMatch {
+				b.WriteString(searchMatchStart)
+			}
+			continue
+		}
+		text, offset := segment.text, segment.offset
+		for len(text) > 0 {
+			if loc < len(locs) && !inMatch && offset >= locs[loc][0] {
+				b.WriteString(searchMatchStart)
+				inMatch = true
+			}
+			end := offset + len(text)
+			if loc < len(locs) {
+				if inMatch {
+					end = min(end, locs[loc][1])
+				} else {
+					end = min(end, locs[loc][0])
+				}
+			}
+			b.WriteString(text[:end-offset])
+			text, offset = text[end-offset:], end
+			if inMatch && offset == locs[loc][1] {
+				b.WriteString(ansi.ResetStyle + strings.Join(active, ""))
+				inMatch = false
+				loc++
+			}
+		}
+	}
+	return b.String()
+}
+
+// trackStyle updates the styles active after the escape sequence seq
+func trackStyle(active []string, seq string) []string {
+	if !strings.HasPrefix(seq, "\x1b[") || !strings.HasSuffix(seq, "m") {
+		return active
+	}
+	params := seq[2 : len(seq)-1]
+	switch {
+	case params == "" || params == "0":
+		return nil
+	case strings.HasPrefix(params, "0;"):
+		return []string{seq}
+	}
+	return append(active, seq)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+func TestHighlightMatches(t *testing.T) {
+	content := "İstanbul: ERROR here\nnothing\nGROẞE STRAẞE\nerror (ERROR)"
+	tests := []struct {
+		query string
+		want  []int
+	}{
+		{"error", []int{0, 3}},
+		{"ERROR", []int{0, 3}},
+		{"Error", nil},
+		{"straße", []int{2}},
+		{"(error)", []int{3}},
+		{"missing", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			_, got := highlightMatches(content, tt.query)
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("matches on lines %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHighlightMatchesSkipsEscapes(t *testing.T) {
+	line := "\x1b[38;5;1mred error\x1b[m plain \x1b[1mbold\x1b[m"
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"38", line},
+		{"m", "\x1b[38;5;1mred error\x1b[m plain \x1b[1mbold\x1b[m"},
+		{";1", line},
+		{"error", "\x1b[38;5;1mred \x1b[7merror\x1b[m\x1b[38;5;1m\x1b[m plain \x1b[1mbold\x1b[m"},
+		{"d e", "\x1b[38;5;1mre\x1b[7md e\x1b[m\x1b[38;5;1mrror\x1b[m plain \x1b[1mbold\x1b[m"},
+		{"r plain b", "\x1b[38;5;1mred erro\x1b[7mr\x1b[m\x1b[7m plain \x1b[1m\x1b[7mb\x1b[m\x1b[1mold\x1b[m"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			got, _ := highlightMatches(line, tt.query)
+			if got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchingLinesFollowsChangedOutputs(t *testing.T) {
+	outputs := []string{"an error", "fine", "streaming"}
+	r := &renderCache{}
+	r.render(outputs, 40)
+	if got := r.matchingLines("error"); !slices.Equal(got, []int{0}) {
+		t.Fatalf("matches on lines %v, want [0]", got)
+	}
+
+	// Only the changed and added outputs are searched again
+	r.matches[0] = []int{}
+	outputs[1] = "fine\nanother error"
+	outputs = append(outputs, "last error")
+	r.render(outputs, 40)
+	if got := r.matchingLines("error"); !slices.Equal(got, []int{2, 4}) {
+		t.Fatalf("matches on lines %v, want [2 4]", got)
+	}
+	if got := r.matchingLines("fine"); !slices.Equal(got, []int{1}) {
+		t.Fatalf("matches of a new query on lines %v, want [1]", got)
+	}
+}
+
+func TestEmptySearchLeavesSearchMode(t *testing.T) {
+	m := chatModel{viewport: viewport.New(20, 5), rendered: &renderCache{}, outputs: []string{"one", "two"}}
+	m.startSearch()
+	m.handleSearchKey(tea.KeyMsg{Type: tea.KeyEnter})
+	if m.search.active || len(m.search.matches) > 0 {
+		t.Fatalf("search still active with matches %v", m.search.matches)
+	}
+}
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/goccy/go-yaml"
+)
+
+// version is the version of a release build, set by goreleaser with -X main.version
+var version string
+
+// releasePublicKey is the minisign public key signing the checksums.txt of
+// releases, set by goreleaser with -X main.releasePublicKey. Builds without it
+// only check downloads against checksums.txt.
+var releasePublicKey string
+
+// releaseFeedURL lists the latest release and its files
+const releaseFeedURL = "https://api.github.com/repos/paul-nameless/aicode/releases/latest"
+
+// releaseFeedEnv points to a mirror of the release feed, for networks
+// without access to GitHub
+const releaseFeedEnv = "AICODE_RELEASE_FEED"
+
+// updateCheckInterval is how often the interactive mode looks for a new release
+const updateCheckInterval = 24 * time.Hour
+
+// Message telling that a newer release is available
+type updateAvailableMsg struct {
+	version string
+}
+
+// release is the latest release as described by the feed
+type release struct {
+	TagName string         `json:"tag_name"`
+	Assets  []releaseAsset `json:"assets"`
+}
+
+// releaseAsset is a file of a release
+type releaseAsset struct {
+	Name string `json:"name"`
+	URL  string `json:"browser_download_url"`
+}
+
+// pseudoVersion matches the versions Go stamps on builds of a commit without
+// a release tag, such as v0.0.0-20250101120000-0123456789ab
+var pseudoVersion = regexp.MustCompile(`-(0\.)?\d{14}-[0-9a-f]{12}|\+dirty`)
+
+// currentVersion returns the version of the running binary, empty for
+// builds from source
+func currentVersion() string {
+	if version != "" {
+		return strings.TrimPrefix(version, "v")
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "(devel)" && !pseudoVersion.MatchString(info.Main.Version) {
+		return strings.TrimPrefix(info.Main.Version, "v")
+	}
+	return ""
+}
+
+// updateChecksEnabled tells whether the profile allows looking for and
+// installing new releases, on unless update_checks is false
+func updateChecksEnabled(config Config) bool {
+	return config.UpdateChecks == nil || *config.UpdateChecks
+}
+
+// newerVersion tells whether version a is newer than b, both as MAJOR.MINOR.PATCH
+func newerVersion(a, b string) bool {
+	parse := func(v string) [3]int {
+		var parts [3]int
+		for i, field := range strings.SplitN(strings.TrimPrefix(v, "v"), ".", 3) {
+			// Pre-release suffixes such as 1.2.0-rc1 are ignored
+			field, _, _ = strings.Cut(field, "-")
+			parts[i], _ = strconv.Atoi(field)
+		}
+		return parts
+	}
+	pa, pb := parse(a), parse(b)
+	for i := range pa {
+		if pa[i] != pb[i] {
+			return pa[i] > pb[i]
+		}
+	}
+	return false
+}
+
+// releaseArchiveName returns the archive of the release for this platform,
+// as named by .goreleaser.yml
+func releaseArchiveName(version string) string {
+	arch := runtime.GOARCH
+	switch arch {
+	case "amd64":
+		arch = "x86_64"
+	case "arm":
+		arch = "armv7"
+	}
+	goos := runtime.GOOS
+	return fmt.Sprintf("aicode_%s_%s_%s.tar.gz", version, strings.ToUpper(goos[:1])+goos[1:], arch)
+}
+
+// fetchLatestRelease reads the release feed
+func fetchLatestRelease(ctx context.Context, config Config) (release, error) {
+	var latest release
+	url := releaseFeedURL
+	if mirror := os.Getenv(releaseFeedEnv); mirror != "" {
+		url = mirror
+	}
+	data, err := download(ctx, config, url)
+	if err != nil {
+		return latest, err
+	}
+	if err := json.Unmarshal(data, &latest); err != nil {
+		return latest, fmt.Errorf("invalid release feed: %v", err)
+	}
+	if latest.TagName == "" {
+		return latest, errors.New("the release feed names no release")
+	}
+	return latest, nil
+}
+
+// download returns the body of url, through the proxy of the profile
+func download(ctx context.Context, config Config, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	client, err := httpClient(config)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s answered %s", url, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// releaseChecksum returns the SHA-256 of a file as listed in checksums.txt
+func releaseChecksum(checksums []byte, name string) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(checksums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[1] == name {
+			return fields[0], nil
+		}
+	}
+	return "", fmt.Errorf("checksums.txt has no checksum for %s", name)
+}
+
+// verifyMinisign checks a minisign signature of data against a public key,
+// both as written by minisign. Only the Ed25519 signatures of the whole data
+// made by minisign -l are supported, not the prehashed ones.
+func verifyMinisign(publicKey string, data, signature []byte) error {
+	key, err := minisignKey(publicKey)
+	if err != nil || len(key) != 42 || string(key[:2]) != "Ed" {
+		return errors.New("invalid public key")
+	}
+	// Untrusted comment, signature, trusted comment and its signature
+	lines := strings.Split(strings.TrimSpace(string(signature)), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[2], "trusted comment: ") {
+		return errors.New("invalid signature")
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[1]))
+	switch {
+	case err != nil || len(sig) != 74:
+		return errors.New("invalid signature")
+	case string(sig[:2]) == "ED":
+		return errors.New("prehashed signatures are not supported, sign with minisign -l")
+	case string(sig[:2]) != "Ed":
+		return errors.New("unknown signature algorithm")
+	case !bytes.Equal(sig[2:10], key[2:10]):
+		return errors.New("signed with another key")
+	}
+	publicKeyBytes := ed25519.PublicKey(key[10:])
+	if !ed25519.Verify(publicKeyBytes, data, sig[10:]) {
+		return errors.New("the signature does not match")
+	}
+	// The trusted comment is signed along with the signature
+	globalSig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[3]))
+	comment := strings.TrimPrefix(strings.TrimRight(lines[2], "\r"), "trusted comment: ")
+	if err != nil || !ed25519.Verify(publicKeyBytes, slices.Concat(sig[10:], []byte(comment)), globalSig) {
+		return errors.New("the trusted comment does not match")
+	}
+	return nil
+}
+
+// minisignKey decodes a minisign public key, given as the file minisign
+// writes or as its last line alone
+func minisignKey(publicKey string) ([]byte, error) {
+	lines := strings.Split(strings.TrimSpace(publicKey), "\n")
+	return base64.StdEncoding.DecodeString(strings.TrimSpace(lines[len(lines)-1]))
+}
+
+// extractBinary returns the aicode binary of a release archive
+func extractBinary(archive []byte) ([]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return nil, err
+	}
+	reader := tar.NewReader(gz)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return nil, errors.New("the archive has no aicode binary")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Typeflag == tar.TypeReg && filepath.Base(header.Name) == "aicode" {
+			return io.ReadAll(reader)
+		}
+	}
+}
+
+// installRelease downloads the archive of the release for this platform,
+// checks it against the checksums of the release, signed with the key of
+// releases when the build has one, and replaces the running binary with the
+// one it holds
+func installRelease(ctx context.Context, config Config, latest release) error {
+	if releasePublicKey == "" && os.Getenv(releaseFeedEnv) != "" {
+		return fmt.Errorf("this build has no key to verify releases, not installing from the mirror in %s", releaseFeedEnv)
+	}
+	assets := map[string]string{}
+	for _, asset := range latest.Assets {
+		assets[asset.Name] = asset.URL
+	}
+	name := releaseArchiveName(strings.TrimPrefix(latest.TagName, "v"))
+	if assets[name] == "" {
+		return fmt.Errorf("release %s has no build for %s/%s", latest.TagName, runtime.GOOS, runtime.GOARCH)
+	}
+	if assets["checksums.txt"] == "" {
+		return fmt.Errorf("release %s has no checksums.txt, not installing an unverified binary", latest.TagName)
+	}
+
+	checksums, err := download(ctx, config, assets["checksums.txt"])
+	if err != nil {
+		return err
+	}
+	if releasePublicKey != "" {
+		if assets["checksums.txt.minisig"] == "" {
+			return fmt.Errorf("release %s has no signature of its checksums.txt, not installing an unsigned binary", latest.TagName)
+		}
+		signature, err := download(ctx, config, assets["checksums.txt.minisig"])
+		if err != nil {
+			return err
+		}
+		if err := verifyMinisign(releasePublicKey, checksums, signature); err != nil {
+			return fmt.Errorf("invalid signature of the checksums.txt of release %s: %v", latest.TagName, err)
+		}
+	}
+	expected, err := releaseChecksum(checksums, name)
+	if err != nil {
+		return err
+	}
+	archive, err := download(ctx, config, assets[name])
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(archive)
+	if actual := hex.EncodeToString(sum[:]); actual != expected {
+		return fmt.Errorf("checksum mismatch for %s: expected %s, got %s", name, expected, actual)
+	}
+	binary, err := extractBinary(archive)
+	if err != nil {
+		return err
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if executable, err = filepath.EvalSymlinks(executable); err != nil {
+		return err
+	}
+	// The new binary is written next to the old one and renamed over it, so
+	// that an interrupted update leaves the old binary in place
+	tmp, err := os.CreateTemp(filepath.Dir(executable), ".aicode-update-*")
+	if err != nil {
+		return fmt.Errorf("cannot write to %s: %v", filepath.Dir(executable), err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(binary); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0755); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), executable)
+}
+
+// loadUpdateSettings reads the settings of the profile used by updates,
+// without requiring the model and API key a session needs
+func loadUpdateSettings(path string) (Config, error) {
+	var config Config
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return config, nil
+	}
+	if err != nil {
+		return config, err
+	}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("invalid profile %s: %v", path, err)
+	}
+	return config, nil
+}
+
+// runSelfUpdate implements the self-update subcommand, replacing the binary
+// with the latest release
+func runSelfUpdate(args []string) int {
+	flags := flag.NewFlagSet("self-update", flag.ExitOnError)
+	configFlag := flags.String("p", defaultProfile, "Profile/config file")
+	checkOnly := flags.Bool("check", false, "Only tell whether a newer release is available")
+	force := flags.Bool("force", false, "Install the latest release even if it is not newer, or over a build from source")
+	flags.Parse(args)
+
+	profile := selectProfile(flags, *configFlag)
+	config, err := loadUpdateSettings(profile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if !updateChecksEnabled(config) {
+		fmt.Fprintf(os.Stderr, "Updates are disabled by update_checks: false in %s\n", profile)
+		return 1
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	latest, err := fetchLatestRelease(ctx, config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error checking for updates: %v\n", err)
+		return 1
+	}
+	current := currentVersion()
+	available := current == "" || newerVersion(latest.TagName, current)
+	switch {
+	case *checkOnly && available:
+		fmt.Printf("aicode %s is available (installed: %s)\n", latest.TagName, versionLabel(current))
+		return 0
+	case *checkOnly || (!available && !*force):
+		fmt.Printf("aicode %s is up to date\n", versionLabel(current))
+		return 0
+	case current == "" && !*force:
+		fmt.Fprintln(os.Stderr, "This aicode was built from source, use -force to replace it with the latest release")
+		return 1
+	}
+
+	fmt.Printf("Installing aicode %s...\n", latest.TagName)
+	if err := installRelease(ctx, config, latest); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Updated aicode from %s to %s\n", versionLabel(current), latest.TagName)
+	return 0
+}
+
+// versionLabel names a version for messages, (devel) for builds from source
+func versionLabel(v string) string {
+	if v == "" {
+		return "(devel)"
+	}
+	return "v" + v
+}
+
+// updateCheckPath returns the file recording when the interactive mode last
+// looked for a new release
+func updateCheckPath() string {
+	return filepath.Join(filepath.Dir(defaultLogPath()), "update-check")
+}
+
+// checkForUpdate looks for a newer release once a day when the interactive
+// mode starts, in the background. Builds from source and profiles with
+// update_checks: false never check.
+func checkForUpdate(config Config) tea.Cmd {
+	current := currentVersion()
+	if current == "" || !updateChecksEnabled(config) {
+		return nil
+	}
+	path := updateCheckPath()
+	if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) < updateCheckInterval {
+		return nil
+	}
+	return func() tea.Msg {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		latest, err := fetchLatestRelease(ctx, config)
+		if err != nil {
+			return nil
+		}
+		os.WriteFile(path, []byte(latest.TagName+"\n"), 0644)
+		if !newerVersion(latest.TagName, current) {
+			return nil
+		}
+		return updateAvailableMsg{version: latest.TagName}
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// testKeyID is the key id of the minisign key of the tests
+var testKeyID = []byte{1, 2, 3, 4, 5, 6, 7, 8}
+
+// testMinisignKey returns a minisign key pair, the public key as the file
+// minisign -G writes
+func testMinisignKey(seed byte) (string, ed25519.PrivateKey) {
+	private := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{seed}, ed25519.SeedSize))
+	key := slices.Concat([]byte("Ed"), testKeyID, private.Public().(ed25519.PublicKey))
+	return "untrusted comment: minisign public key 0807060504030201\n" + base64.StdEncoding.EncodeToString(key) + "\n", private
+}
+
+// testMinisign signs data as minisign -l does, with the given algorithm and
+// trusted comment
+func testMinisign(private ed25519.PrivateKey, algorithm string, data []byte, comment string) string {
+	signed := data
+	if algorithm == "ED" {
+		hash := sha256.Sum256(data)
+		signed = hash[:]
+	}
+	sig := ed25519.Sign(private, signed)
+	globalSig := ed25519.Sign(private, slices.Concat(sig, []byte(comment)))
+	return fmt.Sprintf("untrusted comment: signature from minisign secret key\n%s\ntrusted comment: %s\n%s\n",
+		base64.StdEncoding.EncodeToString(slices.Concat([]byte(algorithm), testKeyID, sig)),
+		comment,
+		base64.StdEncoding.EncodeToString(globalSig))
+}
+
+func TestVerifyMinisign(t *testing.T) {
+	publicKey, private := testMinisignKey(1)
+	otherKey, _ := testMinisignKey(2)
+	checksums := []byte("0123abcd  aicode_1.2.0_Linux_x86_64.tar.gz\n")
+	valid := testMinisign(private, "Ed", checksums, "timestamp:1700000000\tfile:checksums.txt")
+
+	// A key with the same algorithm and another id
+	otherID, _ := minisignKey(publicKey)
+	otherID = slices.Clone(otherID)
+	otherID[2] ^= 0xff
+	wrongID := base64.StdEncoding.EncodeToString(otherID)
+
+	tests := []struct {
+		name      string
+		publicKey string
+		data      []byte
+		signature string
+		wantErr   string
+	}{
+		{"valid", publicKey, checksums, valid, ""},
+		{"valid with the key line alone", strings.Split(publicKey, "\n")[1], checksums, valid, ""},
+		{"wrong key id", wrongID, checksums, valid, "signed with another key"},
+		{"other key with the same id", otherKey, checksums, valid, "the signature does not match"},
+		{"tampered checksums", publicKey, []byte("ffff  aicode_1.2.0_Linux_x86_64.tar.gz\n"), valid, "the signature does not match"},
+		{"tampered trusted comment", publicKey, checksums, strings.Replace(valid, "timestamp:1700000000", "timestamp:1800000000", 1), "the trusted comment does not match"},
+		{"prehashed", publicKey, checksums, testMinisign(private, "ED", checksums, "timestamp:1700000000"), "prehashed signatures are not supported"},
+		{"missing lines", publicKey, checksums, strings.Join(strings.Split(valid, "\n")[:2], "\n"), "invalid signature"},
+		{"not base64", publicKey, checksums, strings.Replace(valid, "\n", "\n!!", 1), "invalid signature"},
+		{"invalid public key", "not a key", checksums, valid, "invalid public key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyMinisign(tt.publicKey, tt.data, []byte(tt.signature))
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReleaseChecksum(t *testing.T) {
+	checksums := []byte("aaaa  aicode_1.2.0_Darwin_arm64.tar.gz\nbbbb  aicode_1.2.0_Linux_x86_64.tar.gz\n")
+	if sum, err := releaseChecksum(checksums, "aicode_1.2.0_Linux_x86_64.tar.gz"); err != nil || sum != "bbbb" {
+		t.Fatalf("releaseChecksum = %q, %v, want bbbb", sum, err)
+	}
+	if _, err := releaseChecksum(checksums, "aicode_1.2.0_Windows_x86_64.tar.gz"); err == nil {
+		t.Fatal("a file missing from checksums.txt has a checksum")
+	}
+}
+
+// testArchive returns a release archive holding the given files
+func testArchive(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0755, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(content))
+	}
+	tw.Close()
+	gz.Close()
+	return buf.Bytes()
+}
+
+func TestExtractBinary(t *testing.T) {
+	binary, err := extractBinary(testArchive(t, map[string]string{"readme.md": "docs", "aicode_1.2.0/aicode": "binary"}))
+	if err != nil || string(binary) != "binary" {
+		t.Fatalf("extractBinary = %q, %v, want the aicode binary", binary, err)
+	}
+	if _, err := extractBinary(testArchive(t, map[string]string{"readme.md": "docs"})); err == nil {
+		t.Fatal("an archive without a binary is accepted")
+	}
+	if _, err := extractBinary([]byte("not an archive")); err == nil {
+		t.Fatal("a file that is not an archive is accepted")
+	}
+}
+
+func TestInstallReleaseRequiresSignature(t *testing.T) {
+	publicKey, _ := testMinisignKey(1)
+	defer func(key string) { releasePublicKey = key }(releasePublicKey)
+	releasePublicKey = publicKey
+
+	name := releaseArchiveName("1.2.0")
+	archive := testArchive(t, map[string]string{"aicode": "binary"})
+	sum := sha256.Sum256(archive)
+	files := map[string][]byte{
+		"/" + name:       archive,
+		"/checksums.txt": []byte(hex.EncodeToString(sum[:]) + "  " + name + "\n"),
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	latest := release{TagName: "v1.2.0", Assets: []releaseAsset{
+		{Name: name, URL: server.URL + "/" + name},
+		{Name: "checksums.txt", URL: server.URL + "/checksums.txt"},
+	}}
+	err := installRelease(context.Background(), Config{}, latest)
+	if err == nil || !strings.Contains(err.Error(), "no signature") {
+		t.Fatalf("error = %v, want a refusal of the unsigned release", err)
+	}
+}
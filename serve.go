@@ -0,0 +1,220 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-runewidth"
+)
+
+//go:embed serve.html
+var serveHTML []byte
+
+// serveActiveWindow is how recently a transcript must have been written for
+// its session to be shown as running
+const serveActiveWindow = 2 * time.Minute
+
+// sessionIDPattern matches the ids of sessionID, the only names served
+var sessionIDPattern = regexp.MustCompile(`^\d{8}-\d{6}$`)
+
+// sessionInfo describes a session in the list of the web UI
+type sessionInfo struct {
+	ID      string    `json:"id"`
+	Title   string    `json:"title"` // First prompt of the session
+	Updated time.Time `json:"updated"`
+	Entries int       `json:"entries"`
+	Notes   int       `json:"notes"`  // Notes attached to turns with /note
+	Active  bool      `json:"active"` // Transcript written recently, the session may be running
+}
+
+// sessionDetail is a session in the web UI, entries starting at an offset
+// so that running sessions are followed by polling
+type sessionDetail struct {
+	Entries []transcriptEntry `json:"entries"`
+	Total   int               `json:"total"`
+	Summary string            `json:"summary,omitempty"`
+	Active  bool              `json:"active"`
+}
+
+// runServe implements the serve subcommand, a read-only web UI of the
+// sessions of a directory, e.g. to follow an agent on a headless machine
+func runServe(args []string) int {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := flags.String("addr", "127.0.0.1:8787", "Address to listen on, the UI has no authentication and answers only requests for a loopback name or this host")
+	dir := flags.String("dir", ".", "Project directory whose sessions are shown")
+	flags.Parse(args)
+
+	projectDir, err := filepath.Abs(*dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	sessionsDir := transcriptsDir(projectDir)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(serveHTML)
+	})
+	mux.HandleFunc("GET /api/sessions", func(w http.ResponseWriter, r *http.Request) {
+		sessions, err := listSessions(sessionsDir)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, sessions)
+	})
+	mux.HandleFunc("GET /api/sessions/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		if !sessionIDPattern.MatchString(id) {
+			http.NotFound(w, r)
+			return
+		}
+		after, _ := strconv.Atoi(r.URL.Query().Get("after"))
+		summaryPath := filepath.Join(projectDir, ".aicode", "sessions", id, "SUMMARY.md")
+		detail, err := loadSessionDetail(filepath.Join(sessionsDir, id), summaryPath, after)
+		if errors.Is(err, fs.ErrNotExist) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, detail)
+	})
+
+	fmt.Printf("Serving the sessions of %s on http://%s\n", projectDir, *addr)
+	if err := http.ListenAndServe(*addr, checkHost(mux, *addr)); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// checkHost refuses requests whose Host header is neither a loopback name
+// nor the host of addr, so that pages using DNS rebinding against the local
+// address cannot read the transcripts
+func checkHost(next http.Handler, addr string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !allowedHost(r.Host, addr) {
+			http.Error(w, "Forbidden host", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allowedHost reports whether the Host header host names the server
+// listening on addr
+func allowedHost(host, addr string) bool {
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		host = name
+	}
+	host = strings.ToLower(strings.Trim(host, "[]"))
+	if host == "localhost" {
+		return true
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return true
+	}
+	listen, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	listen = strings.ToLower(strings.Trim(listen, "[]"))
+	return listen != "" && host == listen
+}
+
+// listSessions returns the sessions with a transcript, most recent first
+func listSessions(sessionsDir string) ([]sessionInfo, error) {
+	dirs, err := os.ReadDir(sessionsDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return []sessionInfo{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := []sessionInfo{}
+	for _, dir := range dirs {
+		if !dir.IsDir() || !sessionIDPattern.MatchString(dir.Name()) {
+			continue
+		}
+		path := filepath.Join(sessionsDir, dir.Name(), "transcript.jsonl")
+		stat, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		entries, err := readTranscript(path)
+		if err != nil {
+			continue
+		}
+		info := sessionInfo{
+			ID:      dir.Name(),
+			Updated: stat.ModTime(),
+			Entries: len(entries),
+			Active:  time.Since(stat.ModTime()) < serveActiveWindow,
+		}
+		for _, entry := range entries {
+			if entry.Type == "note" {
+				info.Notes++
+			}
+			if entry.Type == "prompt" && info.Title == "" {
+				info.Title = strings.Join(strings.Fields(entry.Text), " ")
+				info.Title = runewidth.Truncate(info.Title, 80, "...")
+			}
+		}
+		sessions = append(sessions, info)
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].Updated.After(sessions[j].Updated) })
+	return sessions, nil
+}
+
+// loadSessionDetail returns the transcript entries of a session from after
+// on, with the summary written by /summary if any
+func loadSessionDetail(dir, summaryPath string, after int) (sessionDetail, error) {
+	path := filepath.Join(dir, "transcript.jsonl")
+	stat, err := os.Stat(path)
+	if err != nil {
+		return sessionDetail{}, err
+	}
+	entries, err := readTranscript(path)
+	if err != nil {
+		return sessionDetail{}, err
+	}
+
+	detail := sessionDetail{
+		Entries: []transcriptEntry{},
+		Total:   len(entries),
+		Active:  time.Since(stat.ModTime()) < serveActiveWindow,
+	}
+	if after >= 0 && after < len(entries) {
+		detail.Entries = entries[after:]
+	}
+	if summary, err := os.ReadFile(summaryPath); err == nil {
+		detail.Summary = string(summary)
+	}
+	return detail, nil
+}
+
+// writeJSON answers a request of the web UI
+func writeJSON(w http.ResponseWriter, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
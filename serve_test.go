@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/mattn/go-runewidth"
+)
+
+func TestAllowedHost(t *testing.T) {
+	tests := []struct {
+		host, addr string
+		want       bool
+	}{
+		{"127.0.0.1:8787", "127.0.0.1:8787", true},
+		{"localhost:8787", "127.0.0.1:8787", true},
+		{"[::1]:8787", "127.0.0.1:8787", true},
+		{"LOCALHOST", "127.0.0.1:8787", true},
+		{"evil.example.com:8787", "127.0.0.1:8787", false},
+		{"192.168.1.5:8787", "192.168.1.5:8787", true},
+		{"devbox:8787", "devbox:8787", true},
+		{"evil.example.com:8787", ":8787", false},
+		{"", ":8787", false},
+	}
+	for _, tt := range tests {
+		if got := allowedHost(tt.host, tt.addr); got != tt.want {
+			t.Errorf("allowedHost(%q, %q) = %v, want %v", tt.host, tt.addr, got, tt.want)
+		}
+	}
+}
+
+func TestListSessionsTruncatesTitleByWidth(t *testing.T) {
+	dir := t.TempDir()
+	session := filepath.Join(dir, "20260101-120000")
+	if err := os.Mkdir(session, 0700); err != nil {
+		t.Fatal(err)
+	}
+	prompt := strings.Repeat("日本語のプロンプト", 20)
+	entry := `{"time":"2026-01-01T12:00:00Z","type":"prompt","text":"` + prompt + `"}` + "\n"
+	if err := os.WriteFile(filepath.Join(session, "transcript.jsonl"), []byte(entry), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	sessions, err := listSessions(dir)
+	if err != nil || len(sessions) != 1 {
+		t.Fatalf("listed %v, %v", sessions, err)
+	}
+	title := sessions[0].Title
+	if !utf8.ValidString(title) || !strings.HasSuffix(title, "...") || runewidth.StringWidth(title) > 80 {
+		t.Fatalf("title %q of width %d", title, runewidth.StringWidth(title))
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// sessionUsage is the token usage of a session, saved after each turn so
+// a session continued with -continue keeps counting from it
+type sessionUsage struct {
+	Model             string    `json:"model"`
+	TotalInputTokens  int       `json:"total_input_tokens"`
+	CachedInputTokens int       `json:"cached_input_tokens"`
+	TotalOutputTokens int       `json:"total_output_tokens"`
+	Cost              float64   `json:"cost"`
+	Updated           time.Time `json:"updated"`
+}
+
+// restoredUsage is the usage of a previous session a provider continues
+// counting from. Its tokens are part of the session totals but are counted at
+// the cost the previous session saved, priced with the models it used.
+type restoredUsage struct {
+	input, cachedInput, output int
+	cost                       float64
+}
+
+// sessionUsagePath returns the file the usage of a session is saved to
+func sessionUsagePath(id string) string {
+	return filepath.Join(".aicode", "sessions", id, "usage.json")
+}
+
+// saveSessionUsage saves the token counters of the session. Sub-agents
+// don't save theirs, their cost is part of the usage ledger only.
+func saveSessionUsage(llm Llm) error {
+	if agentDepth() > 0 {
+		return nil
+	}
+	info := llm.ProviderInfo()
+	if info.TotalInputTokens == 0 && info.TotalOutputTokens == 0 {
+		return nil
+	}
+	data, err := json.MarshalIndent(sessionUsage{
+		Model:             info.Model,
+		TotalInputTokens:  info.TotalInputTokens,
+		CachedInputTokens: info.CachedInputTokens,
+		TotalOutputTokens: info.TotalOutputTokens,
+		Cost:              llm.CalculatePrice(),
+		Updated:           time.Now(),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := sessionUsagePath(sessionID)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// previousSessionUsage returns the usage saved by the latest other session
+// of this directory, nil when there is none
+func previousSessionUsage() (*sessionUsage, error) {
+	paths, err := filepath.Glob(sessionUsagePath("*"))
+	if err != nil {
+		return nil, err
+	}
+	// Session IDs are timestamps, the latest sorts last
+	sort.Strings(paths)
+	for i := len(paths) - 1; i >= 0; i-- {
+		if paths[i] == sessionUsagePath(sessionID) {
+			continue
+		}
+		data, err := os.ReadFile(paths[i])
+		if err != nil {
+			return nil, err
+		}
+		var usage sessionUsage
+		if err := json.Unmarshal(data, &usage); err != nil {
+			return nil, err
+		}
+		return &usage, nil
+	}
+	return nil, nil
+}
+
+// restoreSessionUsage continues counting from the usage of the previous
+// session, so /cost covers the work it started
+func restoreSessionUsage(llm Llm) error {
+	usage, err := previousSessionUsage()
+	if err != nil || usage == nil {
+		return err
+	}
+	llm.RestoreUsage(usage.TotalInputTokens, usage.CachedInputTokens, usage.TotalOutputTokens, usage.Cost)
+	return nil
+}
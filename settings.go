@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+)
+
+// generationSettings are the generation parameters that can be overridden for
+// the session with /set or for a single custom command with front matter
+type generationSettings struct {
+	ReasoningEffort string   `yaml:"reasoning_effort"`
+	Verbosity       string   `yaml:"verbosity"`
+	Temperature     *float64 `yaml:"temperature"`
+	Model           string   `yaml:"model"`
+}
+
+// validLevels are the accepted values of reasoning_effort and verbosity
+var validLevels = map[string]bool{"low": true, "medium": true, "high": true}
+
+// verbosityInstructions steer the length of responses for models without a verbosity parameter
+var verbosityInstructions = map[string]string{
+	"low":  "Keep responses as short as possible: answer directly and skip explanations unless asked.",
+	"high": "Give thorough responses: explain your reasoning, the changes made and the alternatives considered.",
+}
+
+// apply copies the settings that are set onto config
+func (s generationSettings) apply(config *Config) {
+	if s.ReasoningEffort != "" {
+		config.ReasoningEffort = s.ReasoningEffort
+	}
+	if s.Verbosity != "" {
+		config.Verbosity = s.Verbosity
+	}
+	if s.Temperature != nil {
+		config.Temperature = s.Temperature
+	}
+	if s.Model != "" {
+		config.Model = s.Model
+	}
+}
+
+// String lists the settings that are set, for the status bar
+func (s generationSettings) String() string {
+	var parts []string
+	if s.Model != "" {
+		parts = append(parts, "model="+s.Model)
+	}
+	if s.Temperature != nil {
+		parts = append(parts, "temp="+strconv.FormatFloat(*s.Temperature, 'g', -1, 64))
+	}
+	if s.ReasoningEffort != "" {
+		parts = append(parts, "reasoning="+s.ReasoningEffort)
+	}
+	if s.Verbosity != "" {
+		parts = append(parts, "verbosity="+s.Verbosity)
+	}
+	return strings.Join(parts, " ")
+}
+
+// set changes one setting by name, as used by /set
+func (s *generationSettings) set(name, value string) error {
+	switch name {
+	case "reasoning", "reasoning_effort":
+		if !validLevels[value] {
+			return fmt.Errorf("invalid reasoning effort %q, expected low, medium or high", value)
+		}
+		s.ReasoningEffort = value
+	case "verbosity":
+		if !validLevels[value] {
+			return fmt.Errorf("invalid verbosity %q, expected low, medium or high", value)
+		}
+		s.Verbosity = value
+	case "temperature", "temp":
+		temperature, err := strconv.ParseFloat(value, 64)
+		if err != nil || temperature < 0 || temperature > 2 {
+			return fmt.Errorf("invalid temperature %q, expected a number between 0 and 2", value)
+		}
+		s.Temperature = &temperature
+	case "model":
+		s.Model = value
+	default:
+		return fmt.Errorf("unknown setting %q", name)
+	}
+	return nil
+}
+
+// reset removes the override of one setting, or of all of them for "all"
+func (s *generationSettings) reset(name string) error {
+	switch name {
+	case "all":
+		*s = generationSettings{}
+	case "reasoning", "reasoning_effort":
+		s.ReasoningEffort = ""
+	case "verbosity":
+		s.Verbosity = ""
+	case "temperature", "temp":
+		s.Temperature = nil
+	case "model":
+		s.Model = ""
+	default:
+		return fmt.Errorf("unknown setting %q", name)
+	}
+	return nil
+}
+
+// parseFrontMatter splits the optional YAML front matter delimited by --- lines
+// from a custom command template
+func parseFrontMatter(content string) (generationSettings, string, error) {
+	var settings generationSettings
+	if !strings.HasPrefix(content, "---\n") {
+		return settings, content, nil
+	}
+	header, body, found := strings.Cut(content[len("---\n"):], "\n---\n")
+	if !found {
+		return settings, content, nil
+	}
+	if err := yaml.Unmarshal([]byte(header), &settings); err != nil {
+		return settings, content, fmt.Errorf("invalid front matter: %v", err)
+	}
+	return settings, body, nil
+}
+
+// setHandler overrides a generation setting for the rest of the session
+func setHandler(m *chatModel) error {
+	args := m.commandArgs()
+	if len(args) == 0 {
+		config := m.effectiveConfig(generationSettings{})
+		temperature := "default"
+		if config.Temperature != nil {
+			temperature = strconv.FormatFloat(*config.Temperature, 'g', -1, 64)
+		}
+		m.outputs = append(m.outputs, fmt.Sprintf("model: %s\ntemperature: %s\nreasoning: %s\nverbosity: %s",
+			config.Model, temperature, config.ReasoningEffort, config.Verbosity))
+		return nil
+	}
+	if len(args) != 2 {
+		return fmt.Errorf("usage: /set <model|temperature|reasoning|verbosity> <value|default>")
+	}
+
+	name, value := args[0], args[1]
+	if value == "default" {
+		if err := m.overrides.reset(name); err != nil {
+			return err
+		}
+		m.outputs = append(m.outputs, fmt.Sprintf("Reset %s to the profile value", name))
+		return nil
+	}
+
+	if name == "model" {
+		config := m.config
+		config.Model = value
+		if _, err := switchConfig(config, m.config.Model); err != nil {
+			return fmt.Errorf("cannot switch to %s: %v", value, err)
+		}
+	}
+	if err := m.overrides.set(name, value); err != nil {
+		return err
+	}
+	message := fmt.Sprintf("Set %s to %s for the next turns", name, value)
+	if name == "model" && usesClaudeAPI(value, m.config) != usesClaudeAPI(m.llm.GetModel(), m.config) {
+		message += ", the conversation moves to its provider with the tool calls and results"
+	}
+	m.outputs = append(m.outputs, message)
+	return nil
+}
+
+// effectiveConfig returns the profile configuration with the session overrides
+// and then the settings of the current command applied
+func (m *chatModel) effectiveConfig(command generationSettings) Config {
+	config := m.config
+	m.overrides.apply(&config)
+	command.apply(&config)
+	return config
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// staleEditHelp is shown in the status line while an edit of a file changed
+// since the model viewed it waits for the user
+const staleEditHelp = "File changed since the model viewed it | a apply to your version, r rebase onto your version, n or esc abort"
+
+// Answers of the user to an edit of a file changed since the model viewed it
+const (
+	staleEditAbort  = iota
+	staleEditApply  // The edit is applied to the current content as it is
+	staleEditRebase // The changes of the edit are merged into the current content
+)
+
+// Message asking the user what to do with an edit of a file changed on disk,
+// for instance in their editor, since the model last viewed it
+type staleEditMsg struct {
+	tool      string
+	path      string
+	base      string // Content the model last viewed
+	current   string // Content on disk
+	edited    string // The edit applied to base, empty when it does not apply to it
+	applied   string // The edit applied to current
+	applyErr  error  // Why the edit does not apply to current
+	rebased   string // The changes of the edit merged into current
+	rebaseErr error  // Why the changes of the edit cannot be merged into current
+	reply     chan int
+}
+
+// canResolveStaleEdits tells whether the user can be asked about edits of
+// files changed since the model viewed them, otherwise these edits are refused
+func canResolveStaleEdits() bool {
+	return programRef != nil
+}
+
+// resolveStaleEdit asks the user what to do with an edit of path, which
+// changed on disk from base to current since the model viewed it. edit
+// returns the content of the file after the edit. It returns the content to
+// write and a note telling the model of the changes it had not seen.
+func resolveStaleEdit(tool, path, base, current string, edit func(string) (string, error)) (string, string, error) {
+	msg := staleEditMsg{tool: tool, path: path, base: base, current: current, reply: make(chan int, 1)}
+	msg.applied, msg.applyErr = edit(current)
+	edited, err := edit(base)
+	if err != nil {
+		msg.rebaseErr = fmt.Errorf("the edit does not apply to the version the model viewed: %v", err)
+	} else {
+		msg.edited = edited
+		msg.rebased, msg.rebaseErr = mergeEdit(base, current, edited)
+	}
+	if msg.applyErr != nil && msg.rebaseErr != nil {
+		return "", "", staleError(path, nil)
+	}
+
+	programRef.Send(msg)
+	changes := plainDiff(base, current)
+	switch <-msg.reply {
+	case staleEditApply:
+		return msg.applied, fmt.Sprintf("\n%s had changed on disk since you last viewed it and the user chose to apply your edit to their version as it is. Their changes since your last View:\n%s", path, changes), nil
+	case staleEditRebase:
+		return msg.rebased, fmt.Sprintf("\n%s had changed on disk since you last viewed it and the user chose to rebase your edit onto their version, which keeps both. Their changes since your last View:\n%s", path, changes), nil
+	}
+	return "", "", fmt.Errorf("%s changed on disk since you last viewed it and the user aborted your %s, View it again before editing it", path, tool)
+}
+
+// mergeEdit merges the changes from base to edited into current with a
+// three-way merge, failing when they overlap the changes from base to current
+func mergeEdit(base, current, edited string) (string, error) {
+	dir, err := os.MkdirTemp("", "aicode-merge-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{"current": current, "base": base, "edited": edited}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			return "", err
+		}
+	}
+
+	output, err := exec.Command("git", "merge-file", "-p", filepath.Join(dir, "current"), filepath.Join(dir, "base"), filepath.Join(dir, "edited")).Output()
+	var exitErr *exec.ExitError
+	switch {
+	case errors.As(err, &exitErr) && exitErr.ExitCode() > 0 && exitErr.ExitCode() < 128:
+		// The exit code is the number of conflicts
+		return "", fmt.Errorf("the edit overlaps %d of the changes on disk", exitErr.ExitCode())
+	case err != nil:
+		return "", fmt.Errorf("git merge-file: %v", err)
+	}
+	return string(output), nil
+}
+
+// queueStaleEdit shows an edit of a file changed since the model viewed it, or
+// queues it behind the one waiting for the user when edits run at the same
+// time, so that each of them gets an answer
+func (m *chatModel) queueStaleEdit(msg staleEditMsg) {
+	if m.staleEdit != nil {
+		m.staleEditQueue = append(m.staleEditQueue, msg)
+		return
+	}
+	m.staleEdit = &msg
+	m.showStaleEdit()
+}
+
+// nextStaleEdit shows the next queued edit once the user answered the last one
+func (m *chatModel) nextStaleEdit() {
+	m.staleEdit = nil
+	if len(m.staleEditQueue) == 0 {
+		return
+	}
+	next := m.staleEditQueue[0]
+	m.staleEditQueue = m.staleEditQueue[1:]
+	m.staleEdit = &next
+	m.showStaleEdit()
+}
+
+// abortStaleEdits aborts the edit waiting for the user and the queued ones
+func (m *chatModel) abortStaleEdits() {
+	if m.staleEdit != nil {
+		m.staleEdit.reply <- staleEditAbort
+		m.staleEdit = nil
+	}
+	for _, s := range m.staleEditQueue {
+		s.reply <- staleEditAbort
+	}
+	m.staleEditQueue = nil
+}
+
+// showStaleEdit prints the three-way view of the edit waiting for the user:
+// the changes on disk and the edit, both against the version the model
+// viewed, and what applying or rebasing the edit would give
+func (m *chatModel) showStaleEdit() {
+	s := m.staleEdit
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s changed on disk since the model last viewed it.\n", s.path)
+	fmt.Fprintf(&b, "\nChanges on disk since the model viewed it:\n%s\n", renderDiff(s.base, s.current))
+	if s.edited != "" {
+		fmt.Fprintf(&b, "\n%s of the model, against the version it viewed:\n%s\n", s.tool, renderDiff(s.base, s.edited))
+	} else {
+		fmt.Fprintf(&b, "\n%s of the model, against your version:\n%s\n", s.tool, renderDiff(s.current, s.applied))
+	}
+	if s.rebaseErr == nil {
+		fmt.Fprintf(&b, "\nRebased onto your version, keeping both:\n%s", renderDiff(s.current, s.rebased))
+	} else {
+		fmt.Fprintf(&b, "\nCannot rebase: %v", s.rebaseErr)
+	}
+	if s.applyErr != nil {
+		fmt.Fprintf(&b, "\nCannot apply to your version: %v", s.applyErr)
+	}
+	m.outputs = append(m.outputs, b.String())
+	m.updateViewportContent()
+}
+
+// handleStaleEditKey answers the pending edit of a file changed since the
+// model viewed it
+func (m *chatModel) handleStaleEditKey(msg tea.KeyMsg) {
+	s := m.staleEdit
+	switch msg.String() {
+	case "a":
+		if s.applyErr != nil {
+			m.outputs = append(m.outputs, fmt.Sprintf("Cannot apply to your version: %v", s.applyErr))
+			m.updateViewportContent()
+			return
+		}
+		s.reply <- staleEditApply
+		m.outputs = append(m.outputs, "Applied the edit to your version of "+s.path)
+	case "r":
+		if s.rebaseErr != nil {
+			m.outputs = append(m.outputs, fmt.Sprintf("Cannot rebase: %v", s.rebaseErr))
+			m.updateViewportContent()
+			return
+		}
+		s.reply <- staleEditRebase
+		m.outputs = append(m.outputs, "Rebased the edit onto your version of "+s.path)
+	case "n", "N", "esc":
+		s.reply <- staleEditAbort
+		m.outputs = append(m.outputs, "Aborted the edit of "+s.path)
+	default:
+		return
+	}
+	m.nextStaleEdit()
+	m.updateViewportContent()
+}
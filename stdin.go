@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// stdinTurn is a user turn given as a line of JSON
+type stdinTurn struct {
+	Prompt string `json:"prompt"`
+}
+
+// stdinReply answers a turn given as JSON in text output
+type stdinReply struct {
+	Response string `json:"response"`
+	Error    string `json:"error,omitempty"`
+}
+
+// maxStdinTurn is the size of the longest line accepted on stdin
+const maxStdinTurn = 10 * 1024 * 1024
+
+// runStdinMode reads user turns from stdin, one per line, and answers each
+// in turn within the same conversation. Lines starting with { are read as
+// {"prompt": "..."} and answered with {"response": "..."}; other lines are
+// answered with the text of the response followed by an empty line. With
+// stream-json output every turn emits its events, ending with done.
+func runStdinMode(llm Llm, config Config) {
+	if config.InitialPrompt != "" {
+		answerTurn(llm, config, config.InitialPrompt, false)
+	}
+
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(make([]byte, 64*1024), maxStdinTurn)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		isJSON := strings.HasPrefix(line, "{")
+		prompt := line
+		if isJSON {
+			var turn stdinTurn
+			if err := json.Unmarshal([]byte(line), &turn); err != nil || turn.Prompt == "" {
+				writeTurnReply(config, true, "", fmt.Errorf("invalid turn, expected {\"prompt\": \"...\"}: %s", line))
+				continue
+			}
+			prompt = turn.Prompt
+		}
+		answerTurn(llm, config, prompt, isJSON)
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading stdin: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// answerTurn runs one turn and writes its answer, errors don't end the session
+func answerTurn(llm Llm, config Config, prompt string, isJSON bool) {
+	response, err := runTurn(llm, config, prompt)
+	writeTurnReply(config, isJSON, response, err)
+}
+
+// writeTurnReply writes the answer to a turn in the format of the turn
+func writeTurnReply(config Config, isJSON bool, response string, err error) {
+	if config.OutputFormat == outputStreamJSON {
+		// runTurn already emitted the events of the turn, failed turns end with done too
+		if err != nil {
+			emitEvent(streamEvent{Type: "done", Error: err.Error()})
+		}
+		return
+	}
+
+	if isJSON {
+		reply := stdinReply{Response: response}
+		if err != nil {
+			reply.Error = err.Error()
+		}
+		data, _ := json.Marshal(reply)
+		fmt.Println(string(data))
+		return
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	}
+	fmt.Println(response)
+	fmt.Println()
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const (
+	viewLineLimit         = 2000 // Lines View returns by default
+	maxSectionLines       = 300  // Longest section sent to the cheap model at once
+	summarizeConcurrency  = 4    // Parallel requests to the cheap model
+	maxSummarizedSections = 40   // Sections beyond this are only listed in the outline
+)
+
+// SummarizeFileParams represents the parameters for the SummarizeFile tool
+type SummarizeFileParams struct {
+	FilePath string `json:"file_path"`
+	Focus    string `json:"focus,omitempty"`
+}
+
+// outlinePatterns match top-level declarations per file extension
+var outlinePatterns = map[string]*regexp.Regexp{
+	".go":   regexp.MustCompile(`^(func|type|var|const)\b`),
+	".py":   regexp.MustCompile(`^(def|class|async def)\s`),
+	".js":   regexp.MustCompile(`^(export\s+)?(default\s+)?(async\s+)?(function|class|const|let)\s`),
+	".ts":   regexp.MustCompile(`^(export\s+)?(default\s+)?(async\s+)?(function|class|const|let|interface|type|enum)\s`),
+	".tsx":  regexp.MustCompile(`^(export\s+)?(default\s+)?(async\s+)?(function|class|const|let|interface|type|enum)\s`),
+	".rs":   regexp.MustCompile(`^(pub(\([a-z]+\))?\s+)?(fn|struct|enum|trait|impl|mod|type|const|static)\b`),
+	".java": regexp.MustCompile(`^\s{0,4}(public|private|protected|class|interface|enum)\b.*[({]\s*$`),
+	".rb":   regexp.MustCompile(`^\s{0,2}(def|class|module)\s`),
+	".c":    regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_ \*]*\([^;]*$`),
+	".h":    regexp.MustCompile(`^(typedef|struct|enum|#define)\b`),
+	".md":   regexp.MustCompile(`^#{1,3}\s`),
+}
+
+// outlineEntry is a declaration found in a file
+type outlineEntry struct {
+	Line int
+	Text string
+}
+
+// fileSection is a range of lines summarized as a whole
+type fileSection struct {
+	Start, End int
+	Summary    string
+}
+
+// buildOutline finds top-level declarations using the pattern for the file's language
+func buildOutline(filePath string, lines []string) []outlineEntry {
+	pattern, ok := outlinePatterns[strings.ToLower(filepath.Ext(filePath))]
+	if !ok {
+		return nil
+	}
+
+	var outline []outlineEntry
+	for i, line := range lines {
+		if pattern.MatchString(line) {
+			text := strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(line), "{"))
+			if len(text) > 120 {
+				text = text[:117] + "..."
+			}
+			outline = append(outline, outlineEntry{Line: i + 1, Text: text})
+		}
+	}
+	return outline
+}
+
+// splitSections groups lines into sections that start at outline entries where
+// possible and never exceed maxSectionLines
+func splitSections(lineCount int, outline []outlineEntry) []fileSection {
+	var sections []fileSection
+	start := 1
+	for _, entry := range outline {
+		for entry.Line-start >= maxSectionLines {
+			sections = append(sections, fileSection{Start: start, End: start + maxSectionLines - 1})
+			start += maxSectionLines
+		}
+		// Close the section at a declaration once it is reasonably large
+		if entry.Line-start >= maxSectionLines/2 {
+			sections = append(sections, fileSection{Start: start, End: entry.Line - 1})
+			start = entry.Line
+		}
+	}
+	for start <= lineCount {
+		end := min(start+maxSectionLines-1, lineCount)
+		sections = append(sections, fileSection{Start: start, End: end})
+		start = end + 1
+	}
+	return sections
+}
+
+// ExecuteSummarizeFileTool returns an outline of a large file along with
+// summaries of its sections generated by the cheap model
+func ExecuteSummarizeFileTool(paramsJSON json.RawMessage, config Config) (string, error) {
+	params, err := parseToolParams[SummarizeFileParams](paramsJSON, "FilePath")
+	if err != nil {
+		return "", fmt.Errorf("failed to parse summarize file tool parameters: %v", err)
+	}
+
+	if params.FilePath == "" {
+		return "", fmt.Errorf("file_path parameter is required")
+	}
+
+	content, err := workspaceReadFile(params.FilePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Sprintf("File does not exist: %s", params.FilePath), nil
+		}
+		return "", fmt.Errorf("error reading file: %v", err)
+	}
+
+	lines := strings.Split(string(content), "\n")
+	outline := buildOutline(params.FilePath, lines)
+	sections := splitSections(len(lines), outline)
+	if len(sections) > maxSummarizedSections {
+		sections = sections[:maxSummarizedSections]
+	}
+
+	systemPrompt := summarizeSectionPrompt
+	if params.Focus != "" {
+		systemPrompt += "\nPay particular attention to: " + params.Focus
+	}
+
+	ctx := GlobalAppContext.Context()
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, summarizeConcurrency)
+	for i := range sections {
+		wg.Add(1)
+		go func(section *fileSection) {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			text := strings.Join(lines[section.Start-1:section.End], "\n")
+			prompt := fmt.Sprintf("File: %s (lines %d-%d)\n\n%s", params.FilePath, section.Start, section.End, text)
+			summary, err := quickCompletion(ctx, config, systemPrompt, prompt)
+			if err != nil {
+				summary = fmt.Sprintf("(summary unavailable: %v)", err)
+			}
+			section.Summary = summary
+		}(&sections[i])
+	}
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+
+	var result strings.Builder
+	fmt.Fprintf(&result, "%s: %d lines\n", params.FilePath, len(lines))
+	if len(lines) <= viewLineLimit {
+		result.WriteString("Note: this file is small enough to read with the View tool.\n")
+	}
+
+	if len(outline) > 0 {
+		result.WriteString("\nOutline:\n")
+		for _, entry := range outline {
+			fmt.Fprintf(&result, "%6d  %s\n", entry.Line, entry.Text)
+		}
+	}
+
+	result.WriteString("\nSections:\n")
+	for _, section := range sections {
+		fmt.Fprintf(&result, "Lines %d-%d: %s\n", section.Start, section.End, section.Summary)
+	}
+	if last := sections[len(sections)-1]; last.End < len(lines) {
+		fmt.Fprintf(&result, "Lines %d-%d: not summarized, use View with offset and limit\n", last.End+1, len(lines))
+	}
+
+	return result.String(), nil
+}
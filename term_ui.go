@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io/fs"
 	"log/slog"
@@ -17,18 +19,21 @@ import (
 	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/mattn/go-runewidth"
 )
 
 // Custom message types for updating results asynchronously
 type updateResultMsg struct {
-	outputs []string
-	err     error
+	outputs   []string
+	reasoning string // Shown as a collapsible block before the outputs
+	err       error
 }
 
 // Message for tool execution status updates
 type toolExecutingMsg struct {
 	toolName string
 	params   string
+	detail   string // Optional explanation shown under the tool call
 }
 
 // Message for cancellation notification
@@ -37,6 +42,17 @@ type cancelOperationMsg struct{}
 // Message indicating processing is done
 type processingDoneMsg struct{}
 
+// Message triggering a coalesced viewport refresh
+type flushViewportMsg struct{}
+
+// viewportRefreshInterval is the window in which async updates are coalesced
+// into a single viewport refresh
+const viewportRefreshInterval = 50 * time.Millisecond
+
+// viewportWindowMargin is the number of screens of the transcript given to
+// the viewport before and after the visible lines
+const viewportWindowMargin = 2
+
 // registerCmdCommands reads the ~/.config/aicode/cmds directory and registers commands
 func registerCmdCommands(m *chatModel) {
 	// Get user's home directory
@@ -74,11 +90,21 @@ func registerCmdCommands(m *chatModel) {
 		// Extract base name without extension
 		baseName := strings.TrimSuffix(d.Name(), ".md")
 
-		// Register command
+		// Register command, with the problems of its template
 		cmdName := "/cmd:" + baseName
+		var problems []string
+		if content, err := os.ReadFile(path); err != nil {
+			problems = []string{err.Error()}
+		} else {
+			problems = lintCmdTemplate(string(content))
+		}
+		for _, problem := range problems {
+			slog.Warn("Problem in custom command", "command", cmdName, "problem", problem)
+		}
 		m.commands[cmdName] = SlashCommand{
 			Description: "Custom command from " + d.Name(),
 			Handler:     nil, // We'll handle these commands separately
+			Problems:    problems,
 		}
 
 		return nil
@@ -92,6 +118,7 @@ func registerCmdCommands(m *chatModel) {
 type SlashCommand struct {
 	Description string
 	Handler     func(m *chatModel) error
+	Problems    []string // Problems of a custom command found when it was loaded
 }
 
 // Bubbletea model for interactive mode
@@ -108,6 +135,39 @@ type chatModel struct {
 	lastExitTimestamp int64
 	focused           bool
 	commands          map[string]SlashCommand
+	search            transcriptSearch
+	rendered          *renderCache
+	windowStart       int // Transcript lines given to the viewport, see showWindow
+	windowEnd         int
+	refreshScheduled  bool
+	altScreen         bool
+	printedOutputs    int
+	lastEscTimestamp  int64
+	picker            *messagePicker
+	review            *fileReview         // Proposed file waiting for approval
+	pendingInit       bool                // The running request is /init, its answer is reviewed
+	commit            *commitFlow         // Pending /commit
+	conflicts         *conflictResolution // Pending /resolve
+	overrides         generationSettings  // Settings changed with /set
+	attachedContext   []string            // Context such as CI logs sent with the next message
+	confirm           *confirmActionMsg   // Action of the model waiting for the user's confirmation
+	approval          *writeApprovalMsg   // Write of Edit or Replace waiting for the user's approval
+	staleEdit         *staleEditMsg       // Edit of a file changed since the model viewed it, waiting for the user
+	staleEditQueue    []staleEditMsg      // Edits of changed files waiting behind staleEdit
+	promptOutputs     []int               // Indices in outputs of the submitted user prompts
+	toolOutputs       []string            // Untruncated output of every tool call
+	afterCmd          tea.Cmd             // Command to run once a slash command handler returns
+	title             string              // Session title shown in the header
+	titleRequested    bool
+	lastResponse      string          // Last text answer of the model
+	thinking          []thinkingBlock // Reasoning blocks of the transcript
+	batches           []*batchGroup   // Batches of the transcript, shown as groups of their invocations
+	batchesExpanded   bool
+	thinkingExpanded  bool
+	retryStatus       string         // Request waiting to be retried, shown next to the spinner
+	lastTurn          submittedTurn  // Turn sent last
+	canceled          *submittedTurn // Turn canceled with esc, resumable when its prompt is submitted again
+	resumeOffered     bool           // Waiting for the user to resume the canceled turn or not
 }
 
 func helpHandler(m *chatModel) error {
@@ -125,6 +185,9 @@ func helpHandler(m *chatModel) error {
 	// Display commands in sorted order
 	for _, cmd := range cmdNames {
 		helpMsg += fmt.Sprintf("  %s - %s\n", cmd, m.commands[cmd].Description)
+		for _, problem := range m.commands[cmd].Problems {
+			helpMsg += fmt.Sprintf("      ! %s\n", problem)
+		}
 	}
 
 	m.outputs = append(m.outputs, helpMsg)
@@ -134,27 +197,49 @@ func helpHandler(m *chatModel) error {
 func clearHandler(m *chatModel) error {
 	m.llm.Clear()
 	m.outputs = getInitialMsgs(&m.llm)
+	m.promptOutputs = nil
+	m.toolOutputs = nil
+	m.thinking = nil
+	m.batches = nil
 	return nil
 }
 
 func costHandler(m *chatModel) error {
-	var price float64
-	var inputDisplay, outputDisplay string
-	switch provider := m.llm.(type) {
-	case *Claude:
-		price = provider.CalculatePrice()
-		inputDisplay = formatTokenCount(provider.InputTokens)
-		outputDisplay = formatTokenCount(provider.OutputTokens)
-	case *OpenAI:
-		price = provider.CalculatePrice()
-		inputDisplay = formatTokenCount(provider.InputTokens)
-		outputDisplay = formatTokenCount(provider.OutputTokens)
-	}
-	msg := fmt.Sprintf("Tokens: %s input, %s output. Cost: $%.2f", inputDisplay, outputDisplay, price)
+	if args := m.commandArgs(); len(args) > 0 {
+		if args[0] != "compare" {
+			return fmt.Errorf("usage: /cost [compare [model...]]")
+		}
+		return costCompareHandler(m, args[1:])
+	}
+
+	info := m.llm.ProviderInfo()
+	inputDisplay := formatTokenCount(info.InputTokens)
+	outputDisplay := formatTokenCount(info.OutputTokens)
+	msg := fmt.Sprintf("Tokens: %s input, %s output. Cost: %s", inputDisplay, outputDisplay, m.config.Currency.Format(m.llm.CalculatePrice()))
+	if !info.Priced {
+		msg = fmt.Sprintf("Tokens: %s input, %s output. No price is set for %s, add it to prices in the profile to see the cost", inputDisplay, outputDisplay, info.Model)
+	}
+	if keys := GlobalKeys.Report(m.config.Currency); len(keys) > 0 {
+		msg += "\n" + strings.Join(keys, "\n")
+	}
 	m.outputs = append(m.outputs, msg)
 	return nil
 }
 
+// refreshHandler rebuilds the project context of the system prompt, which is
+// otherwise computed once per session
+func refreshHandler(m *chatModel) error {
+	refreshEnvironmentContext()
+	m.llm.RefreshSystemPrompt()
+	m.outputs = append(m.outputs, "Refreshed the directory structure, project facts and git status of the system prompt")
+	return nil
+}
+
+func pasteImageHandler(m *chatModel) error {
+	m.afterCmd = pasteClipboardImage(false)
+	return nil
+}
+
 func (m *chatModel) isCmd(input string) (string, bool) {
 	if strings.HasPrefix(input, "/") {
 		fields := strings.Fields(input)
@@ -183,6 +268,7 @@ func initialChatModel(llm Llm, config Config) chatModel {
 	ta.CharLimit = 0
 	ta.ShowLineNumbers = false
 	ta.SetHeight(4)
+	ta.SetValue(config.InputPrefill)
 
 	outputs := getInitialMsgs(&llm)
 
@@ -209,14 +295,33 @@ func initialChatModel(llm Llm, config Config) chatModel {
 		lastExitKeypress:  0,
 		lastExitTimestamp: 0,
 		focused:           true,
+		rendered:          &renderCache{},
+		altScreen:         true,
 	}
 
 	model.commands = map[string]SlashCommand{
-		"/help":   {Description: "Show available commands", Handler: helpHandler},
-		"/clear":  {Description: "Clear conversation history", Handler: clearHandler},
-		"/cost":   {Description: "Display token usage and cost information", Handler: costHandler},
-		"/init":   {Description: "Initialize with the system prompt", Handler: nil},
-		"/commit": {Description: "Commit changes", Handler: nil},
+		"/help":        {Description: "Show available commands", Handler: helpHandler},
+		"/clear":       {Description: "Clear conversation history", Handler: clearHandler},
+		"/cost":        {Description: "Display token usage and cost information, /cost compare [model...] prices the session with other models", Handler: costHandler},
+		"/init":        {Description: "Propose an AI.md for the project to review, edit and commit", Handler: nil},
+		"/commit":      {Description: "Commit the staged changes with a generated message", Handler: commitHandler},
+		"/paste-image": {Description: "Attach the clipboard image to the next message", Handler: pasteImageHandler},
+		"/ci":          {Description: "Attach the logs of the latest failing CI run of the branch to the next message", Handler: ciHandler},
+		"/set":         {Description: "Override model, temperature, reasoning or verbosity for the session, e.g. /set temperature 0.2", Handler: setHandler},
+		"/rename":      {Description: "Rename the session, or show its title without arguments", Handler: renameHandler},
+		"/timing":      {Description: "Show the time spent in the model and tools for each turn", Handler: timingHandler},
+		"/debug":       {Description: "Turn debug logging on or off for the rest of the session, and show where the log is written", Handler: debugHandler},
+		"/refresh":     {Description: "Refresh the directory structure, project facts and git status given to the model", Handler: refreshHandler},
+		"/resolve":     {Description: "Resolve merge conflicts hunk by hunk with proposed resolutions to approve, e.g. /resolve keep both import lists", Handler: resolveHandler},
+		"/changes":     {Description: "List the files created, modified or deleted in the session, by turn", Handler: changesHandler},
+		"/thinking":    {Description: "Expand or collapse the reasoning of the model, shown with show_reasoning", Handler: thinkingHandler},
+		"/batch":       {Description: "Expand or collapse the invocations of Batch calls, showing their outputs", Handler: batchHandler},
+		"/summary":     {Description: "Write a summary of the session's changes to .aicode/sessions, /summary pr also adds it to the pull request", Handler: summaryHandler},
+		"/quit":        {Description: "Exit and list follow-ups for the next session", Handler: quitHandler},
+		"/open":        {Description: "Open the full output of tool call N in $EDITOR or $PAGER", Handler: openOutputHandler},
+		"/copy-code":   {Description: "List the code blocks of the last answer, /copy-code N copies one, /copy-code N PATH writes it to a file", Handler: copyCodeHandler},
+		"/note":        {Description: "Attach a note to turn N for later review, e.g. /note 12 this approach was wrong, or list the notes", Handler: noteHandler},
+		"/postmortem":  {Description: "Ask the cheap model for the lessons of the failed attempts of the session and append them to AI.md", Handler: postmortemHandler},
 	}
 
 	// Add custom commands from ~/.config/aicode/cmds directory
@@ -238,7 +343,7 @@ func initialChatModel(llm Llm, config Config) chatModel {
 }
 
 func (m chatModel) Init() tea.Cmd {
-	return tea.Batch(textarea.Blink, m.spinner.Tick)
+	return tea.Batch(textarea.Blink, m.spinner.Tick, checkForUpdate(m.config))
 }
 
 func (m chatModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
@@ -260,15 +365,67 @@ func (m chatModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		return m, cmd
 	case toolExecutingMsg:
 		m.outputs = append(m.outputs, fmt.Sprintf("%s(%s)", msg.toolName, msg.params))
+		if msg.detail != "" {
+			m.outputs = append(m.outputs, "  ↳ "+msg.detail)
+		}
+		return m, tea.Batch(m.scheduleViewportUpdate(), setAgentStatus(statusRunningTool, msg.toolName))
+	case retryStatusMsg:
+		m.retryStatus = msg.text
+		return m, nil
+	case turnTimeLimitMsg:
+		m.outputs = append(m.outputs, fmt.Sprintf("Time limit of %s reached, asking for a summary", msg.limit))
+		return m, m.scheduleViewportUpdate()
+	case clipboardImageMsg:
+		return m, m.attachClipboardImage(msg)
+	case updateAvailableMsg:
+		m.outputs = append(m.outputs, fmt.Sprintf("aicode %s is available, run aicode self-update to install it", msg.version))
+		return m, m.scheduleViewportUpdate()
+	case modelFallbackMsg:
+		m.outputs = append(m.outputs, fmt.Sprintf("%s is unavailable, continuing with %s", msg.from, msg.to))
+		return m, m.scheduleViewportUpdate()
+	case agentProgressMsg:
+		m.outputs = append(m.outputs, "  │ "+msg.line)
+		return m, m.scheduleViewportUpdate()
+	case batchStartedMsg:
+		m.addBatchGroup(msg)
+		return m, tea.Batch(m.scheduleViewportUpdate(), setAgentStatus(statusRunningTool, "Batch"))
+	case batchInvocationMsg:
+		m.updateBatchGroup(msg)
+		return m, m.scheduleViewportUpdate()
+	case toolResultMsg:
+		if msg.grouped {
+			m.addBatchOutput(msg.output)
+		} else {
+			m.addToolOutput(msg.output)
+		}
+		return m, tea.Batch(m.scheduleViewportUpdate(), setAgentStatus(statusThinking, ""))
+	case editorClosedMsg:
+		if msg.err != nil {
+			m.outputs = append(m.outputs, fmt.Sprintf("Error running editor: %v", msg.err))
+			m.updateViewportContent()
+		}
+		return m, nil
+	case flushViewportMsg:
+		m.refreshScheduled = false
 		m.updateViewportContent()
 		return m, nil
 	case cancelOperationMsg:
 		m.outputs = append(m.outputs, "Operation canceled")
 		m.processing = false
 		m.updateViewportContent()
-		return m, nil
+		return m, setAgentStatus(statusIdle, "")
 	case processingDoneMsg:
 		m.processing = false
+		m.retryStatus = ""
+		if m.confirm != nil {
+			m.confirm.reply <- false
+			m.confirm = nil
+		}
+		if m.approval != nil {
+			m.approval.reply <- writeDecision{}
+			m.approval = nil
+		}
+		m.abortStaleEdits()
 		if !m.focused {
 			_, err := executeShellCommand(m.config.NotifyCmd)
 			if err != nil {
@@ -276,21 +433,137 @@ func (m chatModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 			}
 		}
+		cmds := []tea.Cmd{setAgentStatus(statusIdle, "")}
+		if m.pendingInit {
+			m.pendingInit = false
+			if m.lastResponse != "" {
+				m.startReview("AI.md", m.lastResponse)
+			}
+		}
+		if m.title == "" && !m.titleRequested {
+			if messages := m.llm.UserMessages(); len(messages) > 0 {
+				m.titleRequested = true
+				cmds = append(cmds, generateTitle(m.config, messages[0], m.lastResponse))
+			}
+		}
+		return m, tea.Batch(cmds...)
+	case commitMessageMsg:
+		m.handleCommitMessage(msg)
+		return m, nil
+	case confirmActionMsg:
+		m.confirm = &msg
+		m.outputs = append(m.outputs, msg.question)
+		m.updateViewportContent()
+		return m, nil
+	case writeApprovalMsg:
+		m.approval = &msg
+		m.showApprovalDiff()
+		return m, nil
+	case staleEditMsg:
+		m.queueStaleEdit(msg)
+		return m, nil
+	case ciLogsMsg:
+		m.handleCILogs(msg)
+		m.updateViewportContent()
+		return m, nil
+	case sessionSummaryMsg:
+		m.handleSessionSummary(msg)
+		m.updateViewportContent()
+		return m, nil
+	case postmortemMsg:
+		m.handlePostmortem(msg)
+		m.updateViewportContent()
+		return m, nil
+	case reviewEditedMsg:
+		if msg.err != nil {
+			m.outputs = append(m.outputs, fmt.Sprintf("Failed to edit: %v", msg.err))
+			m.updateViewportContent()
+		} else if m.approval != nil {
+			m.approval.proposed = msg.content
+			m.approval.edited = true
+			m.showApprovalDiff()
+		} else if m.review != nil {
+			m.review.proposed = msg.content
+			m.showReviewDiff()
+		} else if m.conflicts != nil {
+			m.conflicts.proposal = msg.content
+			m.showConflictProposal()
+		}
+		return m, nil
+	case conflictProposalMsg:
+		m.handleConflictProposal(msg)
+		m.updateViewportContent()
+		return m, nil
+	case titleGeneratedMsg:
+		if m.title == "" {
+			m.title = msg.title
+		}
 		return m, nil
 	case updateResultMsg:
 		// Handle the update from our async processing
+		m.addThinking(msg.reasoning)
 		m.outputs = append(m.outputs, msg.outputs...)
-		if msg.err != nil {
+		if len(msg.outputs) > 0 {
+			m.lastResponse = msg.outputs[len(msg.outputs)-1]
+		}
+		if errors.Is(msg.err, context.Canceled) {
+			// Canceled with esc, the deadlines and timeouts are errors
+			m.outputs = append(m.outputs, "Operation canceled")
+		} else if msg.err != nil {
 			errorStyle := lipgloss.NewStyle().
 				Foreground(lipgloss.Color("9")).
 				Bold(true)
 			error := errorStyle.Render(fmt.Sprintf("Error: %v", msg.err))
 			m.outputs = append(m.outputs, error)
 		}
-		m.updateViewportContent()
-		return m, nil
+		if m.processing {
+			return m, tea.Batch(m.scheduleViewportUpdate(), setAgentStatus(statusThinking, ""))
+		}
+		return m, m.scheduleViewportUpdate()
 	case tea.KeyMsg:
+		if m.search.active && m.handleSearchKey(msg) {
+			return m, nil
+		}
+		if m.picker != nil && msg.Type != tea.KeyCtrlC && msg.Type != tea.KeyCtrlQ {
+			m.handlePickerKey(msg)
+			return m, nil
+		}
+		if m.confirm != nil && msg.Type != tea.KeyCtrlC && msg.Type != tea.KeyCtrlQ {
+			m.handleConfirmKey(msg)
+			return m, nil
+		}
+		if m.resumeOffered && msg.Type != tea.KeyCtrlC && msg.Type != tea.KeyCtrlQ {
+			return m.handleResumeKey(msg)
+		}
+		if m.approval != nil && msg.Type != tea.KeyCtrlC && msg.Type != tea.KeyCtrlQ {
+			return m, m.handleApprovalKey(msg)
+		}
+		if m.staleEdit != nil && msg.Type != tea.KeyCtrlC && msg.Type != tea.KeyCtrlQ {
+			m.handleStaleEditKey(msg)
+			return m, nil
+		}
+		if m.review != nil && msg.Type != tea.KeyCtrlC && msg.Type != tea.KeyCtrlQ {
+			return m, m.handleReviewKey(msg)
+		}
+		if m.conflicts != nil && msg.Type != tea.KeyCtrlC && msg.Type != tea.KeyCtrlQ {
+			return m, m.handleConflictKey(msg)
+		}
+		if m.commit != nil && msg.Type != tea.KeyCtrlC && msg.Type != tea.KeyCtrlQ {
+			if cmd, handled := m.handleCommitKey(msg); handled {
+				return m, cmd
+			}
+		}
+
 		switch {
+		case msg.Type == tea.KeyCtrlF:
+			m.startSearch()
+			return m, nil
+		case msg.Type == tea.KeyCtrlO:
+			return m, m.toggleAltScreen()
+		case msg.Type == tea.KeyCtrlV && !m.processing:
+			// Attach an image if there is one in the clipboard, otherwise
+			// the textarea pastes the clipboard text
+			return m, pasteClipboardImage(true)
 		case msg.Type == tea.KeyEsc && m.processing:
 			// Cancel the current operation
 			m.outputs = append(m.outputs, "Canceling operation...")
@@ -298,11 +571,17 @@ func (m chatModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 			// Cancel the global context
 			GlobalAppContext.Cancel()
+			turn := m.lastTurn
+			m.canceled = &turn
 
 			// Instead of immediate reset, mark as no longer processing
 			// We'll reset the context after the goroutine exits
 			m.processing = false
+			m.pendingInit = false
 
+			return m, nil
+		case msg.Type == tea.KeyEsc:
+			m.handleIdleEsc()
 			return m, nil
 		case msg.Type == tea.KeyTab:
 			// Get current text
@@ -323,18 +602,21 @@ func (m chatModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					}
 				}
 			} else {
-				// Handle filename completion
+				// Handle filename completion on the line under the cursor.
+				// Cursor positions are counted in runes, not bytes.
 				lineInfo := m.textarea.LineInfo()
-				cursorPos := lineInfo.CharOffset
-				content := m.textarea.Value()
+				cursorPos := lineInfo.StartColumn + lineInfo.ColumnOffset
+				lines := strings.Split(m.textarea.Value(), "\n")
+				line := []rune(lines[m.textarea.Line()])
 
-				// Get matches and word start position
-				matches, wordStart := m.completeFilename(content, cursorPos)
+				// Get the word being completed and its matches
+				word := getCurrentWord(line, cursorPos)
+				matches := m.completeFilename(word)
 
 				// If we have matches, apply the completion
 				if len(matches) > 0 {
 					// Apply the completion
-					m.applyCompletion(matches, content, wordStart, cursorPos)
+					m.applyCompletion(matches, word)
 				}
 			}
 			return m, nil
@@ -374,13 +656,19 @@ func (m chatModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			if input == "" {
 				return m, nil
 			}
+			if m.canceled != nil && m.offerResume(input) {
+				return m, nil
+			}
+			typed := input
 
+			// Commands may only offer some tools to the model and override settings
+			var toolSubset []string
+			var commandSettings generationSettings
 			if cmdName, exists := m.isCmd(input); exists {
+				toolSubset = m.config.ToolProfiles[cmdName]
 				if strings.HasPrefix(cmdName, "/cmd:") {
 					// Handle /cmd: commands directly
-					cmdFile := strings.TrimPrefix(cmdName, "/cmd:")
-					cmdPath := filepath.Join(os.Getenv("HOME"), ".config/aicode/cmds", cmdFile+".md")
-					content, err := os.ReadFile(cmdPath)
+					content, err := os.ReadFile(cmdTemplatePath(cmdName))
 					if err != nil {
 						m.outputs = append(m.outputs, fmt.Sprintf("Error loading command file: %v", err))
 					} else {
@@ -392,7 +680,12 @@ func (m chatModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 						}
 
 						// Process the command template with arguments
-						processedCmd, err := processCommandTemplate(string(content), args)
+						settings, body, err := parseFrontMatter(string(content))
+						if err != nil {
+							m.outputs = append(m.outputs, fmt.Sprintf("Error loading command file: %v", err))
+						}
+						commandSettings = settings
+						processedCmd, err := processCommandTemplate(body, args, nil)
 						if err != nil {
 							m.outputs = append(m.outputs, fmt.Sprintf("Error processing command template: %v", err))
 						} else {
@@ -406,119 +699,55 @@ func (m chatModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					}
 					m.textarea.Reset()
 					m.updateViewportContent()
-					return m, nil
+					afterCmd := m.afterCmd
+					m.afterCmd = nil
+					return m, afterCmd
 				} else if cmdName == "/init" {
-					input = initPrompt
-				} else if cmdName == "/commit" {
-					input = defaultCommitPrompt
+					input = initPrompt + initReviewInstructions
+					m.pendingInit = true
 				}
 			}
 
 			// Mark as processing
 			m.processing = true
+			m.lastResponse = ""
 			m.textarea.Reset()
 
 			// Add the input message to the display
+			m.promptOutputs = append(m.promptOutputs, len(m.outputs))
 			m.outputs = append(m.outputs, "> "+input)
 			m.updateViewportContent()
 
 			// Store a copy of the model for the goroutine to use
 			llm := m.llm
-			config := m.config
+			config := m.effectiveConfig(commandSettings)
+			llm.SetConfig(config)
+			llm.SetToolSubset(toolSubset)
+			m.lastTurn = submittedTurn{input: typed, userIndex: len(llm.UserMessages()), config: config, toolSubset: toolSubset}
+
+			// Images mentioned as @path go along with the prompt
+			attached, err := attachImageMentions(llm, input)
+			for _, path := range attached {
+				m.outputs = append(m.outputs, "Attached image "+path)
+			}
+			if err != nil {
+				m.outputs = append(m.outputs, fmt.Sprintf("Failed to attach an image: %v", err))
+			}
 
-			// Get the prompt to process
+			// Get the prompt to process, with the attached context
 			prompt := input
+			if len(m.attachedContext) > 0 {
+				prompt = strings.Join(m.attachedContext, "\n\n") + "\n\n" + input
+				m.attachedContext = nil
+			}
+			prompt, editorFiles, err := withEditorContext(prompt)
+			if err != nil {
+				m.outputs = append(m.outputs, fmt.Sprintf("Failed to read the files open in the editor: %v", err))
+			} else if editorFiles != "" {
+				m.outputs = append(m.outputs, "[Editor context attached: "+editorFiles+"]")
+			}
 
-			// Reset the global app context for this new operation
-			GlobalAppContext.Reset()
-
-			// Use a goroutine to process the request asynchronously
-			go func() {
-				defer func() {
-					// Always notify that processing is done when we exit this goroutine
-					if programRef != nil {
-						programRef.Send(processingDoneMsg{})
-						// Reset context for next operation
-						GlobalAppContext.Reset()
-					}
-				}()
-
-				// Get context for this operation
-				ctx := GlobalAppContext.Context()
-
-				// First check if context is already canceled
-				if ctx.Err() != nil {
-					return
-				}
-
-				for {
-					// Check if context was cancelled before making any API call
-					if ctx.Err() != nil {
-						// Operation was cancelled
-						return
-					}
-
-					// Get response from LLM
-					inferenceResponse, err := llm.Inference(ctx, prompt)
-					if programRef != nil {
-						updateMsgs := []string{}
-						if inferenceResponse.Content != "" {
-							updateMsgs = append(updateMsgs, inferenceResponse.Content)
-						}
-						programRef.Send(updateResultMsg{
-							outputs: updateMsgs,
-							err:     err,
-						})
-
-					}
-					if err != nil {
-						break
-					}
-
-					// Clear prompt for next iteration
-					prompt = ""
-
-					// Check if we have tool calls
-					if len(inferenceResponse.ToolCalls) == 0 {
-						break
-					}
-
-					// Check context again before processing tool calls
-					if ctx.Err() != nil {
-						return
-					}
-
-					// Process tool calls
-					_, toolResults, err := HandleToolCallsWithResultsContext(ctx, inferenceResponse.ToolCalls, config)
-					if err != nil {
-						// Check if this was a cancellation
-						if ctx.Err() != nil {
-							return
-						}
-						if programRef != nil {
-							programRef.Send(updateResultMsg{
-								outputs: []string{},
-								err:     err,
-							})
-						}
-						break
-					}
-
-					// Add tool results to LLM conversation history
-					for _, result := range toolResults {
-						llm.AddToolResult(result.CallID, result.Output)
-						if programRef != nil {
-							programRef.Send(updateResultMsg{
-								outputs: chunkOutput(result.Output, 4),
-								err:     nil,
-							})
-						}
-					}
-				}
-
-			}()
-
-			return m, nil
+			return m, m.startTurn(llm, config, prompt)
 
 		// Handle viewport scrolling
 		case msg.String() == "up":
@@ -534,9 +763,9 @@ func (m chatModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.viewport, cmd = m.viewport.Update(msg)
 			cmds = append(cmds, cmd)
 		case msg.Type == tea.KeyHome:
-			m.viewport.GotoTop()
+			m.showWindow(0)
 		case msg.Type == tea.KeyEnd:
-			m.viewport.GotoBottom()
+			m.showWindow(m.rendered.total)
 		}
 	case tea.WindowSizeMsg:
 		// Calculate height for the viewport based on window size
@@ -566,27 +795,283 @@ func (m chatModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	m.viewport, cmd = m.viewport.Update(msg)
 	cmds = append(cmds, cmd)
+	m.syncViewportWindow()
 
 	return m, tea.Batch(cmds...)
 }
 
-// Update the viewport content based on current outputs
-func (m *chatModel) updateViewportContent() {
-	content := ""
+// startTurn sends prompt to the model in the background, running the tool
+// calls it asks for until it answers. An empty prompt continues the
+// conversation from the tool results at its end.
+func (m *chatModel) startTurn(llm Llm, config Config, prompt string) tea.Cmd {
+	// Reset the global app context for this new operation
+	GlobalAppContext.Reset()
+
+	// Use a goroutine to process the request asynchronously
+	go func() {
+		GlobalTiming.StartTurn(prompt)
+		resetTurnGuards()
+		if prompt != "" {
+			recordTranscript(streamEvent{Type: "prompt", Text: prompt})
+		}
+		defer func() {
+			GlobalTiming.EndTurn()
+			if err := saveSessionUsage(llm); err != nil {
+				slog.Warn("Failed to save the session usage", "error", err)
+			}
 
-	// Concatenate all outputs with a blank line between them
-	for i, output := range m.outputs {
-		// Wrap long lines to fit viewport width
-		wrappedOutput := wrapText(output, m.viewport.Width)
-		content += wrappedOutput
-		// Add blank line between messages
-		if i < len(m.outputs)-1 {
-			content += "\n"
+			// Always notify that processing is done when we exit this goroutine
+			if programRef != nil {
+				programRef.Send(processingDoneMsg{})
+				// Reset context for next operation
+				GlobalAppContext.Reset()
+			}
+		}()
+
+		// Get context for this operation, ended by esc or turn_deadline
+		ctx, cancel := withTurnDeadline(GlobalAppContext.Context(), config)
+		defer cancel()
+
+		// First check if context is already canceled
+		if ctx.Err() != nil {
+			return
+		}
+		deadline := newTurnDeadline(config.TurnTimeLimit)
+
+		for {
+			// Check if context was cancelled before making any API call
+			if ctx.Err() != nil {
+				// Operation was cancelled
+				reportTurnDeadline(ctx)
+				return
+			}
+
+			// Get response from LLM
+			inferenceStart := time.Now()
+			inferenceResponse, err := llm.Inference(ctx, prompt)
+			GlobalTiming.RecordModel(time.Since(inferenceStart))
+			if programRef != nil {
+				updateMsgs := []string{}
+				if inferenceResponse.Content != "" {
+					updateMsgs = append(updateMsgs, labelCodeFences(inferenceResponse.Content))
+				}
+				reasoning := ""
+				if config.ShowReasoning {
+					reasoning = inferenceResponse.Reasoning
+				}
+				programRef.Send(updateResultMsg{
+					outputs:   updateMsgs,
+					reasoning: reasoning,
+					err:       err,
+				})
+
+			}
+			if err != nil {
+				recordTranscript(streamEvent{Type: "error", Error: err.Error()})
+				break
+			}
+			if inferenceResponse.Content != "" {
+				recordTranscript(streamEvent{Type: "assistant_delta", Text: inferenceResponse.Content})
+			}
+			for _, toolCall := range inferenceResponse.ToolCalls {
+				recordTranscript(streamEvent{Type: "tool_call", ID: toolCall.ID, Name: toolCall.Name, Input: toolInputJSON(toolCall.Input), Version: toolSchemaVersion(toolCall.Name)})
+			}
+
+			// Clear prompt for next iteration
+			prompt = ""
+
+			// Check if we have tool calls
+			if len(inferenceResponse.ToolCalls) == 0 {
+				break
+			}
+			if deadline.reached {
+				refuseToolCalls(llm, inferenceResponse.ToolCalls)
+				break
+			}
+
+			// Check context again before processing tool calls
+			if ctx.Err() != nil {
+				reportTurnDeadline(ctx)
+				return
+			}
+
+			// Process tool calls
+			_, toolResults, err := HandleToolCallsWithResultsContext(ctx, inferenceResponse.ToolCalls, config)
+			for _, result := range toolResults {
+				recordTranscript(streamEvent{Type: "tool_result", ID: result.CallID, Output: result.Output})
+			}
+			if err != nil {
+				// Check if this was a cancellation
+				if ctx.Err() != nil {
+					reportTurnDeadline(ctx)
+					return
+				}
+				// Keep the history valid so the conversation can go on
+				if errors.Is(err, errToolLoop) {
+					for _, result := range toolResults {
+						llm.AddToolResult(result.CallID, result.Output)
+					}
+				}
+				if programRef != nil {
+					programRef.Send(updateResultMsg{
+						outputs: []string{},
+						err:     err,
+					})
+				}
+				break
+			}
+
+			// Add tool results to LLM conversation history
+			for _, result := range toolResults {
+				llm.AddToolResult(result.CallID, result.Output)
+				if programRef != nil {
+					programRef.Send(toolResultMsg{output: result.Output, grouped: result.Grouped})
+				}
+			}
+
+			// Out of time, the next request asks for a summary
+			if deadline.Expired() {
+				reportTimeLimit(config.TurnTimeLimit)
+				prompt = deadline.Prompt()
+				recordTranscript(streamEvent{Type: "prompt", Text: prompt})
+			}
+		}
+
+	}()
+
+	return setAgentStatus(statusThinking, "")
+}
+
+// renderCache keeps the wrapped lines of each output so that only new or
+// changed outputs are re-wrapped when the viewport content is refreshed, and
+// where each output starts so that any range of lines is found directly
+type renderCache struct {
+	width   int
+	sources []string
+	lines   [][]string // Wrapped lines of each output
+	starts  []int      // Transcript line each output starts at
+	total   int        // Lines of the transcript
+	query   string     // Search query of matches
+	matches [][]int    // Lines of each output matching query, nil until searched
+}
+
+// render re-wraps the outputs that differ from the cached ones and updates
+// the line offsets from the first changed output on
+func (r *renderCache) render(outputs []string, width int) {
+	if r.width != width {
+		r.width = width
+		r.sources = nil
+		r.lines = nil
+		r.starts = nil
+		r.matches = nil
+	}
+	changed := min(len(outputs), len(r.sources))
+	if len(outputs) < len(r.sources) {
+		r.sources = r.sources[:len(outputs)]
+		r.lines = r.lines[:len(outputs)]
+		r.starts = r.starts[:len(outputs)]
+	}
+	r.matches = r.matches[:min(len(r.matches), len(outputs))]
+
+	for i, output := range outputs {
+		if i < len(r.sources) {
+			if r.sources[i] == output {
+				continue
+			}
+			r.sources[i] = output
+			r.lines[i] = strings.Split(wrapText(output, width), "\n")
+			if i < len(r.matches) {
+				r.matches[i] = nil
+			}
+			changed = min(changed, i)
+			continue
 		}
+		r.sources = append(r.sources, output)
+		r.lines = append(r.lines, strings.Split(wrapText(output, width), "\n"))
+		r.starts = append(r.starts, 0)
+	}
+
+	line := 0
+	if changed > 0 {
+		line = r.starts[changed-1] + len(r.lines[changed-1])
+	}
+	for i := changed; i < len(r.lines); i++ {
+		r.starts[i] = line
+		line += len(r.lines[i])
+	}
+	r.total = line
+}
+
+// window returns the transcript lines from start to end
+func (r *renderCache) window(start, end int) []string {
+	var lines []string
+	i := sort.Search(len(r.starts), func(i int) bool { return r.starts[i]+len(r.lines[i]) > start })
+	for ; i < len(r.lines) && r.starts[i] < end; i++ {
+		from := max(start-r.starts[i], 0)
+		to := min(end-r.starts[i], len(r.lines[i]))
+		lines = append(lines, r.lines[i][from:to]...)
+	}
+	return lines
+}
+
+// scheduleViewportUpdate defers the viewport refresh so that bursts of async
+// updates result in a single re-render per refresh interval
+func (m *chatModel) scheduleViewportUpdate() tea.Cmd {
+	if m.refreshScheduled {
+		return nil
+	}
+	m.refreshScheduled = true
+	return tea.Tick(viewportRefreshInterval, func(time.Time) tea.Msg {
+		return flushViewportMsg{}
+	})
+}
+
+// Update the viewport content based on current outputs, following the end
+// of the transcript unless a search is shown
+func (m *chatModel) updateViewportContent() {
+	if m.rendered == nil {
+		m.rendered = &renderCache{}
 	}
+	m.rendered.render(m.outputs, m.viewport.Width)
+
+	if m.search.active && !m.search.editing {
+		m.search.matches = m.rendered.matchingLines(m.search.query)
+		m.showWindow(m.windowStart + m.viewport.YOffset)
+		return
+	}
+	m.showWindow(m.rendered.total)
+}
 
+// showWindow gives the viewport the lines around the transcript line top,
+// a few screens before and after it, and scrolls it to top. Only these
+// lines are highlighted and measured by the viewport, however long the
+// session.
+func (m *chatModel) showWindow(top int) {
+	height := max(m.viewport.Height, 1)
+	top = max(min(top, m.rendered.total-height), 0)
+	m.windowStart = max(top-viewportWindowMargin*height, 0)
+	m.windowEnd = min(top+height+viewportWindowMargin*height, m.rendered.total)
+
+	content := strings.Join(m.rendered.window(m.windowStart, m.windowEnd), "\n")
+	if m.search.active && !m.search.editing {
+		content, _ = highlightMatches(content, m.search.query)
+	}
 	m.viewport.SetContent(content)
-	m.viewport.GotoBottom()
+	m.viewport.SetYOffset(top - m.windowStart)
+}
+
+// syncViewportWindow moves the window of the viewport once it was scrolled
+// close to an edge with more of the transcript beyond it
+func (m *chatModel) syncViewportWindow() {
+	if m.rendered == nil {
+		return
+	}
+	height := max(m.viewport.Height, 1)
+	top := m.windowStart + m.viewport.YOffset
+	if (m.windowStart > 0 && top-m.windowStart < height) ||
+		(m.windowEnd < m.rendered.total && m.windowEnd-(top+height) < height) {
+		m.showWindow(top)
+	}
 }
 
 // showCommandSuggestions processes command completions and displays them
@@ -614,20 +1099,24 @@ func (m *chatModel) showCommandSuggestions(prefix string) []string {
 	return suggestions
 }
 
-// completeFilename handles filename completion based on cursor position
-func (m *chatModel) completeFilename(content string, cursorPos int) ([]string, int) {
-	// Extract the current word at cursor position
-	word := getCurrentWord(content, cursorPos)
-
+// completeFilename returns the file paths starting with word. The directory
+// part of word is kept as typed so every match has word as its prefix.
+func (m *chatModel) completeFilename(word string) []string {
 	// If no word is found, return empty result
 	if word == "" {
-		return nil, 0
+		return nil
 	}
 
 	// Find matching files
 	matches, err := filepath.Glob(word + "*")
 	if err != nil || len(matches) == 0 {
-		return nil, 0
+		return nil
+	}
+
+	// Glob cleans the returned paths, so rebuild them from the typed directory
+	dir := word[:strings.LastIndex(word, string(filepath.Separator))+1]
+	for i, match := range matches {
+		matches[i] = dir + filepath.Base(match)
 	}
 
 	// Sort matches
@@ -638,36 +1127,17 @@ func (m *chatModel) completeFilename(content string, cursorPos int) ([]string, i
 	m.outputs = append(m.outputs, suggestionMsg)
 	m.updateViewportContent()
 
-	// Find the start of the current word
-	wordStart := cursorPos
-	for wordStart > 0 && !isWordSeparator(content[wordStart-1]) {
-		wordStart--
-	}
-
-	return matches, wordStart
+	return matches
 }
 
-// applyCompletion applies the completion to the textarea
-func (m *chatModel) applyCompletion(suggestions []string, currentText string, wordStart int, cursorPos int) {
-	// If only one suggestion, replace the text with it
-	if len(suggestions) == 1 {
-		newContent := currentText[:wordStart] + suggestions[0] + currentText[cursorPos:]
-		m.textarea.SetValue(newContent)
-
-		// Set cursor at end of inserted text
-		m.textarea.SetCursor(wordStart + len(suggestions[0]))
-	} else if len(suggestions) > 1 {
-		// Find common prefix
-		commonPrefix := findCommonPrefix(suggestions)
+// applyCompletion inserts the part of the completion missing after word at the cursor
+func (m *chatModel) applyCompletion(suggestions []string, word string) {
+	// Use the common prefix when there are several suggestions
+	completion := findCommonPrefix(suggestions)
 
-		// Only autocomplete if the common prefix is longer than the current text
-		if len(commonPrefix) > len(currentText[wordStart:cursorPos]) {
-			newContent := currentText[:wordStart] + commonPrefix + currentText[cursorPos:]
-			m.textarea.SetValue(newContent)
-
-			// Set cursor at end of inserted common prefix
-			m.textarea.SetCursor(wordStart + len(commonPrefix))
-		}
+	// Only autocomplete if the completion is longer than the current word
+	if len(completion) > len(word) && strings.HasPrefix(completion, word) {
+		m.textarea.InsertString(completion[len(word):])
 	}
 }
 
@@ -693,21 +1163,17 @@ func customViewportKeyMap() viewport.KeyMap {
 }
 
 // processCommandTemplate processes a command template, replacing {{.ARGS}} with the provided arguments
-func processCommandTemplate(cmdContent, args string) (string, error) {
-	// If the template doesn't contain {{.ARGS}}, return the content as is
-	if !strings.Contains(cmdContent, "{{.ARGS}}") {
-		return cmdContent, nil
+// and {{.name}} with the given variables
+func processCommandTemplate(cmdContent, args string, vars map[string]string) (string, error) {
+	// Create template data with the arguments and variables
+	data := map[string]string{}
+	for key, value := range vars {
+		data[key] = value
 	}
+	data["ARGS"] = args
 
-	// Create template data with arguments
-	data := struct {
-		ARGS string
-	}{
-		ARGS: args,
-	}
-
-	// Parse and execute the template
-	tmpl, err := template.New("cmd").Parse(cmdContent)
+	// Parse and execute the template, failing on variables that weren't given
+	tmpl, err := template.New("cmd").Option("missingkey=error").Parse(cmdContent)
 	if err != nil {
 		return "", fmt.Errorf("failed to parse template: %w", err)
 	}
@@ -721,31 +1187,32 @@ func processCommandTemplate(cmdContent, args string) (string, error) {
 }
 
 // isWordSeparator checks if a rune is a word separator
-func isWordSeparator(r byte) bool {
+func isWordSeparator(r rune) bool {
 	return r == ' ' || r == '\t' || r == '\n' || r == ',' || r == ';' || r == ':' || r == '=' || r == '(' || r == ')' || r == '[' || r == ']' || r == '{' || r == '}'
 }
 
-// getCurrentWord extracts the word at cursor position
-func getCurrentWord(text string, cursorPos int) string {
-	if cursorPos <= 0 || cursorPos > len(text) {
+// getCurrentWord extracts the word ending at the cursor position (in runes)
+func getCurrentWord(line []rune, cursorPos int) string {
+	if cursorPos <= 0 || cursorPos > len(line) {
 		return ""
 	}
 
 	// Find the start of the current word
 	wordStart := cursorPos
-	for wordStart > 0 && !isWordSeparator(text[wordStart-1]) {
+	for wordStart > 0 && !isWordSeparator(line[wordStart-1]) {
 		wordStart--
 	}
 
 	// Extract the word
 	if wordStart < cursorPos {
-		return text[wordStart:cursorPos]
+		return string(line[wordStart:cursorPos])
 	}
 
 	return ""
 }
 
 // findCommonPrefix finds the longest common prefix of a set of strings
+// without splitting multi-byte characters
 func findCommonPrefix(strs []string) string {
 	if len(strs) == 0 {
 		return ""
@@ -755,26 +1222,28 @@ func findCommonPrefix(strs []string) string {
 	}
 
 	// Start with the first string as the prefix
-	prefix := strs[0]
+	prefix := []rune(strs[0])
 
 	// Compare with other strings
 	for i := 1; i < len(strs); i++ {
 		// Find common prefix between current prefix and strs[i]
+		other := []rune(strs[i])
 		j := 0
-		for j < len(prefix) && j < len(strs[i]) && prefix[j] == strs[i][j] {
+		for j < len(prefix) && j < len(other) && prefix[j] == other[j] {
 			j++
 		}
 		// Update prefix to common part
 		prefix = prefix[:j]
-		if prefix == "" {
+		if len(prefix) == 0 {
 			break
 		}
 	}
 
-	return prefix
+	return string(prefix)
 }
 
-// wrapText wraps long lines to fit within the specified width
+// wrapText wraps long lines to fit within the specified width. The width is
+// measured in terminal cells so wide characters are accounted for.
 func wrapText(text string, width int) string {
 	if width <= 0 {
 		return text
@@ -784,29 +1253,41 @@ func wrapText(text string, width int) string {
 	lines := strings.Split(text, "\n")
 
 	for i, line := range lines {
-		if len(line) <= width {
-			result.WriteString(line)
-		} else {
-			// Process the line in chunks of width characters
-			for len(line) > 0 {
-				if len(line) <= width {
-					result.WriteString(line)
-					line = ""
-				} else {
-					// Find the last space before width
-					lastSpace := strings.LastIndex(line[:width], " ")
-					if lastSpace == -1 || lastSpace == 0 {
-						// No space found or space at beginning, just cut at width
-						result.WriteString(line[:width])
-						line = line[width:]
-					} else {
-						// Cut at the last space
-						result.WriteString(line[:lastSpace])
-						line = line[lastSpace+1:] // Skip the space
-					}
-					result.WriteString("\n")
+		runes := []rune(line)
+		for len(runes) > 0 {
+			// Find how many runes fit within width
+			cut, lineWidth := 0, 0
+			for cut < len(runes) && lineWidth+runewidth.RuneWidth(runes[cut]) <= width {
+				lineWidth += runewidth.RuneWidth(runes[cut])
+				cut++
+			}
+			if cut == len(runes) {
+				result.WriteString(string(runes))
+				break
+			}
+			if cut == 0 {
+				// A single character wider than the line, keep it anyway
+				cut = 1
+			}
+
+			// Find the last space before width
+			lastSpace := -1
+			for j := cut - 1; j > 0; j-- {
+				if runes[j] == ' ' {
+					lastSpace = j
+					break
 				}
 			}
+			if lastSpace == -1 {
+				// No space found or space at beginning, just cut at width
+				result.WriteString(string(runes[:cut]))
+				runes = runes[cut:]
+			} else {
+				// Cut at the last space
+				result.WriteString(string(runes[:lastSpace]))
+				runes = runes[lastSpace+1:] // Skip the space
+			}
+			result.WriteString("\n")
 		}
 
 		// Add newline between original lines (but not after the last line)
@@ -829,13 +1310,46 @@ func (m chatModel) View() string {
 
 	// Render textarea input
 	inputView := m.textarea.View()
+	if m.picker != nil {
+		inputView = m.picker.View(m.viewport.Width, m.textarea.Height())
+	}
 
 	// Render status line
 	statusLine := ""
 
 	// Add token usage and cost
-	tokenInfo := getTokenInfoString(m.llm)
+	tokenInfo := getTokenInfoString(m.llm, m.config.Currency)
+	if overrides := m.overrides.String(); overrides != "" {
+		tokenInfo += " | " + overrides
+	}
 	statusLine = tokenStyle.Render(tokenInfo)
+	if m.search.active {
+		statusLine = tokenStyle.Render(m.searchStatus())
+	}
+	if m.picker != nil {
+		statusLine = tokenStyle.Render("Select a message to edit | ↑/↓ move, enter edit, esc cancel")
+	}
+	if m.review != nil {
+		statusLine = tokenStyle.Render(reviewHelp)
+	}
+	if m.commit != nil && m.commit.editing {
+		statusLine = tokenStyle.Render(commitHelp)
+	}
+	if m.conflicts != nil {
+		statusLine = tokenStyle.Render(conflictHelp)
+	}
+	if m.confirm != nil {
+		statusLine = tokenStyle.Render(confirmHelp)
+	}
+	if m.resumeOffered {
+		statusLine = tokenStyle.Render(resumeHelp)
+	}
+	if m.approval != nil {
+		statusLine = tokenStyle.Render(approvalHelp)
+	}
+	if m.staleEdit != nil {
+		statusLine = tokenStyle.Render(staleEditHelp)
+	}
 
 	// Create spinner line if processing
 	spinnerLine := ""
@@ -845,18 +1359,31 @@ func (m chatModel) View() string {
 			PaddingLeft(2).
 			Width(m.viewport.Width)
 
-		spinnerLine = spinnerStyle.Render(m.spinner.View() + " (Press ESC to cancel)")
+		status := " "
+		if m.retryStatus != "" {
+			status += m.retryStatus + " "
+		}
+		spinnerLine = spinnerStyle.Render(m.spinner.View() + status + "(Press ESC to cancel)")
+	}
+
+	// Render header with the session title
+	title := "AiCode"
+	if m.title != "" {
+		title += " - " + m.title
 	}
+	header := lipgloss.NewStyle().Bold(true).Render(title)
 
 	// Combine all elements
 	if m.processing {
-		return fmt.Sprintf("%s\n%s\n%s\n%s",
+		return fmt.Sprintf("%s\n%s\n%s\n%s\n%s",
+			header,
 			contentView,
 			spinnerLine,
 			inputView,
 			statusLine)
 	} else {
-		return fmt.Sprintf("%s\n\n%s\n%s",
+		return fmt.Sprintf("%s\n%s\n\n%s\n%s",
+			header,
 			contentView,
 			inputView,
 			statusLine)
@@ -864,25 +1391,20 @@ func (m chatModel) View() string {
 }
 
 // getTokenInfoString returns a formatted string with token usage and cost information
-func getTokenInfoString(llm Llm) string {
-	var price float64
-	var inputTokens, outputTokens int
-
-	switch provider := llm.(type) {
-	case *Claude:
-		price = provider.CalculatePrice()
-		inputTokens = provider.InputTokens
-		outputTokens = provider.OutputTokens
-	case *OpenAI:
-		price = provider.CalculatePrice()
-		inputTokens = provider.InputTokens
-		outputTokens = provider.OutputTokens
+func getTokenInfoString(llm Llm, currency Currency) string {
+	info := llm.ProviderInfo()
+	contextInfo := ""
+	if info.ContextTokens > 0 && info.ContextWindow > 0 {
+		contextInfo = fmt.Sprintf(" | Context: %s (%d%%)", formatTokenCount(info.ContextTokens), info.ContextTokens*100/info.ContextWindow)
 	}
-
-	return fmt.Sprintf("Tokens: %s in, %s out | Cost: $%.2f",
-		formatTokenCount(inputTokens),
-		formatTokenCount(outputTokens),
-		price)
+	if !info.Priced {
+		return fmt.Sprintf("Tokens: %s in, %s out%s", formatTokenCount(info.InputTokens), formatTokenCount(info.OutputTokens), contextInfo)
+	}
+	return fmt.Sprintf("Tokens: %s in, %s out%s | Cost: %s",
+		formatTokenCount(info.InputTokens),
+		formatTokenCount(info.OutputTokens),
+		contextInfo,
+		currency.Format(llm.CalculatePrice()))
 
 }
 
@@ -891,12 +1413,26 @@ var programRef *tea.Program
 
 // runInteractiveMode initializes and runs the terminal UI
 func runInteractiveMode(llm Llm, config Config) {
+	terminalOutput = &sequenceWriter{File: os.Stdout}
 	p := tea.NewProgram(initialChatModel(llm, config),
 		tea.WithAltScreen(),
-		tea.WithReportFocus())
+		tea.WithReportFocus(),
+		tea.WithOutput(terminalOutput))
 	programRef = p
-	if _, err := p.Run(); err != nil {
+	final, err := p.Run()
+	removePastedImages()
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
+
+	// On stderr, apart from the output of the session
+	if summary := GlobalTiming.Summary(); summary != "" {
+		fmt.Fprintln(os.Stderr, summary)
+	}
+	var title string
+	if model, ok := final.(chatModel); ok {
+		title = model.title
+	}
+	endSession(llm, config, title)
 }
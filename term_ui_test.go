@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/charmbracelet/bubbles/viewport"
+)
+
+func TestProcessCommandTemplateMissingVariable(t *testing.T) {
+	if _, err := processCommandTemplate("Review PR {{.pr}}", "", nil); err == nil {
+		t.Fatal("a variable that wasn't given is not reported")
+	}
+	got, err := processCommandTemplate("Review PR {{.pr}} {{.ARGS}}", "carefully", map[string]string{"pr": "42"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Review PR 42 carefully"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestRenderCacheWindow(t *testing.T) {
+	outputs := []string{"one", "two\nthree", "", "four five six seven", "eight"}
+	r := &renderCache{}
+	r.render(outputs, 10)
+	all := strings.Split(wrapText(strings.Join(outputs, "\n"), 10), "\n")
+	if r.total != len(all) {
+		t.Fatalf("total %d, want %d", r.total, len(all))
+	}
+	for start := 0; start <= len(all); start++ {
+		for end := start; end <= len(all); end++ {
+			if got := r.window(start, end); !slices.Equal(got, all[start:end]) {
+				t.Fatalf("window(%d, %d) = %q, want %q", start, end, got, all[start:end])
+			}
+		}
+	}
+
+	// Changing an output moves the lines of the following ones
+	outputs[1] = "two"
+	r.render(outputs, 10)
+	all = strings.Split(wrapText(strings.Join(outputs, "\n"), 10), "\n")
+	if got := r.window(0, r.total); !slices.Equal(got, all) {
+		t.Fatalf("after a change got %q, want %q", got, all)
+	}
+}
+
+func TestViewportShowsWindowOfTranscript(t *testing.T) {
+	m := chatModel{viewport: viewport.New(20, 5), rendered: &renderCache{}}
+	for i := range 1000 {
+		m.outputs = append(m.outputs, fmt.Sprintf("line %d", i))
+	}
+	m.updateViewportContent()
+	if got := m.viewport.TotalLineCount(); got > 5*(2*viewportWindowMargin+1) {
+		t.Fatalf("viewport holds %d lines, want only a window", got)
+	}
+	if !strings.Contains(m.viewport.View(), "line 999") {
+		t.Fatalf("the end of the transcript is not shown:\n%s", m.viewport.View())
+	}
+
+	// Scrolling up past the window brings the earlier lines in
+	for range 100 {
+		m.viewport.LineUp(3)
+		m.syncViewportWindow()
+	}
+	if !strings.Contains(m.viewport.View(), "line 699") {
+		t.Fatalf("scrolled 300 lines up, got:\n%s", m.viewport.View())
+	}
+
+	m.showWindow(0)
+	if !strings.HasPrefix(m.viewport.View(), "line 0") {
+		t.Fatalf("the start of the transcript is not shown:\n%s", m.viewport.View())
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"unicode"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// agentStatus describes what the agent is currently doing
+type agentStatus int
+
+const (
+	statusIdle agentStatus = iota
+	statusThinking
+	statusRunningTool
+)
+
+// OSC 9;4 progress states understood by Windows Terminal, ConEmu, Ghostty and others
+const (
+	oscProgressClear         = 0
+	oscProgressIndeterminate = 3
+)
+
+// setAgentStatus reflects the agent status in the terminal title and progress
+// indicator, which stays visible while the window is in the background
+func setAgentStatus(status agentStatus, detail string) tea.Cmd {
+	title := "AiCode"
+	progress := oscProgressClear
+
+	switch status {
+	case statusThinking:
+		title += " - thinking"
+		progress = oscProgressIndeterminate
+	case statusRunningTool:
+		title += " - running " + detail
+		progress = oscProgressIndeterminate
+	}
+
+	setTitle := tea.SetWindowTitle(stripControls(title))
+	return func() tea.Msg {
+		// The progress goes out with the title the renderer writes next
+		terminalOutput.Queue(fmt.Sprintf("\x1b]9;4;%d;0\x07", progress))
+		return setTitle()
+	}
+}
+
+// stripControls removes the control characters of s, such as ESC and BEL
+// in tool parameters, which would end the title sequence or start another
+func stripControls(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// sequenceWriter is the output of the interactive program. It writes the
+// sequences queued outside of the renderer, such as the progress indicator,
+// together with the next write of the renderer so that they never split a
+// frame, and reach no output when the program writes elsewhere, as -script
+// runs do.
+type sequenceWriter struct {
+	*os.File // Also the terminal whose size and modes the program reads
+	mu       sync.Mutex
+	pending  string
+}
+
+// terminalOutput is the output of the running interactive program, if any
+var terminalOutput *sequenceWriter
+
+// Queue sets the sequence written before the next output, replacing any
+// sequence still waiting
+func (w *sequenceWriter) Queue(seq string) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.pending = seq
+}
+
+// Write writes p after the queued sequence, in a single write
+func (w *sequenceWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.pending == "" {
+		return w.File.Write(p)
+	}
+	n, err := w.File.Write(append([]byte(w.pending), p...))
+	n = max(n-len(w.pending), 0)
+	w.pending = ""
+	return n, err
+}
+
+// WriteString writes s after the queued sequence, in a single write
+func (w *sequenceWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// toggleAltScreen switches between the alt-screen and the main screen. When
+// leaving the alt-screen the outputs not yet printed are written to the native
+// scrollback so they can be browsed with the terminal's own scrolling.
+func (m *chatModel) toggleAltScreen() tea.Cmd {
+	if !m.altScreen {
+		m.altScreen = true
+		return tea.EnterAltScreen
+	}
+
+	m.altScreen = false
+	cmds := []tea.Cmd{tea.ExitAltScreen}
+	if m.printedOutputs > len(m.outputs) {
+		// Outputs were cleared since the last time we printed them
+		m.printedOutputs = 0
+	}
+	if m.printedOutputs < len(m.outputs) {
+		cmds = append(cmds, tea.Println(strings.Join(m.outputs[m.printedOutputs:], "\n")))
+		m.printedOutputs = len(m.outputs)
+	}
+	return tea.Sequence(cmds...)
+}
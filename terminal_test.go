@@ -0,0 +1,35 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStripControls(t *testing.T) {
+	got := stripControls("AiCode - running Bash\x07\x1b]0;pwned\x07\u009b2J\n")
+	if want := "AiCode - running Bash]0;pwned2J"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestSequenceWriterWritesQueuedSequenceWithNextWrite(t *testing.T) {
+	file, err := os.Create(filepath.Join(t.TempDir(), "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	w := &sequenceWriter{File: file}
+
+	w.Queue("\x1b]9;4;0;0\x07")
+	w.Queue("\x1b]9;4;3;0\x07")
+	if n, err := w.WriteString("\x1b]2;AiCode - thinking\x07"); err != nil || n != 22 {
+		t.Fatalf("wrote %d bytes, %v", n, err)
+	}
+	w.Write([]byte("frame"))
+
+	got, _ := os.ReadFile(file.Name())
+	if want := "\x1b]9;4;3;0\x07\x1b]2;AiCode - thinking\x07frame"; string(got) != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// thinkingBlock is the reasoning of a response shown in the transcript
+type thinkingBlock struct {
+	index int // Index in outputs
+	text  string
+}
+
+// addThinking shows the reasoning of a response, collapsed unless /thinking expanded the blocks
+func (m *chatModel) addThinking(text string) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return
+	}
+	m.thinking = append(m.thinking, thinkingBlock{index: len(m.outputs), text: text})
+	m.outputs = append(m.outputs, m.renderThinking(text))
+}
+
+// renderThinking renders a reasoning block as expanded or collapsed
+func (m *chatModel) renderThinking(text string) string {
+	style := lipgloss.NewStyle().Faint(true).Italic(true)
+	if !m.thinkingExpanded {
+		return style.Render(fmt.Sprintf("▸ Thinking, %d lines (/thinking to expand)", strings.Count(text, "\n")+1))
+	}
+	// Styled line by line so lines are not padded to the longest one
+	lines := strings.Split("▾ Thinking (/thinking to collapse)\n"+text, "\n")
+	for i, line := range lines {
+		lines[i] = style.Render(line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// dropThinking forgets the reasoning blocks of outputs removed from the transcript
+func (m *chatModel) dropThinking() {
+	kept := m.thinking[:0]
+	for _, block := range m.thinking {
+		if block.index < len(m.outputs) {
+			kept = append(kept, block)
+		}
+	}
+	m.thinking = kept
+}
+
+// thinkingHandler expands or collapses the reasoning blocks of the transcript
+func thinkingHandler(m *chatModel) error {
+	if len(m.thinking) == 0 {
+		if !m.config.ShowReasoning {
+			return fmt.Errorf("reasoning is not shown, set show_reasoning: true in the profile")
+		}
+		return fmt.Errorf("no reasoning in this session yet")
+	}
+	m.thinkingExpanded = !m.thinkingExpanded
+	for _, block := range m.thinking {
+		m.outputs[block.index] = m.renderThinking(block.text)
+	}
+	return nil
+}
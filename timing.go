@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// turnTiming records the wall-clock breakdown of a single turn
+type turnTiming struct {
+	Prompt string
+	Total  time.Duration
+	Model  time.Duration
+	Tools  map[string]time.Duration
+	start  time.Time
+}
+
+// Wait returns the time spent neither in the model nor in tools
+func (t *turnTiming) Wait() time.Duration {
+	wait := t.Total - t.Model
+	for _, d := range t.Tools {
+		wait -= d
+	}
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+// timingRecorder collects turn timings for the session
+type timingRecorder struct {
+	mu      sync.Mutex
+	turns   []*turnTiming
+	current *turnTiming
+}
+
+// GlobalTiming is the application-wide timing recorder
+var GlobalTiming = &timingRecorder{}
+
+// StartTurn begins timing a new turn
+func (r *timingRecorder) StartTurn(prompt string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.current = &turnTiming{Prompt: prompt, Tools: map[string]time.Duration{}, start: time.Now()}
+}
+
+// EndTurn finishes timing the current turn
+func (r *timingRecorder) EndTurn() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.current == nil {
+		return
+	}
+	r.current.Total = time.Since(r.current.start)
+	r.turns = append(r.turns, r.current)
+	r.current = nil
+}
+
+// CurrentTurn returns the number and prompt of the turn in progress, 0 between turns
+func (r *timingRecorder) CurrentTurn() (int, string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.current == nil {
+		return 0, ""
+	}
+	return len(r.turns) + 1, r.current.Prompt
+}
+
+// Turns returns the number of turns started in the session
+func (r *timingRecorder) Turns() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.current != nil {
+		return len(r.turns) + 1
+	}
+	return len(r.turns)
+}
+
+// RecordModel adds time spent waiting for the model to the current turn
+func (r *timingRecorder) RecordModel(d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.current != nil {
+		r.current.Model += d
+	}
+}
+
+// RecordTool adds time spent executing a tool to the current turn
+func (r *timingRecorder) RecordTool(name string, d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.current != nil {
+		r.current.Tools[name] += d
+	}
+}
+
+// Report returns the per-turn timing breakdown
+func (r *timingRecorder) Report() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.turns) == 0 {
+		return "No completed turns yet"
+	}
+
+	var b strings.Builder
+	for i, turn := range r.turns {
+		prompt := strings.ReplaceAll(turn.Prompt, "\n", " ")
+		if len([]rune(prompt)) > 40 {
+			prompt = string([]rune(prompt)[:37]) + "..."
+		}
+		fmt.Fprintf(&b, "Turn %d: %s (%q)\n", i+1, formatDuration(turn.Total), prompt)
+		fmt.Fprintf(&b, "  model: %s\n", formatDuration(turn.Model))
+
+		names := make([]string, 0, len(turn.Tools))
+		for name := range turn.Tools {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(&b, "  %s: %s\n", name, formatDuration(turn.Tools[name]))
+		}
+		fmt.Fprintf(&b, "  wait: %s\n", formatDuration(turn.Wait()))
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// Summary returns the total timing breakdown across all turns, empty before
+// the first turn ends
+func (r *timingRecorder) Summary() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.turns) == 0 {
+		return ""
+	}
+
+	var total, model, tools, wait time.Duration
+	for _, turn := range r.turns {
+		total += turn.Total
+		model += turn.Model
+		for _, d := range turn.Tools {
+			tools += d
+		}
+		wait += turn.Wait()
+	}
+	return fmt.Sprintf("Time: %s in %d turns (model %s, tools %s, wait %s)",
+		formatDuration(total), len(r.turns), formatDuration(model), formatDuration(tools), formatDuration(wait))
+}
+
+// formatDuration formats a duration with a precision suited for display
+func formatDuration(d time.Duration) string {
+	if d < time.Second {
+		return d.Round(time.Millisecond).String()
+	}
+	return d.Round(100 * time.Millisecond).String()
+}
+
+func timingHandler(m *chatModel) error {
+	m.outputs = append(m.outputs, GlobalTiming.Report())
+	return nil
+}
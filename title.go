@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Message carrying a generated session title
+type titleGeneratedMsg struct {
+	title string
+}
+
+// generateTitle asks the cheap model for a short title describing the
+// session based on its first exchange
+func generateTitle(config Config, prompt, answer string) tea.Cmd {
+	return func() tea.Msg {
+		if len(answer) > 2000 {
+			answer = answer[:2000]
+		}
+		request := "Request:\n" + prompt + "\n\nAnswer:\n" + answer
+		title, err := quickCompletion(context.Background(), config, titlePrompt, request)
+		if err != nil {
+			slog.Warn("Failed to generate session title", "error", err)
+			return nil
+		}
+		title = strings.Trim(strings.SplitN(title, "\n", 2)[0], "\"'. ")
+		return titleGeneratedMsg{title: title}
+	}
+}
+
+// renameHandler sets the session title, or shows it when no title is given
+func renameHandler(m *chatModel) error {
+	title := strings.Join(m.commandArgs(), " ")
+	if title == "" {
+		m.outputs = append(m.outputs, "Session title: "+m.title)
+		return nil
+	}
+	m.title = title
+	m.outputs = append(m.outputs, "Session renamed to: "+title)
+	return nil
+}
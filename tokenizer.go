@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkoukk/tiktoken-go"
+)
+
+// o200kModels are the prefixes of the models tokenized with o200k_base,
+// the others being counted with cl100k_base. Claude and most open models
+// have tokenizers of their own, so their counts are approximate.
+var o200kModels = []string{"gpt-4o", "gpt-4.1", "gpt-4.5", "gpt-5", "o1", "o3", "o4", "chatgpt-4o"}
+
+// tokenizers are the encodings by name, loaded in the background on first use
+var tokenizers sync.Map
+
+// tokenizerSetup installs the loader of the encodings before the first is loaded
+var tokenizerSetup sync.Once
+
+// tokenizer is an encoding being loaded, nil until it is ready
+type tokenizer struct {
+	once     sync.Once
+	encoding atomic.Pointer[tiktoken.Tiktoken]
+}
+
+// tokenizerHashes are the SHA-256 of the published encodings, so that an
+// error page or cut-off download is neither used nor cached
+var tokenizerHashes = map[string]string{
+	"cl100k_base.tiktoken": "223921b76ee99bde995b7ff738513eef100fb51d18c93597a113bcffe865b2a7",
+	"o200k_base.tiktoken":  "446a9538cb6c348e3516120d7c08b09f57c36495e2acfffe59a5bf8b0cfb1a2d",
+}
+
+// tokenizerLoader reads the encodings from dir, downloading them once with
+// the client of the profile
+type tokenizerLoader struct {
+	client *http.Client
+	dir    string
+}
+
+// LoadTiktokenBpe returns the token ranks of the encoding published at url
+func (l tokenizerLoader) LoadTiktokenBpe(url string) (map[string]int, error) {
+	name := path.Base(url)
+	hash, ok := tokenizerHashes[name]
+	if !ok {
+		return nil, fmt.Errorf("no known hash for the encoding %s", name)
+	}
+	cachePath := filepath.Join(l.dir, name)
+	data, err := os.ReadFile(cachePath)
+	if err == nil && checksum(data) == hash {
+		return parseTiktokenBpe(data)
+	}
+
+	resp, err := l.client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("downloading %s answered %d", url, resp.StatusCode)
+	}
+	if data, err = io.ReadAll(resp.Body); err != nil {
+		return nil, err
+	}
+	if sum := checksum(data); sum != hash {
+		return nil, fmt.Errorf("downloaded %s has SHA-256 %s, expected %s", url, sum, hash)
+	}
+	ranks, err := parseTiktokenBpe(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeTokenizerCache(cachePath, data); err != nil {
+		slog.Warn("Failed to cache the tokenizer", "path", cachePath, "error", err)
+	}
+	return ranks, nil
+}
+
+// checksum returns the hex SHA-256 of data
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// writeTokenizerCache writes an encoding to a temporary file renamed to
+// path, so that an interrupted write never leaves a partial encoding
+func writeTokenizerCache(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tiktoken-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// parseTiktokenBpe reads the ranks of an encoding, one base64 token and its
+// rank per line
+func parseTiktokenBpe(data []byte) (map[string]int, error) {
+	ranks := map[string]int{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		if scanner.Text() == "" {
+			continue
+		}
+		token, rank, ok := strings.Cut(scanner.Text(), " ")
+		if !ok {
+			return nil, fmt.Errorf("invalid encoding line %q", scanner.Text())
+		}
+		decoded, err := base64.StdEncoding.DecodeString(token)
+		if err != nil {
+			return nil, fmt.Errorf("invalid encoding: %v", err)
+		}
+		if ranks[string(decoded)], err = strconv.Atoi(rank); err != nil {
+			return nil, fmt.Errorf("invalid encoding: %v", err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(ranks) == 0 {
+		return nil, fmt.Errorf("empty encoding")
+	}
+	return ranks, nil
+}
+
+// tokenizerEncoding returns the name of the encoding counting the tokens of model
+func tokenizerEncoding(model string) string {
+	model = model[strings.LastIndex(model, "/")+1:]
+	for _, prefix := range o200kModels {
+		if strings.HasPrefix(model, prefix) {
+			return tiktoken.MODEL_O200K_BASE
+		}
+	}
+	return tiktoken.MODEL_CL100K_BASE
+}
+
+// countTokens counts the tokens of a serialized request or part of one for
+// the model of config, inline images at a flat rate. The encoding is loaded
+// in the background, estimateRequestTokens counting meanwhile and when it
+// cannot be downloaded.
+func countTokens(config Config, data []byte) int {
+	name := tokenizerEncoding(config.Model)
+	value, _ := tokenizers.LoadOrStore(name, &tokenizer{})
+	t := value.(*tokenizer)
+	t.once.Do(func() {
+		tokenizerSetup.Do(func() {
+			client, err := httpClient(config)
+			if err != nil {
+				client = http.DefaultClient
+			}
+			tiktoken.SetBpeLoader(tokenizerLoader{
+				client: client,
+				dir:    expandHomeDir("~/.config/aicode/tiktoken"),
+			})
+		})
+		go func() {
+			encoding, err := tiktoken.GetEncoding(name)
+			if err != nil {
+				slog.Debug("Failed to load the tokenizer, estimating tokens", "encoding", name, "error", err)
+				return
+			}
+			t.encoding.Store(encoding)
+		}()
+	})
+
+	encoding := t.encoding.Load()
+	if encoding == nil {
+		return estimateRequestTokens(data)
+	}
+	images := 0
+	text := base64Run.ReplaceAllFunc(data, func([]byte) []byte {
+		images++
+		return nil
+	})
+	return len(encoding.EncodeOrdinary(string(text))) + images*imageTokenEstimate
+}
+
+// contextCount is the size of the conversation counted by the provider in
+// its last response, so that only the messages added since are counted here
+type contextCount struct {
+	tokens   int // Prompt and answer tokens of the last response
+	messages int // Messages of the history the tokens cover
+}
+
+// contextTokens returns the tokens the history takes in the context window:
+// the count of the provider plus the messages added since, or when no count
+// covers the history, such as after it was summarized, all of it counted
+// along with the system prompt
+func contextTokens[M any](config Config, count contextCount, system any, history []M) int {
+	if count.tokens > 0 && count.messages <= len(history) {
+		added, _ := json.Marshal(history[count.messages:])
+		return count.tokens + countTokens(config, added)
+	}
+	tokens := 0
+	if system != nil {
+		data, _ := json.Marshal(system)
+		tokens = countTokens(config, data)
+	}
+	data, _ := json.Marshal(history)
+	return tokens + countTokens(config, data)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTokenizerLoaderChecksDownloadsBeforeCaching(t *testing.T) {
+	encoding := "YQ== 0\nYg== 1\n"
+	body := "<html>Sign in to the network</html>"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	hashes := tokenizerHashes
+	tokenizerHashes = map[string]string{"test.tiktoken": checksum([]byte(encoding))}
+	defer func() { tokenizerHashes = hashes }()
+	loader := tokenizerLoader{client: server.Client(), dir: t.TempDir()}
+	cachePath := filepath.Join(loader.dir, "test.tiktoken")
+
+	if _, err := loader.LoadTiktokenBpe(server.URL + "/test.tiktoken"); err == nil {
+		t.Fatal("loaded a download with the wrong hash")
+	}
+	if _, err := os.Stat(cachePath); !os.IsNotExist(err) {
+		t.Fatalf("cached a download with the wrong hash: %v", err)
+	}
+
+	body = encoding
+	ranks, err := loader.LoadTiktokenBpe(server.URL + "/test.tiktoken")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ranks["a"] != 0 || ranks["b"] != 1 || len(ranks) != 2 {
+		t.Fatalf("unexpected ranks %v", ranks)
+	}
+	if cached, err := os.ReadFile(cachePath); err != nil || string(cached) != encoding {
+		t.Fatalf("cache holds %q, %v", cached, err)
+	}
+
+	if _, err := loader.LoadTiktokenBpe(server.URL + "/other.tiktoken"); err == nil {
+		t.Fatal("loaded an encoding without a known hash")
+	}
+}
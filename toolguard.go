@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// defaultToolResultShare is the share of the remaining context window a
+// single tool result may take
+const defaultToolResultShare = 0.25
+
+// minToolResultTokens is kept for tool results even when the context is nearly full
+const minToolResultTokens = 1000
+
+// guardToolResult cuts a tool result that would take more than share of the
+// remaining context window, keeping its head and tail with a note in between
+func guardToolResult(result string, remainingTokens int, share float64) string {
+	if share <= 0 {
+		share = defaultToolResultShare
+	}
+	budget := int(float64(remainingTokens) * share)
+	if budget < minToolResultTokens {
+		budget = minToolResultTokens
+	}
+	tokens := estimateTextTokens(result)
+	if tokens <= budget {
+		return result
+	}
+
+	// Keep more of the head, where commands usually print what matters most,
+	// and the tail for summaries and final errors
+	chars := min(budget*4, len(result))
+	headChars := chars * 2 / 3
+	tailChars := chars - headChars
+	head := result[:headChars]
+	if i := strings.LastIndexByte(head, '\n'); i > 0 {
+		head = head[:i+1]
+	}
+	tail := result[len(result)-tailChars:]
+	if i := strings.IndexByte(tail, '\n'); i >= 0 && i < len(tail)-1 {
+		tail = tail[i+1:]
+	}
+	omitted := result[len(head) : len(result)-len(tail)]
+
+	note := fmt.Sprintf("\n[... %d lines (~%d tokens) omitted: the result took more than %.0f%% of the remaining context window. Narrow the command, e.g. with grep, head or offset and limit, to see the rest ...]\n",
+		strings.Count(omitted, "\n"), estimateTextTokens(omitted), share*100)
+	return strings.ToValidUTF8(head, "") + note + strings.ToValidUTF8(tail, "")
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGuardToolResultBase64(t *testing.T) {
+	tests := []struct {
+		name      string
+		result    string
+		remaining int
+		cut       bool
+	}{
+		{
+			name:      "certificate with a full context",
+			result:    "tls.crt: " + strings.Repeat("QUJD", 301),
+			remaining: 0,
+			cut:       false,
+		},
+		{
+			name:      "base64 dump",
+			result:    strings.Repeat(strings.Repeat("QUJD", 250)+"\n", 48),
+			remaining: 150000,
+			cut:       false,
+		},
+		{
+			name:      "base64 dump with a full context",
+			result:    strings.Repeat(strings.Repeat("QUJD", 250)+"\n", 48),
+			remaining: 0,
+			cut:       true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := guardToolResult(tt.result, tt.remaining, 0)
+			if cut := got != tt.result; cut != tt.cut {
+				t.Fatalf("cut = %v, want %v", cut, tt.cut)
+			}
+			if tt.cut && !strings.Contains(got, "omitted") {
+				t.Fatalf("cut result has no note: %q", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestExecuteGrepSingleFile(t *testing.T) {
+	if _, err := exec.LookPath("rg"); err != nil {
+		t.Skip("rg is not installed")
+	}
+	path := filepath.Join(t.TempDir(), "main.go")
+	if err := os.WriteFile(path, []byte("package main\n\nfunc handleRequest() {}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	params, _ := json.Marshal(GrepParams{Pattern: "handleRequest", Path: path})
+	output, err := ExecuteGrep(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(output, "3:func handleRequest() {}") {
+		t.Fatalf("matches of a single file are missing: %q", output)
+	}
+}
+
+func TestExecuteViewToolTruncatesAtLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "big.txt")
+	line := strings.Repeat("é", 99) + "\n"
+	if err := os.WriteFile(path, []byte(strings.Repeat(line, 400)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	params, _ := json.Marshal(ViewToolParams{FilePath: path, Limit: 1000})
+	output, err := ExecuteViewTool(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !utf8.ValidString(output) {
+		t.Fatal("output splits a character")
+	}
+	if !strings.Contains(output, line+"... [Output truncated due to size: lines 1-150 of 400 shown, view from offset 151 for the rest]") {
+		t.Fatalf("output does not end at a line with the shown range: %q", output[len(output)-300:])
+	}
+
+	if err := os.WriteFile(path, []byte(strings.Repeat("é", 20000)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if output, err = ExecuteViewTool(params); err != nil {
+		t.Fatal(err)
+	}
+	if !utf8.ValidString(output) || !strings.Contains(output, "line 1 cut after 30000 of its 40000 bytes") {
+		t.Fatalf("long line not cut at a character: %q", output[len(output)-300:])
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"slices"
+	"sync"
+)
+
+// Dialects of JSON schema the tool definitions are adapted to
+const (
+	toolDialectAnthropic = "anthropic" // input_schema of the Messages API
+	toolDialectOpenAI    = "openai"    // parameters of chat completions and Responses, also used by OpenAI-compatible servers
+)
+
+// toolDefinition is the canonical definition of a tool in tools/*.json, the
+// description being the markdown file next to it. Version is increased when
+// the parameters change in a way that breaks the callers of the tool, such
+// as scripts reading the tool calls of stream-json.
+type toolDefinition struct {
+	Name       string         `json:"name"`
+	Version    int            `json:"version"`
+	Parameters map[string]any `json:"parameters"`
+}
+
+// toolSchemaShims adjust the canonical schemas to what each API accepts
+var toolSchemaShims = map[string][]func(schema map[string]any, top bool){
+	toolDialectAnthropic: {dropSchemaKeyword, requireTopObject},
+	toolDialectOpenAI:    {dropSchemaKeyword, requireArrayItems},
+}
+
+// dropSchemaKeyword removes $schema, which the APIs assume and the strict
+// mode of OpenAI rejects
+func dropSchemaKeyword(schema map[string]any, top bool) {
+	delete(schema, "$schema")
+}
+
+// requireTopObject makes the input schema an object with properties, the
+// only input_schema Anthropic accepts
+func requireTopObject(schema map[string]any, top bool) {
+	if !top {
+		return
+	}
+	schema["type"] = "object"
+	if _, ok := schema["properties"]; !ok {
+		schema["properties"] = map[string]any{}
+	}
+}
+
+// requireArrayItems gives arrays without items a schema for them, which
+// OpenAI rejects function definitions without
+func requireArrayItems(schema map[string]any, top bool) {
+	if schema["type"] == "array" && schema["items"] == nil {
+		schema["items"] = map[string]any{}
+	}
+}
+
+// toolDefinitions are the parsed definitions of the tools of ToolData,
+// checked once against it so that a definition cannot drift from its tool
+var toolDefinitions = sync.OnceValue(func() map[string]toolDefinition {
+	definitions := map[string]toolDefinition{}
+	for name, tool := range ToolData {
+		var definition toolDefinition
+		err := json.Unmarshal([]byte(tool.Schema), &definition)
+		switch {
+		case err != nil:
+		case definition.Name != name:
+			err = fmt.Errorf("named %q", definition.Name)
+		case definition.Version < 1:
+			err = fmt.Errorf("missing version")
+		case definition.Parameters["type"] != "object":
+			err = fmt.Errorf("parameters are not an object")
+		}
+		if err != nil {
+			slog.Error("Invalid tool definition", "tool", name, "error", err)
+			os.Exit(1)
+		}
+		definitions[name] = definition
+	}
+	return definitions
+})
+
+// toolSchemaVersion returns the version of the definition of a tool, 0 for
+// unknown tools
+func toolSchemaVersion(name string) int {
+	return toolDefinitions()[name].Version
+}
+
+// toolSchema returns the parameters of a tool adapted to dialect
+func toolSchema(name, dialect string) json.RawMessage {
+	schema := cloneSchema(toolDefinitions()[name].Parameters)
+	walkSchema(schema, true, func(node map[string]any, top bool) {
+		for _, shim := range toolSchemaShims[dialect] {
+			shim(node, top)
+		}
+	})
+	data, _ := json.Marshal(schema)
+	return data
+}
+
+// strictToolSchema returns the parameters of a tool for the strict mode of
+// OpenAI function calling, and false when strict mode cannot express them
+func strictToolSchema(name string) (json.RawMessage, bool) {
+	var schema map[string]any
+	if err := json.Unmarshal(toolSchema(name, toolDialectOpenAI), &schema); err != nil || !strictSchema(schema) {
+		return nil, false
+	}
+	data, _ := json.Marshal(schema)
+	return data, true
+}
+
+// strictSchema adapts a schema to strict mode, where objects allow no other
+// properties and require all of theirs, the optional ones accepting null
+// instead. It returns false for what strict mode rejects: free-form objects
+// such as the input of a Batch invocation, and optional properties without
+// a type.
+func strictSchema(schema map[string]any) bool {
+	ok := true
+	walkSchema(schema, true, func(node map[string]any, top bool) {
+		delete(node, "default")
+		if !schemaHasType(node, "object") {
+			return
+		}
+		properties, hasProperties := node["properties"].(map[string]any)
+		if extra, set := node["additionalProperties"]; !hasProperties || (set && extra != false) {
+			ok = false
+			return
+		}
+		node["additionalProperties"] = false
+
+		required, _ := node["required"].([]any)
+		names := slices.Sorted(maps.Keys(properties))
+		for _, name := range names {
+			property, isSchema := properties[name].(map[string]any)
+			if slices.Contains(required, any(name)) {
+				continue
+			}
+			if !isSchema || !makeNullable(property) {
+				ok = false
+			}
+		}
+		all := make([]any, len(names))
+		for i, name := range names {
+			all[i] = name
+		}
+		node["required"] = all
+	})
+	return ok
+}
+
+// schemaHasType tells whether a schema accepts values of type t
+func schemaHasType(schema map[string]any, t string) bool {
+	switch types := schema["type"].(type) {
+	case string:
+		return types == t
+	case []any:
+		return slices.Contains(types, any(t))
+	}
+	return false
+}
+
+// makeNullable lets a property accept null, which strict mode asks for in
+// place of leaving it out, returning false for a property without a type
+func makeNullable(property map[string]any) bool {
+	switch types := property["type"].(type) {
+	case string:
+		property["type"] = []any{types, "null"}
+	case []any:
+		if !slices.Contains(types, any("null")) {
+			property["type"] = append(types, "null")
+		}
+	default:
+		return false
+	}
+	if enum, ok := property["enum"].([]any); ok && !slices.Contains(enum, nil) {
+		property["enum"] = append(enum, nil)
+	}
+	return true
+}
+
+// dropNullArguments removes the null members of the arguments of a tool
+// call, given by strict mode for the optional parameters left out, so that
+// the tools see them as not set
+func dropNullArguments(arguments []byte) []byte {
+	decoder := json.NewDecoder(bytes.NewReader(arguments))
+	decoder.UseNumber()
+	var value map[string]any
+	if err := decoder.Decode(&value); err != nil || !dropNulls(value) {
+		return arguments
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return arguments
+	}
+	return data
+}
+
+// dropNulls removes the null members of value and of the objects nested in
+// it, returning whether there were any
+func dropNulls(value any) bool {
+	dropped := false
+	switch value := value.(type) {
+	case map[string]any:
+		for key, member := range value {
+			if member == nil {
+				delete(value, key)
+				dropped = true
+			} else if dropNulls(member) {
+				dropped = true
+			}
+		}
+	case []any:
+		for _, item := range value {
+			if dropNulls(item) {
+				dropped = true
+			}
+		}
+	}
+	return dropped
+}
+
+// cloneSchema deep copies a schema so that the shims leave the definition intact
+func cloneSchema(schema map[string]any) map[string]any {
+	clone := maps.Clone(schema)
+	for key, value := range clone {
+		switch value := value.(type) {
+		case map[string]any:
+			clone[key] = cloneSchema(value)
+		case []any:
+			items := make([]any, len(value))
+			for i, item := range value {
+				items[i] = item
+				if nested, ok := item.(map[string]any); ok {
+					items[i] = cloneSchema(nested)
+				}
+			}
+			clone[key] = items
+		}
+	}
+	return clone
+}
+
+// walkSchema calls visit on a schema and the schemas nested in its
+// properties, items, additionalProperties and combinations
+func walkSchema(schema map[string]any, top bool, visit func(node map[string]any, top bool)) {
+	visit(schema, top)
+	if properties, ok := schema["properties"].(map[string]any); ok {
+		for _, property := range properties {
+			if property, ok := property.(map[string]any); ok {
+				walkSchema(property, false, visit)
+			}
+		}
+	}
+	for _, key := range []string{"items", "additionalProperties"} {
+		if nested, ok := schema[key].(map[string]any); ok {
+			walkSchema(nested, false, visit)
+		}
+	}
+	for _, key := range []string{"anyOf", "oneOf", "allOf"} {
+		if variants, ok := schema[key].([]any); ok {
+			for _, variant := range variants {
+				if variant, ok := variant.(map[string]any); ok {
+					walkSchema(variant, false, visit)
+				}
+			}
+		}
+	}
+}
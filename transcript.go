@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// transcriptEntry is one event of a session transcript, in the format of
+// the stream-json output
+type transcriptEntry struct {
+	Time time.Time `json:"time"`
+	streamEvent
+}
+
+var transcriptMu sync.Mutex
+
+// transcriptEnabled is set from record_transcript, transcripts hold tool
+// outputs such as the files read and are only written when asked for
+var transcriptEnabled bool
+
+// configureTranscript turns the recording of the transcript on or off
+func configureTranscript(config Config) {
+	transcriptEnabled = config.RecordTranscript
+}
+
+// transcriptsDir returns the directory under ~/.config/aicode holding the
+// transcripts of the sessions run in the project directory dir
+func transcriptsDir(dir string) string {
+	return filepath.Join(expandHomeDir("~/.config/aicode/sessions"), projectKey(dir))
+}
+
+// sessionTranscriptPath returns the transcript file of the session with the
+// given id run in the current directory
+func sessionTranscriptPath(id string) string {
+	cwd, _ := os.Getwd()
+	return filepath.Join(transcriptsDir(cwd), id, "transcript.jsonl")
+}
+
+// recordTranscript appends an event to the transcript of the session, which
+// aicode serve shows, when record_transcript is set. Sub-agents report to
+// their parent instead.
+func recordTranscript(event streamEvent) {
+	GlobalSessionActivity.RecordEvent(event)
+	if !transcriptEnabled || agentDepth() > 0 {
+		return
+	}
+	data, err := json.Marshal(transcriptEntry{Time: time.Now(), streamEvent: event})
+	if err != nil {
+		return
+	}
+
+	transcriptMu.Lock()
+	defer transcriptMu.Unlock()
+	path := sessionTranscriptPath(sessionID)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		slog.Debug("Failed to create the session directory", "error", err)
+		return
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		slog.Debug("Failed to open the session transcript", "error", err)
+		return
+	}
+	defer file.Close()
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		slog.Debug("Failed to write the session transcript", "error", err)
+	}
+}
+
+// readTranscript returns the entries of a transcript file, skipping a line
+// being written
+func readTranscript(path string) ([]transcriptEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []transcriptEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var entry transcriptEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err == nil {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, scanner.Err()
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Tool result given to the calls made after the model was asked to wrap up
+const timeLimitToolResult = "Not run: the time limit of the turn was reached"
+
+// Message telling that a turn ran out of time and the model is wrapping up
+type turnTimeLimitMsg struct {
+	limit time.Duration
+}
+
+// turnDeadline is the wall-clock budget of a turn set with turn_time_limit.
+// Once it is over, the turn goes on only for the model to sum up its
+// progress: the tool calls in progress finish, then the model is asked for a
+// summary and the next steps, and control returns to the user.
+type turnDeadline struct {
+	limit   time.Duration // No limit when zero
+	start   time.Time
+	reached bool // The model was asked to wrap up
+}
+
+// newTurnDeadline starts the budget of a turn
+func newTurnDeadline(limit time.Duration) *turnDeadline {
+	return &turnDeadline{limit: limit, start: time.Now()}
+}
+
+// Expired tells, only once, that the turn is over its time and the model
+// must be asked to wrap up
+func (d *turnDeadline) Expired() bool {
+	if d.limit <= 0 || d.reached || time.Since(d.start) < d.limit {
+		return false
+	}
+	d.reached = true
+	return true
+}
+
+// Prompt asks the model to end the turn
+func (d *turnDeadline) Prompt() string {
+	return fmt.Sprintf("The time limit of %s for this turn was reached. Do not call any more tools: summarize the progress made so far and propose the next steps.", d.limit)
+}
+
+// turnDeadlineError ends a turn still running at its turn_deadline, unlike
+// turn_time_limit the requests and tools in progress are stopped
+type turnDeadlineError struct {
+	deadline time.Duration
+}
+
+func (e turnDeadlineError) Error() string {
+	return fmt.Sprintf("the turn ran past its deadline of %s", e.deadline)
+}
+
+// withTurnDeadline bounds the context of a turn by the turn_deadline of the
+// profile, telling it apart from a cancel by its cause
+func withTurnDeadline(ctx context.Context, config Config) (context.Context, context.CancelFunc) {
+	if config.TurnDeadline <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeoutCause(ctx, config.TurnDeadline, turnDeadlineError{config.TurnDeadline})
+}
+
+// reportTurnDeadline shows in the UI that the turn_deadline ended the turn,
+// whose context ended while no request was running
+func reportTurnDeadline(ctx context.Context) {
+	var deadline turnDeadlineError
+	if programRef != nil && errors.As(context.Cause(ctx), &deadline) {
+		programRef.Send(updateResultMsg{err: deadline})
+	}
+}
+
+// refuseToolCalls answers the tool calls the model made after being asked to
+// wrap up without running them, keeping the history valid for the next turn
+func refuseToolCalls(llm Llm, calls []ToolCall) {
+	for _, call := range calls {
+		llm.AddToolResult(call.ID, timeLimitToolResult)
+	}
+}
+
+// reportTimeLimit shows that the turn ran out of time in the UI, to the
+// parent agent or on stderr
+func reportTimeLimit(limit time.Duration) {
+	switch {
+	case programRef != nil:
+		programRef.Send(turnTimeLimitMsg{limit: limit})
+	case os.Getenv(agentProgressEnv) != "":
+		reportAgentProgress("Time limit of %s reached, summarizing", limit)
+	default:
+		fmt.Fprintf(os.Stderr, "Time limit of %s reached, asking for a summary\n", limit)
+	}
+}
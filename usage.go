@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// usageRecord is the cost ledger entry of one model request
+type usageRecord struct {
+	Time              time.Time `json:"time"`
+	Project           string    `json:"project"`
+	Model             string    `json:"model"`
+	InputTokens       int       `json:"input_tokens"`
+	CachedInputTokens int       `json:"cached_input_tokens,omitempty"`
+	OutputTokens      int       `json:"output_tokens"`
+	Cost              float64   `json:"cost"`
+}
+
+var usageMu sync.Mutex
+
+// usageLedgerPath returns the file of the cost ledger, shared by all sessions
+func usageLedgerPath() string {
+	return expandHomeDir("~/.config/aicode/usage.jsonl")
+}
+
+// currentProject names the project of the session for the ledger
+func currentProject() string {
+	if activeRemote != nil {
+		return activeRemote.Host + ":" + activeRemote.Dir
+	}
+	wd, _ := os.Getwd()
+	return wd
+}
+
+// recordUsage appends a request to the cost ledger. Failures are only logged.
+func recordUsage(model string, inputTokens, cachedInputTokens, outputTokens int, cost float64) {
+	record := usageRecord{
+		Time:              time.Now(),
+		Project:           currentProject(),
+		Model:             model,
+		InputTokens:       inputTokens,
+		CachedInputTokens: cachedInputTokens,
+		OutputTokens:      outputTokens,
+		Cost:              cost,
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+
+	usageMu.Lock()
+	defer usageMu.Unlock()
+	path := usageLedgerPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		slog.Error("Failed to create usage ledger directory", "error", err)
+		return
+	}
+	// Lines are appended in a single write so concurrent sessions don't interleave
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		slog.Error("Failed to open usage ledger", "error", err)
+		return
+	}
+	defer file.Close()
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		slog.Error("Failed to write usage ledger", "error", err)
+	}
+}
+
+// readUsage returns the ledger entries since the given time, oldest first
+func readUsage(since time.Time) ([]usageRecord, error) {
+	file, err := os.Open(usageLedgerPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	var records []usageRecord
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record usageRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			continue
+		}
+		if !record.Time.Before(since) {
+			records = append(records, record)
+		}
+	}
+	sort.SliceStable(records, func(i, j int) bool { return records[i].Time.Before(records[j].Time) })
+	return records, scanner.Err()
+}
+
+// usageTotal sums the ledger entries of one group
+type usageTotal struct {
+	Key          string
+	Requests     int
+	InputTokens  int
+	OutputTokens int
+	Cost         float64
+}
+
+// groupUsage sums the records by the key returned for each of them, sorted by key
+func groupUsage(records []usageRecord, key func(usageRecord) string) []usageTotal {
+	totals := map[string]*usageTotal{}
+	for _, record := range records {
+		k := key(record)
+		total, ok := totals[k]
+		if !ok {
+			total = &usageTotal{Key: k}
+			totals[k] = total
+		}
+		total.Requests++
+		total.InputTokens += record.InputTokens
+		total.OutputTokens += record.OutputTokens
+		total.Cost += record.Cost
+	}
+
+	result := make([]usageTotal, 0, len(totals))
+	for _, total := range totals {
+		result = append(result, *total)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Key < result[j].Key })
+	return result
+}
+
+// writeUsageCSV exports the records for expense reporting
+func writeUsageCSV(w io.Writer, records []usageRecord) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"date", "time", "project", "model", "input_tokens", "cached_input_tokens", "output_tokens", "cost_usd"})
+	for _, record := range records {
+		local := record.Time.Local()
+		out.Write([]string{
+			local.Format("2006-01-02"),
+			local.Format("15:04:05"),
+			record.Project,
+			record.Model,
+			strconv.Itoa(record.InputTokens),
+			strconv.Itoa(record.CachedInputTokens),
+			strconv.Itoa(record.OutputTokens),
+			fmt.Sprintf("%.6f", record.Cost),
+		})
+	}
+	out.Flush()
+	return out.Error()
+}
@@ -49,3 +49,8 @@ func chunkOutput(output string, maxLines int) []string {
 		fmt.Sprintf("... %d more lines", remainingCount),
 	}
 }
+
+// floatPtr returns a pointer to v, for optional numeric request fields
+func floatPtr(v float64) *float64 {
+	return &v
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// defaultSyntaxChecks are used when no syntax_checks are configured
+var defaultSyntaxChecks = map[string]string{
+	".go": "gofmt -e {{.File}} > /dev/null",
+}
+
+// validateFileContent writes content to a temporary copy of filePath and runs
+// the syntax check configured for its extension, if any
+func validateFileContent(filePath, content string, config Config) error {
+	checks := config.SyntaxChecks
+	if checks == nil {
+		checks = defaultSyntaxChecks
+	}
+
+	ext := filepath.Ext(filePath)
+	checkCmd, ok := checks[ext]
+	if !ok {
+		return nil
+	}
+
+	// Skip the check if the checker itself isn't installed
+	if fields := strings.Fields(checkCmd); len(fields) > 0 {
+		if _, err := exec.LookPath(fields[0]); err != nil {
+			return nil
+		}
+	}
+
+	tmpFile, err := os.CreateTemp("", "aicode-validate-*"+ext)
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	if _, err := tmpFile.WriteString(content); err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("failed to write temp file: %v", err)
+	}
+	tmpFile.Close()
+
+	tmpl, err := template.New("check").Parse(checkCmd)
+	if err != nil {
+		return fmt.Errorf("invalid syntax check for %s: %v", ext, err)
+	}
+	var cmd strings.Builder
+	data := struct{ File string }{File: "'" + strings.ReplaceAll(tmpFile.Name(), "'", "'\\''") + "'"}
+	if err := tmpl.Execute(&cmd, data); err != nil {
+		return fmt.Errorf("invalid syntax check for %s: %v", ext, err)
+	}
+
+	ctx := GlobalAppContext.Context()
+	output, err := exec.CommandContext(ctx, "bash", "-c", cmd.String()).CombinedOutput()
+	if err != nil {
+		// Report errors against the real file name rather than the temp copy
+		report := strings.ReplaceAll(string(output), tmpFile.Name(), filePath)
+		return fmt.Errorf("validation failed for %s: %v\n%s", filePath, err, report)
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// imageMediaTypes are the image formats the vision models of Anthropic and
+// OpenAI accept, by extension
+var imageMediaTypes = map[string]string{
+	".png":  "image/png",
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".gif":  "image/gif",
+	".webp": "image/webp",
+}
+
+// maxImageBytes is the largest image sent to the model, the limit of the
+// Anthropic API
+const maxImageBytes = 5 * 1024 * 1024
+
+// toolImage is an image read by a tool for the model to see
+type toolImage struct {
+	mediaType string
+	data      []byte
+}
+
+// toolImages holds the images read by tools until their results are added
+// to the conversation. Tool results are text, so they refer to their images
+// with a marker the providers replace with image blocks.
+type toolImages struct {
+	mu     sync.Mutex
+	next   int
+	images map[int]toolImage
+}
+
+// GlobalToolImages is the application-wide store of the images read by tools
+var GlobalToolImages = &toolImages{images: map[int]toolImage{}}
+
+// toolImageMarker matches the markers of images in tool results
+var toolImageMarker = regexp.MustCompile(`\[image #(\d+) attached\]`)
+
+// Add stores an image and returns the marker to put in the tool result
+func (t *toolImages) Add(mediaType string, data []byte) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.next++
+	t.images[t.next] = toolImage{mediaType: mediaType, data: data}
+	return fmt.Sprintf("[image #%d attached]", t.next)
+}
+
+// Take removes and returns the images a tool result refers to
+func (t *toolImages) Take(result string) []toolImage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var images []toolImage
+	for _, match := range toolImageMarker.FindAllStringSubmatch(result, -1) {
+		id, _ := strconv.Atoi(match[1])
+		if image, ok := t.images[id]; ok {
+			images = append(images, image)
+			delete(t.images, id)
+		}
+	}
+	return images
+}
+
+// readImage reads an image of the workspace for the model, refusing the
+// formats models cannot read and images over maxImageBytes
+func readImage(path string) (string, []byte, error) {
+	mediaType, ok := imageMediaTypes[strings.ToLower(filepath.Ext(path))]
+	if !ok {
+		return "", nil, fmt.Errorf("only PNG, JPEG, GIF and WebP images can be shown to the model")
+	}
+	info, err := workspaceStat(path)
+	if err != nil {
+		return "", nil, err
+	}
+	if info.Size() > maxImageBytes {
+		return "", nil, fmt.Errorf("%s is larger than %d MB", path, maxImageBytes/1024/1024)
+	}
+	data, err := workspaceReadFile(path)
+	return mediaType, data, err
+}
+
+// viewImage returns the result of View on an image, which the model sees
+// along with it
+func viewImage(path string) string {
+	mediaType, data, err := readImage(path)
+	if err != nil {
+		return fmt.Sprintf("Image file %s cannot be shown: %v", path, err)
+	}
+	return fmt.Sprintf("Image %s (%s, %d KB) %s", path, mediaType, (len(data)+1023)/1024, GlobalToolImages.Add(mediaType, data))
+}
+
+// imageMention matches the @path mentions of images in prompts
+var imageMention = regexp.MustCompile(`(?i)(?:^|\s)@(\S+\.(?:png|jpe?g|gif|webp))\b`)
+
+// attachImageMentions attaches the images mentioned as @path in a prompt,
+// e.g. "why is the button cut off in @screenshot.png", to the message of the
+// prompt, and returns their paths. Mentions of missing files are left as text.
+func attachImageMentions(llm Llm, prompt string) ([]string, error) {
+	var attached []string
+	var errs []error
+	for _, match := range imageMention.FindAllStringSubmatch(prompt, -1) {
+		path := expandHomeDir(match[1])
+		mediaType, data, err := readImage(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		llm.AttachImage(mediaType, data)
+		attached = append(attached, path)
+	}
+	return attached, errors.Join(errs...)
+}